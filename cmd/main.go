@@ -2,10 +2,12 @@ package main
 
 import (
 	"Altheia-Backend/config"
+	"Altheia-Backend/internal/audit"
 	"Altheia-Backend/internal/auth"
 	"Altheia-Backend/internal/clinical"
 	"Altheia-Backend/internal/clinical/appointments"
 	"Altheia-Backend/internal/db"
+	"Altheia-Backend/internal/jobs"
 	"Altheia-Backend/internal/middleware"
 	"Altheia-Backend/internal/users"
 	"Altheia-Backend/internal/users/clinicOwner"
@@ -14,7 +16,11 @@ import (
 	"Altheia-Backend/internal/users/receptionist"
 	"Altheia-Backend/internal/users/superAdmin"
 	wsInternal "Altheia-Backend/internal/websocket"
+	"Altheia-Backend/pkg/utils"
+	"log"
 	"os"
+	"time"
+	_ "time/tzdata" // clinic time zones must load in images without zoneinfo
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
@@ -22,8 +28,14 @@ import (
 )
 
 func main() {
+	keyring, err := utils.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load JWT signing keys: %v", err)
+	}
+	utils.SetKeyring(keyring)
+
 	database := db.GetDB()
-	err := database.AutoMigrate(
+	err = database.AutoMigrate(
 
 		&clinical.Clinic{},
 		&clinical.ClinicInformation{},
@@ -40,10 +52,30 @@ func main() {
 		&users.LabTechnician{},
 		&users.SuperAdmin{},
 		&users.LoginActivity{},
+		&users.RefreshToken{},
+		&users.MFACredential{},
+		&users.MFARecoveryCode{},
+		&users.MFARolePolicy{},
+		&users.UserToken{},
+		&users.PasswordHistory{},
 
 		&clinical.MedicalHistory{},
 		&clinical.MedicalConsultation{},
+		&clinical.MedicalHistoryVersion{},
+		&clinical.MedicalConsultationVersion{},
+		&audit.Entry{},
+		&appointments.AppointmentSeries{},
 		&appointments.MedicalAppointment{},
+		&appointments.PhysicianAvailability{},
+		&appointments.AvailabilityException{},
+		&appointments.AppointmentStatusHistory{},
+		&appointments.WaitlistEntry{},
+		&appointments.SlotOffer{},
+		&appointments.AppointmentActionToken{},
+		&appointments.CalendarFeed{},
+		&appointments.BookingPolicy{},
+		&appointments.AppointmentCheckIn{},
+		&jobs.Job{},
 		&clinical.MedicalPrescription{},
 		&clinical.MedicalDocument{},
 	)
@@ -51,6 +83,16 @@ func main() {
 		return
 	}
 
+	if err := appointments.EnsureConstraints(database); err != nil {
+		log.Fatalf("failed to set up appointment constraints: %v", err)
+	}
+	if err := clinical.EnsureVersionHistory(database); err != nil {
+		log.Fatalf("failed to set up medical record versions: %v", err)
+	}
+	if err := audit.EnsureAppendOnly(database); err != nil {
+		log.Fatalf("failed to set up the audit log: %v", err)
+	}
+
 	client := os.Getenv("CLIENT")
 
 	// Crear hub y servicio de WebSocket
@@ -70,7 +112,7 @@ func main() {
 
 	// Patient handler
 	patientRepo := patient.NewRepository(database)
-	patientService := patient.NewService(patientRepo)
+	patientService := patient.NewService(patientRepo, authRepo)
 	patientHandler := patient.NewHandler(patientService)
 
 	// Physician handler
@@ -79,7 +121,9 @@ func main() {
 	physicianHandler := physician.NewHandler(physicianService)
 
 	//Create Clinic handler
-	clinicRepo := clinical.NewRepository(database)
+	appointmentRepo := appointments.NewRepository(database)
+
+	clinicRepo := clinical.NewRepository(database, appointmentRepo)
 	clinicService := clinical.NewService(clinicRepo)
 	clinicHandler := clinical.NewHandler(clinicService)
 
@@ -98,12 +142,38 @@ func main() {
 	superAdminService := superAdmin.NewService(superAdminRepo)
 	superAdminHandler := superAdmin.NewHandler(superAdminService)
 
+	// Audit log handler
+	auditService := audit.NewService(audit.NewRepository(database))
+	auditHandler := audit.NewHandler(auditService)
+
 	// Appointment handler
-	appointmentRepo := appointments.NewRepository(database)
-	appointmentService := appointments.NewService(appointmentRepo)
+	appointmentService := appointments.NewService(appointmentRepo, wsService)
 	appointmentHandler := appointments.NewHandler(appointmentService)
 
-	app := fiber.New()
+	// Background jobs
+	scheduler := jobs.NewScheduler(jobs.NewStore(database))
+	scheduler.PollInterval = config.GetEnvDuration("JOBS_POLL_INTERVAL", 15*time.Second)
+	reminders := appointments.NewReminders(appointmentRepo, scheduler,
+		config.GetEnvDurations("APPOINTMENT_REMINDER_OFFSETS", []time.Duration{48 * time.Hour, 2 * time.Hour}))
+	scheduler.Handle(appointments.ReminderJob, reminders.Send)
+	scheduler.Every("appointment reminders", time.Minute, reminders.Plan)
+	scheduler.Every("waitlist offer expiry", time.Minute, appointmentService.ExpireWaitlistOffers)
+	scheduler.Every("no-show sweep", 5*time.Minute, appointmentService.MarkNoShows)
+	scheduler.Start()
+
+	// c.IP() only honours the proxy header on requests coming from
+	// TRUSTED_PROXIES; anyone else gets their socket address, so clients cannot
+	// pick the IP the login throttle and the audit log see.
+	proxyHeader := config.GetEnv("PROXY_HEADER")
+	if proxyHeader == "" {
+		proxyHeader = "X-Real-IP"
+	}
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             proxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          config.GetEnvList("TRUSTED_PROXIES"),
+		EnableIPValidation:      true,
+	})
 
 	// Configuración CORS
 	app.Use(cors.New(cors.Config{
@@ -113,75 +183,100 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	// Route policies. Every route declares who may call it: public routes are
+	// listed explicitly, everything else goes through middleware.RequireRoles and,
+	// when the route targets clinic data, middleware.RequireClinic.
+	anyRole := middleware.RequireRoles(users.RolePatient, users.RolePhysician, users.RoleReceptionist, users.RoleOwner, users.RoleLabTechnician, users.RoleSuperAdmin)
+	staff := middleware.RequireRoles(users.RolePhysician, users.RoleReceptionist, users.RoleOwner, users.RoleSuperAdmin)
+	patientsAndStaff := middleware.RequireRoles(users.RolePatient, users.RolePhysician, users.RoleReceptionist, users.RoleOwner, users.RoleSuperAdmin)
+	frontDesk := middleware.RequireRoles(users.RoleReceptionist, users.RoleOwner, users.RoleSuperAdmin)
+	management := middleware.RequireRoles(users.RoleOwner, users.RoleSuperAdmin)
+	clinicians := middleware.RequireRoles(users.RolePhysician, users.RoleSuperAdmin)
+	recordReaders := middleware.RequireRoles(users.RolePatient, users.RolePhysician, users.RoleOwner, users.RoleSuperAdmin)
+	superAdminOnly := middleware.SuperAdminOnly()
+
 	// WebSocket routes
 	wsGroup := app.Group("/ws")
-	wsGroup.Get("/stats", wsHandler.WebSocketUpgrade, websocket.New(wsHandler.HandleWebSocket))
-	wsGroup.Get("/status", wsHandler.GetStats)
-	wsGroup.Get("/clinic/:clinicId/status", wsHandler.GetClinicStats)
-	wsGroup.Post("/broadcast", wsHandler.BroadcastMessage)
+	wsGroup.Get("/stats", staff, middleware.RequireClinic(middleware.ClinicQuery("clinic_id")), wsHandler.WebSocketUpgrade, websocket.New(wsHandler.HandleWebSocket))
+	wsGroup.Get("/status", superAdminOnly, wsHandler.GetStats)
+	wsGroup.Get("/clinic/:clinicId/status", staff, middleware.RequireClinic(middleware.ClinicParam("clinicId")), wsHandler.GetClinicStats)
+	wsGroup.Post("/broadcast", superAdminOnly, wsHandler.BroadcastMessage)
 
 	// Routes
 
 	// Physician routes
 	physicianGroup := app.Group("/physician")
-	physicianGroup.Post("/register", physicianHandler.RegisterPhysician)
-	physicianGroup.Patch("/update/:id", physicianHandler.UpdatePhysician)
-	physicianGroup.Get("/getAllPaginated/", physicianHandler.GetAllPhysiciansPaginated)
-	physicianGroup.Get("/getAll/", physicianHandler.GetAllPhysicians)
-	physicianGroup.Get("/:id", physicianHandler.GetPhysicianById)
+	physicianGroup.Post("/register", management, middleware.RequireClinic(middleware.ClinicBody("clinic_id")), physicianHandler.RegisterPhysician)
+	physicianGroup.Patch("/update/:id", middleware.RequireSelfOrRoles("id", users.RoleOwner, users.RoleSuperAdmin), middleware.RequireClinic(middleware.UserParam("id")), physicianHandler.UpdatePhysician)
+	physicianGroup.Get("/getAllPaginated/", staff, physicianHandler.GetAllPhysiciansPaginated)
+	physicianGroup.Get("/getAll/", staff, physicianHandler.GetAllPhysicians)
+	physicianGroup.Get("/:id", anyRole, physicianHandler.GetPhysicianById)
 
 	//Clinical routes
 	clinicGroup := app.Group("/clinic")
-	clinicGroup.Post("/register", clinicHandler.CreateClinical)
-	clinicGroup.Post("/create-eps", clinicHandler.CreateEps)
-	clinicGroup.Get("/get-eps", clinicHandler.GetAllEps)
-	clinicGroup.Post("/create-services", clinicHandler.CreateServices)
-	clinicGroup.Get("/get-services", clinicHandler.GetAllServices)
-	clinicGroup.Get("/by-owner/:ownerId", clinicHandler.GetClinicByOwnerID)
-	clinicGroup.Get("/:clinicId", clinicHandler.GetClinicByID)
-	clinicGroup.Post("/assign-services", clinicHandler.AssignServicesToClinic)
-	clinicGroup.Get("/by-eps/:epsId", clinicHandler.GetClinicsByEps)
-	clinicGroup.Get("/personnel/:clinicId", clinicHandler.GetClinicPersonnel)
-	clinicGroup.Get("/patients/:clinicId", patientHandler.GetPatientByClinicId)
+	clinicGroup.Post("/register", clinicHandler.CreateClinical) // public: clinic onboarding
+	clinicGroup.Post("/create-eps", superAdminOnly, clinicHandler.CreateEps)
+	clinicGroup.Get("/get-eps", clinicHandler.GetAllEps) // public catalog
+	clinicGroup.Post("/create-services", superAdminOnly, clinicHandler.CreateServices)
+	clinicGroup.Get("/get-services", clinicHandler.GetAllServices) // public catalog
+	clinicGroup.Get("/by-owner/:ownerId", middleware.RequireSelfOrRoles("ownerId", users.RoleSuperAdmin), clinicHandler.GetClinicByOwnerID)
+	clinicGroup.Get("/:clinicId", anyRole, middleware.RequireClinic(middleware.ClinicParam("clinicId")), clinicHandler.GetClinicByID)
+	clinicGroup.Put("/:clinicId/timezone", management, middleware.RequireClinic(middleware.ClinicParam("clinicId")), clinicHandler.UpdateClinicTimezone)
+	clinicGroup.Get("/:clinicId/booking-policy", management, middleware.RequireClinic(middleware.ClinicParam("clinicId")), appointmentHandler.GetBookingPolicy)
+	clinicGroup.Put("/:clinicId/booking-policy", management, middleware.RequireClinic(middleware.ClinicParam("clinicId")), appointmentHandler.SetBookingPolicy)
+	clinicGroup.Post("/assign-services", management, middleware.RequireClinic(middleware.ClinicBody("clinic_id")), clinicHandler.AssignServicesToClinic)
+	clinicGroup.Get("/by-eps/:epsId", anyRole, clinicHandler.GetClinicsByEps)
+	clinicGroup.Get("/personnel/:clinicId", staff, middleware.RequireClinic(middleware.ClinicParam("clinicId")), clinicHandler.GetClinicPersonnel)
+	clinicGroup.Get("/patients/:clinicId", staff, middleware.RequireClinic(middleware.ClinicParam("clinicId")), patientHandler.GetPatientByClinicId)
 
 	// Medical History routes
 	medicalHistoryGroup := app.Group("/medical-history")
-	medicalHistoryGroup.Get("/patient/:patientId", clinicHandler.GetMedicalHistoryByPatientID)
-	medicalHistoryGroup.Post("/create", clinicHandler.CreateMedicalHistory)
-	medicalHistoryGroup.Post("/consultation/create", clinicHandler.CreateConsultation)
-	medicalHistoryGroup.Put("/update/:historyId", clinicHandler.UpdateMedicalHistory)
-	medicalHistoryGroup.Get("/clinic/:clinicId", clinicHandler.GetClinicMedicalHistoriesPaginated)
+	medicalHistoryGroup.Get("/patient/:patientId", middleware.Audit(audit.ResourceMedicalHistory), recordReaders, middleware.RequireClinic(middleware.PatientParam("patientId")), clinicHandler.GetMedicalHistoryByPatientID)
+	medicalHistoryGroup.Post("/create", middleware.Audit(audit.ResourceMedicalHistory), clinicians, middleware.RequireClinic(middleware.PatientBody("patient_id")), clinicHandler.CreateMedicalHistory)
+	medicalHistoryGroup.Post("/consultation/create", middleware.Audit(audit.ResourceConsultation), clinicians, middleware.RequireClinic(middleware.PatientBody("patient_id")), clinicHandler.CreateConsultation)
+	medicalHistoryGroup.Put("/update/:historyId", middleware.Audit(audit.ResourceMedicalHistory), clinicians, middleware.RequireClinic(middleware.MedicalHistoryParam("historyId")), clinicHandler.UpdateMedicalHistory)
+	medicalHistoryGroup.Get("/versions/:historyId", middleware.Audit(audit.ResourceMedicalHistory), recordReaders, middleware.RequireClinic(middleware.MedicalHistoryParam("historyId")), clinicHandler.GetMedicalHistoryVersions)
+	medicalHistoryGroup.Get("/versions/:historyId/diff", middleware.Audit(audit.ResourceMedicalHistory), recordReaders, middleware.RequireClinic(middleware.MedicalHistoryParam("historyId")), clinicHandler.DiffMedicalHistoryVersions)
+	medicalHistoryGroup.Put("/consultation/update/:consultationId", middleware.Audit(audit.ResourceConsultation), clinicians, middleware.RequireClinic(middleware.ConsultationParam("consultationId")), clinicHandler.AmendConsultation)
+	medicalHistoryGroup.Get("/consultation/versions/:consultationId", middleware.Audit(audit.ResourceConsultation), recordReaders, middleware.RequireClinic(middleware.ConsultationParam("consultationId")), clinicHandler.GetConsultationVersions)
+	medicalHistoryGroup.Get("/consultation/versions/:consultationId/diff", middleware.Audit(audit.ResourceConsultation), recordReaders, middleware.RequireClinic(middleware.ConsultationParam("consultationId")), clinicHandler.DiffConsultationVersions)
+	medicalHistoryGroup.Get("/clinic/:clinicId", middleware.Audit(audit.ResourceMedicalHistory), middleware.RequireRoles(users.RolePhysician, users.RoleOwner, users.RoleSuperAdmin), middleware.RequireClinic(middleware.ClinicParam("clinicId")), clinicHandler.GetClinicMedicalHistoriesPaginated)
 
 	// Document management routes
-	medicalHistoryGroup.Post("/documents/add", clinicHandler.AddDocumentsToMedicalHistory)
-	medicalHistoryGroup.Post("/consultation/documents/add", clinicHandler.AddDocumentsToConsultation)
-	medicalHistoryGroup.Get("/documents/:medicalHistoryId", clinicHandler.GetDocumentsByMedicalHistory)
-	medicalHistoryGroup.Get("/consultation/documents/:consultationId", clinicHandler.GetDocumentsByConsultation)
+	medicalHistoryGroup.Post("/documents/add", middleware.Audit(audit.ResourceDocument), clinicians, middleware.RequireClinic(middleware.MedicalHistoryBody("medical_history_id")), clinicHandler.AddDocumentsToMedicalHistory)
+	medicalHistoryGroup.Post("/consultation/documents/add", middleware.Audit(audit.ResourceDocument), clinicians, middleware.RequireClinic(middleware.ConsultationBody("consultation_id")), clinicHandler.AddDocumentsToConsultation)
+	medicalHistoryGroup.Get("/documents/:medicalHistoryId", middleware.Audit(audit.ResourceDocument), recordReaders, middleware.RequireClinic(middleware.MedicalHistoryParam("medicalHistoryId")), clinicHandler.GetDocumentsByMedicalHistory)
+	medicalHistoryGroup.Get("/consultation/documents/:consultationId", middleware.Audit(audit.ResourceDocument), recordReaders, middleware.RequireClinic(middleware.ConsultationParam("consultationId")), clinicHandler.GetDocumentsByConsultation)
+
+	// Audit log routes
+	auditGroup := app.Group("/audit")
+	auditGroup.Get("/logs", management, middleware.RequireClinic(middleware.ClinicQuery("clinic_id")), auditHandler.SearchAuditLog)
+	auditGroup.Get("/me", middleware.RequireRoles(users.RolePatient), auditHandler.GetOwnRecordAccesses)
 
 	//Patient routes
 	patientGroup := app.Group("/patient")
-	patientGroup.Post("/register", patientHandler.RegisterPatient)
-	patientGroup.Get("/getAllPaginated", patientHandler.GetAllPatientsPaginated)
-	patientGroup.Get("/getAll", patientHandler.GetAllPatients)
-	patientGroup.Get("/getByClinicId/:clinicId", patientHandler.GetPatientByClinicId)
-	patientGroup.Patch("/update/:id", patientHandler.UpdatePatient)
-	patientGroup.Post("/delete/:id", patientHandler.SoftDeletePatient)
+	patientGroup.Post("/register", patientHandler.RegisterPatient) // public: patient self sign-up
+	patientGroup.Get("/getAllPaginated", superAdminOnly, patientHandler.GetAllPatientsPaginated)
+	patientGroup.Get("/getAll", superAdminOnly, patientHandler.GetAllPatients)
+	patientGroup.Get("/getByClinicId/:clinicId", staff, middleware.RequireClinic(middleware.ClinicParam("clinicId")), patientHandler.GetPatientByClinicId)
+	patientGroup.Patch("/update/:id", middleware.RequireSelfOrRoles("id", users.RoleReceptionist, users.RoleOwner, users.RoleSuperAdmin), middleware.RequireClinic(middleware.UserParam("id")), patientHandler.UpdatePatient)
+	patientGroup.Post("/delete/:id", frontDesk, middleware.RequireClinic(middleware.UserParam("id")), patientHandler.SoftDeletePatient)
 
 	// Receptionist routes
 	receptionistGroup := app.Group("/receptionist")
-	receptionistGroup.Post("/register", receptionistHandler.RegisterReceptionist)
-	receptionistGroup.Patch("/update/:id", receptionistHandler.UpdateReceptionist)
-	receptionistGroup.Get("/getAll", receptionistHandler.GetAllReceptionistsPaginated)
+	receptionistGroup.Post("/register", management, middleware.RequireClinic(middleware.ClinicBody("clinic_id")), receptionistHandler.RegisterReceptionist)
+	receptionistGroup.Patch("/update/:id", middleware.RequireSelfOrRoles("id", users.RoleOwner, users.RoleSuperAdmin), middleware.RequireClinic(middleware.UserParam("id")), receptionistHandler.UpdateReceptionist)
+	receptionistGroup.Get("/getAll", superAdminOnly, receptionistHandler.GetAllReceptionistsPaginated)
 
 	// Lab Technician routes
 
 	// Clinic Owner Routes
 	clinicOwnerGroup := app.Group("/clinic-owner")
-	clinicOwnerGroup.Post("/register", clinicOwnerHandler.CreateClinicOwner)
+	clinicOwnerGroup.Post("/register", superAdminOnly, clinicOwnerHandler.CreateClinicOwner)
 
 	superAdminGroup := app.Group("/super-admin")
-	superAdminGroup.Post("/register", superAdminHandler.RegisterSuperAdmin)
-	superAdminGroup.Use(middleware.SuperAdminOnly())
+	superAdminGroup.Post("/register", superAdminHandler.RegisterSuperAdmin) // public: bootstrap
+	superAdminGroup.Use(superAdminOnly)
 	superAdminGroup.Patch("/update/:id", superAdminHandler.UpdateSuperAdmin)
 	superAdminGroup.Get("/:id", superAdminHandler.GetSuperAdminByID)
 	superAdminGroup.Get("/", superAdminHandler.GetAllSuperAdminsPaginated)
@@ -192,30 +287,99 @@ func main() {
 
 	// Appointments routes
 	appointmentGroup := app.Group("/appointments")
-	//appointmentGroup.Use(middleware.JWTProtected())
-	appointmentGroup.Post("/create", appointmentHandler.CreateAppointment)
-	appointmentGroup.Get("/getAll", appointmentHandler.GetAllAppointments)
-	appointmentGroup.Patch("/updateStatus/:id", appointmentHandler.UpdateAppointmentStatus)
-	appointmentGroup.Get("/getAllByMedicId/:id", appointmentHandler.GetAllAppointmentsByMedicId)
-	appointmentGroup.Get("/getAllByUserId/:id", appointmentHandler.GetAllAppointmentsByUserId)
-	appointmentGroup.Patch("/cancel/:id", appointmentHandler.CancelAppointment)
-	appointmentGroup.Patch("/reschedule/:id", appointmentHandler.RescheduleAppointment)
+	appointmentGroup.Use(middleware.JWTProtected())
+	appointmentGroup.Post("/create", staff, middleware.RequireClinic(middleware.PatientBody("patient_id")), middleware.RequireClinic(middleware.PhysicianBody("physician_id")), appointmentHandler.CreateAppointment)
+	appointmentGroup.Get("/getAll", superAdminOnly, appointmentHandler.GetAllAppointments)
+	appointmentGroup.Get("/search", patientsAndStaff, appointmentHandler.SearchAppointments)
+	appointmentGroup.Patch("/updateStatus/:id", staff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.UpdateAppointmentStatus)
+	appointmentGroup.Get("/getAllByMedicId/:id", staff, middleware.RequireClinic(middleware.PhysicianParam("id")), appointmentHandler.GetAllAppointmentsByMedicId)
+	appointmentGroup.Get("/getAllByUserId/:id", patientsAndStaff, middleware.RequireClinic(middleware.UserParam("id")), appointmentHandler.GetAllAppointmentsByUserId)
+	appointmentGroup.Patch("/cancel/:id", staff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.CancelAppointment)
+	appointmentGroup.Patch("/reschedule/:id", staff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.RescheduleAppointment)
+	appointmentGroup.Get("/available-slots", staff, middleware.RequireClinic(middleware.PhysicianQuery("physician_id")), appointmentHandler.GetAvailableSlots)
+	appointmentGroup.Get("/availability/:id", staff, middleware.RequireClinic(middleware.PhysicianParam("id")), appointmentHandler.GetAvailability)
+	appointmentGroup.Put("/availability/:id", staff, middleware.RequireClinic(middleware.PhysicianParam("id")), appointmentHandler.SetAvailability)
+	appointmentGroup.Get("/availability/:id/exceptions", staff, middleware.RequireClinic(middleware.PhysicianParam("id")), appointmentHandler.GetAvailabilityExceptions)
+	appointmentGroup.Post("/availability/:id/exceptions", staff, middleware.RequireClinic(middleware.PhysicianParam("id")), appointmentHandler.AddAvailabilityException)
+	appointmentGroup.Delete("/availability/:id/exceptions/:exceptionId", staff, middleware.RequireClinic(middleware.PhysicianParam("id")), appointmentHandler.DeleteAvailabilityException)
+	appointmentGroup.Get("/:id/history", patientsAndStaff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.GetStatusHistory)
+	appointmentGroup.Get("/:id/series", patientsAndStaff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.GetSeries)
+	appointmentGroup.Get("/:id/consultation", middleware.Audit(audit.ResourceConsultation), recordReaders, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.GetAppointmentConsultation)
+	appointmentGroup.Post("/waitlist", patientsAndStaff, middleware.RequireClinic(middleware.PatientBody("patient_id")), appointmentHandler.JoinWaitlist)
+	appointmentGroup.Get("/waitlist", staff, middleware.RequireClinic(middleware.ClinicQuery("clinic_id")), appointmentHandler.GetClinicWaitlist)
+	appointmentGroup.Delete("/waitlist/:id", patientsAndStaff, middleware.RequireClinic(middleware.WaitlistParam("id")), appointmentHandler.LeaveWaitlist)
+	appointmentGroup.Get("/reliability/:patientId", staff, middleware.RequireClinic(middleware.PatientParam("patientId")), appointmentHandler.GetPatientReliability)
+	appointmentGroup.Get("/reports/no-shows", staff, middleware.RequireClinic(middleware.ClinicQuery("clinic_id")), appointmentHandler.GetNoShowReport)
+	appointmentGroup.Post("/:id/check-in", frontDesk, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.CheckIn)
+	appointmentGroup.Patch("/:id/triage", staff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.SetTriagePriority)
+	appointmentGroup.Get("/queue/metrics", staff, middleware.RequireClinic(middleware.ClinicQuery("clinic_id")), appointmentHandler.GetWaitMetrics)
+	appointmentGroup.Get("/queue/:physicianId", staff, middleware.RequireClinic(middleware.PhysicianParam("physicianId")), appointmentHandler.GetPhysicianQueue)
+	appointmentGroup.Post("/queue/:physicianId/next", staff, middleware.RequireClinic(middleware.PhysicianParam("physicianId")), appointmentHandler.CallNextPatient)
+	feedOwners := middleware.RequireRoles(users.RolePhysician, users.RolePatient)
+	appointmentGroup.Post("/calendar-feeds", feedOwners, appointmentHandler.CreateCalendarFeed)
+	appointmentGroup.Get("/calendar-feeds", feedOwners, appointmentHandler.GetCalendarFeeds)
+	appointmentGroup.Delete("/calendar-feeds/:id", feedOwners, appointmentHandler.RevokeCalendarFeed)
+	// Registered last so it does not shadow the fixed paths above.
+	appointmentGroup.Get("/:id", patientsAndStaff, middleware.RequireClinic(middleware.AppointmentParam("id")), appointmentHandler.GetAppointment)
+
+	// Patient self-service portal: every route acts on the caller's own records.
+	portalGroup := app.Group("/portal")
+	portalGroup.Use(middleware.JWTProtected(), middleware.RequireRoles(users.RolePatient))
+	portalGroup.Get("/clinics", clinicHandler.SearchClinics)
+	portalGroup.Get("/clinics/:clinicId/physicians", appointmentHandler.GetClinicPhysicians)
+	portalGroup.Get("/clinics/:clinicId/booking-policy", appointmentHandler.GetBookingPolicy)
+	portalGroup.Get("/physicians/:id/slots", appointmentHandler.GetBookableSlots)
+	portalGroup.Get("/appointments", appointmentHandler.SearchAppointments)
+	portalGroup.Post("/appointments", appointmentHandler.BookOwnAppointment)
+	portalGroup.Patch("/appointments/:id/reschedule", appointmentHandler.RescheduleOwnAppointment)
+	portalGroup.Patch("/appointments/:id/cancel", appointmentHandler.CancelOwnAppointment)
+
+	// Waitlist offers are answered from the emailed link.
+	waitlistGroup := app.Group("/waitlist")
+	waitlistGroup.Post("/offers/claim", appointmentHandler.ClaimOffer)     // public, authenticated by the emailed token
+	waitlistGroup.Post("/offers/decline", appointmentHandler.DeclineOffer) // public, authenticated by the emailed token
+
+	// Reminder emails link here to confirm attendance or cancel.
+	reminderGroup := app.Group("/reminders")
+	reminderGroup.Post("/confirm", appointmentHandler.ConfirmAttendance) // public, authenticated by the emailed token
+	reminderGroup.Post("/cancel", appointmentHandler.CancelFromReminder) // public, authenticated by the emailed token
+
+	// Calendar apps poll subscribed feeds without signing in.
+	app.Get("/calendar/:token", appointmentHandler.GetCalendarFeed) // public, authenticated by the feed token
+
+	app.Get("/.well-known/jwks.json", authHandler.JWKS) // public
 
 	// Auth routes
 	authGroup := app.Group("/auth")
-	authGroup.Post("/login", authHandler.Login)
-	authGroup.Post("/logout", authHandler.Logout)
-	authGroup.Get("/verify-token", authHandler.VerifyToken)
-	authGroup.Get("/user/:id", authHandler.GetUserDetails)
-	authGroup.Get("/user/:id/login-activities", authHandler.GetUserLoginActivities)
-	authGroup.Get("/user/:id/exists", authHandler.CheckUserExists)
-	authGroup.Delete("/user/:id", authHandler.DeleteUserCompletely)
-	authGroup.Patch("/user/:id/deactivate", authHandler.DeactivateUser)
-	authGroup.Patch("/user/:id/reactivate", authHandler.ReactivateUser)
+	authGroup.Post("/login", authHandler.Login)                                   // public
+	authGroup.Post("/logout", authHandler.Logout)                                 // public
+	authGroup.Post("/refresh-token/:refresh_token?", authHandler.RefreshTokenH)   // public, authenticated by the refresh token itself
+	authGroup.Get("/verify-token", authHandler.VerifyToken)                       // public, authenticated by the access token cookie itself
+	authGroup.Post("/forgot-password", authHandler.ForgotPassword)                // public
+	authGroup.Post("/reset-password", authHandler.ResetPassword)                  // public, authenticated by the emailed token
+	authGroup.Post("/set-password", authHandler.SetInitialPassword)               // public, authenticated by the emailed token
+	authGroup.Post("/verify-email", authHandler.VerifyEmail)                      // public, authenticated by the emailed token
+	authGroup.Post("/resend-verification", authHandler.ResendVerification)        // public
+	authGroup.Post("/mfa/verify", authHandler.VerifyMFA)                          // public, authenticated by the MFA challenge
+	authGroup.Post("/mfa/challenge/enroll", authHandler.EnrollMFAWithChallenge)   // public, authenticated by the MFA challenge
+	authGroup.Post("/mfa/challenge/confirm", authHandler.ConfirmMFAWithChallenge) // public, authenticated by the MFA challenge
+	authGroup.Get("/mfa/policies", superAdminOnly, authHandler.GetMFARolePolicies)
+	authGroup.Put("/mfa/policies/:role", superAdminOnly, authHandler.SetMFARolePolicy)
+	authGroup.Get("/user/:id", middleware.RequireSelfOrRoles("id", users.RoleOwner, users.RoleReceptionist, users.RolePhysician, users.RoleSuperAdmin), middleware.RequireClinic(middleware.UserParam("id")), authHandler.GetUserDetails)
+	authGroup.Get("/user/:id/login-activities", middleware.RequireSelfOrRoles("id", users.RoleSuperAdmin), authHandler.GetUserLoginActivities)
+	authGroup.Get("/user/:id/exists", anyRole, authHandler.CheckUserExists)
+	authGroup.Delete("/user/:id", superAdminOnly, authHandler.DeleteUserCompletely)
+	authGroup.Patch("/user/:id/deactivate", management, middleware.RequireClinic(middleware.UserParam("id")), authHandler.DeactivateUser)
+	authGroup.Patch("/user/:id/reactivate", management, middleware.RequireClinic(middleware.UserParam("id")), authHandler.ReactivateUser)
 
 	authGroup.Use(middleware.JWTProtected())
 	authGroup.Post("/change-password", authHandler.ChangePassword)
-	authGroup.Post("/refresh-token/:refresh_token", authHandler.RefreshTokenH)
+	authGroup.Post("/mfa/enroll", authHandler.EnrollMFA)
+	authGroup.Post("/mfa/confirm", authHandler.ConfirmMFA)
+	authGroup.Post("/mfa/disable", authHandler.DisableMFA)
+	authGroup.Get("/sessions", authHandler.ListSessions)
+	authGroup.Delete("/sessions", authHandler.RevokeOtherSessions)
+	authGroup.Delete("/sessions/:id", authHandler.RevokeSession)
 
 	profile := app.Group("/profile")
 	profile.Use(middleware.JWTProtected())
@@ -4,6 +4,9 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func LoadEnv() {
@@ -15,3 +18,60 @@ func LoadEnv() {
 func GetEnv(key string) string {
 	return os.Getenv(key)
 }
+
+// GetEnvInt reads key as a non-negative integer, returning fallback when it is
+// unset or invalid.
+func GetEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvDuration reads key as a time.Duration such as "15m", returning
+// fallback when it is unset or invalid.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value >= 0 {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvBool reads key as a boolean, returning fallback when it is unset or
+// invalid.
+func GetEnvBool(key string, fallback bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvList reads key as a comma-separated list, skipping empty entries.
+func GetEnvList(key string) []string {
+	var values []string
+	for _, part := range strings.Split(os.Getenv(key), ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// GetEnvDurations reads key as a comma-separated list of durations such as
+// "48h,2h", returning fallback when it is unset or any entry is invalid.
+func GetEnvDurations(key string, fallback []time.Duration) []time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback
+	}
+
+	var values []time.Duration
+	for _, part := range strings.Split(raw, ",") {
+		value, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || value <= 0 {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(s Service) *Handler {
+	return &Handler{s}
+}
+
+// SearchAuditLog answers GET /audit/logs?clinic_id=&patient_id=&user_id=&action=&resource=&outcome=&from=&to=&page=&limit=.
+// Dates without a time are UTC days, and to includes its whole day.
+func (h *Handler) SearchAuditLog(c *fiber.Ctx) error {
+	filter := Filter{
+		ClinicID:  c.Query("clinic_id"),
+		PatientID: c.Query("patient_id"),
+		UserID:    c.Query("user_id"),
+		Action:    c.Query("action"),
+		Resource:  c.Query("resource"),
+		Outcome:   c.Query("outcome"),
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(c.Query("from"), false); err != nil {
+		return auditError(c, err)
+	}
+	if filter.To, err = parseTimeParam(c.Query("to"), true); err != nil {
+		return auditError(c, err)
+	}
+
+	result, err := h.service.Search(filter, c.QueryInt("page", 1), c.QueryInt("limit", 20))
+	if err != nil {
+		return auditError(c, err)
+	}
+	return c.JSON(result)
+}
+
+// GetOwnRecordAccesses answers GET /audit/me for the signed-in patient.
+func (h *Handler) GetOwnRecordAccesses(c *fiber.Ctx) error {
+	userId, _ := c.Locals("user_id").(string)
+	result, err := h.service.GetOwnRecordAccesses(userId, c.QueryInt("page", 1), c.QueryInt("limit", 20))
+	if err != nil {
+		return auditError(c, err)
+	}
+	return c.JSON(result)
+}
+
+// parseTimeParam reads an RFC 3339 time or a date. A date is the start of
+// that day, or the start of the next one when endOfDay is set.
+func parseTimeParam(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: from and to must be RFC 3339 times or dates like 2006-01-02", ErrInvalidFilter)
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+func auditError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrInvalidFilter):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("invalid audit log filter")
+
+// Actions, named after what the request did to the record.
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Audited clinical resources.
+const (
+	ResourceMedicalHistory = "medical_history"
+	ResourceConsultation   = "consultation"
+	ResourceDocument       = "document"
+)
+
+// Outcomes of an audited request.
+const (
+	OutcomeSuccess = "success"
+	// OutcomeDenied is an authenticated request refused by an access policy.
+	OutcomeDenied = "denied"
+	OutcomeFailed = "failed"
+)
+
+// Entry is one request of a signed-in user to clinical data. Entries are only
+// ever added; the database rejects changing or deleting them.
+type Entry struct {
+	ID       string `gorm:"primaryKey" json:"id"`
+	UserID   string `gorm:"not null;index" json:"user_id"`
+	UserRole string `json:"user_role"`
+	// PatientID is the patient whose data was requested, when the request
+	// named one patient's data. A request listing several patients is
+	// recorded once for each patient it returned.
+	PatientID string `gorm:"index" json:"patient_id"`
+	ClinicID  string `gorm:"index" json:"clinic_id"`
+	Action    string `gorm:"not null" json:"action"`
+	Resource  string `gorm:"not null" json:"resource"`
+	// ResourceID is the record the request named, when it named a record of
+	// Resource; Path always has the full request.
+	ResourceID string    `json:"resource_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Status     int       `json:"status"`
+	Outcome    string    `gorm:"not null" json:"outcome"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+func (Entry) TableName() string {
+	return "audit_log"
+}
+
+// Filter narrows an audit log search. Empty fields do not filter.
+type Filter struct {
+	ClinicID  string
+	PatientID string
+	UserID    string
+	Action    string
+	Resource  string
+	Outcome   string
+	From      time.Time
+	To        time.Time
+}
+
+// Access is an entry as shown to the patient whose record was accessed.
+type Access struct {
+	ID         string    `json:"id"`
+	UserName   string    `json:"user_name"`
+	UserRole   string    `json:"user_role"`
+	Action     string    `json:"action"`
+	Resource   string    `json:"resource"`
+	ResourceID string    `json:"resource_id"`
+	Outcome    string    `json:"outcome"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OutcomeFor classifies the HTTP status a request was answered with.
+func OutcomeFor(status int) string {
+	switch {
+	case status < 400:
+		return OutcomeSuccess
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	default:
+		return OutcomeFailed
+	}
+}
+
+// ActionFor is the action of a request with the given HTTP method.
+func ActionFor(method string) string {
+	switch method {
+	case "POST":
+		return ActionCreate
+	case "PUT", "PATCH":
+		return ActionUpdate
+	case "DELETE":
+		return ActionDelete
+	default:
+		return ActionRead
+	}
+}
@@ -0,0 +1,158 @@
+package audit
+
+import (
+	"Altheia-Backend/internal/users"
+	"fmt"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	Record(entry *Entry) error
+	Search(filter Filter, page, limit int) (users.Pagination, error)
+	GetPatientAccesses(patientId, excludeUserId string, page, limit int) (users.Pagination, error)
+	GetPatientIdByUserId(userId string) (string, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Record(entry *Entry) error {
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+	if err := r.db.Create(entry).Error; err != nil {
+		return fmt.Errorf("error al registrar el acceso en la auditoría: %w", err)
+	}
+	return nil
+}
+
+// Search returns the entries matching filter, newest first.
+func (r *repository) Search(filter Filter, page, limit int) (users.Pagination, error) {
+	pagination := users.Pagination{
+		Limit: limit,
+		Page:  page,
+		Sort:  "created_at desc",
+	}
+
+	if err := r.matching(filter).Count(&pagination.Total).Error; err != nil {
+		return users.Pagination{}, fmt.Errorf("error al contar los registros de auditoría: %w", err)
+	}
+
+	entries := []Entry{}
+	err := r.matching(filter).
+		Order("created_at DESC, id").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&entries).Error
+	if err != nil {
+		return users.Pagination{}, fmt.Errorf("error al obtener los registros de auditoría: %w", err)
+	}
+	pagination.Result = entries
+	return pagination, nil
+}
+
+// matching selects the entries matching filter.
+func (r *repository) matching(filter Filter) *gorm.DB {
+	query := r.db.Model(&Entry{})
+	for column, value := range map[string]string{
+		"clinic_id":  filter.ClinicID,
+		"patient_id": filter.PatientID,
+		"user_id":    filter.UserID,
+		"action":     filter.Action,
+		"resource":   filter.Resource,
+		"outcome":    filter.Outcome,
+	} {
+		if value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	return query
+}
+
+// GetPatientAccesses returns the requests to the patient's data, newest
+// first, leaving out those made by excludeUserId.
+func (r *repository) GetPatientAccesses(patientId, excludeUserId string, page, limit int) (users.Pagination, error) {
+	pagination := users.Pagination{
+		Limit: limit,
+		Page:  page,
+		Sort:  "created_at desc",
+	}
+
+	accessesTo := func() *gorm.DB {
+		query := r.db.Table("audit_log al").Where("al.patient_id = ?", patientId)
+		if excludeUserId != "" {
+			query = query.Where("al.user_id <> ?", excludeUserId)
+		}
+		return query
+	}
+
+	if err := accessesTo().Count(&pagination.Total).Error; err != nil {
+		return users.Pagination{}, fmt.Errorf("error al contar los accesos a la historia clínica: %w", err)
+	}
+
+	accesses := []Access{}
+	err := accessesTo().
+		Select(`al.id, COALESCE(u.name, '') AS user_name, al.user_role, al.action,
+			al.resource, al.resource_id, al.outcome, al.created_at`).
+		Joins("LEFT JOIN users u ON u.id = al.user_id").
+		Order("al.created_at DESC, al.id").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Scan(&accesses).Error
+	if err != nil {
+		return users.Pagination{}, fmt.Errorf("error al obtener los accesos a la historia clínica: %w", err)
+	}
+	pagination.Result = accesses
+	return pagination, nil
+}
+
+func (r *repository) GetPatientIdByUserId(userId string) (string, error) {
+	var patient users.Patient
+	if err := r.db.Select("id").Where("user_id = ?", userId).First(&patient).Error; err != nil {
+		return "", err
+	}
+	return patient.ID, nil
+}
+
+// EnsureAppendOnly installs the trigger that keeps audit log entries from
+// being changed or deleted. It is safe to run on every start.
+func EnsureAppendOnly(db *gorm.DB) error {
+	err := db.Exec(`CREATE OR REPLACE FUNCTION reject_audit_log_change() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'el registro de auditoría no se puede modificar ni eliminar';
+		END;
+		$$ LANGUAGE plpgsql`).Error
+	if err != nil {
+		return fmt.Errorf("error al preparar el registro de auditoría: %w", err)
+	}
+
+	var exists bool
+	if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'audit_log_append_only')`).Scan(&exists).Error; err != nil {
+		return fmt.Errorf("error al verificar el disparador audit_log_append_only: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	err = db.Exec(`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+		FOR EACH ROW EXECUTE FUNCTION reject_audit_log_change()`).Error
+	if err != nil {
+		return fmt.Errorf("error al crear el disparador audit_log_append_only: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package audit
+
+import "github.com/gofiber/fiber/v2"
+
+// patientsLocal holds the patients whose data a listing request returned.
+const patientsLocal = "audited_patients"
+
+// SetPatients names the patients whose data the response of a listing request
+// carries. The request is then audited once per patient, so it shows in each
+// patient's access history.
+func SetPatients(c *fiber.Ctx, patientIds []string) {
+	c.Locals(patientsLocal, patientIds)
+}
+
+// Patients returns the patients named with SetPatients, if any.
+func Patients(c *fiber.Ctx) []string {
+	patientIds, _ := c.Locals(patientsLocal).([]string)
+	return patientIds
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"Altheia-Backend/internal/users"
+	"fmt"
+)
+
+// maxPageSize bounds the entries returned per page.
+const maxPageSize = 100
+
+type Service interface {
+	Search(filter Filter, page, limit int) (users.Pagination, error)
+	GetOwnRecordAccesses(userId string, page, limit int) (users.Pagination, error)
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(r Repository) Service {
+	return &service{repo: r}
+}
+
+func (s *service) Search(filter Filter, page, limit int) (users.Pagination, error) {
+	if err := checkFilter(filter); err != nil {
+		return users.Pagination{}, err
+	}
+	page, limit = pageBounds(page, limit)
+	return s.repo.Search(filter, page, limit)
+}
+
+// GetOwnRecordAccesses lists who requested the data of the patient signed in
+// as userId. The patient's own requests are left out.
+func (s *service) GetOwnRecordAccesses(userId string, page, limit int) (users.Pagination, error) {
+	patientId, err := s.repo.GetPatientIdByUserId(userId)
+	if err != nil {
+		return users.Pagination{}, err
+	}
+	page, limit = pageBounds(page, limit)
+	return s.repo.GetPatientAccesses(patientId, userId, page, limit)
+}
+
+func checkFilter(filter Filter) error {
+	switch filter.Action {
+	case "", ActionRead, ActionCreate, ActionUpdate, ActionDelete:
+	default:
+		return fmt.Errorf("%w: action must be read, create, update or delete", ErrInvalidFilter)
+	}
+	switch filter.Outcome {
+	case "", OutcomeSuccess, OutcomeDenied, OutcomeFailed:
+	default:
+		return fmt.Errorf("%w: outcome must be success, denied or failed", ErrInvalidFilter)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return fmt.Errorf("%w: to must be after from", ErrInvalidFilter)
+	}
+	return nil
+}
+
+func pageBounds(page, limit int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > maxPageSize {
+		limit = 20
+	}
+	return page, limit
+}
@@ -3,7 +3,9 @@ package auth
 import (
 	"Altheia-Backend/internal/users"
 	"Altheia-Backend/pkg/utils"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"time"
 
@@ -25,71 +27,125 @@ func (h *Handler) Login(c *fiber.Ctx) error {
 	}
 
 	userAgent := c.Get("User-Agent")
-	ipAddress := utils.GetClientIP(
-		c.Get("X-Forwarded-For"),
-		c.Get("X-Real-IP"),
-		c.IP(),
-	)
+	ipAddress := c.IP()
 
 	var user UserInfo
 
 	user, accessToken, refreshToken, err := h.service.LoginWithActivity(data.Email, data.Password, userAgent, ipAddress)
 
-	if err != nil {
-		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
-	}
-
-	accessTokenCookie := fiber.Cookie{
-		Name:     "access_token",
-		Value:    accessToken,
-		Expires:  time.Now().Add(1 * time.Hour),
-		HTTPOnly: true,
-		SameSite: "Lax",
-		Secure:   false,
-		Path:     "/",
+	var challenge *MFAChallengeError
+	if errors.As(err, &challenge) {
+		return c.JSON(fiber.Map{
+			"mfa_required":        true,
+			"mfa_token":           challenge.Token,
+			"enrollment_required": challenge.EnrollmentRequired,
+		})
 	}
 
-	refreshTokenCookie := fiber.Cookie{
-		Name:     "refresh_token",
-		Value:    refreshToken,
-		Expires:  time.Now().Add(72 * time.Hour),
-		HTTPOnly: true,
-		SameSite: "Lax",
-		Secure:   false,
-		Path:     "/",
+	if err != nil {
+		return loginError(c, err)
 	}
 
-	c.Cookie(&accessTokenCookie)
-	c.Cookie(&refreshTokenCookie)
+	setAuthCookies(c, accessToken, refreshToken)
 
 	return c.JSON(fiber.Map{"accessToken": accessToken, "refreshToken": refreshToken, "user": user})
 }
 
 func (h *Handler) RefreshTokenH(c *fiber.Ctx) error {
-	c.AllParams()
-	refreshToken := c.Params("refresh_token")
-	accessToken, refreshToken, err := utils.RefreshToken(refreshToken)
+	refreshToken := c.Cookies("refresh_token")
+	if refreshToken == "" {
+		refreshToken = c.Params("refresh_token")
+	}
 
+	accessToken, refreshToken, err := h.service.RefreshTokens(refreshToken)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "cannot parse JSON"})
+		clearAuthCookies(c)
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	setAuthCookies(c, accessToken, refreshToken)
+
 	return c.JSON(fiber.Map{"refresh_token": refreshToken, "access_token": accessToken, "message": "token refreshed successfully"})
 }
 
 func (h *Handler) Logout(c *fiber.Ctx) error {
-	cookie := fiber.Cookie{
+	if err := h.service.Logout(c.Cookies("refresh_token")); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	clearAuthCookies(c)
+	return c.JSON(fiber.Map{"message": "logout successful"})
+}
+
+// loginError answers a failed login step. Throttled attempts get 429 with a
+// Retry-After header, expired passwords 403; anything else is 401.
+func loginError(c *fiber.Ctx, err error) error {
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) {
+		seconds := int(math.Ceil(throttled.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":       err.Error(),
+			"locked":      throttled.Locked,
+			"retry_after": seconds,
+		})
+	}
+	if errors.Is(err, ErrPasswordExpired) {
+		return c.Status(403).JSON(fiber.Map{"error": err.Error(), "password_expired": true})
+	}
+	return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+}
+
+// passwordError answers a request that set a password. Policy violations are
+// listed so the client can show them next to the field.
+func passwordError(c *fiber.Ctx, err error) error {
+	var policy *users.PasswordPolicyError
+	if errors.As(err, &policy) {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error(), "violations": policy.Violations})
+	}
+	return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+}
+
+func setAuthCookies(c *fiber.Ctx, accessToken, refreshToken string) {
+	c.Cookie(&fiber.Cookie{
 		Name:     "access_token",
-		Value:    "",
-		Expires:  time.Now().Add(-1 * time.Hour),
+		Value:    accessToken,
+		Expires:  time.Now().Add(utils.AccessTokenTTL()),
+		HTTPOnly: true,
+		SameSite: "Lax",
+		Secure:   false,
+		Path:     "/",
+	})
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Expires:  time.Now().Add(RefreshTokenTTL),
 		HTTPOnly: true,
 		SameSite: "Lax",
 		Secure:   false,
 		Path:     "/",
+	})
+}
+
+func clearAuthCookies(c *fiber.Ctx) {
+	for _, name := range []string{"access_token", "refresh_token"} {
+		c.Cookie(&fiber.Cookie{
+			Name:     name,
+			Value:    "",
+			Expires:  time.Now().Add(-1 * time.Hour),
+			HTTPOnly: true,
+			SameSite: "Lax",
+			Secure:   false,
+			Path:     "/",
+		})
 	}
+}
 
-	c.Cookie(&cookie)
-	return c.JSON(fiber.Map{"message": "logout successful"})
+// JWKS publishes the public keys that verify access tokens, so other services
+// can validate Altheia tokens without a shared secret.
+func (h *Handler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(utils.CurrentKeyring().JWKS())
 }
 
 func (h *Handler) VerifyToken(c *fiber.Ctx) error {
@@ -149,7 +205,7 @@ func (h *Handler) ChangePassword(c *fiber.Ctx) error {
 	}
 
 	if err := h.service.ChangePassword(userIDStr, request); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		return passwordError(c, err)
 	}
 
 	return c.JSON(fiber.Map{"message": "Password changed successfully"})
@@ -279,3 +335,288 @@ func (h *Handler) ReactivateUser(c *fiber.Ctx) error {
 		"status":    "active",
 	})
 }
+
+// VerifyMFA exchanges the challenge returned by Login and a TOTP or recovery
+// code for the session tokens.
+func (h *Handler) VerifyMFA(c *fiber.Ctx) error {
+	var request MFAVerifyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	userAgent := c.Get("User-Agent")
+	ipAddress := c.IP()
+
+	user, accessToken, refreshToken, err := h.service.VerifyMFA(request.MFAToken, request.Code, userAgent, ipAddress)
+	if err != nil {
+		return loginError(c, err)
+	}
+
+	setAuthCookies(c, accessToken, refreshToken)
+
+	return c.JSON(fiber.Map{"accessToken": accessToken, "refreshToken": refreshToken, "user": user})
+}
+
+func (h *Handler) EnrollMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	enrollment, err := h.service.EnrollMFA(userID)
+	if err != nil {
+		return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(enrollment)
+}
+
+func (h *Handler) ConfirmMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var request MFAVerifyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	userAgent := c.Get("User-Agent")
+	ipAddress := c.IP()
+
+	codes, err := h.service.ConfirmMFA(userID, request.Code, userAgent, ipAddress)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// EnrollMFAWithChallenge starts enrollment for a user whose role requires MFA
+// but who has not enrolled yet, authenticated by the login challenge.
+func (h *Handler) EnrollMFAWithChallenge(c *fiber.Ctx) error {
+	var request MFAVerifyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	userID, err := utils.ValidateMFAChallenge(request.MFAToken)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid or expired MFA challenge"})
+	}
+
+	enrollment, err := h.service.EnrollMFA(userID)
+	if err != nil {
+		return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(enrollment)
+}
+
+// ConfirmMFAWithChallenge confirms an enrollment started with
+// EnrollMFAWithChallenge and completes the login it belongs to.
+func (h *Handler) ConfirmMFAWithChallenge(c *fiber.Ctx) error {
+	var request MFAVerifyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	userID, err := utils.ValidateMFAChallenge(request.MFAToken)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid or expired MFA challenge"})
+	}
+
+	userAgent := c.Get("User-Agent")
+	ipAddress := c.IP()
+
+	codes, err := h.service.ConfirmMFA(userID, request.Code, userAgent, ipAddress)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	user, accessToken, refreshToken, err := h.service.completeLogin(userID, userAgent, ipAddress)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	setAuthCookies(c, accessToken, refreshToken)
+
+	return c.JSON(fiber.Map{
+		"accessToken":    accessToken,
+		"refreshToken":   refreshToken,
+		"user":           user,
+		"recovery_codes": codes,
+	})
+}
+
+func (h *Handler) DisableMFA(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	var request MFAVerifyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	userAgent := c.Get("User-Agent")
+	ipAddress := c.IP()
+
+	if err := h.service.DisableMFA(userID, request.Code, userAgent, ipAddress); err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+func (h *Handler) GetMFARolePolicies(c *fiber.Ctx) error {
+	policies, err := h.service.GetMFARolePolicies()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(policies)
+}
+
+func (h *Handler) SetMFARolePolicy(c *fiber.Ctx) error {
+	role := c.Params("role")
+
+	var request MFARolePolicyRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	updatedBy, _ := c.Locals("user_id").(string)
+	if err := h.service.SetMFARolePolicy(role, request.Required, updatedBy); err != nil {
+		return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "MFA policy updated",
+		"role":     role,
+		"required": request.Required,
+	})
+}
+
+// mfaError answers a failed MFA management request. Throttled attempts are
+// answered like throttled logins.
+func mfaError(c *fiber.Ctx, err error) error {
+	var throttled *ThrottledError
+	if errors.As(err, &throttled) {
+		return loginError(c, err)
+	}
+	return c.Status(mfaErrorStatus(err)).JSON(fiber.Map{"error": err.Error()})
+}
+
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidMFACode), errors.Is(err, ErrMFACodeReused):
+		return 401
+	case errors.Is(err, ErrMFAEnforced), errors.Is(err, ErrMFANotAvailable):
+		return 403
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		return 409
+	default:
+		return 400
+	}
+}
+
+func (h *Handler) ForgotPassword(c *fiber.Ctx) error {
+	var request ForgotPasswordRequest
+	if err := c.BodyParser(&request); err != nil || request.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Email is required"})
+	}
+
+	h.service.ForgotPassword(request.Email)
+	return c.JSON(fiber.Map{"message": "If the email is registered, a reset link has been sent"})
+}
+
+func (h *Handler) ResetPassword(c *fiber.Ctx) error {
+	var request ResetPasswordRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := h.service.ResetPassword(request.Token, request.NewPassword); err != nil {
+		return passwordError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Password reset successfully"})
+}
+
+func (h *Handler) SetInitialPassword(c *fiber.Ctx) error {
+	var request ResetPasswordRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := h.service.SetInitialPassword(request.Token, request.NewPassword); err != nil {
+		return passwordError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"message": "Password set successfully, your account is now active"})
+}
+
+func (h *Handler) VerifyEmail(c *fiber.Ctx) error {
+	var request VerifyEmailRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := h.service.VerifyEmail(request.Token); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"message": "Email verified successfully"})
+}
+
+func (h *Handler) ResendVerification(c *fiber.Ctx) error {
+	var request ForgotPasswordRequest
+	if err := c.BodyParser(&request); err != nil || request.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Email is required"})
+	}
+
+	h.service.ResendVerification(request.Email)
+	return c.JSON(fiber.Map{"message": "If the account is pending verification, a new link has been sent"})
+}
+
+func (h *Handler) ListSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	sessionID, _ := c.Locals("session_id").(string)
+
+	sessions, err := h.service.ListSessions(userID, sessionID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to retrieve sessions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+func (h *Handler) RevokeSession(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	sessionID, _ := c.Locals("session_id").(string)
+
+	target := c.Params("id")
+	if err := h.service.RevokeSession(userID, target); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if target == sessionID {
+		clearAuthCookies(c)
+	}
+
+	return c.JSON(fiber.Map{"message": "Session revoked successfully"})
+}
+
+func (h *Handler) RevokeOtherSessions(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	sessionID, _ := c.Locals("session_id").(string)
+
+	if err := h.service.RevokeOtherSessions(userID, sessionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"message": "Signed out of all other sessions"})
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"Altheia-Backend/internal/users"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// failingTokenRepository cannot store the tokens of emailed links.
+type failingTokenRepository struct {
+	*fakeRepository
+}
+
+func (failingTokenRepository) ReplaceUserToken(*users.UserToken) error {
+	return errors.New("database unavailable")
+}
+
+func TestAccountLinksDoNotRevealAccounts(t *testing.T) {
+	repo := newFakeRepository()
+	repo.users["patient-1"] = &users.User{ID: "patient-1", Name: "Ana", Rol: users.RolePatient, Email: "p@example.com"}
+	issueUserToken(t, repo, "patient-1", users.TokenPurposeEmailVerification, users.EmailVerificationTTL)
+
+	h := NewHandler(NewService(failingTokenRepository{repo}))
+	app := fiber.New()
+	app.Post("/forgot-password", h.ForgotPassword)
+	app.Post("/resend-verification", h.ResendVerification)
+
+	respond := func(path, email string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for _, path := range []string{"/forgot-password", "/resend-verification"} {
+		registeredStatus, registeredBody := respond(path, "p@example.com")
+		unknownStatus, unknownBody := respond(path, "nobody@example.com")
+		if registeredStatus != fiber.StatusOK || registeredStatus != unknownStatus || registeredBody != unknownBody {
+			t.Errorf("%s answered %d %s for a registered email whose link failed and %d %s for an unknown one, want the same success",
+				path, registeredStatus, registeredBody, unknownStatus, unknownBody)
+		}
+	}
+}
+
+func TestLoginThrottleIgnoresForwardedFor(t *testing.T) {
+	svc := &service{repo: newFakeRepository(), throttle: LoginThrottle{
+		Window:        time.Hour,
+		DelayAfter:    10,
+		MaxFailures:   10,
+		MaxIPFailures: 2,
+		Lockout:       time.Hour,
+	}}
+	// Immutable keeps the fake's recorded IPs from aliasing request buffers.
+	app := fiber.New(fiber.Config{Immutable: true})
+	app.Post("/login", NewHandler(svc).Login)
+
+	var status int
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":"nobody@example.com","password":"wrong"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		status = resp.StatusCode
+	}
+	if status != fiber.StatusTooManyRequests {
+		t.Errorf("third failed login from the same client with a new X-Forwarded-For answered %d, want %d", status, fiber.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	mfaIssuer         = "Altheia"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrInvalidMFACode    = errors.New("invalid verification code")
+	ErrMFACodeReused     = errors.New("verification code already used")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFAEnforced       = errors.New("two-factor authentication is required for your role")
+	ErrMFANotAvailable   = errors.New("two-factor authentication is only available for staff accounts")
+)
+
+// MFAChallengeError is returned by the password step of a login when the user
+// still has to present a second factor. Token is exchanged for the session
+// tokens through VerifyMFA, or through the enrollment endpoints when the role
+// requires MFA and the user has not enrolled yet.
+type MFAChallengeError struct {
+	Token              string
+	EnrollmentRequired bool
+}
+
+func (e *MFAChallengeError) Error() string {
+	return "two-factor authentication required"
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type MFARolePolicyRequest struct {
+	Required bool `json:"required"`
+}
+
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+func mfaEligible(role string) bool {
+	switch role {
+	case users.RolePhysician, users.RoleReceptionist, users.RoleOwner, users.RoleLabTechnician, users.RoleSuperAdmin:
+		return true
+	}
+	return false
+}
+
+// requireSecondFactor returns an *MFAChallengeError when user has enabled MFA
+// or its role enforces it.
+func (s *service) requireSecondFactor(user *users.User) error {
+	if !mfaEligible(user.Rol) {
+		return nil
+	}
+
+	enrolled := false
+	credential, err := s.repo.FindMFACredential(user.ID)
+	if err == nil {
+		enrolled = credential.ConfirmedAt != nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to load MFA credential: %v", err)
+	}
+
+	required := enrolled
+	if !enrolled {
+		required, err = s.repo.IsMFARequiredForRole(user.Rol)
+		if err != nil {
+			return fmt.Errorf("failed to load MFA policy: %v", err)
+		}
+	}
+	if !required {
+		return nil
+	}
+
+	token, err := utils.GenerateMFAChallenge(user.ID)
+	if err != nil {
+		return err
+	}
+	return &MFAChallengeError{Token: token, EnrollmentRequired: !enrolled}
+}
+
+// VerifyMFA completes a two-step login: it checks code, a TOTP code or an
+// unused recovery code, against the user behind challenge and starts a session.
+func (s *service) VerifyMFA(challenge, code, userAgent, ipAddress string) (UserInfo, string, string, error) {
+	userID, err := utils.ValidateMFAChallenge(challenge)
+	if err != nil {
+		return UserInfo{}, "", "", errors.New("invalid or expired MFA challenge")
+	}
+
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return UserInfo{}, "", "", errors.New("invalid credentials")
+	}
+
+	if err := s.checkAccountThrottle(user, userAgent, ipAddress); err != nil {
+		return UserInfo{}, "", "", err
+	}
+
+	credential, err := s.repo.FindMFACredential(userID)
+	if err != nil || credential.ConfirmedAt == nil {
+		return UserInfo{}, "", "", ErrMFANotEnrolled
+	}
+
+	if err := s.checkSecondFactor(credential, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFACodeReused) {
+			s.loginFailed(user, users.LoginFailureInvalidMFACode, userAgent, ipAddress)
+		}
+		return UserInfo{}, "", "", err
+	}
+
+	return s.completeLogin(userID, userAgent, ipAddress)
+}
+
+func (s *service) completeLogin(userID, userAgent, ipAddress string) (UserInfo, string, string, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return UserInfo{}, "", "", errors.New("invalid credentials")
+	}
+
+	if !user.Status {
+		return UserInfo{}, "", "", errors.New("account is deactivated. Please contact support")
+	}
+
+	return s.startSession(user, userAgent, ipAddress)
+}
+
+// checkSecondFactor accepts either a TOTP code, which can be used only once, or
+// a recovery code, which is consumed.
+func (s *service) checkSecondFactor(credential *users.MFACredential, code string) error {
+	if step, ok := utils.ValidateTOTP(credential.Secret, code, time.Now()); ok {
+		return s.repo.UseMFAStep(credential.UserID, step)
+	}
+
+	normalized := utils.NormalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidMFACode
+	}
+
+	if err := s.repo.UseRecoveryCode(credential.UserID, utils.HashToken(normalized)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// EnrollMFA generates a new TOTP secret for userID. The secret only takes
+// effect once confirmed with ConfirmMFA.
+func (s *service) EnrollMFA(userID string) (MFAEnrollment, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return MFAEnrollment{}, errors.New("user not found")
+	}
+
+	if !mfaEligible(user.Rol) {
+		return MFAEnrollment{}, ErrMFANotAvailable
+	}
+
+	existing, err := s.repo.FindMFACredential(userID)
+	if err == nil && existing.ConfirmedAt != nil {
+		return MFAEnrollment{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return MFAEnrollment{}, err
+	}
+
+	now := time.Now()
+	credential := &users.MFACredential{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.SaveMFACredential(credential); err != nil {
+		return MFAEnrollment{}, fmt.Errorf("failed to save MFA credential: %v", err)
+	}
+
+	return MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA activates a pending enrollment with a code from the
+// authenticator and returns the recovery codes. They are shown only once.
+// Wrong codes count towards the account lockout, as in VerifyMFA.
+func (s *service) ConfirmMFA(userID, code, userAgent, ipAddress string) ([]string, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	if err := s.checkAccountThrottle(user, userAgent, ipAddress); err != nil {
+		return nil, err
+	}
+
+	credential, err := s.repo.FindMFACredential(userID)
+	if err != nil {
+		return nil, errors.New("no pending MFA enrollment")
+	}
+
+	if credential.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := utils.ValidateTOTP(credential.Secret, code, time.Now())
+	if !ok {
+		s.loginFailed(user, users.LoginFailureInvalidMFACode, userAgent, ipAddress)
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]users.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, users.MFARecoveryCode{
+			ID:        utils.GenerateNanoID(),
+			UserID:    userID,
+			CodeHash:  utils.HashToken(utils.NormalizeRecoveryCode(code)),
+			CreatedAt: time.Now(),
+		})
+	}
+
+	if err := s.repo.ConfirmMFACredential(userID, step, records); err != nil {
+		return nil, fmt.Errorf("failed to confirm MFA: %v", err)
+	}
+
+	return codes, nil
+}
+
+// DisableMFA removes the second factor after checking a current code. Users
+// whose role enforces MFA cannot disable it. Wrong codes count towards the
+// account lockout, as in VerifyMFA.
+func (s *service) DisableMFA(userID, code, userAgent, ipAddress string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	if err := s.checkAccountThrottle(user, userAgent, ipAddress); err != nil {
+		return err
+	}
+
+	credential, err := s.repo.FindMFACredential(userID)
+	if err != nil || credential.ConfirmedAt == nil {
+		return ErrMFANotEnrolled
+	}
+
+	required, err := s.repo.IsMFARequiredForRole(user.Rol)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFAEnforced
+	}
+
+	if err := s.checkSecondFactor(credential, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFACodeReused) {
+			s.loginFailed(user, users.LoginFailureInvalidMFACode, userAgent, ipAddress)
+		}
+		return err
+	}
+
+	return s.repo.DeleteMFACredential(userID)
+}
+
+func (s *service) SetMFARolePolicy(role string, required bool, updatedBy string) error {
+	if !mfaEligible(role) {
+		return ErrMFANotAvailable
+	}
+
+	now := time.Now()
+	return s.repo.SaveMFARolePolicy(&users.MFARolePolicy{
+		Role:      role,
+		Required:  required,
+		UpdatedBy: updatedBy,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+func (s *service) GetMFARolePolicies() ([]users.MFARolePolicy, error) {
+	return s.repo.ListMFARolePolicies()
+}
@@ -4,6 +4,7 @@ import (
 	"Altheia-Backend/internal/clinical"
 	"Altheia-Backend/internal/clinical/appointments"
 	"Altheia-Backend/internal/users"
+	"errors"
 	"fmt"
 	"time"
 
@@ -15,13 +16,41 @@ type Repository interface {
 	FindByID(id string) (*users.User, error)
 	GetUserWithAllDetails(id string) (*users.User, error)
 	ChangePassword(userID string, newHashedPassword string) error
+	RecentPasswordHashes(userID string, limit int) ([]string, error)
+	RehashPassword(userID string, oldHash string, newHash string) error
 	UpdateLastLogin(userID string) error
 	CreateLoginActivity(activity *users.LoginActivity) error
+	CountLoginFailures(userID string, since time.Time) (LoginFailures, error)
+	CountIPLoginFailures(ipAddress string, since time.Time) (LoginFailures, error)
 	GetUserLoginActivities(userID string, limit int) ([]users.LoginActivity, error)
-	MarkAllSessionsAsInactive(userID string) error
+	IsSessionActive(sessionID string) (bool, error)
+	ListActiveSessions(userID string) ([]users.LoginActivity, error)
 	DeleteUserCompletely(userID string) error
 	DeactivateUser(userID string) error
 	ReactivateUser(userID string) error
+
+	CreateRefreshToken(token *users.RefreshToken) error
+	FindRefreshTokenByHash(hash string) (*users.RefreshToken, error)
+	RotateRefreshToken(current *users.RefreshToken, next *users.RefreshToken) error
+	RevokeSessionRefreshTokens(sessionID string, reason string) error
+	RevokeUserRefreshTokens(userID string, reason string) error
+	RevokeOtherSessions(userID string, keepSessionID string, reason string) error
+
+	FindMFACredential(userID string) (*users.MFACredential, error)
+	SaveMFACredential(credential *users.MFACredential) error
+	ConfirmMFACredential(userID string, step int64, codes []users.MFARecoveryCode) error
+	UseMFAStep(userID string, step int64) error
+	UseRecoveryCode(userID string, codeHash string) error
+	DeleteMFACredential(userID string) error
+	IsMFARequiredForRole(role string) (bool, error)
+	SaveMFARolePolicy(policy *users.MFARolePolicy) error
+	ListMFARolePolicies() ([]users.MFARolePolicy, error)
+
+	ReplaceUserToken(token *users.UserToken) error
+	FindUserToken(hash string, purpose string) (*users.UserToken, error)
+	ConsumeUserToken(hash string, purpose string) (*users.UserToken, error)
+	HasUserToken(userID string, purpose string) (bool, error)
+	MarkEmailVerified(userID string, activate bool) error
 }
 
 type repository struct {
@@ -34,25 +63,57 @@ func NewRepository(db *gorm.DB) Repository {
 
 func (r *repository) FindByEmail(email string) (*users.User, error) {
 	var user users.User
-	err := r.db.Preload("Patient").Preload("Physician").Preload("Receptionist").Preload("ClinicOwner").Preload("SuperAdmin").Where("email = ?", email).First(&user).Error
+	err := r.db.Preload("Patient").Preload("Physician").Preload("Receptionist").Preload("ClinicOwner").Preload("LabTechnician").Preload("SuperAdmin").Where("email = ?", email).First(&user).Error
 	return &user, err
 }
 
 func (r *repository) FindByID(id string) (*users.User, error) {
 	var user users.User
 	fmt.Print("ID del usuario desde repository: ", id)
-	err := r.db.Preload("Patient").Preload("Physician").Preload("Receptionist").Preload("ClinicOwner").Preload("SuperAdmin").Where("id = ?", id).First(&user).Error
+	err := r.db.Preload("Patient").Preload("Physician").Preload("Receptionist").Preload("ClinicOwner").Preload("LabTechnician").Preload("SuperAdmin").Where("id = ?", id).First(&user).Error
 	return &user, err
 }
 
 func (r *repository) GetUserWithAllDetails(id string) (*users.User, error) {
 	var user users.User
-	err := r.db.Preload("Patient").Preload("Physician").Preload("Receptionist").Preload("ClinicOwner").Preload("SuperAdmin").Where("id = ?", id).First(&user).Error
+	err := r.db.Preload("Patient").Preload("Physician").Preload("Receptionist").Preload("ClinicOwner").Preload("LabTechnician").Preload("SuperAdmin").Where("id = ?", id).First(&user).Error
 	return &user, err
 }
 
+// ChangePassword stores the new password hash and adds it to the user's
+// password history.
 func (r *repository) ChangePassword(userID string, newHashedPassword string) error {
-	return r.db.Model(&users.User{}).Where("id = ?", userID).Update("password", newHashedPassword).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users.User{}).Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"password":            newHashedPassword,
+				"password_changed_at": time.Now(),
+			}).Error; err != nil {
+			return err
+		}
+		return tx.Create(users.NewPasswordHistory(userID, newHashedPassword)).Error
+	})
+}
+
+// RehashPassword swaps the stored hash of an unchanged password for newHash.
+// It is not a password change: the history and change date are left alone,
+// and nothing happens if the password changed since oldHash was read.
+func (r *repository) RehashPassword(userID string, oldHash string, newHash string) error {
+	return r.db.Model(&users.User{}).
+		Where("id = ? AND password = ?", userID, oldHash).
+		Update("password", newHash).Error
+}
+
+// RecentPasswordHashes returns the hashes of the last limit passwords of
+// userID, newest first.
+func (r *repository) RecentPasswordHashes(userID string, limit int) ([]string, error) {
+	var hashes []string
+	err := r.db.Model(&users.PasswordHistory{}).
+		Where("user_id = ?", userID).
+		Order("created_at desc").
+		Limit(limit).
+		Pluck("password_hash", &hashes).Error
+	return hashes, err
 }
 
 func (r *repository) UpdateLastLogin(userID string) error {
@@ -60,7 +121,46 @@ func (r *repository) UpdateLastLogin(userID string) error {
 }
 
 func (r *repository) CreateLoginActivity(activity *users.LoginActivity) error {
-	return r.db.Create(activity).Error
+	// Select("*") keeps Success=false from being replaced by the column default.
+	return r.db.Select("*").Create(activity).Error
+}
+
+// countedFailures are the failure reasons that count towards throttling.
+// Attempts rejected because of a lockout are recorded but not counted, so an
+// attacker cannot keep an account locked indefinitely.
+var countedFailures = []string{users.LoginFailureInvalidCredentials, users.LoginFailureInvalidMFACode}
+
+// CountLoginFailures counts the failed attempts of userID after since and
+// after its last successful login.
+func (r *repository) CountLoginFailures(userID string, since time.Time) (LoginFailures, error) {
+	return r.countFailures(r.db.
+		Where("user_id = ?", userID).
+		Where("login_time > COALESCE((SELECT MAX(s.login_time) FROM login_activities s WHERE s.user_id = ? AND s.success = ?), ?)", userID, true, since),
+		since)
+}
+
+func (r *repository) CountIPLoginFailures(ipAddress string, since time.Time) (LoginFailures, error) {
+	return r.countFailures(r.db.Where("ip_address = ?", ipAddress), since)
+}
+
+func (r *repository) countFailures(scope *gorm.DB, since time.Time) (LoginFailures, error) {
+	var row struct {
+		Count int
+		Last  *time.Time
+	}
+	err := scope.Model(&users.LoginActivity{}).
+		Select("COUNT(*) AS count, MAX(login_time) AS last").
+		Where("success = ? AND failure_reason IN ? AND login_time > ?", false, countedFailures, since).
+		Scan(&row).Error
+	if err != nil {
+		return LoginFailures{}, err
+	}
+
+	failures := LoginFailures{Count: row.Count}
+	if row.Last != nil {
+		failures.Last = *row.Last
+	}
+	return failures, nil
 }
 
 func (r *repository) GetUserLoginActivities(userID string, limit int) ([]users.LoginActivity, error) {
@@ -69,8 +169,27 @@ func (r *repository) GetUserLoginActivities(userID string, limit int) ([]users.L
 	return activities, err
 }
 
-func (r *repository) MarkAllSessionsAsInactive(userID string) error {
-	return r.db.Model(&users.LoginActivity{}).Where("user_id = ?", userID).Update("is_current_session", false).Error
+func (r *repository) IsSessionActive(sessionID string) (bool, error) {
+	var count int64
+	err := r.db.Model(&users.LoginActivity{}).
+		Where("id = ? AND success = ? AND is_current_session = ?", sessionID, true, true).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ListActiveSessions returns the sessions of userID that can still be used,
+// i.e. that were not revoked and hold an unexpired refresh token, newest first.
+func (r *repository) ListActiveSessions(userID string) ([]users.LoginActivity, error) {
+	var sessions []users.LoginActivity
+	err := r.db.
+		Where("user_id = ? AND success = ? AND is_current_session = ?", userID, true, true).
+		Where(`EXISTS (
+			SELECT 1 FROM refresh_tokens rt
+			WHERE rt.session_id = login_activities.id
+			AND rt.rotated_at IS NULL AND rt.revoked_at IS NULL AND rt.expires_at > ?)`, time.Now()).
+		Order("login_time desc").
+		Find(&sessions).Error
+	return sessions, err
 }
 
 func (r *repository) DeleteUserCompletely(userID string) error {
@@ -185,7 +304,27 @@ func (r *repository) DeleteUserCompletely(userID string) error {
 			fmt.Printf("Deleted super admin record, rows affected: %d\n", result.RowsAffected)
 		}
 
-		result := tx.Where("user_id = ?", userID).Delete(&users.LoginActivity{})
+		result := tx.Where("user_id = ?", userID).Delete(&users.RefreshToken{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete refresh tokens: %v", result.Error)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&users.UserToken{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user tokens: %v", err)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&users.PasswordHistory{}).Error; err != nil {
+			return fmt.Errorf("failed to delete password history: %v", err)
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&users.MFARecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete MFA recovery codes: %v", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&users.MFACredential{}).Error; err != nil {
+			return fmt.Errorf("failed to delete MFA credential: %v", err)
+		}
+
+		result = tx.Where("user_id = ?", userID).Delete(&users.LoginActivity{})
 		if result.Error != nil {
 			return fmt.Errorf("failed to delete login activities: %v", result.Error)
 		}
@@ -209,3 +348,239 @@ func (r *repository) DeactivateUser(userID string) error {
 func (r *repository) ReactivateUser(userID string) error {
 	return r.db.Model(&users.User{}).Where("id = ?", userID).Update("status", true).Error
 }
+
+func (r *repository) CreateRefreshToken(token *users.RefreshToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *repository) FindRefreshTokenByHash(hash string) (*users.RefreshToken, error) {
+	var token users.RefreshToken
+	err := r.db.Where("token_hash = ?", hash).First(&token).Error
+	return &token, err
+}
+
+// RotateRefreshToken marks current as rotated and stores next in one
+// transaction. The conditional update guarantees that two concurrent refreshes
+// with the same token cannot both succeed.
+func (r *repository) RotateRefreshToken(current *users.RefreshToken, next *users.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&users.RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", current.ID).
+			Updates(map[string]interface{}{
+				"rotated_at":     now,
+				"replaced_by_id": next.ID,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		return tx.Create(next).Error
+	})
+}
+
+func (r *repository) RevokeSessionRefreshTokens(sessionID string, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users.RefreshToken{}).
+			Where("session_id = ? AND revoked_at IS NULL", sessionID).
+			Updates(map[string]interface{}{
+				"revoked_at":     time.Now(),
+				"revoked_reason": reason,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&users.LoginActivity{}).
+			Where("id = ?", sessionID).
+			Update("is_current_session", false).Error
+	})
+}
+
+func (r *repository) RevokeUserRefreshTokens(userID string, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Updates(map[string]interface{}{
+				"revoked_at":     time.Now(),
+				"revoked_reason": reason,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&users.LoginActivity{}).
+			Where("user_id = ?", userID).
+			Update("is_current_session", false).Error
+	})
+}
+
+// RevokeOtherSessions revokes every session of userID except keepSessionID.
+func (r *repository) RevokeOtherSessions(userID string, keepSessionID string, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users.RefreshToken{}).
+			Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userID, keepSessionID).
+			Updates(map[string]interface{}{
+				"revoked_at":     time.Now(),
+				"revoked_reason": reason,
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&users.LoginActivity{}).
+			Where("user_id = ? AND id <> ?", userID, keepSessionID).
+			Update("is_current_session", false).Error
+	})
+}
+
+func (r *repository) FindMFACredential(userID string) (*users.MFACredential, error) {
+	var credential users.MFACredential
+	err := r.db.Where("user_id = ?", userID).First(&credential).Error
+	return &credential, err
+}
+
+func (r *repository) SaveMFACredential(credential *users.MFACredential) error {
+	return r.db.Save(credential).Error
+}
+
+// ConfirmMFACredential activates the credential and replaces any previous
+// recovery codes with codes, consuming the time step used to confirm it.
+func (r *repository) ConfirmMFACredential(userID string, step int64, codes []users.MFARecoveryCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users.MFACredential{}).
+			Where("user_id = ?", userID).
+			Updates(map[string]interface{}{
+				"confirmed_at":   time.Now(),
+				"last_used_step": step,
+			}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&users.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseMFAStep records step as the last accepted TOTP step. It fails with
+// ErrMFACodeReused when that step, or a later one, was already used.
+func (r *repository) UseMFAStep(userID string, step int64) error {
+	result := r.db.Model(&users.MFACredential{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrMFACodeReused
+	}
+	return nil
+}
+
+func (r *repository) UseRecoveryCode(userID string, codeHash string) error {
+	result := r.db.Model(&users.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) DeleteMFACredential(userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&users.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&users.MFACredential{}).Error
+	})
+}
+
+func (r *repository) IsMFARequiredForRole(role string) (bool, error) {
+	var policy users.MFARolePolicy
+	err := r.db.Where("role = ?", role).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return policy.Required, err
+}
+
+func (r *repository) SaveMFARolePolicy(policy *users.MFARolePolicy) error {
+	return r.db.Save(policy).Error
+}
+
+func (r *repository) ListMFARolePolicies() ([]users.MFARolePolicy, error) {
+	var policies []users.MFARolePolicy
+	err := r.db.Order("role").Find(&policies).Error
+	return policies, err
+}
+
+// ReplaceUserToken stores token and invalidates any unused token with the same
+// purpose, so only the most recently emailed link works.
+func (r *repository) ReplaceUserToken(token *users.UserToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&users.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// FindUserToken returns an unused, unexpired token without consuming it.
+func (r *repository) FindUserToken(hash string, purpose string) (*users.UserToken, error) {
+	var token users.UserToken
+	err := r.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, time.Now()).
+		First(&token).Error
+	return &token, err
+}
+
+// ConsumeUserToken marks an unused, unexpired token as used and returns it.
+// The conditional update makes sure a token can be consumed only once.
+func (r *repository) ConsumeUserToken(hash string, purpose string) (*users.UserToken, error) {
+	var token users.UserToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ? AND purpose = ?", hash, purpose).First(&token).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&users.UserToken{}).
+			Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	return &token, err
+}
+
+func (r *repository) HasUserToken(userID string, purpose string) (bool, error) {
+	var count int64
+	err := r.db.Model(&users.UserToken{}).
+		Where("user_id = ? AND purpose = ?", userID, purpose).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// MarkEmailVerified records the first verification of the user's email and,
+// when activate is set, activates the account in the same update.
+func (r *repository) MarkEmailVerified(userID string, activate bool) error {
+	updates := map[string]interface{}{"email_verified_at": time.Now()}
+	if activate {
+		updates["status"] = true
+	}
+	return r.db.Model(&users.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Updates(updates).Error
+}
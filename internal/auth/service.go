@@ -5,6 +5,7 @@ import (
 	"Altheia-Backend/pkg/utils"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 )
 
@@ -18,11 +19,39 @@ type Service interface {
 	DeleteUserCompletely(userID string) error
 	DeactivateUser(userID string) error
 	ReactivateUser(userID string) error
+	RefreshTokens(refreshToken string) (string, string, error)
+	Logout(refreshToken string) error
+	VerifyMFA(challenge, code, userAgent, ipAddress string) (UserInfo, string, string, error)
+	EnrollMFA(userID string) (MFAEnrollment, error)
+	ConfirmMFA(userID, code, userAgent, ipAddress string) ([]string, error)
+	DisableMFA(userID, code, userAgent, ipAddress string) error
+	SetMFARolePolicy(role string, required bool, updatedBy string) error
+	GetMFARolePolicies() ([]users.MFARolePolicy, error)
+	ForgotPassword(email string)
+	ResetPassword(token, newPassword string) error
+	SetInitialPassword(token, newPassword string) error
+	VerifyEmail(token string) error
+	ResendVerification(email string)
+	ListSessions(userID, currentSessionID string) ([]SessionInfo, error)
+	RevokeSession(userID, sessionID string) error
+	RevokeOtherSessions(userID, currentSessionID string) error
 	verifyToken(token string) (UserInfo, string, error)
+	completeLogin(userID, userAgent, ipAddress string) (UserInfo, string, string, error)
 }
 
+// RefreshTokenTTL is how long an issued refresh token stays valid. Each
+// successful refresh issues a new token with a fresh lifetime.
+const RefreshTokenTTL = 72 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrPasswordExpired     = errors.New("your password has expired, please reset it")
+)
+
 type service struct {
-	repo Repository
+	repo     Repository
+	throttle LoginThrottle
 }
 
 type UserInfo struct {
@@ -53,26 +82,32 @@ type ChangePasswordRequest struct {
 }
 
 func NewService(r Repository) Service {
-	return &service{r}
+	return &service{repo: r, throttle: LoginThrottleFromEnv()}
 }
 
-func (s *service) getClinicIDFromUser(user *users.User) string {
+// ClinicIDFromUser resolves the clinic a user belongs to from its role profile.
+// Super-admins are not bound to any clinic and always resolve to "".
+func ClinicIDFromUser(user *users.User) string {
 	switch user.Rol {
-	case "patient":
+	case users.RolePatient:
 		if user.Patient.ClinicID != nil {
 			return *user.Patient.ClinicID
 		}
-	case "physician":
+	case users.RolePhysician:
 		if user.Physician.ClinicID != nil {
 			return *user.Physician.ClinicID
 		}
-	case "receptionist":
+	case users.RoleReceptionist:
 		if user.Receptionist.ClinicID != nil {
 			return *user.Receptionist.ClinicID
 		}
-	case "owner":
+	case users.RoleLabTechnician:
+		if user.LabTechnician.ClinicID != nil {
+			return *user.LabTechnician.ClinicID
+		}
+	case users.RoleOwner:
 		return user.ClinicOwner.ClinicID
-	case "super-admin":
+	case users.RoleSuperAdmin:
 
 		return ""
 	}
@@ -85,44 +120,100 @@ func (s *service) Login(email, password string) (UserInfo, string, string, error
 }
 
 func (s *service) LoginWithActivity(email, password, userAgent, ipAddress string) (UserInfo, string, string, error) {
+	if err := s.checkIPThrottle(userAgent, ipAddress); err != nil {
+		return UserInfo{}, "", "", err
+	}
+
 	user, err := s.repo.FindByEmail(email)
+	if err != nil {
+		s.recordLoginFailure(nil, users.LoginFailureInvalidCredentials, userAgent, ipAddress)
+		return UserInfo{}, "", "", errors.New("invalid credentials")
+	}
+
+	if err := s.checkAccountThrottle(user, userAgent, ipAddress); err != nil {
+		return UserInfo{}, "", "", err
+	}
 
-	if err != nil || !utils.CheckPasswordHash(password, user.Password) {
+	if !utils.CheckPasswordHash(password, user.Password) {
+		s.loginFailed(user, users.LoginFailureInvalidCredentials, userAgent, ipAddress)
 		return UserInfo{}, "", "", errors.New("invalid credentials")
 	}
 
+	s.upgradePasswordHash(user, password)
+
 	if !user.Status {
+		s.recordLoginFailure(&user.ID, users.LoginFailureInactive, userAgent, ipAddress)
+		if err := s.pendingActivationError(user); err != nil {
+			return UserInfo{}, "", "", err
+		}
 		return UserInfo{}, "", "", errors.New("account is deactivated. Please contact support")
 	}
 
-	accessToken, tokenError := utils.GenerateJWT(user.ID, 1)
+	if users.CurrentPasswordPolicy().PasswordExpired(user, time.Now()) {
+		return UserInfo{}, "", "", ErrPasswordExpired
+	}
+
+	if err := s.requireSecondFactor(user); err != nil {
+		return UserInfo{}, "", "", err
+	}
+
+	return s.startSession(user, userAgent, ipAddress)
+}
+
+// upgradePasswordHash replaces a hash made with a legacy algorithm or outdated
+// parameters once the password behind it is known. Failures only leave the old
+// hash in place.
+func (s *service) upgradePasswordHash(user *users.User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("failed to rehash password of user %s: %v", user.ID, err)
+		return
+	}
+
+	if err := s.repo.RehashPassword(user.ID, user.Password, hashed); err != nil {
+		log.Printf("failed to store rehashed password of user %s: %v", user.ID, err)
+		return
+	}
+	user.Password = hashed
+}
+
+// startSession records a new login session for user and issues its tokens.
+func (s *service) startSession(user *users.User, userAgent, ipAddress string) (UserInfo, string, string, error) {
+	s.repo.UpdateLastLogin(user.ID)
+
+	loginActivity := &users.LoginActivity{
+		ID:               utils.GenerateNanoID(),
+		UserID:           &user.ID,
+		DeviceType:       utils.GetDeviceTypeFromUserAgent(userAgent),
+		IPAddress:        ipAddress,
+		Location:         utils.GetLocationFromIP(ipAddress),
+		LoginTime:        time.Now(),
+		IsCurrentSession: true,
+		UserAgent:        userAgent,
+		Success:          true,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if err := s.repo.CreateLoginActivity(loginActivity); err != nil {
+		return UserInfo{}, "", "", fmt.Errorf("failed to create session: %v", err)
+	}
+
+	clinicID := ClinicIDFromUser(user)
+	accessToken, tokenError := utils.GenerateJWT(user.ID, user.Rol, clinicID, loginActivity.ID, 0)
 	if tokenError != nil {
 		return UserInfo{}, "", "", tokenError
 	}
 
-	refreshToken, refreshError := utils.GenerateJWT(user.ID, 72)
+	refreshToken, record, refreshError := newRefreshToken(user.ID, loginActivity.ID)
 	if refreshError != nil {
 		return UserInfo{}, "", "", refreshError
 	}
-
-	s.repo.UpdateLastLogin(user.ID)
-
-	s.repo.MarkAllSessionsAsInactive(user.ID)
-
-	if userAgent != "" || ipAddress != "" {
-		loginActivity := &users.LoginActivity{
-			ID:               utils.GenerateNanoID(),
-			UserID:           user.ID,
-			DeviceType:       utils.GetDeviceTypeFromUserAgent(userAgent),
-			IPAddress:        ipAddress,
-			Location:         utils.GetLocationFromIP(ipAddress),
-			LoginTime:        time.Now(),
-			IsCurrentSession: true,
-			UserAgent:        userAgent,
-			CreatedAt:        time.Now(),
-			UpdatedAt:        time.Now(),
-		}
-		s.repo.CreateLoginActivity(loginActivity)
+	if err := s.repo.CreateRefreshToken(record); err != nil {
+		return UserInfo{}, "", "", fmt.Errorf("failed to store refresh token: %v", err)
 	}
 
 	userInfo := UserInfo{
@@ -130,7 +221,7 @@ func (s *service) LoginWithActivity(email, password, userAgent, ipAddress string
 		Name:     user.Name,
 		Email:    user.Email,
 		Role:     user.Rol,
-		ClinicId: s.getClinicIDFromUser(user),
+		ClinicId: clinicID,
 	}
 
 	return userInfo, accessToken, refreshToken, nil
@@ -147,7 +238,7 @@ func (s *service) GetUserDetails(id string) (UserDetailsResponse, error) {
 	}
 
 	roleDetails := make(map[string]interface{})
-	clinicID := s.getClinicIDFromUser(user)
+	clinicID := ClinicIDFromUser(user)
 
 	switch user.Rol {
 	case "patient":
@@ -197,14 +288,104 @@ func (s *service) GetUserDetails(id string) (UserDetailsResponse, error) {
 	return response, nil
 }
 
-func (s *service) Logout() error {
-	return nil
+// newRefreshToken generates a refresh token for the given session and the
+// record to persist for it.
+func newRefreshToken(userID, sessionID string) (string, *users.RefreshToken, error) {
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token: %v", err)
+	}
+
+	now := time.Now()
+	return raw, &users.RefreshToken{
+		ID:        utils.GenerateNanoID(),
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: now.Add(RefreshTokenTTL),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new access/refresh pair. The
+// presented token is rotated and can never be used again; presenting an
+// already rotated token is treated as theft and revokes the whole session.
+func (s *service) RefreshTokens(refreshToken string) (string, string, error) {
+	if refreshToken == "" {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	current, err := s.repo.FindRefreshTokenByHash(utils.HashToken(refreshToken))
+	if err != nil {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	if current.RotatedAt != nil {
+		s.repo.RevokeSessionRefreshTokens(current.SessionID, "reuse_detected")
+		return "", "", ErrRefreshTokenReused
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	user, err := s.repo.FindByID(current.UserID)
+	if err != nil || !user.Status {
+		s.repo.RevokeSessionRefreshTokens(current.SessionID, "account_unavailable")
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	newRaw, next, err := newRefreshToken(current.UserID, current.SessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.repo.RotateRefreshToken(current, next); err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			s.repo.RevokeSessionRefreshTokens(current.SessionID, "reuse_detected")
+			return "", "", ErrRefreshTokenReused
+		}
+		return "", "", fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+
+	accessToken, err := utils.GenerateJWT(user.ID, user.Rol, ClinicIDFromUser(user), current.SessionID, 0)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, newRaw, nil
+}
+
+// Logout revokes the session behind refreshToken. Unknown tokens are ignored so
+// that logging out is always safe to call.
+func (s *service) Logout(refreshToken string) error {
+	if refreshToken == "" {
+		return nil
+	}
+
+	current, err := s.repo.FindRefreshTokenByHash(utils.HashToken(refreshToken))
+	if err != nil {
+		return nil
+	}
+
+	return s.repo.RevokeSessionRefreshTokens(current.SessionID, "logout")
 }
 
 func (s *service) verifyToken(token string) (UserInfo, string, error) {
-	userId, _ := utils.ValidateJWT(token)
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		return UserInfo{}, "", err
+	}
 
-	userData, _ := s.repo.FindByID(userId)
+	if active, err := s.repo.IsSessionActive(claims.SessionID()); err != nil || !active {
+		return UserInfo{}, "", ErrSessionRevoked
+	}
+
+	userData, err := s.repo.FindByID(claims.UserID())
+	if err != nil {
+		return UserInfo{}, "", err
+	}
 
 	fmt.Print(userData)
 
@@ -213,7 +394,7 @@ func (s *service) verifyToken(token string) (UserInfo, string, error) {
 		Name:     userData.Name,
 		Email:    userData.Email,
 		Role:     userData.Rol,
-		ClinicId: s.getClinicIDFromUser(userData),
+		ClinicId: ClinicIDFromUser(userData),
 	}
 
 	return userInfo, token, nil
@@ -234,12 +415,33 @@ func (s *service) ChangePassword(id string, request ChangePasswordRequest) error
 		return errors.New("current password is incorrect")
 	}
 
+	if err := s.checkNewPassword(user, request.NewPassword); err != nil {
+		return err
+	}
+
 	hashedNewPassword, err := utils.HashPassword(request.NewPassword)
 	if err != nil {
 		return errors.New("failed to hash new password")
 	}
 
-	return s.repo.ChangePassword(id, hashedNewPassword)
+	if err := s.repo.ChangePassword(id, hashedNewPassword); err != nil {
+		return err
+	}
+
+	return s.repo.RevokeUserRefreshTokens(id, "password_changed")
+}
+
+// checkNewPassword validates password against the password policy for user,
+// including the current and recent passwords it must not repeat.
+func (s *service) checkNewPassword(user *users.User, password string) error {
+	policy := users.CurrentPasswordPolicy()
+
+	history, err := s.repo.RecentPasswordHashes(user.ID, policy.HistorySize)
+	if err != nil {
+		return fmt.Errorf("failed to load password history: %v", err)
+	}
+
+	return policy.Validate(password, user, append([]string{user.Password}, history...))
 }
 
 func (s *service) GetUserLoginActivities(userID string, limit int) ([]users.LoginActivity, error) {
@@ -251,7 +453,11 @@ func (s *service) DeleteUserCompletely(userID string) error {
 }
 
 func (s *service) DeactivateUser(userID string) error {
-	return s.repo.DeactivateUser(userID)
+	if err := s.repo.DeactivateUser(userID); err != nil {
+		return err
+	}
+
+	return s.repo.RevokeUserRefreshTokens(userID, "account_deactivated")
 }
 
 func (s *service) ReactivateUser(userID string) error {
@@ -0,0 +1,854 @@
+package auth
+
+import (
+	"Altheia-Backend/internal/mail"
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"net/smtp"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// fakeRepository keeps refresh tokens in memory. Methods the tests do not need
+// fall through to the embedded nil Repository.
+type fakeRepository struct {
+	Repository
+	users       map[string]*users.User
+	tokens      map[string]*users.RefreshToken
+	revoked     map[string]string
+	credentials map[string]*users.MFACredential
+	recovery    map[string]*users.MFARecoveryCode
+	mfaRoles    map[string]bool
+	sessions    []*users.LoginActivity
+	userTokens  map[string]*users.UserToken
+	passwords   map[string]string
+	history     map[string][]string
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		users: map[string]*users.User{
+			"user-1": {ID: "user-1", Rol: users.RolePhysician, Status: true},
+		},
+		tokens:      map[string]*users.RefreshToken{},
+		revoked:     map[string]string{},
+		credentials: map[string]*users.MFACredential{},
+		recovery:    map[string]*users.MFARecoveryCode{},
+		mfaRoles:    map[string]bool{},
+		userTokens:  map[string]*users.UserToken{},
+		passwords:   map[string]string{},
+		history:     map[string][]string{},
+	}
+}
+
+func (r *fakeRepository) FindByEmail(email string) (*users.User, error) {
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) ChangePassword(userID string, newHashedPassword string) error {
+	r.passwords[userID] = newHashedPassword
+	r.history[userID] = append(r.history[userID], newHashedPassword)
+	return nil
+}
+
+func (r *fakeRepository) RehashPassword(userID string, oldHash string, newHash string) error {
+	if user := r.users[userID]; user.Password == oldHash {
+		user.Password = newHash
+	}
+	return nil
+}
+
+func (r *fakeRepository) RecentPasswordHashes(userID string, limit int) ([]string, error) {
+	var hashes []string
+	for i := len(r.history[userID]) - 1; i >= 0 && len(hashes) < limit; i-- {
+		hashes = append(hashes, r.history[userID][i])
+	}
+	return hashes, nil
+}
+
+func (r *fakeRepository) RevokeUserRefreshTokens(userID string, reason string) error {
+	r.revoked[userID] = reason
+	return nil
+}
+
+func (r *fakeRepository) ReplaceUserToken(token *users.UserToken) error {
+	now := time.Now()
+	for _, t := range r.userTokens {
+		if t.UserID == token.UserID && t.Purpose == token.Purpose && t.UsedAt == nil {
+			t.UsedAt = &now
+		}
+	}
+	r.userTokens[token.TokenHash] = token
+	return nil
+}
+
+func (r *fakeRepository) FindUserToken(hash string, purpose string) (*users.UserToken, error) {
+	t, ok := r.userTokens[hash]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return t, nil
+}
+
+func (r *fakeRepository) ConsumeUserToken(hash string, purpose string) (*users.UserToken, error) {
+	t, ok := r.userTokens[hash]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return t, nil
+}
+
+func (r *fakeRepository) HasUserToken(userID string, purpose string) (bool, error) {
+	for _, t := range r.userTokens {
+		if t.UserID == userID && t.Purpose == purpose {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeRepository) MarkEmailVerified(userID string, activate bool) error {
+	user := r.users[userID]
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	if activate {
+		user.Status = true
+	}
+	return nil
+}
+
+func (r *fakeRepository) UpdateLastLogin(userID string) error { return nil }
+
+func (r *fakeRepository) CreateLoginActivity(activity *users.LoginActivity) error {
+	r.sessions = append(r.sessions, activity)
+	return nil
+}
+
+func (r *fakeRepository) CountLoginFailures(userID string, since time.Time) (LoginFailures, error) {
+	for _, activity := range r.sessions {
+		if activity.Success && activity.UserID != nil && *activity.UserID == userID && activity.LoginTime.After(since) {
+			since = activity.LoginTime
+		}
+	}
+	return r.countFailures(since, func(activity *users.LoginActivity) bool {
+		return activity.UserID != nil && *activity.UserID == userID
+	}), nil
+}
+
+func (r *fakeRepository) CountIPLoginFailures(ipAddress string, since time.Time) (LoginFailures, error) {
+	return r.countFailures(since, func(activity *users.LoginActivity) bool {
+		return activity.IPAddress == ipAddress
+	}), nil
+}
+
+func (r *fakeRepository) countFailures(since time.Time, match func(*users.LoginActivity) bool) LoginFailures {
+	var failures LoginFailures
+	for _, activity := range r.sessions {
+		if activity.Success || !countedFailure(activity.FailureReason) || !activity.LoginTime.After(since) || !match(activity) {
+			continue
+		}
+		failures.Count++
+		if activity.LoginTime.After(failures.Last) {
+			failures.Last = activity.LoginTime
+		}
+	}
+	return failures
+}
+
+func (r *fakeRepository) FindByID(id string) (*users.User, error) {
+	if u, ok := r.users[id]; ok {
+		return u, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) CreateRefreshToken(token *users.RefreshToken) error {
+	r.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (r *fakeRepository) FindRefreshTokenByHash(hash string) (*users.RefreshToken, error) {
+	if t, ok := r.tokens[hash]; ok {
+		found := *t
+		return &found, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) RotateRefreshToken(current, next *users.RefreshToken) error {
+	stored := r.tokens[current.TokenHash]
+	if stored.RotatedAt != nil || stored.RevokedAt != nil {
+		return ErrRefreshTokenReused
+	}
+	now := time.Now()
+	stored.RotatedAt = &now
+	stored.ReplacedByID = next.ID
+	r.tokens[next.TokenHash] = next
+	return nil
+}
+
+func (r *fakeRepository) RevokeSessionRefreshTokens(sessionID, reason string) error {
+	now := time.Now()
+	for _, t := range r.tokens {
+		if t.SessionID == sessionID && t.RevokedAt == nil {
+			t.RevokedAt = &now
+			t.RevokedReason = reason
+		}
+	}
+	for _, session := range r.sessions {
+		if session.ID == sessionID {
+			session.IsCurrentSession = false
+		}
+	}
+	r.revoked[sessionID] = reason
+	return nil
+}
+
+func (r *fakeRepository) RevokeOtherSessions(userID, keepSessionID, reason string) error {
+	for _, session := range r.sessions {
+		if session.UserID != nil && *session.UserID == userID && session.ID != keepSessionID {
+			r.RevokeSessionRefreshTokens(session.ID, reason)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) IsSessionActive(sessionID string) (bool, error) {
+	for _, session := range r.sessions {
+		if session.ID == sessionID {
+			return session.Success && session.IsCurrentSession, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeRepository) ListActiveSessions(userID string) ([]users.LoginActivity, error) {
+	var sessions []users.LoginActivity
+	for _, session := range r.sessions {
+		if session.UserID == nil || *session.UserID != userID || !session.Success || !session.IsCurrentSession {
+			continue
+		}
+		for _, t := range r.tokens {
+			if t.SessionID == session.ID && t.RotatedAt == nil && t.RevokedAt == nil && time.Now().Before(t.ExpiresAt) {
+				sessions = append(sessions, *session)
+				break
+			}
+		}
+	}
+	return sessions, nil
+}
+
+func (r *fakeRepository) FindMFACredential(userID string) (*users.MFACredential, error) {
+	if c, ok := r.credentials[userID]; ok {
+		return c, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeRepository) SaveMFACredential(credential *users.MFACredential) error {
+	r.credentials[credential.UserID] = credential
+	return nil
+}
+
+func (r *fakeRepository) ConfirmMFACredential(userID string, step int64, codes []users.MFARecoveryCode) error {
+	now := time.Now()
+	r.credentials[userID].ConfirmedAt = &now
+	r.credentials[userID].LastUsedStep = step
+	for i := range codes {
+		r.recovery[codes[i].CodeHash] = &codes[i]
+	}
+	return nil
+}
+
+func (r *fakeRepository) UseMFAStep(userID string, step int64) error {
+	credential := r.credentials[userID]
+	if credential.LastUsedStep >= step {
+		return ErrMFACodeReused
+	}
+	credential.LastUsedStep = step
+	return nil
+}
+
+func (r *fakeRepository) UseRecoveryCode(userID string, codeHash string) error {
+	code, ok := r.recovery[codeHash]
+	if !ok || code.UserID != userID || code.UsedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	now := time.Now()
+	code.UsedAt = &now
+	return nil
+}
+
+func (r *fakeRepository) IsMFARequiredForRole(role string) (bool, error) {
+	return r.mfaRoles[role], nil
+}
+
+func issue(t *testing.T, repo *fakeRepository, sessionID string) string {
+	t.Helper()
+	raw, record, err := newRefreshToken("user-1", sessionID)
+	if err != nil {
+		t.Fatalf("newRefreshToken() error = %v", err)
+	}
+	repo.CreateRefreshToken(record)
+	return raw
+}
+
+type discardSMTP struct{}
+
+func (discardSMTP) SendMail(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	keyring, err := utils.NewEphemeralKeyring()
+	if err != nil {
+		panic(err)
+	}
+	utils.SetKeyring(keyring)
+	mail.SetSMTPClient(discardSMTP{})
+	os.Exit(m.Run())
+}
+
+func TestRefreshTokensRotates(t *testing.T) {
+	repo := newFakeRepository()
+	svc := NewService(repo)
+	first := issue(t, repo, "session-1")
+
+	access, second, err := svc.RefreshTokens(first)
+	if err != nil {
+		t.Fatalf("RefreshTokens() error = %v", err)
+	}
+	if access == "" || second == "" || second == first {
+		t.Fatalf("RefreshTokens() = %q, %q; want a new access and refresh token", access, second)
+	}
+	if _, ok := repo.tokens[utils.HashToken(first)]; !ok {
+		t.Fatal("refresh tokens must be stored by hash")
+	}
+
+	if _, _, err := svc.RefreshTokens(second); err != nil {
+		t.Fatalf("RefreshTokens() with rotated token error = %v", err)
+	}
+}
+
+func TestRefreshTokensReuseRevokesSession(t *testing.T) {
+	repo := newFakeRepository()
+	svc := NewService(repo)
+	first := issue(t, repo, "session-1")
+	other := issue(t, repo, "session-2")
+
+	_, second, err := svc.RefreshTokens(first)
+	if err != nil {
+		t.Fatalf("RefreshTokens() error = %v", err)
+	}
+
+	if _, _, err := svc.RefreshTokens(first); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RefreshTokens() reusing token error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if repo.revoked["session-1"] != "reuse_detected" {
+		t.Errorf("session-1 revoke reason = %q, want reuse_detected", repo.revoked["session-1"])
+	}
+
+	if _, _, err := svc.RefreshTokens(second); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("RefreshTokens() after reuse error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, _, err := svc.RefreshTokens(other); err != nil {
+		t.Errorf("RefreshTokens() on unrelated session error = %v", err)
+	}
+}
+
+func TestRefreshTokensRejectsInvalid(t *testing.T) {
+	repo := newFakeRepository()
+	svc := NewService(repo)
+
+	expired := issue(t, repo, "session-1")
+	repo.tokens[utils.HashToken(expired)].ExpiresAt = time.Now().Add(-time.Minute)
+
+	repo.users["user-2"] = &users.User{ID: "user-2", Status: false}
+	raw, record, _ := newRefreshToken("user-2", "session-2")
+	repo.CreateRefreshToken(record)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"unknown", "not-a-token"},
+		{"expired", expired},
+		{"deactivated user", raw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := svc.RefreshTokens(tt.token); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("RefreshTokens() error = %v, want %v", err, ErrInvalidRefreshToken)
+			}
+		})
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	repo := newFakeRepository()
+	svc := NewService(repo)
+	token := issue(t, repo, "session-1")
+
+	if err := svc.Logout(token); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+	if repo.revoked["session-1"] != "logout" {
+		t.Errorf("session-1 revoke reason = %q, want logout", repo.revoked["session-1"])
+	}
+	if _, _, err := svc.RefreshTokens(token); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("RefreshTokens() after logout error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func enrollMFA(t *testing.T, svc Service, userID string) (string, []string) {
+	t.Helper()
+	enrollment, err := svc.EnrollMFA(userID)
+	if err != nil {
+		t.Fatalf("EnrollMFA() error = %v", err)
+	}
+	code, _ := utils.TOTPCode(enrollment.Secret, utils.TOTPStep(time.Now()))
+	recoveryCodes, err := svc.ConfirmMFA(userID, code, "", "")
+	if err != nil {
+		t.Fatalf("ConfirmMFA() error = %v", err)
+	}
+	return enrollment.Secret, recoveryCodes
+}
+
+func TestRequireSecondFactor(t *testing.T) {
+	repo := newFakeRepository()
+	repo.users["owner-1"] = &users.User{ID: "owner-1", Rol: users.RoleOwner, Status: true}
+	repo.users["patient-1"] = &users.User{ID: "patient-1", Rol: users.RolePatient, Status: true}
+	svc := NewService(repo).(*service)
+
+	if err := svc.requireSecondFactor(repo.users["user-1"]); err != nil {
+		t.Errorf("requireSecondFactor() without enrollment or policy = %v, want nil", err)
+	}
+
+	enrollMFA(t, svc, "user-1")
+	var challenge *MFAChallengeError
+	if err := svc.requireSecondFactor(repo.users["user-1"]); !errors.As(err, &challenge) || challenge.EnrollmentRequired {
+		t.Errorf("requireSecondFactor() for enrolled user = %v, want challenge without enrollment", err)
+	}
+
+	repo.mfaRoles[users.RoleOwner] = true
+	if err := svc.requireSecondFactor(repo.users["owner-1"]); !errors.As(err, &challenge) || !challenge.EnrollmentRequired {
+		t.Errorf("requireSecondFactor() for enforced role = %v, want challenge requiring enrollment", err)
+	}
+
+	repo.mfaRoles[users.RolePatient] = true
+	if err := svc.requireSecondFactor(repo.users["patient-1"]); err != nil {
+		t.Errorf("requireSecondFactor() for patient = %v, want nil", err)
+	}
+	if _, err := svc.EnrollMFA("patient-1"); !errors.Is(err, ErrMFANotAvailable) {
+		t.Errorf("EnrollMFA() for patient error = %v, want %v", err, ErrMFANotAvailable)
+	}
+}
+
+func TestVerifyMFA(t *testing.T) {
+	repo := newFakeRepository()
+	svc := NewService(repo)
+	secret, recoveryCodes := enrollMFA(t, svc, "user-1")
+
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("ConfirmMFA() returned %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	for hash := range repo.recovery {
+		for _, code := range recoveryCodes {
+			if hash == code {
+				t.Fatal("recovery codes must be stored hashed")
+			}
+		}
+	}
+
+	challenge, err := utils.GenerateMFAChallenge("user-1")
+	if err != nil {
+		t.Fatalf("GenerateMFAChallenge() error = %v", err)
+	}
+
+	// The confirmation consumed the current step; the next one is still accepted.
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+1)
+
+	if _, _, _, err := svc.VerifyMFA(challenge, "000000", "", ""); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("VerifyMFA() with wrong code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+
+	user, access, refresh, err := svc.VerifyMFA(challenge, code, "", "")
+	if err != nil {
+		t.Fatalf("VerifyMFA() error = %v", err)
+	}
+	if user.ID != "user-1" || access == "" || refresh == "" {
+		t.Errorf("VerifyMFA() = %+v, %q, %q", user, access, refresh)
+	}
+
+	if _, _, _, err := svc.VerifyMFA(challenge, code, "", ""); !errors.Is(err, ErrMFACodeReused) {
+		t.Errorf("VerifyMFA() replaying code error = %v, want %v", err, ErrMFACodeReused)
+	}
+
+	recovery := strings.ToUpper(recoveryCodes[0])
+	if _, _, _, err := svc.VerifyMFA(challenge, recovery, "", ""); err != nil {
+		t.Errorf("VerifyMFA() with recovery code error = %v", err)
+	}
+	if _, _, _, err := svc.VerifyMFA(challenge, recovery, "", ""); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("VerifyMFA() reusing recovery code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+
+	if _, _, _, err := svc.VerifyMFA(access, code, "", ""); err == nil {
+		t.Error("VerifyMFA() must not accept an access token as challenge")
+	}
+	if _, err := utils.ValidateJWT(challenge); err == nil {
+		t.Error("ValidateJWT() must not accept an MFA challenge as access token")
+	}
+}
+
+func issueUserToken(t *testing.T, repo *fakeRepository, userID, purpose string, ttl time.Duration) string {
+	t.Helper()
+	raw, token, err := users.NewUserToken(userID, purpose, ttl)
+	if err != nil {
+		t.Fatalf("NewUserToken() error = %v", err)
+	}
+	repo.ReplaceUserToken(token)
+	return raw
+}
+
+func TestResetPassword(t *testing.T) {
+	repo := newFakeRepository()
+	svc := NewService(repo)
+
+	svc.ForgotPassword("nobody@example.com")
+	if len(repo.userTokens) != 0 {
+		t.Errorf("ForgotPassword() for unknown email stored %d tokens, want none", len(repo.userTokens))
+	}
+
+	first := issueUserToken(t, repo, "user-1", users.TokenPurposePasswordReset, users.PasswordResetTTL)
+	expired := issueUserToken(t, repo, "user-1", users.TokenPurposePasswordReset, -time.Minute)
+
+	if err := svc.ResetPassword(first, "n3w-Password!"); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("ResetPassword() with superseded token error = %v, want %v", err, ErrInvalidUserToken)
+	}
+	if err := svc.ResetPassword(expired, "n3w-Password!"); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("ResetPassword() with expired token error = %v, want %v", err, ErrInvalidUserToken)
+	}
+
+	second := issueUserToken(t, repo, "user-1", users.TokenPurposePasswordReset, users.PasswordResetTTL)
+	if err := svc.ResetPassword(second, "n3w-Password!"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	if !utils.CheckPasswordHash("n3w-Password!", repo.passwords["user-1"]) {
+		t.Error("ResetPassword() did not store the new password hash")
+	}
+	if repo.revoked["user-1"] != "password_reset" {
+		t.Errorf("ResetPassword() revoke reason = %q, want password_reset", repo.revoked["user-1"])
+	}
+
+	if err := svc.ResetPassword(second, "an0ther-Password!"); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("ResetPassword() reusing token error = %v, want %v", err, ErrInvalidUserToken)
+	}
+	if err := svc.VerifyEmail(second); !errors.Is(err, ErrInvalidUserToken) {
+		t.Errorf("VerifyEmail() with reset token error = %v, want %v", err, ErrInvalidUserToken)
+	}
+}
+
+func TestAccountActivation(t *testing.T) {
+	repo := newFakeRepository()
+	repo.users["patient-1"] = &users.User{ID: "patient-1", Rol: users.RolePatient, Email: "p@example.com"}
+	repo.users["owner-1"] = &users.User{ID: "owner-1", Rol: users.RoleOwner, Email: "o@example.com"}
+	repo.users["disabled-1"] = &users.User{ID: "disabled-1", Rol: users.RolePhysician, Email: "d@example.com"}
+	svc := NewService(repo).(*service)
+
+	verification := issueUserToken(t, repo, "patient-1", users.TokenPurposeEmailVerification, users.EmailVerificationTTL)
+	setPassword := issueUserToken(t, repo, "owner-1", users.TokenPurposeSetPassword, users.SetPasswordTTL)
+
+	if err := svc.pendingActivationError(repo.users["patient-1"]); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("pendingActivationError() for unverified patient = %v, want %v", err, ErrEmailNotVerified)
+	}
+	if err := svc.pendingActivationError(repo.users["owner-1"]); !errors.Is(err, ErrPasswordNotSetUp) {
+		t.Errorf("pendingActivationError() for new owner = %v, want %v", err, ErrPasswordNotSetUp)
+	}
+
+	if err := svc.VerifyEmail(verification); err != nil {
+		t.Fatalf("VerifyEmail() error = %v", err)
+	}
+	if !repo.users["patient-1"].Status || repo.users["patient-1"].EmailVerifiedAt == nil {
+		t.Error("VerifyEmail() should verify and activate the account")
+	}
+
+	if err := svc.SetInitialPassword(setPassword, "0wner-Password!"); err != nil {
+		t.Fatalf("SetInitialPassword() error = %v", err)
+	}
+	if !repo.users["owner-1"].Status {
+		t.Error("SetInitialPassword() should activate the account")
+	}
+
+	reset := issueUserToken(t, repo, "disabled-1", users.TokenPurposePasswordReset, users.PasswordResetTTL)
+	if err := svc.ResetPassword(reset, "d1sabled-Password!"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	if repo.users["disabled-1"].Status {
+		t.Error("ResetPassword() must not reactivate an account deactivated by an administrator")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	hashed, err := utils.HashPassword("c0rrect-Password!")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	repo := newFakeRepository()
+	repo.users["user-1"].Email = "user@example.com"
+	repo.users["user-1"].Password = hashed
+	svc := &service{repo: repo, throttle: LoginThrottle{
+		Window:        time.Hour,
+		DelayAfter:    10,
+		MaxFailures:   2,
+		MaxIPFailures: 10,
+		Lockout:       time.Hour,
+	}}
+
+	if _, _, _, err := svc.LoginWithActivity("nobody@example.com", "whatever", "", "10.0.0.1"); err == nil {
+		t.Fatal("LoginWithActivity() with unknown email should fail")
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, _, err := svc.LoginWithActivity("user@example.com", "wrong", "", "10.0.0.1"); err == nil {
+			t.Fatal("LoginWithActivity() with wrong password should fail")
+		}
+	}
+
+	_, _, _, err = svc.LoginWithActivity("user@example.com", "c0rrect-Password!", "", "10.0.0.1")
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) || !throttled.Locked || throttled.RetryAfter <= 0 {
+		t.Fatalf("LoginWithActivity() on locked account error = %v, want locked ThrottledError", err)
+	}
+
+	var failed, anonymous int
+	for _, activity := range repo.sessions {
+		if activity.Success {
+			t.Errorf("LoginActivity %+v recorded as successful", activity)
+		}
+		failed++
+		if activity.UserID == nil {
+			anonymous++
+		}
+	}
+	if failed != 4 || anonymous != 1 {
+		t.Errorf("recorded %d failed attempts (%d without user), want 4 (1)", failed, anonymous)
+	}
+	if reason := repo.sessions[len(repo.sessions)-1].FailureReason; reason != users.LoginFailureLocked {
+		t.Errorf("last FailureReason = %q, want %q", reason, users.LoginFailureLocked)
+	}
+
+	svc.throttle.Lockout = 0
+	if _, _, _, err := svc.LoginWithActivity("user@example.com", "c0rrect-Password!", "", "10.0.0.1"); err != nil {
+		t.Fatalf("LoginWithActivity() after lockout error = %v", err)
+	}
+	if failures, _ := repo.CountLoginFailures("user-1", time.Now().Add(-time.Hour)); failures.Count != 0 {
+		t.Errorf("CountLoginFailures() after successful login = %d, want 0", failures.Count)
+	}
+}
+
+func TestMFAManagementThrottled(t *testing.T) {
+	repo := newFakeRepository()
+	repo.users["user-1"].Email = "user@example.com"
+	repo.users["user-2"] = &users.User{ID: "user-2", Rol: users.RolePhysician, Status: true, Email: "other@example.com"}
+	svc := &service{repo: repo, throttle: LoginThrottle{
+		Window:        time.Hour,
+		DelayAfter:    10,
+		MaxFailures:   2,
+		MaxIPFailures: 10,
+		Lockout:       time.Hour,
+	}}
+	var throttled *ThrottledError
+
+	enrollment, err := svc.EnrollMFA("user-1")
+	if err != nil {
+		t.Fatalf("EnrollMFA() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := svc.ConfirmMFA("user-1", "not-a-code", "", "10.0.0.1"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("ConfirmMFA() with wrong code error = %v, want %v", err, ErrInvalidMFACode)
+		}
+	}
+	code, _ := utils.TOTPCode(enrollment.Secret, utils.TOTPStep(time.Now()))
+	if _, err := svc.ConfirmMFA("user-1", code, "", "10.0.0.1"); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Errorf("ConfirmMFA() on locked account error = %v, want locked ThrottledError", err)
+	}
+
+	secret, _ := enrollMFA(t, svc, "user-2")
+	for i := 0; i < 2; i++ {
+		if err := svc.DisableMFA("user-2", "not-a-code", "", "10.0.0.1"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("DisableMFA() with wrong code error = %v, want %v", err, ErrInvalidMFACode)
+		}
+	}
+	code, _ = utils.TOTPCode(secret, utils.TOTPStep(time.Now())+1)
+	if err := svc.DisableMFA("user-2", code, "", "10.0.0.1"); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Errorf("DisableMFA() on locked account error = %v, want locked ThrottledError", err)
+	}
+	if _, err := repo.FindMFACredential("user-2"); err != nil {
+		t.Error("DisableMFA() on locked account removed the second factor")
+	}
+}
+
+func countedFailure(reason string) bool {
+	for _, counted := range countedFailures {
+		if reason == counted {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSessions(t *testing.T) {
+	repo := newFakeRepository()
+	repo.users["user-2"] = &users.User{ID: "user-2", Rol: users.RolePatient, Status: true}
+	svc := NewService(repo).(*service)
+
+	login := func(userID string) (string, string) {
+		t.Helper()
+		_, access, _, err := svc.startSession(repo.users[userID], "", "")
+		if err != nil {
+			t.Fatalf("startSession() error = %v", err)
+		}
+		claims, err := utils.ValidateJWT(access)
+		if err != nil {
+			t.Fatalf("ValidateJWT() error = %v", err)
+		}
+		return access, claims.SessionID()
+	}
+
+	_, laptop := login("user-1")
+	phoneAccess, phone := login("user-1")
+	_, tablet := login("user-1")
+	_, other := login("user-2")
+
+	sessions, err := svc.ListSessions("user-1", laptop)
+	if err != nil {
+		t.Fatalf("ListSessions() error = %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("ListSessions() returned %d sessions, want 3: a new login must not end the others", len(sessions))
+	}
+	for _, session := range sessions {
+		if session.Current != (session.ID == laptop) {
+			t.Errorf("session %s Current = %v", session.ID, session.Current)
+		}
+	}
+
+	if err := svc.RevokeSession("user-1", other); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("RevokeSession() on another user's session error = %v, want %v", err, ErrSessionNotFound)
+	}
+
+	if err := svc.RevokeSession("user-1", phone); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if _, _, err := svc.verifyToken(phoneAccess); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("verifyToken() with revoked session error = %v, want %v", err, ErrSessionRevoked)
+	}
+
+	if err := svc.RevokeOtherSessions("user-1", laptop); err != nil {
+		t.Fatalf("RevokeOtherSessions() error = %v", err)
+	}
+	sessions, _ = svc.ListSessions("user-1", laptop)
+	if len(sessions) != 1 || sessions[0].ID != laptop {
+		t.Errorf("ListSessions() after RevokeOtherSessions() = %+v, want only %s", sessions, laptop)
+	}
+	if active, _ := repo.IsSessionActive(tablet); active {
+		t.Error("RevokeOtherSessions() left another session active")
+	}
+	if active, _ := repo.IsSessionActive(other); !active {
+		t.Error("RevokeOtherSessions() must not touch other users' sessions")
+	}
+}
+
+func TestPasswordPolicyOnPasswordChanges(t *testing.T) {
+	current, err := utils.HashPassword("0ld-Password!")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	repo := newFakeRepository()
+	repo.users["user-1"].Name = "Laura Gomez"
+	repo.users["user-1"].Email = "laura@example.com"
+	repo.users["user-1"].Password = current
+	svc := NewService(repo)
+
+	var policyErr *users.PasswordPolicyError
+	change := func(newPassword string) error {
+		return svc.ChangePassword("user-1", ChangePasswordRequest{CurrentPassword: "0ld-Password!", NewPassword: newPassword})
+	}
+
+	if err := change("short"); !errors.As(err, &policyErr) {
+		t.Errorf("ChangePassword() with weak password error = %v, want PasswordPolicyError", err)
+	}
+	if err := change("Gomez-Secure-9!"); !errors.As(err, &policyErr) {
+		t.Errorf("ChangePassword() with the user's name error = %v, want PasswordPolicyError", err)
+	}
+	if err := change("0ld-Password!"); !errors.As(err, &policyErr) {
+		t.Errorf("ChangePassword() reusing the current password error = %v, want PasswordPolicyError", err)
+	}
+	if err := change("n3w-Password!"); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+
+	reset := issueUserToken(t, repo, "user-1", users.TokenPurposePasswordReset, users.PasswordResetTTL)
+	if err := svc.ResetPassword(reset, "n3w-Password!"); !errors.As(err, &policyErr) {
+		t.Errorf("ResetPassword() reusing a recent password error = %v, want PasswordPolicyError", err)
+	}
+	if err := svc.ResetPassword(reset, "an0ther-Password!"); err != nil {
+		t.Errorf("ResetPassword() after a rejected password must accept the same link, error = %v", err)
+	}
+}
+
+func TestLoginRehashesLegacyPasswords(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("c0rrect-Password!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+
+	repo := newFakeRepository()
+	repo.users["user-1"].Email = "user@example.com"
+	repo.users["user-1"].Password = string(legacy)
+	svc := NewService(repo)
+
+	if _, _, _, err := svc.LoginWithActivity("user@example.com", "wrong", "", ""); err == nil {
+		t.Fatal("LoginWithActivity() with wrong password should fail")
+	}
+	if repo.users["user-1"].Password != string(legacy) {
+		t.Fatal("a failed login must not rehash the password")
+	}
+
+	if _, _, _, err := svc.LoginWithActivity("user@example.com", "c0rrect-Password!", "", ""); err != nil {
+		t.Fatalf("LoginWithActivity() with bcrypt hash error = %v", err)
+	}
+	upgraded := repo.users["user-1"].Password
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("password hash after login = %q, want argon2id", upgraded)
+	}
+
+	if _, _, _, err := svc.LoginWithActivity("user@example.com", "c0rrect-Password!", "", ""); err != nil {
+		t.Fatalf("LoginWithActivity() with argon2id hash error = %v", err)
+	}
+	if repo.users["user-1"].Password != upgraded {
+		t.Error("an up-to-date hash must not be rehashed")
+	}
+	if len(repo.history["user-1"]) != 0 {
+		t.Error("rehashing must not be recorded as a password change")
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session has been revoked")
+)
+
+// SessionInfo describes a live login session of the user, one per device that
+// signed in. Current marks the session of the token making the request.
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	DeviceType string    `json:"device_type"`
+	Location   string    `json:"location"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	LoginTime  time.Time `json:"login_time"`
+	Current    bool      `json:"current"`
+}
+
+func (s *service) ListSessions(userID, currentSessionID string) ([]SessionInfo, error) {
+	sessions, err := s.repo.ListActiveSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, SessionInfo{
+			ID:         session.ID,
+			DeviceType: session.DeviceType,
+			Location:   session.Location,
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			LoginTime:  session.LoginTime,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+	return result, nil
+}
+
+// RevokeSession signs one of the user's sessions out. Access tokens issued to
+// it stop working immediately and its refresh token can no longer be used.
+func (s *service) RevokeSession(userID, sessionID string) error {
+	sessions, err := s.repo.ListActiveSessions(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return s.repo.RevokeSessionRefreshTokens(sessionID, "session_revoked")
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeOtherSessions signs the user out of every device except the one making
+// the request.
+func (s *service) RevokeOtherSessions(userID, currentSessionID string) error {
+	return s.repo.RevokeOtherSessions(userID, currentSessionID, "session_revoked")
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"Altheia-Backend/config"
+	"Altheia-Backend/internal/mail"
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"fmt"
+	"log"
+	"time"
+)
+
+// LoginThrottle is the brute-force policy applied to logins. Failures are
+// counted per account since its last successful login, and per IP address,
+// within Window. From DelayAfter failures on, each new attempt must wait a
+// delay that doubles with every failure, and from MaxFailures on the account
+// is locked for Lockout.
+type LoginThrottle struct {
+	Window        time.Duration
+	DelayAfter    int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	MaxFailures   int
+	MaxIPFailures int
+	Lockout       time.Duration
+}
+
+// LoginThrottleFromEnv reads the policy from LOGIN_* variables, using the
+// defaults for anything unset or invalid.
+func LoginThrottleFromEnv() LoginThrottle {
+	return LoginThrottle{
+		Window:        config.GetEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		DelayAfter:    config.GetEnvInt("LOGIN_DELAY_AFTER", 3),
+		BaseDelay:     config.GetEnvDuration("LOGIN_BASE_DELAY", 2*time.Second),
+		MaxDelay:      config.GetEnvDuration("LOGIN_MAX_DELAY", 30*time.Second),
+		MaxFailures:   config.GetEnvInt("LOGIN_MAX_FAILURES", 5),
+		MaxIPFailures: config.GetEnvInt("LOGIN_MAX_IP_FAILURES", 20),
+		Lockout:       config.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+// LoginFailures summarizes the recent failed attempts of an account or IP.
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+// ThrottledError is returned when a login attempt arrives before the caller is
+// allowed to try again.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed attempts, account temporarily locked. Try again in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed attempts. Try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// accountWait returns how long the account has to wait before its next
+// attempt, or nil when it may try now.
+func (t LoginThrottle) accountWait(failures LoginFailures, now time.Time) *ThrottledError {
+	if failures.Count >= t.MaxFailures {
+		if wait := failures.Last.Add(t.Lockout).Sub(now); wait > 0 {
+			return &ThrottledError{RetryAfter: wait, Locked: true}
+		}
+		return nil
+	}
+
+	if failures.Count < t.DelayAfter {
+		return nil
+	}
+
+	delay := t.BaseDelay << uint(failures.Count-t.DelayAfter)
+	if delay > t.MaxDelay || delay <= 0 {
+		delay = t.MaxDelay
+	}
+	if wait := failures.Last.Add(delay).Sub(now); wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// ipWait blocks an IP address that exceeded MaxIPFailures until Lockout has
+// passed since its last failure.
+func (t LoginThrottle) ipWait(failures LoginFailures, now time.Time) *ThrottledError {
+	if failures.Count < t.MaxIPFailures {
+		return nil
+	}
+	if wait := failures.Last.Add(t.Lockout).Sub(now); wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// checkIPThrottle rejects the attempt when its IP address has failed too often
+// recently, across any accounts.
+func (s *service) checkIPThrottle(userAgent, ipAddress string) error {
+	if ipAddress == "" {
+		return nil
+	}
+
+	now := time.Now()
+	failures, err := s.repo.CountIPLoginFailures(ipAddress, now.Add(-s.throttle.Window))
+	if err != nil {
+		return fmt.Errorf("failed to check login attempts: %v", err)
+	}
+
+	if throttled := s.throttle.ipWait(failures, now); throttled != nil {
+		s.recordLoginFailure(nil, users.LoginFailureLocked, userAgent, ipAddress)
+		return throttled
+	}
+	return nil
+}
+
+// checkAccountThrottle rejects the attempt when the account is locked or has
+// to wait before trying again.
+func (s *service) checkAccountThrottle(user *users.User, userAgent, ipAddress string) error {
+	now := time.Now()
+	failures, err := s.repo.CountLoginFailures(user.ID, now.Add(-s.throttle.Window))
+	if err != nil {
+		return fmt.Errorf("failed to check login attempts: %v", err)
+	}
+
+	if throttled := s.throttle.accountWait(failures, now); throttled != nil {
+		s.recordLoginFailure(&user.ID, users.LoginFailureLocked, userAgent, ipAddress)
+		return throttled
+	}
+	return nil
+}
+
+// loginFailed records a counted failure for user and emails them when it is
+// the failure that locks the account.
+func (s *service) loginFailed(user *users.User, reason, userAgent, ipAddress string) {
+	s.recordLoginFailure(&user.ID, reason, userAgent, ipAddress)
+
+	failures, err := s.repo.CountLoginFailures(user.ID, time.Now().Add(-s.throttle.Window))
+	if err != nil || failures.Count != s.throttle.MaxFailures {
+		return
+	}
+
+	if err := mail.SendAccountLockedEmail(user.Name, user.Email, s.throttle.Lockout, ipAddress); err != nil {
+		log.Printf("failed to send lockout notice to %s: %v", user.Email, err)
+	}
+}
+
+func (s *service) recordLoginFailure(userID *string, reason, userAgent, ipAddress string) {
+	now := time.Now()
+	activity := &users.LoginActivity{
+		ID:            utils.GenerateNanoID(),
+		UserID:        userID,
+		DeviceType:    utils.GetDeviceTypeFromUserAgent(userAgent),
+		IPAddress:     ipAddress,
+		Location:      utils.GetLocationFromIP(ipAddress),
+		LoginTime:     now,
+		UserAgent:     userAgent,
+		Success:       false,
+		FailureReason: reason,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := s.repo.CreateLoginActivity(activity); err != nil {
+		log.Printf("failed to record login attempt: %v", err)
+	}
+}
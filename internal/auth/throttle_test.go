@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleAccountWait(t *testing.T) {
+	throttle := LoginThrottle{
+		DelayAfter:  3,
+		BaseDelay:   2 * time.Second,
+		MaxDelay:    5 * time.Second,
+		MaxFailures: 6,
+		Lockout:     15 * time.Minute,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		count  int
+		wait   time.Duration
+		locked bool
+	}{
+		{"below threshold", 2, 0, false},
+		{"first delay", 3, 2 * time.Second, false},
+		{"delay doubles", 4, 4 * time.Second, false},
+		{"delay is capped", 5, 5 * time.Second, false},
+		{"locked", 6, 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := throttle.accountWait(LoginFailures{Count: tt.count, Last: now}, now)
+			if tt.wait == 0 {
+				if got != nil {
+					t.Fatalf("accountWait() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.RetryAfter != tt.wait || got.Locked != tt.locked {
+				t.Fatalf("accountWait() = %+v, want wait %s locked %v", got, tt.wait, tt.locked)
+			}
+		})
+	}
+
+	if got := throttle.accountWait(LoginFailures{Count: 6, Last: now.Add(-time.Hour)}, now); got != nil {
+		t.Errorf("accountWait() after lockout expired = %+v, want nil", got)
+	}
+}
+
+func TestLoginThrottleIPWait(t *testing.T) {
+	throttle := LoginThrottle{MaxIPFailures: 20, Lockout: 15 * time.Minute}
+	now := time.Now()
+
+	if got := throttle.ipWait(LoginFailures{Count: 19, Last: now}, now); got != nil {
+		t.Errorf("ipWait() below limit = %+v, want nil", got)
+	}
+	if got := throttle.ipWait(LoginFailures{Count: 20, Last: now}, now); got == nil || got.RetryAfter != 15*time.Minute {
+		t.Errorf("ipWait() at limit = %+v, want 15m", got)
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"Altheia-Backend/internal/mail"
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	ErrInvalidUserToken    = errors.New("invalid or expired link")
+	ErrEmailNotVerified    = errors.New("please verify your email before logging in")
+	ErrPasswordNotSetUp    = errors.New("please set your password using the link sent to your email")
+	ErrNewPasswordRequired = errors.New("new password is required")
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// pendingActivationError explains why an inactive account cannot log in yet
+// when it is still waiting for its first email confirmation. It returns nil
+// for accounts that were deactivated by an administrator.
+func (s *service) pendingActivationError(user *users.User) error {
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	if pending, err := s.repo.HasUserToken(user.ID, users.TokenPurposeSetPassword); err == nil && pending {
+		return ErrPasswordNotSetUp
+	}
+	if pending, err := s.repo.HasUserToken(user.ID, users.TokenPurposeEmailVerification); err == nil && pending {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// ForgotPassword emails a password reset link. It never reports whether the
+// email belongs to an account, so failures to send the link are only logged.
+func (s *service) ForgotPassword(email string) {
+	if err := s.sendPasswordReset(email); err != nil {
+		log.Printf("failed to send password reset link: %v", err)
+	}
+}
+
+func (s *service) sendPasswordReset(email string) error {
+	user, err := s.repo.FindByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	raw, token, err := users.NewUserToken(user.ID, users.TokenPurposePasswordReset, users.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.ReplaceUserToken(token); err != nil {
+		return fmt.Errorf("failed to store reset token: %v", err)
+	}
+
+	return mail.SendPasswordResetEmail(user.Name, user.Email, raw)
+}
+
+// ResetPassword sets a new password with a reset link and signs the user out
+// everywhere.
+func (s *service) ResetPassword(token, newPassword string) error {
+	return s.setPasswordWithToken(token, users.TokenPurposePasswordReset, newPassword, false)
+}
+
+// SetInitialPassword sets the first password of an account created on the
+// user's behalf, such as a clinic owner, and activates it.
+func (s *service) SetInitialPassword(token, newPassword string) error {
+	return s.setPasswordWithToken(token, users.TokenPurposeSetPassword, newPassword, true)
+}
+
+func (s *service) setPasswordWithToken(token, purpose, newPassword string, activate bool) error {
+	if newPassword == "" {
+		return ErrNewPasswordRequired
+	}
+
+	// The token is only consumed once the password passes the policy, so that
+	// the user can retry with the same link.
+	pending, err := s.repo.FindUserToken(utils.HashToken(token), purpose)
+	if err != nil {
+		return ErrInvalidUserToken
+	}
+
+	user, err := s.repo.FindByID(pending.UserID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	if err := s.checkNewPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	record, err := s.repo.ConsumeUserToken(utils.HashToken(token), purpose)
+	if err != nil {
+		return ErrInvalidUserToken
+	}
+
+	hashed, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return errors.New("failed to hash new password")
+	}
+
+	if err := s.repo.ChangePassword(user.ID, hashed); err != nil {
+		return err
+	}
+
+	// Following an emailed link proves the user owns the address, which also
+	// completes a pending activation. Accounts deactivated by an administrator
+	// stay deactivated.
+	activate = activate || s.pendingActivationError(user) != nil
+	if err := s.repo.MarkEmailVerified(record.UserID, activate); err != nil {
+		return err
+	}
+
+	return s.repo.RevokeUserRefreshTokens(record.UserID, "password_reset")
+}
+
+// VerifyEmail confirms the user's email and activates the account.
+func (s *service) VerifyEmail(token string) error {
+	record, err := s.repo.ConsumeUserToken(utils.HashToken(token), users.TokenPurposeEmailVerification)
+	if err != nil {
+		return ErrInvalidUserToken
+	}
+
+	return s.repo.MarkEmailVerified(record.UserID, true)
+}
+
+// ResendVerification emails a new verification link to an account that is
+// still waiting for its first confirmation. Like ForgotPassword it does not
+// reveal whether the email is registered, and only logs failures.
+func (s *service) ResendVerification(email string) {
+	if err := s.resendVerification(email); err != nil {
+		log.Printf("failed to resend verification link: %v", err)
+	}
+}
+
+func (s *service) resendVerification(email string) error {
+	user, err := s.repo.FindByEmail(email)
+	if err != nil || user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	var (
+		purpose string
+		ttl     time.Duration
+		send    func(name, to, token string) error
+	)
+	switch s.pendingActivationError(user) {
+	case ErrEmailNotVerified:
+		purpose, ttl, send = users.TokenPurposeEmailVerification, users.EmailVerificationTTL, mail.SendEmailVerification
+	case ErrPasswordNotSetUp:
+		purpose, ttl, send = users.TokenPurposeSetPassword, users.SetPasswordTTL, mail.SendSetPasswordEmail
+	default:
+		return nil
+	}
+
+	raw, token, err := users.NewUserToken(user.ID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.ReplaceUserToken(token); err != nil {
+		return fmt.Errorf("failed to store verification token: %v", err)
+	}
+
+	return send(user.Name, user.Email, raw)
+}
@@ -0,0 +1,274 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSlotUnavailable     = errors.New("the requested time is not an available slot for this physician")
+	ErrInvalidAvailability = errors.New("invalid availability")
+	ErrInvalidSlotRange    = errors.New("the slot range must end after it starts and span at most 31 days")
+	ErrInvalidDateTime     = errors.New("invalid date or time format")
+)
+
+// maxSlotRange bounds the period a single available-slots query may cover.
+const maxSlotRange = 31 * 24 * time.Hour
+
+// PhysicianAvailability is one block of a physician's weekly template, such as
+// Mondays from 08:00 to 12:00 in 20-minute slots. Times are wall-clock times in
+// the clinic's time zone.
+type PhysicianAvailability struct {
+	ID          string    `gorm:"primaryKey" json:"id"`
+	PhysicianID string    `gorm:"not null;index" json:"physician_id"`
+	Weekday     int       `gorm:"not null" json:"weekday"`
+	StartTime   string    `gorm:"not null" json:"start_time"`
+	EndTime     string    `gorm:"not null" json:"end_time"`
+	SlotMinutes int       `gorm:"not null" json:"slot_minutes"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// AvailabilityException blocks a period of a physician's template, such as a
+// vacation or a holiday.
+type AvailabilityException struct {
+	ID          string    `gorm:"primaryKey" json:"id"`
+	PhysicianID string    `gorm:"not null;index" json:"physician_id"`
+	StartsAt    time.Time `gorm:"not null;index" json:"starts_at"`
+	EndsAt      time.Time `gorm:"not null" json:"ends_at"`
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Slot is a bookable period of a physician's time.
+type Slot struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type AvailabilityBlockDTO struct {
+	Weekday     int    `json:"weekday"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	SlotMinutes int    `json:"slot_minutes"`
+}
+
+type AvailabilityExceptionDTO struct {
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason"`
+}
+
+// physicianLocations resolves the time zone of each physician's clinic, the
+// zone their wall-clock times are read and shown in. Physicians without a
+// clinic, and clinics without a zone, use clinical.DefaultTimezone.
+func physicianLocations(repo Repository, physicianIds ...string) (map[string]*time.Location, error) {
+	zones, err := repo.GetPhysicianTimezones(physicianIds)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]*time.Location, len(physicianIds))
+	for _, physicianId := range physicianIds {
+		if _, ok := locations[physicianId]; ok {
+			continue
+		}
+		loc, err := clinical.LoadTimezone(zones[physicianId])
+		if err != nil {
+			return nil, fmt.Errorf("error al cargar la zona horaria: %w", err)
+		}
+		locations[physicianId] = loc
+	}
+	return locations, nil
+}
+
+func physicianLocation(repo Repository, physicianId string) (*time.Location, error) {
+	locations, err := physicianLocations(repo, physicianId)
+	if err != nil {
+		return nil, err
+	}
+	return locations[physicianId], nil
+}
+
+// dateTime reads the appointment's date and time as a wall-clock time in loc,
+// the time zone of the physician's clinic.
+func (dto CreateAppointmentDTO) dateTime(loc *time.Location) (time.Time, error) {
+	dateTime, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprintf("%s %s", dto.Date, dto.Time), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidDateTime, err)
+	}
+	return dateTime, nil
+}
+
+// parseClock parses an "HH:MM" wall-clock time into minutes after midnight.
+func parseClock(value string) (int, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%w: time %q must use the HH:MM format", ErrInvalidAvailability, value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// validateAvailability checks a weekly template: valid days and times, slots
+// that fit their block and no overlapping blocks on the same day.
+func validateAvailability(blocks []AvailabilityBlockDTO) error {
+	type span struct{ start, end int }
+	byDay := map[int][]span{}
+
+	for _, block := range blocks {
+		if block.Weekday < 0 || block.Weekday > 6 {
+			return fmt.Errorf("%w: weekday must be between 0 (Sunday) and 6 (Saturday)", ErrInvalidAvailability)
+		}
+
+		start, err := parseClock(block.StartTime)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(block.EndTime)
+		if err != nil {
+			return err
+		}
+		if end <= start {
+			return fmt.Errorf("%w: %s-%s ends before it starts", ErrInvalidAvailability, block.StartTime, block.EndTime)
+		}
+
+		if block.SlotMinutes < 5 || block.SlotMinutes > end-start {
+			return fmt.Errorf("%w: slot_minutes must be at least 5 and fit in %s-%s", ErrInvalidAvailability, block.StartTime, block.EndTime)
+		}
+
+		for _, other := range byDay[block.Weekday] {
+			if start < other.end && other.start < end {
+				return fmt.Errorf("%w: blocks on weekday %d overlap", ErrInvalidAvailability, block.Weekday)
+			}
+		}
+		byDay[block.Weekday] = append(byDay[block.Weekday], span{start, end})
+	}
+	return nil
+}
+
+// openingHours are the hours a clinic is open per weekday, in minutes after
+// midnight. A nil value means the clinic has no schedule and does not restrict
+// its physicians.
+type openingHours map[time.Weekday][2]int
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "domingo": time.Sunday,
+	"monday": time.Monday, "lunes": time.Monday,
+	"tuesday": time.Tuesday, "martes": time.Tuesday,
+	"wednesday": time.Wednesday, "miercoles": time.Wednesday, "miércoles": time.Wednesday,
+	"thursday": time.Thursday, "jueves": time.Thursday,
+	"friday": time.Friday, "viernes": time.Friday,
+	"saturday": time.Saturday, "sabado": time.Saturday, "sábado": time.Saturday,
+}
+
+// clinicOpeningHours turns the clinic schedule rows into opening hours. Days
+// are accepted by English or Spanish name or by number (0 is Sunday); closed
+// or unreadable days are left out, so the clinic is closed on them.
+func clinicOpeningHours(schedule []clinical.ClinicSchedule) openingHours {
+	if len(schedule) == 0 {
+		return nil
+	}
+
+	hours := openingHours{}
+	for _, day := range schedule {
+		name := strings.ToLower(strings.TrimSpace(day.Day))
+		weekday, ok := weekdayNames[name]
+		if !ok {
+			number, err := strconv.Atoi(name)
+			if err != nil || number < 0 || number > 6 {
+				continue
+			}
+			weekday = time.Weekday(number)
+		}
+
+		if !day.Open {
+			continue
+		}
+		from, errFrom := parseClock(day.From)
+		to, errTo := parseClock(day.To)
+		if errFrom != nil || errTo != nil || to <= from {
+			continue
+		}
+		hours[weekday] = [2]int{from, to}
+	}
+	return hours
+}
+
+// computeSlots lays the weekly template over [from, to) in loc and returns the
+// slots that start in the future, fit the clinic's opening hours and do not
+// overlap an exception or a booked appointment. Slots always last their
+// template's length, also on days when DST starts or ends.
+func computeSlots(templates []PhysicianAvailability, exceptions []AvailabilityException, booked []MedicalAppointment,
+	hours openingHours, from, to, now time.Time, loc *time.Location) []Slot {
+
+	slots := []Slot{}
+	day := time.Date(from.In(loc).Year(), from.In(loc).Month(), from.In(loc).Day(), 0, 0, 0, 0, loc)
+
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, block := range templates {
+			if time.Weekday(block.Weekday) != day.Weekday() {
+				continue
+			}
+
+			start, errStart := parseClock(block.StartTime)
+			end, errEnd := parseClock(block.EndTime)
+			if errStart != nil || errEnd != nil || block.SlotMinutes <= 0 {
+				continue
+			}
+
+			if hours != nil {
+				open, ok := hours[day.Weekday()]
+				if !ok {
+					continue
+				}
+				start, end = max(start, open[0]), min(end, open[1])
+			}
+
+			for minute := start; minute+block.SlotMinutes <= end; minute += block.SlotMinutes {
+				slotStart := wallClock(day, minute, loc)
+				if slotStart.Hour()*60+slotStart.Minute() != minute {
+					// The wall-clock time does not exist on this day: it falls
+					// in the hour skipped when DST begins.
+					continue
+				}
+				slot := Slot{
+					Start: slotStart,
+					End:   slotStart.Add(time.Duration(block.SlotMinutes) * time.Minute),
+				}
+				if slot.Start.Before(from) || !slot.Start.Before(to) || !slot.Start.After(now) {
+					continue
+				}
+				if slotBlocked(slot, exceptions, booked) {
+					continue
+				}
+				slots = append(slots, slot)
+			}
+		}
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Start.Before(slots[j].Start) })
+	return slots
+}
+
+func wallClock(day time.Time, minute int, loc *time.Location) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minute/60, minute%60, 0, 0, loc)
+}
+
+func slotBlocked(slot Slot, exceptions []AvailabilityException, booked []MedicalAppointment) bool {
+	for _, exception := range exceptions {
+		if slot.Start.Before(exception.EndsAt) && exception.StartsAt.Before(slot.End) {
+			return true
+		}
+	}
+	for _, appointment := range booked {
+		if slot.Start.Before(appointment.EndTime) && appointment.DateTime.Before(slot.End) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package appointments
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handler) GetAvailability(c *fiber.Ctx) error {
+	availability, err := h.service.GetAvailability(c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(availability)
+}
+
+func (h *Handler) SetAvailability(c *fiber.Ctx) error {
+	var blocks []AvailabilityBlockDTO
+	if err := c.BodyParser(&blocks); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	availability, err := h.service.SetAvailability(c.Params("id"), blocks)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(availability)
+}
+
+func (h *Handler) GetAvailabilityExceptions(c *fiber.Ctx) error {
+	from, to, err := h.slotRange(c, c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	exceptions, err := h.service.GetAvailabilityExceptions(c.Params("id"), from, to)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(exceptions)
+}
+
+func (h *Handler) AddAvailabilityException(c *fiber.Ctx) error {
+	var dto AvailabilityExceptionDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	exception, err := h.service.AddAvailabilityException(c.Params("id"), dto)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(exception)
+}
+
+func (h *Handler) DeleteAvailabilityException(c *fiber.Ctx) error {
+	if err := h.service.DeleteAvailabilityException(c.Params("id"), c.Params("exceptionId")); err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "deleted successfully"})
+}
+
+// GetAvailableSlots answers GET /appointments/available-slots?physician_id=&from=&to=.
+func (h *Handler) GetAvailableSlots(c *fiber.Ctx) error {
+	from, to, err := h.slotRange(c, c.Query("physician_id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	slots, err := h.service.GetAvailableSlots(c.Query("physician_id"), from, to)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(slots)
+}
+
+// slotRange reads the from and to query parameters. Each is either an RFC 3339
+// timestamp or a date in the time zone of the physician's clinic; a date in to
+// includes that whole day. Without from the range starts now, and without to
+// it covers a week.
+func (h *Handler) slotRange(c *fiber.Ctx, physicianId string) (time.Time, time.Time, error) {
+	loc, err := h.service.PhysicianLocation(physicianId)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	from := time.Now()
+	if value := c.Query("from"); value != "" {
+		if from, err = parseDateParam(value, loc, false); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	to := from.Add(7 * 24 * time.Hour)
+	if value := c.Query("to"); value != "" {
+		if to, err = parseDateParam(value, loc, true); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return from, to, nil
+}
+
+// parseDateParam reads a query parameter holding an RFC 3339 timestamp or a
+// date in loc. With endOfDay a date stands for the end of that day.
+func parseDateParam(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, ErrInvalidDateTime
+	}
+	if endOfDay {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
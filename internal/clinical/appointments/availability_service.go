@@ -0,0 +1,186 @@
+package appointments
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+func (s *service) GetAvailability(physicianId string) ([]PhysicianAvailability, error) {
+	return s.repo.GetAvailability(physicianId)
+}
+
+// SetAvailability replaces the physician's weekly template.
+func (s *service) SetAvailability(physicianId string, blocks []AvailabilityBlockDTO) ([]PhysicianAvailability, error) {
+	if err := validateAvailability(blocks); err != nil {
+		return nil, err
+	}
+
+	availability := make([]PhysicianAvailability, 0, len(blocks))
+	for _, block := range blocks {
+		id, err := gonanoid.Nanoid()
+		if err != nil {
+			return nil, err
+		}
+		availability = append(availability, PhysicianAvailability{
+			ID:          id,
+			PhysicianID: physicianId,
+			Weekday:     block.Weekday,
+			StartTime:   block.StartTime,
+			EndTime:     block.EndTime,
+			SlotMinutes: block.SlotMinutes,
+		})
+	}
+
+	if err := s.repo.ReplaceAvailability(physicianId, availability); err != nil {
+		return nil, err
+	}
+	return availability, nil
+}
+
+func (s *service) GetAvailabilityExceptions(physicianId string, from, to time.Time) ([]AvailabilityException, error) {
+	return s.repo.GetAvailabilityExceptions(physicianId, from, to)
+}
+
+func (s *service) AddAvailabilityException(physicianId string, dto AvailabilityExceptionDTO) (*AvailabilityException, error) {
+	if dto.StartsAt.IsZero() || !dto.EndsAt.After(dto.StartsAt) {
+		return nil, fmt.Errorf("%w: an exception must end after it starts", ErrInvalidAvailability)
+	}
+
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return nil, err
+	}
+
+	exception := &AvailabilityException{
+		ID:          id,
+		PhysicianID: physicianId,
+		StartsAt:    dto.StartsAt.UTC(),
+		EndsAt:      dto.EndsAt.UTC(),
+		Reason:      dto.Reason,
+	}
+	if err := s.repo.CreateAvailabilityException(exception); err != nil {
+		return nil, err
+	}
+	return exception, nil
+}
+
+func (s *service) DeleteAvailabilityException(physicianId, exceptionId string) error {
+	return s.repo.DeleteAvailabilityException(physicianId, exceptionId)
+}
+
+// GetAvailableSlots lists the physician's free slots starting in [from, to).
+func (s *service) GetAvailableSlots(physicianId string, from, to time.Time) ([]Slot, error) {
+	if !to.After(from) || to.Sub(from) > maxSlotRange {
+		return nil, ErrInvalidSlotRange
+	}
+	return s.availableSlots(physicianId, from, to)
+}
+
+// availableSlots computes the free slots in [from, to). Slots held by a
+// waitlist offer count as taken. The appointments and offers in ignoreIds are
+// left out, so that they can be moved within, or claim, their own time.
+func (s *service) availableSlots(physicianId string, from, to time.Time, ignoreIds ...string) ([]Slot, error) {
+	loc, err := physicianLocation(s.repo, physicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := s.repo.GetAvailability(physicianId)
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return []Slot{}, nil
+	}
+
+	exceptions, err := s.repo.GetAvailabilityExceptions(physicianId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := s.repo.GetPhysicianClinicSchedule(physicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	appointments, err := s.repo.GetActiveAppointmentsInRange(physicianId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	offers, err := s.repo.GetPendingOffers(physicianId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	booked := appointments[:0]
+	for _, appointment := range append(appointments, heldSlots(offers)...) {
+		if !slices.Contains(ignoreIds, appointment.ID) {
+			booked = append(booked, appointment)
+		}
+	}
+
+	return computeSlots(templates, exceptions, booked, clinicOpeningHours(schedule), from, to, time.Now(), loc), nil
+}
+
+// freeSlot returns the physician's free slot that begins at start, or
+// ErrSlotUnavailable when there is none.
+func (s *service) freeSlot(physicianId string, start time.Time, ignoreIds ...string) (Slot, error) {
+	slots, unavailable, err := s.freeSlots(physicianId, []time.Time{start}, ignoreIds...)
+	if err != nil {
+		return Slot{}, err
+	}
+	if len(unavailable) > 0 {
+		return Slot{}, ErrSlotUnavailable
+	}
+	return slots[0], nil
+}
+
+// freeSlots matches each of starts to the physician's free slot beginning at
+// it. The starts without one are returned as unavailable.
+func (s *service) freeSlots(physicianId string, starts []time.Time, ignoreIds ...string) ([]Slot, []time.Time, error) {
+	if len(starts) == 0 {
+		return nil, nil, nil
+	}
+
+	loc, err := physicianLocation(s.repo, physicianId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	first, last := starts[0], starts[0]
+	for _, start := range starts {
+		if start.Before(first) {
+			first = start
+		}
+		if start.After(last) {
+			last = start
+		}
+	}
+	first, last = first.In(loc), last.In(loc)
+	from := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	to := time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, loc)
+
+	available, err := s.availableSlots(physicianId, from, to, ignoreIds...)
+	if err != nil {
+		return nil, nil, err
+	}
+	byStart := make(map[int64]Slot, len(available))
+	for _, slot := range available {
+		byStart[slot.Start.Unix()] = slot
+	}
+
+	var slots []Slot
+	var unavailable []time.Time
+	for _, start := range starts {
+		slot, ok := byStart[start.Unix()]
+		if !ok || !slot.Start.Equal(start) {
+			unavailable = append(unavailable, start)
+			continue
+		}
+		slots = append(slots, slot)
+	}
+	return slots, unavailable, nil
+}
@@ -0,0 +1,137 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"testing"
+	"time"
+)
+
+func TestComputeSlots(t *testing.T) {
+	loc, err := clinical.LoadTimezone(clinical.DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Monday 2030-01-07.
+	monday := time.Date(2030, 1, 7, 0, 0, 0, 0, loc)
+	now := monday.AddDate(0, 0, -1)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2030, 1, 7, hour, minute, 0, 0, loc)
+	}
+
+	templates := []PhysicianAvailability{
+		{Weekday: int(time.Monday), StartTime: "08:00", EndTime: "10:00", SlotMinutes: 30},
+		{Weekday: int(time.Tuesday), StartTime: "08:00", EndTime: "09:00", SlotMinutes: 60},
+	}
+
+	tests := []struct {
+		name       string
+		exceptions []AvailabilityException
+		booked     []MedicalAppointment
+		hours      openingHours
+		want       []time.Time
+	}{
+		{
+			name: "template only",
+			want: []time.Time{at(8, 0), at(8, 30), at(9, 0), at(9, 30)},
+		},
+		{
+			name:   "booked slot is taken",
+			booked: []MedicalAppointment{{DateTime: at(8, 30), EndTime: at(9, 0)}},
+			want:   []time.Time{at(8, 0), at(9, 0), at(9, 30)},
+		},
+		{
+			name:   "appointment overlapping two slots takes both",
+			booked: []MedicalAppointment{{DateTime: at(8, 15), EndTime: at(8, 45)}},
+			want:   []time.Time{at(9, 0), at(9, 30)},
+		},
+		{
+			name:       "exception blocks overlapping slots",
+			exceptions: []AvailabilityException{{StartsAt: at(8, 45), EndsAt: at(9, 15)}},
+			want:       []time.Time{at(8, 0), at(9, 30)},
+		},
+		{
+			name:  "clinic hours clip the template",
+			hours: openingHours{time.Monday: {9 * 60, 18 * 60}},
+			want:  []time.Time{at(9, 0), at(9, 30)},
+		},
+		{
+			name:  "clinic closed on the day",
+			hours: openingHours{time.Tuesday: {8 * 60, 18 * 60}},
+			want:  []time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slots := computeSlots(templates, tt.exceptions, tt.booked, tt.hours, monday, monday.AddDate(0, 0, 1), now, loc)
+			if len(slots) != len(tt.want) {
+				t.Fatalf("got %d slots %v, want %d", len(slots), slots, len(tt.want))
+			}
+			for i, slot := range slots {
+				if !slot.Start.Equal(tt.want[i]) {
+					t.Errorf("slot %d starts at %v, want %v", i, slot.Start, tt.want[i])
+				}
+				if slot.End.Sub(slot.Start) != 30*time.Minute {
+					t.Errorf("slot %d lasts %v, want 30m", i, slot.End.Sub(slot.Start))
+				}
+			}
+		})
+	}
+
+	t.Run("past slots are skipped", func(t *testing.T) {
+		slots := computeSlots(templates, nil, nil, nil, monday, monday.AddDate(0, 0, 1), at(8, 30), loc)
+		if len(slots) != 2 || !slots[0].Start.Equal(at(9, 0)) {
+			t.Errorf("got %v, want the 09:00 and 09:30 slots", slots)
+		}
+	})
+}
+
+func TestValidateAvailability(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocks  []AvailabilityBlockDTO
+		wantErr bool
+	}{
+		{"valid", []AvailabilityBlockDTO{{1, "08:00", "12:00", 20}, {1, "14:00", "18:00", 30}}, false},
+		{"invalid weekday", []AvailabilityBlockDTO{{7, "08:00", "12:00", 20}}, true},
+		{"invalid time", []AvailabilityBlockDTO{{1, "8am", "12:00", 20}}, true},
+		{"ends before it starts", []AvailabilityBlockDTO{{1, "12:00", "08:00", 20}}, true},
+		{"slot too long", []AvailabilityBlockDTO{{1, "08:00", "08:30", 45}}, true},
+		{"overlapping blocks", []AvailabilityBlockDTO{{1, "08:00", "12:00", 20}, {1, "11:00", "13:00", 20}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAvailability(tt.blocks); (err != nil) != tt.wantErr {
+				t.Errorf("validateAvailability() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClinicOpeningHours(t *testing.T) {
+	hours := clinicOpeningHours([]clinical.ClinicSchedule{
+		{Day: "Lunes", Open: true, From: "07:00", To: "17:00"},
+		{Day: "tuesday", Open: true, From: "08:00", To: "12:00"},
+		{Day: "Domingo", Open: false, From: "08:00", To: "12:00"},
+		{Day: "6", Open: true, From: "09:00", To: "13:00"},
+	})
+
+	if got := hours[time.Monday]; got != [2]int{7 * 60, 17 * 60} {
+		t.Errorf("monday = %v", got)
+	}
+	if got := hours[time.Tuesday]; got != [2]int{8 * 60, 12 * 60} {
+		t.Errorf("tuesday = %v", got)
+	}
+	if got := hours[time.Saturday]; got != [2]int{9 * 60, 13 * 60} {
+		t.Errorf("saturday = %v", got)
+	}
+	if _, open := hours[time.Sunday]; open {
+		t.Error("sunday should be closed")
+	}
+
+	if clinicOpeningHours(nil) != nil {
+		t.Error("a clinic without a schedule should not restrict slots")
+	}
+}
@@ -0,0 +1,380 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidCheckIn = errors.New("invalid check-in")
+	ErrCheckInDay     = errors.New("patients can only be checked in on the day of their appointment")
+	ErrNotCheckedIn   = errors.New("the patient is not waiting in the queue")
+	ErrQueueEmpty     = errors.New("no patients are waiting")
+)
+
+// QueueMessage is the type of the messages that push a physician's queue to
+// the clinic's screens.
+const QueueMessage = "waiting_room"
+
+// Notifier pushes messages to the screens connected for a clinic; the
+// websocket service implements it.
+type Notifier interface {
+	BroadcastToClinic(clinicID string, messageType string, data interface{})
+}
+
+// TriagePriority orders the patients waiting for the same physician: higher
+// priorities are called first.
+type TriagePriority int
+
+const (
+	TriageRoutine TriagePriority = 0
+	// TriagePreferential is for patients entitled to preferential care, such
+	// as older adults, pregnant women and people with disabilities.
+	TriagePreferential TriagePriority = 1
+	TriageUrgent       TriagePriority = 2
+)
+
+func (p TriagePriority) IsValid() bool {
+	return p >= TriageRoutine && p <= TriageUrgent
+}
+
+// AppointmentCheckIn records a patient's arrival at the front desk and when
+// the physician called them in.
+type AppointmentCheckIn struct {
+	AppointmentID string         `gorm:"primaryKey" json:"appointment_id"`
+	ClinicID      string         `gorm:"not null;index" json:"clinic_id"`
+	PhysicianID   string         `gorm:"not null;index" json:"physician_id"`
+	Priority      TriagePriority `gorm:"not null;default:0" json:"priority"`
+	ArrivedAt     time.Time      `gorm:"not null;index" json:"arrived_at"`
+	CheckedInBy   string         `json:"checked_in_by"`
+	CalledAt      *time.Time     `json:"called_at"`
+	CalledBy      string         `json:"called_by"`
+}
+
+type CheckInDTO struct {
+	Priority TriagePriority `json:"priority"`
+}
+
+// QueueEntry is a checked-in appointment as shown in the waiting room.
+type QueueEntry struct {
+	AppointmentID string            `json:"appointment_id"`
+	PatientID     string            `json:"patient_id"`
+	PatientName   string            `json:"patient_name"`
+	PhysicianID   string            `json:"physician_id"`
+	Status        AppointmentStatus `json:"status"`
+	DateTime      time.Time         `json:"date_time"`
+	Priority      TriagePriority    `json:"priority"`
+	ArrivedAt     time.Time         `json:"arrived_at"`
+	CalledAt      *time.Time        `json:"called_at"`
+	// WaitMinutes is how long the patient waited to be called, or has been
+	// waiting so far.
+	WaitMinutes float64 `json:"wait_minutes"`
+}
+
+// PhysicianQueue is a physician's waiting room: the patients being seen and
+// those waiting, in the order they will be called.
+type PhysicianQueue struct {
+	PhysicianID string       `json:"physician_id"`
+	InProgress  []QueueEntry `json:"in_progress"`
+	Waiting     []QueueEntry `json:"waiting"`
+}
+
+// WaitStats summarizes the waits of the patients checked in over a period.
+type WaitStats struct {
+	PhysicianID string `json:"physician_id,omitempty"`
+	CheckedIn   int    `json:"checked_in"`
+	Called      int    `json:"called"`
+	Waiting     int    `json:"waiting"`
+	// AverageWaitMinutes and MaxWaitMinutes cover the patients who were
+	// called in; LongestWaitingMinutes is the longest current wait.
+	AverageWaitMinutes    float64 `json:"average_wait_minutes"`
+	MaxWaitMinutes        float64 `json:"max_wait_minutes"`
+	LongestWaitingMinutes float64 `json:"longest_waiting_minutes"`
+}
+
+// WaitMetrics are a clinic's wait statistics, overall and by physician.
+type WaitMetrics struct {
+	ClinicID string    `json:"clinic_id"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	WaitStats
+	ByPhysician []WaitStats `json:"by_physician"`
+}
+
+// waitMinutes is how long the entry waited to be called, or has waited by now.
+func (e QueueEntry) waitMinutes(now time.Time) float64 {
+	end := now
+	if e.CalledAt != nil {
+		end = *e.CalledAt
+	}
+	return end.Sub(e.ArrivedAt).Minutes()
+}
+
+// sortQueue orders waiting patients by priority, then by appointment time and
+// then by arrival.
+func sortQueue(entries []QueueEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.DateTime.Equal(b.DateTime) {
+			return a.DateTime.Before(b.DateTime)
+		}
+		return a.ArrivedAt.Before(b.ArrivedAt)
+	})
+}
+
+// buildQueue splits the physician's checked-in appointments into those being
+// seen and the ordered waiting list.
+func buildQueue(physicianId string, entries []QueueEntry, now time.Time) *PhysicianQueue {
+	queue := &PhysicianQueue{PhysicianID: physicianId, InProgress: []QueueEntry{}, Waiting: []QueueEntry{}}
+	for _, entry := range entries {
+		entry.WaitMinutes = entry.waitMinutes(now)
+		if entry.Status == AppointmentStatusInProgress {
+			queue.InProgress = append(queue.InProgress, entry)
+		} else {
+			queue.Waiting = append(queue.Waiting, entry)
+		}
+	}
+	sort.SliceStable(queue.InProgress, func(i, j int) bool {
+		return queue.InProgress[i].CalledAt.Before(*queue.InProgress[j].CalledAt)
+	})
+	sortQueue(queue.Waiting)
+	return queue
+}
+
+// CheckIn records the patient's arrival for an appointment of today and puts
+// them in the physician's queue.
+func (s *service) CheckIn(appointmentId, actorId string, priority TriagePriority) (*AppointmentCheckIn, error) {
+	if !priority.IsValid() {
+		return nil, fmt.Errorf("%w: priority must be between %d and %d", ErrInvalidCheckIn, TriageRoutine, TriageUrgent)
+	}
+
+	appointment, err := s.repo.GetAppointmentByID(appointmentId)
+	if err != nil {
+		return nil, err
+	}
+	current := AppointmentStatus(appointment.Status)
+	if err := checkTransition(current, AppointmentStatusCheckedIn); err != nil {
+		return nil, err
+	}
+
+	loc, err := physicianLocation(s.repo, appointment.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.In(loc).Format("2006-01-02") != appointment.DateTime.In(loc).Format("2006-01-02") {
+		return nil, ErrCheckInDay
+	}
+
+	clinicId, err := s.repo.GetPhysicianClinicId(appointment.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	checkIn := &AppointmentCheckIn{
+		AppointmentID: appointment.ID,
+		ClinicID:      clinicId,
+		PhysicianID:   appointment.PhysicianId,
+		Priority:      priority,
+		ArrivedAt:     now,
+		CheckedInBy:   actorId,
+	}
+	err = s.repo.CreateCheckIn(checkIn, StatusChange{
+		AppointmentID: appointment.ID,
+		From:          current,
+		To:            AppointmentStatusCheckedIn,
+		ActorUserID:   actorId,
+		Reason:        "Llegada registrada en recepción",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyQueue(clinicId, appointment.PhysicianId)
+	return checkIn, nil
+}
+
+// SetTriagePriority changes the priority of a patient who is still waiting.
+func (s *service) SetTriagePriority(appointmentId string, priority TriagePriority) error {
+	if !priority.IsValid() {
+		return fmt.Errorf("%w: priority must be between %d and %d", ErrInvalidCheckIn, TriageRoutine, TriageUrgent)
+	}
+
+	checkIn, err := s.repo.UpdateTriagePriority(appointmentId, priority)
+	if err != nil {
+		return err
+	}
+
+	s.notifyQueue(checkIn.ClinicID, checkIn.PhysicianID)
+	return nil
+}
+
+// GetPhysicianQueue returns the physician's waiting room. Only patients who
+// arrived today, in the clinic's time zone, are in it.
+func (s *service) GetPhysicianQueue(physicianId string) (*PhysicianQueue, error) {
+	loc, err := physicianLocation(s.repo, physicianId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entries, err := s.repo.GetPhysicianQueue(physicianId, startOfDay(now, loc))
+	if err != nil {
+		return nil, err
+	}
+	return buildQueue(physicianId, entries, now), nil
+}
+
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+}
+
+// CallNextPatient calls the first waiting patient in to the physician. When
+// someone else calls the same patient first, the next one is called instead.
+func (s *service) CallNextPatient(physicianId, actorId string) (*QueueEntry, error) {
+	queue, err := s.GetPhysicianQueue(physicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range queue.Waiting {
+		now := time.Now()
+		err := s.repo.CallCheckIn(StatusChange{
+			AppointmentID: entry.AppointmentID,
+			From:          AppointmentStatusCheckedIn,
+			To:            AppointmentStatusInProgress,
+			ActorUserID:   actorId,
+			Reason:        "Paciente llamado a consulta",
+		}, now)
+		if errors.Is(err, ErrStatusChanged) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Status = AppointmentStatusInProgress
+		entry.CalledAt = &now
+		entry.WaitMinutes = entry.waitMinutes(now)
+		if clinicId, err := s.repo.GetPhysicianClinicId(physicianId); err == nil {
+			s.notifyQueue(clinicId, physicianId)
+		}
+		return &entry, nil
+	}
+	return nil, ErrQueueEmpty
+}
+
+// GetWaitMetrics summarizes the waits of the patients who checked in at the
+// clinic in [from, to), by default today in the clinic's time zone.
+func (s *service) GetWaitMetrics(clinicId string, from, to time.Time) (*WaitMetrics, error) {
+	now := time.Now()
+	if from.IsZero() || to.IsZero() {
+		loc, err := s.ClinicLocation(clinicId)
+		if err != nil {
+			return nil, err
+		}
+		today := startOfDay(now, loc)
+		if from.IsZero() {
+			from = today
+		}
+		if to.IsZero() {
+			to = today.AddDate(0, 0, 1)
+		}
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("%w: to must be after from", ErrInvalidSearch)
+	}
+
+	entries, err := s.repo.GetClinicCheckIns(clinicId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := &WaitMetrics{ClinicID: clinicId, From: from, To: to, WaitStats: waitStats(entries, now), ByPhysician: []WaitStats{}}
+	byPhysician := map[string][]QueueEntry{}
+	var physicians []string
+	for _, entry := range entries {
+		if _, ok := byPhysician[entry.PhysicianID]; !ok {
+			physicians = append(physicians, entry.PhysicianID)
+		}
+		byPhysician[entry.PhysicianID] = append(byPhysician[entry.PhysicianID], entry)
+	}
+	sort.Strings(physicians)
+	for _, physicianId := range physicians {
+		stats := waitStats(byPhysician[physicianId], now)
+		stats.PhysicianID = physicianId
+		metrics.ByPhysician = append(metrics.ByPhysician, stats)
+	}
+	return metrics, nil
+}
+
+func waitStats(entries []QueueEntry, now time.Time) WaitStats {
+	var stats WaitStats
+	var total float64
+	for _, entry := range entries {
+		stats.CheckedIn++
+		wait := entry.waitMinutes(now)
+		if entry.CalledAt != nil {
+			stats.Called++
+			total += wait
+			if wait > stats.MaxWaitMinutes {
+				stats.MaxWaitMinutes = wait
+			}
+			continue
+		}
+		// Patients who left without being called are not waiting anymore.
+		if entry.Status == AppointmentStatusCheckedIn {
+			stats.Waiting++
+			if wait > stats.LongestWaitingMinutes {
+				stats.LongestWaitingMinutes = wait
+			}
+		}
+	}
+	if stats.Called > 0 {
+		stats.AverageWaitMinutes = total / float64(stats.Called)
+	}
+	return stats
+}
+
+// queueChanged pushes the queues of the physicians of the given appointments
+// that were in a waiting room before a status change.
+func (s *service) queueChanged(appointments []MedicalAppointment) {
+	notified := map[string]bool{}
+	for _, appointment := range appointments {
+		switch AppointmentStatus(appointment.Status) {
+		case AppointmentStatusCheckedIn, AppointmentStatusInProgress:
+		default:
+			continue
+		}
+		if notified[appointment.PhysicianId] {
+			continue
+		}
+		notified[appointment.PhysicianId] = true
+
+		clinicId, err := s.repo.GetPhysicianClinicId(appointment.PhysicianId)
+		if err != nil {
+			log.Printf("waiting room: could not find the clinic of physician %s: %v", appointment.PhysicianId, err)
+			continue
+		}
+		s.notifyQueue(clinicId, appointment.PhysicianId)
+	}
+}
+
+// notifyQueue pushes the physician's current queue to the clinic's screens.
+func (s *service) notifyQueue(clinicId, physicianId string) {
+	if s.notifier == nil {
+		return
+	}
+	queue, err := s.GetPhysicianQueue(physicianId)
+	if err != nil {
+		log.Printf("waiting room: could not load the queue of physician %s: %v", physicianId, err)
+		return
+	}
+	s.notifier.BroadcastToClinic(clinicId, QueueMessage, queue)
+}
@@ -0,0 +1,81 @@
+package appointments
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CheckIn answers POST /appointments/:id/check-in with an optional
+// {"priority"}.
+func (h *Handler) CheckIn(c *fiber.Ctx) error {
+	// The priority is optional, so an empty body is fine.
+	var dto CheckInDTO
+	_ = c.BodyParser(&dto)
+
+	userId, _ := c.Locals("user_id").(string)
+	checkIn, err := h.service.CheckIn(c.Params("id"), userId, dto.Priority)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(checkIn)
+}
+
+func (h *Handler) SetTriagePriority(c *fiber.Ctx) error {
+	var dto CheckInDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := h.service.SetTriagePriority(c.Params("id"), dto.Priority); err != nil {
+		return appointmentError(c, err)
+	}
+	return nil
+}
+
+func (h *Handler) GetPhysicianQueue(c *fiber.Ctx) error {
+	queue, err := h.service.GetPhysicianQueue(c.Params("physicianId"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(queue)
+}
+
+func (h *Handler) CallNextPatient(c *fiber.Ctx) error {
+	userId, _ := c.Locals("user_id").(string)
+	entry, err := h.service.CallNextPatient(c.Params("physicianId"), userId)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(entry)
+}
+
+// GetWaitMetrics answers GET /appointments/queue/metrics?clinic_id=&from=&to=.
+// Dates without a time are days in the clinic's time zone.
+func (h *Handler) GetWaitMetrics(c *fiber.Ctx) error {
+	clinicId := c.Query("clinic_id")
+	loc, err := h.service.ClinicLocation(clinicId)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		if from, err = parseDateParam(value, loc, false); err != nil {
+			return appointmentError(c, err)
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = parseDateParam(value, loc, true); err != nil {
+			return appointmentError(c, err)
+		}
+	}
+
+	metrics, err := h.service.GetWaitMetrics(clinicId, from, to)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(metrics)
+}
@@ -0,0 +1,229 @@
+package appointments
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func (r *statusRepo) CreateCheckIn(checkIn *AppointmentCheckIn, change StatusChange) error {
+	if err := r.UpdateAppointmentStatus(change); err != nil {
+		return err
+	}
+	copied := *checkIn
+	r.checkIns[checkIn.AppointmentID] = &copied
+	return nil
+}
+
+func (r *statusRepo) UpdateTriagePriority(appointmentId string, priority TriagePriority) (*AppointmentCheckIn, error) {
+	checkIn := r.checkIns[appointmentId]
+	if checkIn == nil || r.appointments[appointmentId].Status != string(AppointmentStatusCheckedIn) {
+		return nil, ErrNotCheckedIn
+	}
+	checkIn.Priority = priority
+	copied := *checkIn
+	return &copied, nil
+}
+
+func (r *statusRepo) CallCheckIn(change StatusChange, at time.Time) error {
+	if err := r.UpdateAppointmentStatus(change); err != nil {
+		return err
+	}
+	r.checkIns[change.AppointmentID].CalledAt = &at
+	r.checkIns[change.AppointmentID].CalledBy = change.ActorUserID
+	return nil
+}
+
+func (r *statusRepo) queueEntries(match func(c *AppointmentCheckIn) bool) []QueueEntry {
+	entries := []QueueEntry{}
+	for _, checkIn := range r.checkIns {
+		if !match(checkIn) {
+			continue
+		}
+		appointment := r.appointments[checkIn.AppointmentID]
+		entries = append(entries, QueueEntry{
+			AppointmentID: checkIn.AppointmentID,
+			PatientID:     appointment.PatientId,
+			PhysicianID:   checkIn.PhysicianID,
+			Status:        AppointmentStatus(appointment.Status),
+			DateTime:      appointment.DateTime,
+			Priority:      checkIn.Priority,
+			ArrivedAt:     checkIn.ArrivedAt,
+			CalledAt:      checkIn.CalledAt,
+		})
+	}
+	return entries
+}
+
+func (r *statusRepo) GetPhysicianQueue(physicianId string, since time.Time) ([]QueueEntry, error) {
+	return r.queueEntries(func(c *AppointmentCheckIn) bool {
+		status := AppointmentStatus(r.appointments[c.AppointmentID].Status)
+		return c.PhysicianID == physicianId && !c.ArrivedAt.Before(since) &&
+			(status == AppointmentStatusCheckedIn || status == AppointmentStatusInProgress)
+	}), nil
+}
+
+func (r *statusRepo) GetClinicCheckIns(clinicId string, from, to time.Time) ([]QueueEntry, error) {
+	return r.queueEntries(func(c *AppointmentCheckIn) bool {
+		return c.ClinicID == clinicId && !c.ArrivedAt.Before(from) && c.ArrivedAt.Before(to)
+	}), nil
+}
+
+func (r *statusRepo) GetClinicTimezone(clinicId string) (string, error) {
+	return "", nil
+}
+
+type queueMessage struct {
+	clinicId string
+	queue    *PhysicianQueue
+}
+
+// recordingNotifier keeps the queues pushed to each clinic.
+type recordingNotifier struct {
+	messages []queueMessage
+}
+
+func (n *recordingNotifier) BroadcastToClinic(clinicID string, messageType string, data interface{}) {
+	if messageType == QueueMessage {
+		n.messages = append(n.messages, queueMessage{clinicId: clinicID, queue: data.(*PhysicianQueue)})
+	}
+}
+
+func (n *recordingNotifier) last() queueMessage {
+	if len(n.messages) == 0 {
+		return queueMessage{}
+	}
+	return n.messages[len(n.messages)-1]
+}
+
+func TestSortQueue(t *testing.T) {
+	at := time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC)
+	entries := []QueueEntry{
+		{AppointmentID: "late", DateTime: at.Add(time.Hour), ArrivedAt: at},
+		{AppointmentID: "early", DateTime: at, ArrivedAt: at.Add(10 * time.Minute)},
+		{AppointmentID: "urgent", DateTime: at.Add(2 * time.Hour), ArrivedAt: at.Add(20 * time.Minute), Priority: TriageUrgent},
+		{AppointmentID: "early-first", DateTime: at, ArrivedAt: at.Add(5 * time.Minute)},
+		{AppointmentID: "preferential", DateTime: at.Add(3 * time.Hour), ArrivedAt: at, Priority: TriagePreferential},
+	}
+	sortQueue(entries)
+
+	want := []string{"urgent", "preferential", "early-first", "early", "late"}
+	for i, entry := range entries {
+		if entry.AppointmentID != want[i] {
+			t.Fatalf("position %d = %s, want order %v", i, entry.AppointmentID, want)
+		}
+	}
+}
+
+func TestWaitingRoom(t *testing.T) {
+	now := time.Now()
+	appointment := func(id string, at time.Time, status AppointmentStatus) *MedicalAppointment {
+		return &MedicalAppointment{ID: id, PatientId: "pa-" + id, PhysicianId: "ph-1", DateTime: at, EndTime: at.Add(30 * time.Minute), Status: string(status)}
+	}
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{
+			"a1":       appointment("a1", now, AppointmentStatusConfirmed),
+			"a2":       appointment("a2", now, AppointmentStatusPending),
+			"a3":       appointment("a3", now, AppointmentStatusConfirmed),
+			"tomorrow": appointment("tomorrow", now.Add(48*time.Hour), AppointmentStatusConfirmed),
+			"done":     appointment("done", now, AppointmentStatusCompleted),
+		},
+		clinics:  map[string]string{"ph-1": "cl-1"},
+		checkIns: map[string]*AppointmentCheckIn{},
+	}
+	notifier := &recordingNotifier{}
+	s := &service{repo: repo, notifier: notifier}
+
+	if _, err := s.CheckIn("a1", "desk", TriageRoutine); err != nil {
+		t.Fatalf("CheckIn() error = %v", err)
+	}
+	if _, err := s.CheckIn("a2", "desk", TriageRoutine); err != nil {
+		t.Fatalf("CheckIn() error = %v", err)
+	}
+	if _, err := s.CheckIn("a3", "desk", TriageUrgent); err != nil {
+		t.Fatalf("CheckIn() error = %v", err)
+	}
+	if _, err := s.CheckIn("a1", "desk", TriageRoutine); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("checking in twice: got %v, want ErrInvalidTransition", err)
+	}
+	if _, err := s.CheckIn("tomorrow", "desk", TriageRoutine); !errors.Is(err, ErrCheckInDay) {
+		t.Errorf("checking in another day: got %v, want ErrCheckInDay", err)
+	}
+	if _, err := s.CheckIn("done", "desk", TriageRoutine); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("checking in a completed appointment: got %v, want ErrInvalidTransition", err)
+	}
+	if _, err := s.CheckIn("a2", "desk", 7); !errors.Is(err, ErrInvalidCheckIn) {
+		t.Errorf("unknown priority: got %v, want ErrInvalidCheckIn", err)
+	}
+
+	pushed := notifier.last()
+	if pushed.clinicId != "cl-1" || len(pushed.queue.Waiting) != 3 || pushed.queue.Waiting[0].AppointmentID != "a3" {
+		t.Fatalf("pushed %+v, want the clinic's queue with the urgent patient first", pushed)
+	}
+
+	// Triage moves a2 ahead of a1 but not of the urgent patient.
+	if err := s.SetTriagePriority("a2", TriagePreferential); err != nil {
+		t.Fatalf("SetTriagePriority() error = %v", err)
+	}
+
+	var called []string
+	for i := 0; i < 3; i++ {
+		entry, err := s.CallNextPatient("ph-1", "doctor")
+		if err != nil {
+			t.Fatalf("CallNextPatient() error = %v", err)
+		}
+		called = append(called, entry.AppointmentID)
+		if entry.Status != AppointmentStatusInProgress || entry.CalledAt == nil {
+			t.Errorf("called entry = %+v, want in progress", entry)
+		}
+	}
+	if got, want := called, []string{"a3", "a2", "a1"}; got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("called %v, want %v", got, want)
+	}
+	if _, err := s.CallNextPatient("ph-1", "doctor"); !errors.Is(err, ErrQueueEmpty) {
+		t.Errorf("empty queue: got %v, want ErrQueueEmpty", err)
+	}
+	if err := s.SetTriagePriority("a1", TriageUrgent); !errors.Is(err, ErrNotCheckedIn) {
+		t.Errorf("triaging a patient being seen: got %v, want ErrNotCheckedIn", err)
+	}
+
+	if err := s.UpdateAppointmentStatus("a3", AppointmentStatusCompleted, "doctor", ""); err != nil {
+		t.Fatalf("completing: %v", err)
+	}
+	if pushed := notifier.last(); len(pushed.queue.InProgress) != 2 {
+		t.Errorf("after completing, pushed %d in progress, want 2", len(pushed.queue.InProgress))
+	}
+	if err := s.UpdateAppointmentStatus("a1", AppointmentStatusCheckedIn, "doctor", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("setting checked_in directly: got %v, want ErrInvalidTransition", err)
+	}
+
+	metrics, err := s.GetWaitMetrics("cl-1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("GetWaitMetrics() error = %v", err)
+	}
+	if metrics.CheckedIn != 3 || metrics.Called != 3 || metrics.Waiting != 0 || len(metrics.ByPhysician) != 1 {
+		t.Errorf("metrics = %+v, want 3 checked in and called", metrics)
+	}
+}
+
+func TestWaitStats(t *testing.T) {
+	now := time.Date(2025, 3, 10, 15, 0, 0, 0, time.UTC)
+	calledAt := func(minutes int) *time.Time {
+		at := now.Add(-time.Hour).Add(time.Duration(minutes) * time.Minute)
+		return &at
+	}
+	arrived := now.Add(-time.Hour)
+	stats := waitStats([]QueueEntry{
+		{Status: AppointmentStatusCompleted, ArrivedAt: arrived, CalledAt: calledAt(10)},
+		{Status: AppointmentStatusInProgress, ArrivedAt: arrived, CalledAt: calledAt(30)},
+		{Status: AppointmentStatusCheckedIn, ArrivedAt: arrived},
+		{Status: AppointmentStatusCancelled, ArrivedAt: arrived},
+	}, now)
+
+	if stats.CheckedIn != 4 || stats.Called != 2 || stats.Waiting != 1 {
+		t.Errorf("counts = %+v", stats)
+	}
+	if stats.AverageWaitMinutes != 20 || stats.MaxWaitMinutes != 30 || stats.LongestWaitingMinutes != 60 {
+		t.Errorf("waits = %+v, want average 20, max 30 and 60 still waiting", stats)
+	}
+}
@@ -0,0 +1,156 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// defaultAppointmentLength is the length given to appointments booked before
+// appointments had an end time.
+const defaultAppointmentLength = "30 minutes"
+
+// exclusionViolation is the Postgres error code raised when a row breaks an
+// exclusion constraint.
+const exclusionViolation = "23P01"
+
+// AppointmentConflictError is returned when an appointment would overlap
+// another active appointment of the same physician or patient.
+type AppointmentConflictError struct {
+	AppointmentID string
+}
+
+func (e *AppointmentConflictError) Error() string {
+	return "the appointment overlaps another appointment of the physician or the patient"
+}
+
+// EnsureConstraints backfills the end time of older appointments and installs
+// the exclusion constraints that keep active appointments of a physician, and
+// of a patient, from overlapping. Appointments double booked before the
+// constraints existed are never changed here: while any remain, the constraint
+// is left out and the overlapping pairs are logged so staff can cancel or move
+// them through the usual endpoints, which notify the patient. It is safe to
+// run on every start.
+func EnsureConstraints(db *gorm.DB) error {
+	statements := []string{
+		`CREATE EXTENSION IF NOT EXISTS btree_gist`,
+		`UPDATE medical_appointments SET end_time = date_time + interval '` + defaultAppointmentLength + `'
+			WHERE end_time IS NULL OR end_time <= date_time`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("error al preparar las restricciones de citas médicas: %w", err)
+		}
+	}
+
+	constraints := []struct{ name, column string }{
+		{"medical_appointments_physician_no_overlap", "physician_id"},
+		{"medical_appointments_patient_no_overlap", "patient_id"},
+	}
+	for _, constraint := range constraints {
+		name, column := constraint.name, constraint.column
+		var exists bool
+		if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = ?)`, name).Scan(&exists).Error; err != nil {
+			return fmt.Errorf("error al verificar la restricción %s: %w", name, err)
+		}
+		if exists {
+			continue
+		}
+
+		statement := fmt.Sprintf(`ALTER TABLE medical_appointments ADD CONSTRAINT %s
+			EXCLUDE USING gist (%s WITH =, tstzrange(date_time, end_time) WITH &&)
+			WHERE (status <> '%s' AND deleted_at IS NULL)`, name, column, AppointmentStatusCancelled)
+		var overlaps []overlapPair
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// Exclusion constraints cannot be added NOT VALID, so writes are
+			// held off between checking for overlaps and adding the constraint.
+			if err := tx.Exec(`LOCK TABLE medical_appointments IN SHARE ROW EXCLUSIVE MODE`).Error; err != nil {
+				return err
+			}
+			var err error
+			if overlaps, err = findOverlaps(tx, column); err != nil || len(overlaps) > 0 {
+				return err
+			}
+			return tx.Exec(statement).Error
+		})
+		if err != nil {
+			return fmt.Errorf("error al crear la restricción %s: %w", name, err)
+		}
+		if len(overlaps) > 0 {
+			log.Printf("appointment constraints: %s left out, %d pairs of active appointments overlap by %s; cancel or move one of each and restart: %s",
+				name, len(overlaps), column, overlapReport(overlaps))
+		}
+	}
+	return nil
+}
+
+// overlapPair is two active appointments of the same physician or patient
+// whose times overlap.
+type overlapPair struct {
+	FirstID  string
+	SecondID string
+}
+
+// findOverlaps lists the active appointments double booked on column, each
+// pair once, earliest first.
+func findOverlaps(tx *gorm.DB, column string) ([]overlapPair, error) {
+	var pairs []overlapPair
+	err := tx.Raw(fmt.Sprintf(`SELECT a.id AS first_id, b.id AS second_id
+		FROM medical_appointments a
+		JOIN medical_appointments b ON b.%[1]s = a.%[1]s AND b.id > a.id
+			AND tstzrange(b.date_time, b.end_time) && tstzrange(a.date_time, a.end_time)
+		WHERE a.status <> ? AND a.deleted_at IS NULL AND b.status <> ? AND b.deleted_at IS NULL
+		ORDER BY a.date_time, a.id, b.id`, column),
+		string(AppointmentStatusCancelled), string(AppointmentStatusCancelled)).
+		Scan(&pairs).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar citas superpuestas: %w", err)
+	}
+	return pairs, nil
+}
+
+func overlapReport(pairs []overlapPair) string {
+	listed := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		listed = append(listed, pair.FirstID+"/"+pair.SecondID)
+	}
+	return strings.Join(listed, ", ")
+}
+
+// conflictError turns an exclusion violation raised while saving appointments
+// into an AppointmentConflictError naming the first existing appointment they
+// collide with. It returns nil for any other error.
+func (r *repository) conflictError(err error, appointments ...MedicalAppointment) error {
+	if !isExclusionViolation(err) {
+		return nil
+	}
+
+	ids := make([]string, 0, len(appointments))
+	for _, appointment := range appointments {
+		ids = append(ids, appointment.ID)
+	}
+
+	for _, appointment := range appointments {
+		var conflicting MedicalAppointment
+		err := r.db.Where("id NOT IN ? AND status <> ? AND date_time < ? AND end_time > ? AND (physician_id = ? OR patient_id = ?)",
+			ids, string(AppointmentStatusCancelled), appointment.EndTime, appointment.DateTime,
+			appointment.PhysicianId, appointment.PatientId).
+			Order("date_time").
+			First(&conflicting).Error
+		if err == nil {
+			return &AppointmentConflictError{AppointmentID: conflicting.ID}
+		}
+	}
+
+	// The appointments overlap each other rather than an existing one.
+	return &AppointmentConflictError{}
+}
+
+func isExclusionViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == exclusionViolation
+}
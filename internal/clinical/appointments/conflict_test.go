@@ -0,0 +1,59 @@
+package appointments
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEnsureConstraints_LeavesOverlapsAlone(t *testing.T) {
+	db, mock := setupTestDB(t)
+
+	mock.ExpectExec(`CREATE EXTENSION IF NOT EXISTS btree_gist`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE medical_appointments SET end_time`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// The physicians' constraint waits for the double bookings to be sorted
+	// out by hand: nothing is cancelled and the constraint is not added.
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_constraint WHERE conname = \$1\)`).
+		WithArgs("medical_appointments_physician_no_overlap").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`LOCK TABLE medical_appointments`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT a.id AS first_id, b.id AS second_id .+ b.physician_id = a.physician_id`).
+		WithArgs(string(AppointmentStatusCancelled), string(AppointmentStatusCancelled)).
+		WillReturnRows(sqlmock.NewRows([]string{"first_id", "second_id"}).
+			AddRow("appt-1", "appt-2").
+			AddRow("appt-3", "appt-4"))
+	mock.ExpectCommit()
+
+	// The patients' constraint goes in once no overlaps are found.
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_constraint WHERE conname = \$1\)`).
+		WithArgs("medical_appointments_patient_no_overlap").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`LOCK TABLE medical_appointments`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT a.id AS first_id, b.id AS second_id .+ b.patient_id = a.patient_id`).
+		WithArgs(string(AppointmentStatusCancelled), string(AppointmentStatusCancelled)).
+		WillReturnRows(sqlmock.NewRows([]string{"first_id", "second_id"}))
+	mock.ExpectExec(`ALTER TABLE medical_appointments ADD CONSTRAINT medical_appointments_patient_no_overlap`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := EnsureConstraints(db); err != nil {
+		t.Fatalf("EnsureConstraints() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestOverlapReport(t *testing.T) {
+	got := overlapReport([]overlapPair{{"appt-1", "appt-2"}, {"appt-3", "appt-4"}})
+	if want := "appt-1/appt-2, appt-3/appt-4"; got != want {
+		t.Errorf("overlapReport() = %q, want %q", got, want)
+	}
+}
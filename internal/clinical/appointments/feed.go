@@ -0,0 +1,138 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// feedHistory is how far back a calendar feed lists appointments.
+const feedHistory = 90 * 24 * time.Hour
+
+var ErrCalendarFeedRole = errors.New("calendar feeds are available to physicians and patients")
+
+type CalendarFeedKind string
+
+const (
+	CalendarFeedPhysician CalendarFeedKind = "physician"
+	CalendarFeedPatient   CalendarFeedKind = "patient"
+)
+
+// CalendarFeed is a read-only iCalendar subscription to a user's
+// appointments. The URL carries a secret token, stored hashed; revoking the
+// feed makes the URL stop working.
+type CalendarFeed struct {
+	ID     string `gorm:"primaryKey" json:"id"`
+	UserID string `gorm:"not null;index" json:"user_id"`
+	Kind   string `gorm:"not null" json:"kind"`
+	// SubjectID is the physician of a physician feed, or the user of a patient
+	// feed.
+	SubjectID      string     `gorm:"not null" json:"subject_id"`
+	TokenHash      string     `gorm:"not null;uniqueIndex" json:"-"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// CreateCalendarFeed creates a feed of the caller's appointments and returns
+// it with its token, which is not stored and cannot be shown again.
+func (s *service) CreateCalendarFeed(userId, role string) (*CalendarFeed, string, error) {
+	feed := &CalendarFeed{UserID: userId}
+	switch role {
+	case users.RolePhysician:
+		physicianId, err := s.repo.GetPhysicianIdByUserId(userId)
+		if err != nil {
+			return nil, "", err
+		}
+		feed.Kind, feed.SubjectID = string(CalendarFeedPhysician), physicianId
+	case users.RolePatient:
+		feed.Kind, feed.SubjectID = string(CalendarFeedPatient), userId
+	default:
+		return nil, "", ErrCalendarFeedRole
+	}
+
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return nil, "", err
+	}
+	feed.ID = id
+	feed.TokenHash = utils.HashToken(raw)
+
+	if err := s.repo.CreateCalendarFeed(feed); err != nil {
+		return nil, "", err
+	}
+	return feed, raw, nil
+}
+
+func (s *service) GetCalendarFeeds(userId string) ([]CalendarFeed, error) {
+	return s.repo.GetCalendarFeeds(userId)
+}
+
+func (s *service) RevokeCalendarFeed(userId, feedId string) error {
+	return s.repo.RevokeCalendarFeed(userId, feedId, time.Now())
+}
+
+// RenderCalendarFeed returns the iCalendar document of the feed with the
+// token: the appointments of the last 90 days and the upcoming ones.
+// Cancelled appointments stay in the feed with STATUS:CANCELLED so subscribed
+// calendars remove them, and each event keeps the appointment's UID so a
+// reschedule updates it in place. Reasons are left out, as the feed ends up in
+// third-party calendars.
+func (s *service) RenderCalendarFeed(token string) ([]byte, error) {
+	feed, err := s.repo.GetCalendarFeedByToken(utils.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	var appointments []AppointmentWithNamesDTO
+	if feed.Kind == string(CalendarFeedPhysician) {
+		appointments, err = s.repo.GetAllAppointmentsByMedicId(feed.SubjectID)
+	} else {
+		appointments, err = s.repo.GetAllAppointmentsByUserId(feed.SubjectID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := s.repo.TouchCalendarFeed(feed.ID, now); err != nil {
+		return nil, err
+	}
+
+	events := []calendarEvent{}
+	for _, appointment := range appointments {
+		if appointment.DateTime.Before(now.Add(-feedHistory)) {
+			continue
+		}
+		// A patient feed covers the appointments the user attends as patient.
+		if feed.Kind == string(CalendarFeedPatient) && appointment.Patient.UserID != feed.SubjectID {
+			continue
+		}
+		events = append(events, feedEvent(appointment, CalendarFeedKind(feed.Kind)))
+	}
+	return buildICS("Altheia - Citas médicas", events, now), nil
+}
+
+func feedEvent(appointment AppointmentWithNamesDTO, kind CalendarFeedKind) calendarEvent {
+	summary := "Cita médica con " + appointment.PhysicianName
+	if kind == CalendarFeedPhysician {
+		summary = "Cita: " + appointment.PatientName
+	}
+
+	return calendarEvent{
+		UID:      appointmentUID(appointment.ID),
+		Summary:  summary,
+		Location: appointment.ClinicName,
+		Start:    appointment.DateTime,
+		End:      appointment.EndTime,
+		Status:   appointmentEventStatus(AppointmentStatus(appointment.Status)),
+		Modified: appointment.UpdatedAt,
+	}
+}
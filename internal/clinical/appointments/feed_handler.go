@@ -0,0 +1,59 @@
+package appointments
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// CreateCalendarFeed answers POST /appointments/calendar-feeds with the
+// subscription URL of a new feed of the caller's appointments.
+func (h *Handler) CreateCalendarFeed(c *fiber.Ctx) error {
+	userId, _ := c.Locals("user_id").(string)
+	role, _ := c.Locals("user_role").(string)
+
+	feed, token, err := h.service.CreateCalendarFeed(userId, role)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	url := c.BaseURL() + "/calendar/" + token + ".ics"
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"feed":       feed,
+		"url":        url,
+		"webcal_url": "webcal://" + strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://"),
+	})
+}
+
+func (h *Handler) GetCalendarFeeds(c *fiber.Ctx) error {
+	userId, _ := c.Locals("user_id").(string)
+
+	feeds, err := h.service.GetCalendarFeeds(userId)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(feeds)
+}
+
+func (h *Handler) RevokeCalendarFeed(c *fiber.Ctx) error {
+	userId, _ := c.Locals("user_id").(string)
+
+	if err := h.service.RevokeCalendarFeed(userId, c.Params("id")); err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "calendar feed revoked"})
+}
+
+// GetCalendarFeed serves GET /calendar/:token.ics to calendar clients.
+func (h *Handler) GetCalendarFeed(c *fiber.Ctx) error {
+	token := strings.TrimSuffix(c.Params("token"), ".ics")
+
+	calendar, err := h.service.RenderCalendarFeed(token)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Send(calendar)
+}
@@ -0,0 +1,148 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/users"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func (r *statusRepo) withNames(match func(*MedicalAppointment) bool) []AppointmentWithNamesDTO {
+	var result []AppointmentWithNamesDTO
+	for _, appointment := range r.appointments {
+		if match(appointment) {
+			result = append(result, AppointmentWithNamesDTO{
+				MedicalAppointment: *appointment,
+				PatientName:        "Ana Pérez",
+				PhysicianName:      "Dr. Ruiz",
+				ClinicName:         "Clínica Norte",
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DateTime.Before(result[j].DateTime) })
+	return result
+}
+
+func (r *statusRepo) GetAllAppointmentsByMedicId(physicianId string) ([]AppointmentWithNamesDTO, error) {
+	return r.withNames(func(a *MedicalAppointment) bool { return a.PhysicianId == physicianId }), nil
+}
+
+func (r *statusRepo) GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNamesDTO, error) {
+	return r.withNames(func(a *MedicalAppointment) bool { return a.Patient.UserID == userId }), nil
+}
+
+func (r *statusRepo) GetPhysicianIdByUserId(userId string) (string, error) {
+	return "ph-of-" + userId, nil
+}
+
+func (r *statusRepo) CreateCalendarFeed(feed *CalendarFeed) error {
+	r.feeds = append(r.feeds, feed)
+	return nil
+}
+
+func (r *statusRepo) GetCalendarFeeds(userId string) ([]CalendarFeed, error) {
+	var feeds []CalendarFeed
+	for _, feed := range r.feeds {
+		if feed.UserID == userId && feed.RevokedAt == nil {
+			feeds = append(feeds, *feed)
+		}
+	}
+	return feeds, nil
+}
+
+func (r *statusRepo) GetCalendarFeedByToken(tokenHash string) (*CalendarFeed, error) {
+	for _, feed := range r.feeds {
+		if feed.TokenHash == tokenHash && feed.RevokedAt == nil {
+			return feed, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) RevokeCalendarFeed(userId, feedId string, at time.Time) error {
+	for _, feed := range r.feeds {
+		if feed.ID == feedId && feed.UserID == userId && feed.RevokedAt == nil {
+			feed.RevokedAt = &at
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) TouchCalendarFeed(feedId string, at time.Time) error {
+	return nil
+}
+
+func TestCalendarFeeds(t *testing.T) {
+	soon := time.Now().Add(48 * time.Hour).Truncate(time.Minute).UTC()
+	appointment := func(id, physicianId, patientUserId, status string, start time.Time) *MedicalAppointment {
+		return &MedicalAppointment{
+			ID: id, PhysicianId: physicianId, DateTime: start, EndTime: start.Add(30 * time.Minute), Status: status,
+			Reason: "Control de diabetes", Patient: users.Patient{UserID: patientUserId},
+		}
+	}
+	repo := &statusRepo{appointments: map[string]*MedicalAppointment{
+		"upcoming":  appointment("upcoming", "ph-of-doc", "pat-user", string(AppointmentStatusConfirmed), soon),
+		"cancelled": appointment("cancelled", "ph-of-doc", "pat-user", string(AppointmentStatusCancelled), soon.Add(time.Hour)),
+		"old":       appointment("old", "ph-of-doc", "pat-user", string(AppointmentStatusCompleted), soon.AddDate(0, -6, 0)),
+		"other":     appointment("other", "ph-other", "someone", string(AppointmentStatusConfirmed), soon),
+	}}
+	s := &service{repo: repo}
+
+	feed, token, err := s.CreateCalendarFeed("doc", users.RolePhysician)
+	if err != nil {
+		t.Fatalf("CreateCalendarFeed() error = %v", err)
+	}
+	if feed.SubjectID != "ph-of-doc" || feed.TokenHash == token {
+		t.Fatalf("feed = %+v, want the caller's physician and a hashed token", feed)
+	}
+
+	calendar, err := s.RenderCalendarFeed(token)
+	if err != nil {
+		t.Fatalf("RenderCalendarFeed() error = %v", err)
+	}
+	ics := strings.ReplaceAll(string(calendar), "\r\n ", "")
+	for _, want := range []string{
+		"UID:upcoming@altheia\r\nDTSTAMP",
+		"SUMMARY:Cita: Ana Pérez",
+		"UID:cancelled@altheia",
+		"STATUS:CANCELLED",
+		"STATUS:CONFIRMED",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("feed does not contain %q:\n%s", want, ics)
+		}
+	}
+	for _, unwanted := range []string{"old@altheia", "other@altheia", "diabetes"} {
+		if strings.Contains(ics, unwanted) {
+			t.Errorf("feed contains %q", unwanted)
+		}
+	}
+
+	_, patientToken, err := s.CreateCalendarFeed("pat-user", users.RolePatient)
+	if err != nil {
+		t.Fatalf("CreateCalendarFeed(patient) error = %v", err)
+	}
+	calendar, err = s.RenderCalendarFeed(patientToken)
+	if err != nil || !strings.Contains(string(calendar), "SUMMARY:Cita médica con Dr. Ruiz") {
+		t.Errorf("patient feed = %q, %v; want the patient's appointments", calendar, err)
+	}
+
+	if err := s.RevokeCalendarFeed("someone-else", feed.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("revoking another user's feed: error = %v, want not found", err)
+	}
+	if err := s.RevokeCalendarFeed("doc", feed.ID); err != nil {
+		t.Fatalf("RevokeCalendarFeed() error = %v", err)
+	}
+	if _, err := s.RenderCalendarFeed(token); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("revoked feed: error = %v, want not found", err)
+	}
+
+	if _, _, err := s.CreateCalendarFeed("desk", users.RoleReceptionist); !errors.Is(err, ErrCalendarFeedRole) {
+		t.Errorf("receptionist feed: error = %v, want ErrCalendarFeedRole", err)
+	}
+}
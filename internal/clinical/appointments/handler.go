@@ -1,7 +1,14 @@
 package appointments
 
 import (
+	"Altheia-Backend/internal/users"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
@@ -20,11 +27,19 @@ func (h *Handler) CreateAppointment(c *fiber.Ctx) error {
 		})
 	}
 
-	createAppointmentError := h.service.CreateAppointment(createAppointmentDTO)
+	if createAppointmentDTO.Recurrence != nil {
+		series, err := h.service.CreateSeries(createAppointmentDTO)
+		if err != nil {
+			return appointmentError(c, err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(series)
+	}
+
+	appointment, createAppointmentError := h.service.CreateAppointment(createAppointmentDTO)
 	if createAppointmentError != nil {
-		return createAppointmentError
+		return appointmentError(c, createAppointmentError)
 	}
-	return nil
+	return c.Status(fiber.StatusCreated).JSON(appointment)
 }
 
 func (h *Handler) RescheduleAppointment(c *fiber.Ctx) error {
@@ -37,19 +52,73 @@ func (h *Handler) RescheduleAppointment(c *fiber.Ctx) error {
 		})
 	}
 
-	rescheduleAppointmentError := h.service.RescheduleAppointment(appointmentId, newDateTimeDTO.NewDateTime)
+	scope, err := ParseSeriesScope(newDateTimeDTO.Scope)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	actorId, _ := c.Locals("user_id").(string)
+	rescheduleAppointmentError := h.service.RescheduleAppointment(appointmentId, newDateTimeDTO.NewDateTime, actorId, newDateTimeDTO.Reason, scope)
 	if rescheduleAppointmentError != nil {
-		return rescheduleAppointmentError
+		return appointmentError(c, rescheduleAppointmentError)
 	}
 	return nil
 }
 
+// appointmentError maps booking errors to their HTTP status.
+func appointmentError(c *fiber.Ctx, err error) error {
+	var unavailable *SeriesUnavailableError
+	if errors.As(err, &unavailable) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":       err.Error(),
+			"unavailable": unavailable.Unavailable,
+		})
+	}
+
+	var conflict *AppointmentConflictError
+	if errors.As(err, &conflict) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":                      err.Error(),
+			"conflicting_appointment_id": conflict.AppointmentID,
+		})
+	}
+
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrSlotUnavailable), errors.Is(err, ErrInvalidTransition), errors.Is(err, ErrStatusChanged),
+		errors.Is(err, ErrBookingPolicy), errors.Is(err, ErrCheckInDay), errors.Is(err, ErrNotCheckedIn):
+		status = fiber.StatusConflict
+	case errors.Is(err, ErrInvalidAvailability), errors.Is(err, ErrInvalidSlotRange), errors.Is(err, ErrInvalidDateTime),
+		errors.Is(err, ErrInvalidStatus), errors.Is(err, ErrInvalidRecurrence), errors.Is(err, ErrInvalidScope),
+		errors.Is(err, ErrInvalidWaitlistEntry), errors.Is(err, ErrInvalidSearch),
+		errors.Is(err, ErrInvalidBookingPolicy), errors.Is(err, ErrInvalidCheckIn):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, ErrNotInSeries), errors.Is(err, ErrQueueEmpty):
+		status = fiber.StatusNotFound
+	case errors.Is(err, ErrOfferUnavailable), errors.Is(err, ErrInvalidActionLink):
+		status = fiber.StatusGone
+	case errors.Is(err, ErrCalendarFeedRole):
+		status = fiber.StatusForbidden
+	}
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
 func (h *Handler) CancelAppointment(c *fiber.Ctx) error {
 	appointmentId := c.Params("id")
 
-	cancelAppointmentError := h.service.CancelAppointment(appointmentId)
+	// The reason and scope are optional, so an empty body is fine.
+	var statusChangeDTO StatusChangeDTO
+	_ = c.BodyParser(&statusChangeDTO)
+
+	scope, err := ParseSeriesScope(statusChangeDTO.Scope)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	actorId, _ := c.Locals("user_id").(string)
+	cancelAppointmentError := h.service.CancelAppointment(appointmentId, actorId, statusChangeDTO.Reason, scope)
 	if cancelAppointmentError != nil {
-		return cancelAppointmentError
+		return appointmentError(c, cancelAppointmentError)
 	}
 	return nil
 }
@@ -68,15 +137,49 @@ func (h *Handler) GetAllAppointments(c *fiber.Ctx) error {
 
 func (h *Handler) UpdateAppointmentStatus(c *fiber.Ctx) error {
 	appointmentId := c.Params("id")
-	status := c.Params("status")
 
-	err := h.service.UpdateAppointmentStatus(appointmentId, AppointmentStatus(status))
+	// The status comes in the body; the query string is still accepted for
+	// older clients.
+	var statusChangeDTO StatusChangeDTO
+	_ = c.BodyParser(&statusChangeDTO)
+	if statusChangeDTO.Status == "" {
+		statusChangeDTO.Status = c.Query("status")
+	}
+
+	actorId, _ := c.Locals("user_id").(string)
+	err := h.service.UpdateAppointmentStatus(appointmentId, AppointmentStatus(statusChangeDTO.Status), actorId, statusChangeDTO.Reason)
 	if err != nil {
-		return err
+		return appointmentError(c, err)
 	}
 	return nil
 }
 
+func (h *Handler) GetStatusHistory(c *fiber.Ctx) error {
+	history, err := h.service.GetStatusHistory(c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(history)
+}
+
+func (h *Handler) GetAppointment(c *fiber.Ctx) error {
+	appointment, err := h.service.GetAppointment(c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(appointment)
+}
+
+// GetAppointmentConsultation answers GET /appointments/:id/consultation with
+// the notes of the consultation that fulfilled the appointment.
+func (h *Handler) GetAppointmentConsultation(c *fiber.Ctx) error {
+	consultation, err := h.service.GetAppointmentConsultation(c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(consultation)
+}
+
 func (h *Handler) GetAllAppointmentsByMedicId(c *fiber.Ctx) error {
 	physicianId := c.Params("id")
 	var appointment []AppointmentWithNamesDTO
@@ -102,3 +205,77 @@ func (h *Handler) GetAllAppointmentsByUserId(c *fiber.Ctx) error {
 
 	return c.JSON(appointment)
 }
+
+// SearchAppointments answers GET /appointments/search. Super-admins search
+// every clinic, other staff only their own, and patients only their own
+// appointments.
+func (h *Handler) SearchAppointments(c *fiber.Ctx) error {
+	search := AppointmentSearch{
+		ClinicID:    c.Query("clinic_id"),
+		PhysicianID: c.Query("physician_id"),
+		PatientID:   c.Query("patient_id"),
+		Reason:      c.Query("q"),
+		Sort:        AppointmentSort(c.Query("sort")),
+		Cursor:      c.Query("cursor"),
+	}
+	for _, status := range strings.Split(c.Query("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			search.Statuses = append(search.Statuses, AppointmentStatus(status))
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return appointmentError(c, fmt.Errorf("%w: limit must be a number", ErrInvalidSearch))
+		}
+		search.Limit = limit
+	}
+
+	role, _ := c.Locals("user_role").(string)
+	switch role {
+	case users.RoleSuperAdmin:
+	case users.RolePatient:
+		search.PatientUserID, _ = c.Locals("user_id").(string)
+	default:
+		clinicId, _ := c.Locals("clinic_id").(string)
+		if clinicId == "" || (search.ClinicID != "" && search.ClinicID != clinicId) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "access denied: resource belongs to another clinic",
+			})
+		}
+		search.ClinicID = clinicId
+	}
+
+	// Dates without a time are days in the clinic's time zone.
+	from, to := c.Query("from"), c.Query("to")
+	if from != "" || to != "" {
+		loc, err := h.service.ClinicLocation(search.ClinicID)
+		if err != nil {
+			return appointmentError(c, err)
+		}
+		if from != "" {
+			if search.From, err = parseDateParam(from, loc, false); err != nil {
+				return appointmentError(c, err)
+			}
+		}
+		if to != "" {
+			if search.To, err = parseDateParam(to, loc, true); err != nil {
+				return appointmentError(c, err)
+			}
+		}
+	}
+
+	page, err := h.service.SearchAppointments(search)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(page)
+}
+
+func (h *Handler) GetSeries(c *fiber.Ctx) error {
+	series, err := h.service.GetSeries(c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(series)
+}
@@ -0,0 +1,102 @@
+package appointments
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// calendarEvent is one VEVENT of an iCalendar document. UID must stay the same
+// for the life of the appointment so calendar apps update the event in place.
+type calendarEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	Status      string
+	Modified    time.Time
+}
+
+const icsTimeFormat = "20060102T150405Z"
+
+// appointmentUID is the stable iCalendar UID of an appointment.
+func appointmentUID(appointmentId string) string {
+	return appointmentId + "@altheia"
+}
+
+// appointmentEventStatus maps the appointment lifecycle onto the iCalendar
+// STATUS values.
+func appointmentEventStatus(status AppointmentStatus) string {
+	switch status {
+	case AppointmentStatusConfirmed, AppointmentStatusCheckedIn, AppointmentStatusInProgress, AppointmentStatusCompleted:
+		return "CONFIRMED"
+	case AppointmentStatusCancelled:
+		return "CANCELLED"
+	default:
+		return "TENTATIVE"
+	}
+}
+
+// buildICS renders an iCalendar (RFC 5545) document with the given events.
+// Times are written in UTC.
+func buildICS(name string, events []calendarEvent, now time.Time) []byte {
+	var b strings.Builder
+	line := func(value string) { b.WriteString(foldICSLine(value)) }
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Altheia EHR//Appointments//ES")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	if name != "" {
+		line("X-WR-CALNAME:" + escapeICSText(name))
+	}
+	for _, event := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + event.UID)
+		line("DTSTAMP:" + now.UTC().Format(icsTimeFormat))
+		line("DTSTART:" + event.Start.UTC().Format(icsTimeFormat))
+		line("DTEND:" + event.End.UTC().Format(icsTimeFormat))
+		if !event.Modified.IsZero() {
+			line("LAST-MODIFIED:" + event.Modified.UTC().Format(icsTimeFormat))
+			line(fmt.Sprintf("SEQUENCE:%d", event.Modified.Unix()))
+		}
+		line("SUMMARY:" + escapeICSText(event.Summary))
+		if event.Description != "" {
+			line("DESCRIPTION:" + escapeICSText(event.Description))
+		}
+		if event.Location != "" {
+			line("LOCATION:" + escapeICSText(event.Location))
+		}
+		line("STATUS:" + event.Status)
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return []byte(b.String())
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICSText(value string) string {
+	return icsTextEscaper.Replace(value)
+}
+
+// foldICSLine ends the content line with CRLF, folding it so no physical line
+// is longer than 75 octets without splitting a UTF-8 character.
+func foldICSLine(value string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range value {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
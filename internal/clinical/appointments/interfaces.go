@@ -11,6 +11,8 @@ const (
 	AppointmentStatusCompleted   AppointmentStatus = "completed"
 	AppointmentStatusNoShow      AppointmentStatus = "no_show"
 	AppointmentStatusRescheduled AppointmentStatus = "rescheduled"
+	AppointmentStatusCheckedIn   AppointmentStatus = "checked_in"
+	AppointmentStatusInProgress  AppointmentStatus = "in_progress"
 )
 
 type CreateAppointmentDTO struct {
@@ -21,6 +23,9 @@ type CreateAppointmentDTO struct {
 	Time        string `json:"time"`
 	Status      string `json:"status"`
 	Reason      string `json:"reason"`
+
+	// Recurrence, when set, books a series starting at Date and Time.
+	Recurrence *RecurrenceDTO `json:"recurrence,omitempty"`
 }
 
 type AppointmentWithNamesDTO struct {
@@ -37,8 +42,12 @@ type AppointmentWithNamesDTO struct {
 	ClinicCity      string `json:"clinic_city"`
 	ClinicAddress   string `json:"clinic_address"`
 	ClinicId        string `json:"clinic_id"`
+	// ConsultationId is the consultation that fulfilled the appointment, if any.
+	ConsultationId *string `json:"consultation_id"`
 }
 
 type NewDateTimeDTO struct {
 	NewDateTime time.Time `json:"new_date_time"`
+	Reason      string    `json:"reason"`
+	Scope       string    `json:"scope"`
 }
@@ -13,8 +13,13 @@ type MedicalAppointment struct {
 	PatientId   string    `json:"patient_id"`
 	PhysicianId string    `json:"physician_id"`
 	DateTime    time.Time `json:"date_time"`
+	EndTime     time.Time `json:"end_time"`
 	Status      string    `json:"status"`
 	Reason      string    `json:"reason"`
+	SeriesID    *string   `gorm:"index" json:"series_id,omitempty"`
+	// AttendanceConfirmedAt is when the patient confirmed they will attend,
+	// from a reminder email.
+	AttendanceConfirmedAt *time.Time `json:"attendance_confirmed_at,omitempty"`
 
 	Patient   users.Patient   `gorm:"foreignKey:PatientId"`
 	Physician users.Physician `gorm:"foreignKey:PhysicianId"`
@@ -46,17 +51,8 @@ func validateAppointment(a *MedicalAppointment) error {
 		return errors.New("status is required")
 	}
 
-	// Validate status
-	validStatuses := map[string]bool{
-		string(AppointmentStatusPending):   true,
-		string(AppointmentStatusConfirmed): true,
-		string(AppointmentStatusCancelled): true,
-		string(AppointmentStatusCompleted): true,
-		string(AppointmentStatusNoShow):    true,
-	}
-
-	if !validStatuses[a.Status] {
-		return errors.New("invalid appointment status")
+	if !AppointmentStatus(a.Status).IsValid() {
+		return ErrInvalidStatus
 	}
 
 	return nil
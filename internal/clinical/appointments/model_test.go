@@ -119,6 +119,10 @@ func TestMedicalAppointment_StatusValidation(t *testing.T) {
 		string(AppointmentStatusConfirmed),
 		string(AppointmentStatusCancelled),
 		string(AppointmentStatusCompleted),
+		string(AppointmentStatusNoShow),
+		string(AppointmentStatusRescheduled),
+		string(AppointmentStatusCheckedIn),
+		string(AppointmentStatusInProgress),
 	}
 	invalidStatuses := []string{"", "INVALID", "PENDING", "CONFIRMED"}
 
@@ -0,0 +1,179 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// noShowBatch bounds how many appointments one sweep marks.
+const noShowBatch = 200
+
+// noShowReportRange is the period a no-show report covers when none is given.
+const noShowReportRange = 90 * 24 * time.Hour
+
+// NoShowAction is what a clinic does with patients who miss too many
+// appointments.
+type NoShowAction string
+
+const (
+	NoShowActionNone NoShowAction = ""
+	// NoShowActionRequireConfirmation leaves their self-bookings pending until
+	// staff confirm them.
+	NoShowActionRequireConfirmation NoShowAction = "require_confirmation"
+	// NoShowActionBlockSelfBooking stops them from booking from the portal.
+	NoShowActionBlockSelfBooking NoShowAction = "block_self_booking"
+)
+
+// AttendanceCounts is how many appointments a patient attended and missed.
+type AttendanceCounts struct {
+	Completed int64 `json:"completed"`
+	NoShows   int64 `json:"no_shows"`
+}
+
+// Rate is the share of attended or missed appointments that were missed.
+func (a AttendanceCounts) Rate() float64 {
+	if a.Completed+a.NoShows == 0 {
+		return 0
+	}
+	return float64(a.NoShows) / float64(a.Completed+a.NoShows)
+}
+
+// PatientReliability is a patient's attendance record over all clinics, with
+// the no-shows at the caller's clinic within its policy window.
+type PatientReliability struct {
+	PatientID string `json:"patient_id"`
+	AttendanceCounts
+	NoShowRate float64 `json:"no_show_rate"`
+	// RecentNoShows counts the no-shows within the clinic's policy window.
+	RecentNoShows int64 `json:"recent_no_shows"`
+	// Restriction is the policy action currently applied to the patient.
+	Restriction NoShowAction `json:"restriction,omitempty"`
+}
+
+// NoShowRate is the attendance of a group of appointments in a report.
+type NoShowRate struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	AttendanceCounts
+	Rate float64 `json:"rate"`
+}
+
+// NoShowReport breaks a clinic's no-show rate down by physician and by
+// weekday, in the clinic's time zone.
+type NoShowReport struct {
+	ClinicID    string       `json:"clinic_id"`
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Overall     NoShowRate   `json:"overall"`
+	ByPhysician []NoShowRate `json:"by_physician"`
+	ByWeekday   []NoShowRate `json:"by_weekday"`
+}
+
+var weekdayLabels = [7]string{"Domingo", "Lunes", "Martes", "Miércoles", "Jueves", "Viernes", "Sábado"}
+
+// MarkNoShows flags confirmed appointments that ended more than the grace
+// period ago without being completed. It runs as a periodic job.
+func (s *service) MarkNoShows() error {
+	missed, err := s.repo.GetMissedAppointments(time.Now().Add(-s.noShowGrace), noShowBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, appointment := range missed {
+		err := s.repo.UpdateAppointmentStatus(StatusChange{
+			AppointmentID: appointment.ID,
+			From:          AppointmentStatusConfirmed,
+			To:            AppointmentStatusNoShow,
+			Reason:        "Marcada automáticamente: el paciente no asistió",
+		})
+		// Staff may complete or cancel the appointment in the meantime.
+		if err != nil && !errors.Is(err, ErrStatusChanged) {
+			log.Printf("no-show: could not mark appointment %s: %v", appointment.ID, err)
+		}
+	}
+	return nil
+}
+
+// noShowRestriction returns the action the clinic's policy applies to the
+// patient, given their no-shows at the clinic within the policy window.
+func (s *service) noShowRestriction(patientId string, policy *BookingPolicy, now time.Time) (NoShowAction, int64, error) {
+	if NoShowAction(policy.NoShowAction) == NoShowActionNone || policy.NoShowThreshold <= 0 {
+		return NoShowActionNone, 0, nil
+	}
+
+	counts, err := s.repo.GetPatientAttendance(patientId, policy.ClinicID, now.AddDate(0, -policy.NoShowWindowMonths, 0))
+	if err != nil {
+		return NoShowActionNone, 0, err
+	}
+	if counts.NoShows >= int64(policy.NoShowThreshold) {
+		return NoShowAction(policy.NoShowAction), counts.NoShows, nil
+	}
+	return NoShowActionNone, counts.NoShows, nil
+}
+
+// GetPatientReliability returns the patient's attendance record, and how the
+// policy of clinicId treats them.
+func (s *service) GetPatientReliability(patientId, clinicId string) (*PatientReliability, error) {
+	counts, err := s.repo.GetPatientAttendance(patientId, "", time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	reliability := &PatientReliability{PatientID: patientId, AttendanceCounts: *counts, NoShowRate: counts.Rate()}
+	if clinicId == "" {
+		return reliability, nil
+	}
+
+	policy, err := s.GetBookingPolicy(clinicId)
+	if err != nil {
+		return nil, err
+	}
+	reliability.Restriction, reliability.RecentNoShows, err = s.noShowRestriction(patientId, policy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return reliability, nil
+}
+
+// GetNoShowReport reports the clinic's no-show rates for appointments in
+// [from, to), by default the last 90 days.
+func (s *service) GetNoShowReport(clinicId string, from, to time.Time) (*NoShowReport, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-noShowReportRange)
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("%w: to must be after from", ErrInvalidSearch)
+	}
+
+	byPhysician, err := s.repo.GetNoShowsByPhysician(clinicId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	byWeekday, err := s.repo.GetNoShowsByWeekday(clinicId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &NoShowReport{ClinicID: clinicId, From: from, To: to, ByPhysician: byPhysician, ByWeekday: []NoShowRate{}}
+	for i := range report.ByPhysician {
+		row := &report.ByPhysician[i]
+		row.Rate = row.AttendanceCounts.Rate()
+		report.Overall.Completed += row.Completed
+		report.Overall.NoShows += row.NoShows
+	}
+	report.Overall.Key, report.Overall.Label = "all", "Total"
+	report.Overall.Rate = report.Overall.AttendanceCounts.Rate()
+
+	// Every weekday is listed, also those without appointments.
+	for day, label := range weekdayLabels {
+		row := NoShowRate{Key: fmt.Sprint(day), Label: label, AttendanceCounts: byWeekday[day]}
+		row.Rate = row.AttendanceCounts.Rate()
+		report.ByWeekday = append(report.ByWeekday, row)
+	}
+	return report, nil
+}
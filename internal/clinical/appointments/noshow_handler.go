@@ -0,0 +1,46 @@
+package appointments
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPatientReliability answers GET /appointments/reliability/:patientId with
+// the patient's attendance and how the caller's clinic policy treats them.
+func (h *Handler) GetPatientReliability(c *fiber.Ctx) error {
+	clinicId, _ := c.Locals("clinic_id").(string)
+	reliability, err := h.service.GetPatientReliability(c.Params("patientId"), clinicId)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(reliability)
+}
+
+// GetNoShowReport answers GET /appointments/reports/no-shows?clinic_id=&from=&to=.
+// Dates without a time are days in the clinic's time zone.
+func (h *Handler) GetNoShowReport(c *fiber.Ctx) error {
+	clinicId := c.Query("clinic_id")
+	loc, err := h.service.ClinicLocation(clinicId)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	var from, to time.Time
+	if value := c.Query("from"); value != "" {
+		if from, err = parseDateParam(value, loc, false); err != nil {
+			return appointmentError(c, err)
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = parseDateParam(value, loc, true); err != nil {
+			return appointmentError(c, err)
+		}
+	}
+
+	report, err := h.service.GetNoShowReport(clinicId, from, to)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(report)
+}
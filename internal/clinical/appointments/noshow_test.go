@@ -0,0 +1,223 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"Altheia-Backend/internal/mail"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+func (r *statusRepo) GetMissedAppointments(endedBefore time.Time, limit int) ([]MedicalAppointment, error) {
+	var missed []MedicalAppointment
+	for _, appointment := range r.appointments {
+		if appointment.Status == string(AppointmentStatusConfirmed) && appointment.EndTime.Before(endedBefore) {
+			missed = append(missed, *appointment)
+		}
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].EndTime.Before(missed[j].EndTime) })
+	if len(missed) > limit {
+		missed = missed[:limit]
+	}
+	return missed, nil
+}
+
+func (r *statusRepo) attendance(match func(a *MedicalAppointment) bool) AttendanceCounts {
+	var counts AttendanceCounts
+	for _, appointment := range r.appointments {
+		if !match(appointment) {
+			continue
+		}
+		switch AppointmentStatus(appointment.Status) {
+		case AppointmentStatusCompleted:
+			counts.Completed++
+		case AppointmentStatusNoShow:
+			counts.NoShows++
+		}
+	}
+	return counts
+}
+
+func (r *statusRepo) GetPatientAttendance(patientId, clinicId string, since time.Time) (*AttendanceCounts, error) {
+	counts := r.attendance(func(a *MedicalAppointment) bool {
+		return a.PatientId == patientId &&
+			(clinicId == "" || r.clinics[a.PhysicianId] == clinicId) &&
+			!a.DateTime.Before(since)
+	})
+	return &counts, nil
+}
+
+func (r *statusRepo) GetNoShowsByPhysician(clinicId string, from, to time.Time) ([]NoShowRate, error) {
+	var physicians []string
+	for physicianId, clinic := range r.clinics {
+		if clinic == clinicId {
+			physicians = append(physicians, physicianId)
+		}
+	}
+	sort.Strings(physicians)
+
+	rows := []NoShowRate{}
+	for _, physicianId := range physicians {
+		counts := r.attendance(func(a *MedicalAppointment) bool {
+			return a.PhysicianId == physicianId && !a.DateTime.Before(from) && a.DateTime.Before(to)
+		})
+		rows = append(rows, NoShowRate{Key: physicianId, Label: physicianId, AttendanceCounts: counts})
+	}
+	return rows, nil
+}
+
+func (r *statusRepo) GetNoShowsByWeekday(clinicId string, from, to time.Time) (map[int]AttendanceCounts, error) {
+	byWeekday := map[int]AttendanceCounts{}
+	for day := 0; day < 7; day++ {
+		byWeekday[day] = r.attendance(func(a *MedicalAppointment) bool {
+			return r.clinics[a.PhysicianId] == clinicId && int(a.DateTime.UTC().Weekday()) == day &&
+				!a.DateTime.Before(from) && a.DateTime.Before(to)
+		})
+	}
+	return byWeekday, nil
+}
+
+func TestMarkNoShows(t *testing.T) {
+	now := time.Now()
+	repo := &statusRepo{appointments: map[string]*MedicalAppointment{
+		"missed":    {ID: "missed", EndTime: now.Add(-2 * time.Hour), Status: string(AppointmentStatusConfirmed)},
+		"grace":     {ID: "grace", EndTime: now.Add(-10 * time.Minute), Status: string(AppointmentStatusConfirmed)},
+		"completed": {ID: "completed", EndTime: now.Add(-2 * time.Hour), Status: string(AppointmentStatusCompleted)},
+		"pending":   {ID: "pending", EndTime: now.Add(-2 * time.Hour), Status: string(AppointmentStatusPending)},
+	}}
+	s := &service{repo: repo, noShowGrace: 30 * time.Minute}
+
+	if err := s.MarkNoShows(); err != nil {
+		t.Fatalf("MarkNoShows() error = %v", err)
+	}
+
+	want := map[string]AppointmentStatus{
+		"missed":    AppointmentStatusNoShow,
+		"grace":     AppointmentStatusConfirmed,
+		"completed": AppointmentStatusCompleted,
+		"pending":   AppointmentStatusPending,
+	}
+	for id, status := range want {
+		if got := repo.appointments[id].Status; got != string(status) {
+			t.Errorf("%s: status = %s, want %s", id, got, status)
+		}
+	}
+	if len(repo.history) != 1 || repo.history[0].ActorUserID != "" {
+		t.Errorf("history = %+v, want one system change", repo.history)
+	}
+}
+
+func TestNoShowReport(t *testing.T) {
+	monday := time.Date(2025, 3, 10, 14, 0, 0, 0, time.UTC)
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{
+			"a1": {ID: "a1", PhysicianId: "ph-1", DateTime: monday, Status: string(AppointmentStatusNoShow)},
+			"a2": {ID: "a2", PhysicianId: "ph-1", DateTime: monday.AddDate(0, 0, 1), Status: string(AppointmentStatusCompleted)},
+			"a3": {ID: "a3", PhysicianId: "ph-2", DateTime: monday, Status: string(AppointmentStatusCompleted)},
+			"a4": {ID: "a4", PhysicianId: "ph-2", DateTime: monday, Status: string(AppointmentStatusCancelled)},
+			"a5": {ID: "a5", PhysicianId: "ph-1", DateTime: monday.AddDate(0, -6, 0), Status: string(AppointmentStatusNoShow)},
+		},
+		clinics: map[string]string{"ph-1": "cl-1", "ph-2": "cl-1"},
+	}
+	s := &service{repo: repo}
+
+	report, err := s.GetNoShowReport("cl-1", monday.AddDate(0, 0, -7), monday.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatalf("GetNoShowReport() error = %v", err)
+	}
+	if report.Overall.NoShows != 1 || report.Overall.Completed != 2 {
+		t.Errorf("overall = %+v, want 1 no-show and 2 completed", report.Overall.AttendanceCounts)
+	}
+	if len(report.ByPhysician) != 2 || report.ByPhysician[0].Rate != 0.5 || report.ByPhysician[1].Rate != 0 {
+		t.Errorf("by physician = %+v, want rates 0.5 and 0", report.ByPhysician)
+	}
+	if len(report.ByWeekday) != 7 {
+		t.Fatalf("by weekday has %d rows, want every day", len(report.ByWeekday))
+	}
+	if monday := report.ByWeekday[time.Monday]; monday.Label != "Lunes" || monday.NoShows != 1 || monday.Completed != 1 {
+		t.Errorf("Monday = %+v, want 1 no-show and 1 completed", monday)
+	}
+
+	if _, err := s.GetNoShowReport("cl-1", monday, monday); !errors.Is(err, ErrInvalidSearch) {
+		t.Errorf("empty range: got %v, want ErrInvalidSearch", err)
+	}
+}
+
+func TestNoShowPolicy(t *testing.T) {
+	mail.SetSMTPClient(discardSMTP{})
+	defer mail.SetSMTPClient(&mail.DefaultSMTPClient{})
+
+	loc, err := clinical.LoadTimezone(clinical.DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Now().In(loc).AddDate(0, 0, 8)
+	for day.Weekday() != time.Monday {
+		day = day.AddDate(0, 0, 1)
+	}
+	missed := func(id string, ago time.Duration) *MedicalAppointment {
+		at := time.Now().Add(-ago)
+		return &MedicalAppointment{ID: id, PatientId: "pa-ana", PhysicianId: "ph-1", DateTime: at, EndTime: at.Add(30 * time.Minute), Status: string(AppointmentStatusNoShow)}
+	}
+
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{
+			"m1": missed("m1", 24*time.Hour),
+			"m2": missed("m2", 30*24*time.Hour),
+			// Outside the three month window.
+			"m3": missed("m3", 200*24*time.Hour),
+		},
+		availability: []PhysicianAvailability{
+			{PhysicianID: "ph-1", Weekday: int(time.Monday), StartTime: "09:00", EndTime: "12:00", SlotMinutes: 30},
+		},
+		patients: map[string]string{"ana": "pa-ana"},
+		clinics:  map[string]string{"ph-1": "cl-1"},
+		policies: map[string]*BookingPolicy{
+			"cl-1": {ClinicID: "cl-1", NoShowAction: string(NoShowActionRequireConfirmation), NoShowThreshold: 2, NoShowWindowMonths: 3},
+		},
+	}
+	s := &service{repo: repo}
+
+	reliability, err := s.GetPatientReliability("pa-ana", "cl-1")
+	if err != nil {
+		t.Fatalf("GetPatientReliability() error = %v", err)
+	}
+	if reliability.NoShows != 3 || reliability.NoShowRate != 1 || reliability.RecentNoShows != 2 || reliability.Restriction != NoShowActionRequireConfirmation {
+		t.Errorf("reliability = %+v, want 3 no-shows, 2 recent and confirmation required", reliability)
+	}
+
+	booking := PortalBookingDTO{PhysicianId: "ph-1", Date: day.Format("2006-01-02"), Time: "09:00"}
+	appointment, err := s.BookOwnAppointment("ana", booking)
+	if err != nil {
+		t.Fatalf("BookOwnAppointment() error = %v", err)
+	}
+	if appointment.Status != string(AppointmentStatusPending) {
+		t.Errorf("status = %s, want pending until staff confirm it", appointment.Status)
+	}
+
+	repo.policies["cl-1"].NoShowAction = string(NoShowActionBlockSelfBooking)
+	booking.Time = "10:00"
+	if _, err := s.BookOwnAppointment("ana", booking); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("booking while blocked: got %v, want ErrBookingPolicy", err)
+	}
+
+	repo.policies["cl-1"].NoShowThreshold = 3
+	if _, err := s.BookOwnAppointment("ana", booking); err != nil {
+		t.Errorf("booking under the threshold: got %v, want nil", err)
+	}
+}
+
+func TestSetBookingPolicyNoShows(t *testing.T) {
+	s := &service{repo: &statusRepo{}}
+	invalid := []BookingPolicyDTO{
+		{NoShowAction: "warn", NoShowThreshold: 2, NoShowWindowMonths: 3},
+		{NoShowAction: string(NoShowActionBlockSelfBooking), NoShowWindowMonths: 3},
+		{NoShowAction: string(NoShowActionRequireConfirmation), NoShowThreshold: 2},
+	}
+	for _, dto := range invalid {
+		if _, err := s.SetBookingPolicy("cl-1", dto); !errors.Is(err, ErrInvalidBookingPolicy) {
+			t.Errorf("SetBookingPolicy(%+v): got %v, want ErrInvalidBookingPolicy", dto, err)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBookingPolicy        = errors.New("the clinic's booking policy does not allow this")
+	ErrInvalidBookingPolicy = errors.New("invalid booking policy")
+)
+
+// Defaults for clinics that have not set a booking policy.
+const (
+	defaultMinNoticeMinutes          = 120
+	defaultMaxActiveBookings         = 3
+	defaultCancellationCutoffMinutes = 24 * 60
+)
+
+// BookingPolicy limits what patients may do from the self-service portal at a
+// clinic. Staff bookings are not restricted by it.
+type BookingPolicy struct {
+	ClinicID string `gorm:"primaryKey" json:"clinic_id"`
+	// MinNoticeMinutes is how far ahead of its start an appointment must be
+	// booked or moved to.
+	MinNoticeMinutes int `gorm:"not null" json:"min_notice_minutes"`
+	// MaxActiveBookings is how many upcoming appointments a patient may hold
+	// at the clinic at once; 0 means no limit.
+	MaxActiveBookings int `gorm:"not null" json:"max_active_bookings"`
+	// CancellationCutoffMinutes is how long before its start an appointment
+	// can still be cancelled or rescheduled by the patient.
+	CancellationCutoffMinutes int `gorm:"not null" json:"cancellation_cutoff_minutes"`
+	// NoShowAction is applied to patients with at least NoShowThreshold
+	// no-shows at the clinic in the last NoShowWindowMonths months.
+	NoShowAction       string    `json:"no_show_action"`
+	NoShowThreshold    int       `json:"no_show_threshold"`
+	NoShowWindowMonths int       `json:"no_show_window_months"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
+
+type BookingPolicyDTO struct {
+	MinNoticeMinutes          int    `json:"min_notice_minutes"`
+	MaxActiveBookings         int    `json:"max_active_bookings"`
+	CancellationCutoffMinutes int    `json:"cancellation_cutoff_minutes"`
+	NoShowAction              string `json:"no_show_action"`
+	NoShowThreshold           int    `json:"no_show_threshold"`
+	NoShowWindowMonths        int    `json:"no_show_window_months"`
+}
+
+// PortalBookingDTO books an appointment for the signed-in patient. Date and
+// Time are in the time zone of the physician's clinic.
+type PortalBookingDTO struct {
+	PhysicianId string `json:"physician_id"`
+	Date        string `json:"date"`
+	Time        string `json:"time"`
+	Reason      string `json:"reason"`
+}
+
+// PortalPhysician is a physician as listed to patients.
+type PortalPhysician struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Gender             string `json:"gender"`
+	PhysicianSpecialty string `json:"physician_specialty"`
+}
+
+func defaultBookingPolicy(clinicId string) *BookingPolicy {
+	return &BookingPolicy{
+		ClinicID:                  clinicId,
+		MinNoticeMinutes:          defaultMinNoticeMinutes,
+		MaxActiveBookings:         defaultMaxActiveBookings,
+		CancellationCutoffMinutes: defaultCancellationCutoffMinutes,
+	}
+}
+
+func (p *BookingPolicy) minNotice() time.Duration {
+	return time.Duration(p.MinNoticeMinutes) * time.Minute
+}
+
+func (p *BookingPolicy) cancellationCutoff() time.Duration {
+	return time.Duration(p.CancellationCutoffMinutes) * time.Minute
+}
+
+// checkNotice rejects a start time closer to now than the minimum notice.
+func (p *BookingPolicy) checkNotice(start, now time.Time) error {
+	if start.Before(now.Add(p.minNotice())) {
+		return fmt.Errorf("%w: appointments must be booked at least %d minutes ahead", ErrBookingPolicy, p.MinNoticeMinutes)
+	}
+	return nil
+}
+
+// checkCutoff rejects changes to an appointment starting at start once the
+// cancellation cutoff has passed.
+func (p *BookingPolicy) checkCutoff(start, now time.Time) error {
+	if !now.Before(start.Add(-p.cancellationCutoff())) {
+		return fmt.Errorf("%w: appointments can only be changed up to %d minutes before they start", ErrBookingPolicy, p.CancellationCutoffMinutes)
+	}
+	return nil
+}
+
+// blockedError explains that the no-show policy blocks a patient with noShows
+// recent no-shows from booking themselves.
+func (p *BookingPolicy) blockedError(noShows int64) error {
+	return fmt.Errorf("%w: self-booking is blocked after %d missed appointments in the last %d months, please contact the clinic",
+		ErrBookingPolicy, noShows, p.NoShowWindowMonths)
+}
+
+// checkActive rejects a booking that would exceed the active bookings limit.
+func (p *BookingPolicy) checkActive(active int64) error {
+	if p.MaxActiveBookings > 0 && active >= int64(p.MaxActiveBookings) {
+		return fmt.Errorf("%w: at most %d upcoming appointments per patient", ErrBookingPolicy, p.MaxActiveBookings)
+	}
+	return nil
+}
+
+// GetBookingPolicy returns the clinic's policy, or the defaults when the
+// clinic has not set one.
+func (s *service) GetBookingPolicy(clinicId string) (*BookingPolicy, error) {
+	policy, err := s.repo.GetBookingPolicy(clinicId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultBookingPolicy(clinicId), nil
+	}
+	return policy, err
+}
+
+func (s *service) SetBookingPolicy(clinicId string, dto BookingPolicyDTO) (*BookingPolicy, error) {
+	if dto.MinNoticeMinutes < 0 || dto.MaxActiveBookings < 0 || dto.CancellationCutoffMinutes < 0 {
+		return nil, fmt.Errorf("%w: values cannot be negative", ErrInvalidBookingPolicy)
+	}
+	switch NoShowAction(dto.NoShowAction) {
+	case NoShowActionNone:
+	case NoShowActionRequireConfirmation, NoShowActionBlockSelfBooking:
+		if dto.NoShowThreshold < 1 || dto.NoShowWindowMonths < 1 {
+			return nil, fmt.Errorf("%w: no_show_threshold and no_show_window_months must be at least 1", ErrInvalidBookingPolicy)
+		}
+	default:
+		return nil, fmt.Errorf("%w: no_show_action must be require_confirmation, block_self_booking or empty", ErrInvalidBookingPolicy)
+	}
+
+	policy := &BookingPolicy{
+		ClinicID:                  clinicId,
+		MinNoticeMinutes:          dto.MinNoticeMinutes,
+		MaxActiveBookings:         dto.MaxActiveBookings,
+		CancellationCutoffMinutes: dto.CancellationCutoffMinutes,
+		NoShowAction:              dto.NoShowAction,
+		NoShowThreshold:           dto.NoShowThreshold,
+		NoShowWindowMonths:        dto.NoShowWindowMonths,
+	}
+	if err := s.repo.SaveBookingPolicy(policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// physicianPolicy returns the booking policy of the physician's clinic.
+// Physicians who are inactive or outside a clinic cannot be booked from the
+// portal and are reported as not found.
+func (s *service) physicianPolicy(physicianId string) (*BookingPolicy, error) {
+	clinicId, err := s.repo.GetBookablePhysicianClinicId(physicianId)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBookingPolicy(clinicId)
+}
+
+func (s *service) GetClinicPhysicians(clinicId string) ([]PortalPhysician, error) {
+	return s.repo.GetClinicPhysicians(clinicId)
+}
+
+// GetBookableSlots returns the physician's free slots in [from, to) that the
+// clinic's minimum notice still allows patients to book.
+func (s *service) GetBookableSlots(physicianId string, from, to time.Time) ([]Slot, error) {
+	policy, err := s.physicianPolicy(physicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	slots, err := s.GetAvailableSlots(physicianId, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	bookable := []Slot{}
+	for _, slot := range slots {
+		if policy.checkNotice(slot.Start, now) == nil {
+			bookable = append(bookable, slot)
+		}
+	}
+	return bookable, nil
+}
+
+// BookOwnAppointment books a free slot for the patient signed in as userId,
+// within the limits of the clinic's booking policy. The booking is confirmed
+// right away, unless the policy asks staff to confirm the bookings of patients
+// who miss appointments.
+func (s *service) BookOwnAppointment(userId string, dto PortalBookingDTO) (*MedicalAppointment, error) {
+	patientId, err := s.repo.GetPatientIdByUserId(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := s.physicianPolicy(dto.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	booking := CreateAppointmentDTO{
+		PatientId:   patientId,
+		PhysicianId: dto.PhysicianId,
+		Date:        dto.Date,
+		Time:        dto.Time,
+		Reason:      dto.Reason,
+	}
+	loc, err := physicianLocation(s.repo, dto.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+	start, err := booking.dateTime(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := policy.checkNotice(start, now); err != nil {
+		return nil, err
+	}
+	restriction, noShows, err := s.noShowRestriction(patientId, policy, now)
+	if err != nil {
+		return nil, err
+	}
+	if restriction == NoShowActionBlockSelfBooking {
+		return nil, policy.blockedError(noShows)
+	}
+
+	slot, err := s.freeSlot(dto.PhysicianId, start)
+	if err != nil {
+		return nil, err
+	}
+
+	// The limit of active bookings is checked by the repository, with the
+	// patient locked, in the same transaction that stores the booking.
+	var confirmation *StatusChange
+	if restriction != NoShowActionRequireConfirmation {
+		confirmation = &StatusChange{
+			From:        AppointmentStatusPending,
+			To:          AppointmentStatusConfirmed,
+			ActorUserID: userId,
+			Reason:      "Reservada por el paciente desde el portal",
+		}
+	}
+	appointment, err := s.repo.CreateOwnAppointment(booking, slot.End, policy, now, confirmation)
+	if err != nil {
+		return nil, err
+	}
+	if confirmation != nil {
+		s.queueChanged([]MedicalAppointment{*appointment})
+	}
+	return appointment, s.localize(appointment)
+}
+
+// RescheduleOwnAppointment moves one of the patient's appointments to another
+// free slot. Only that occurrence of a series is moved.
+func (s *service) RescheduleOwnAppointment(userId, appointmentId string, newDateTime time.Time, reason string) error {
+	appointment, policy, err := s.ownAppointment(userId, appointmentId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := policy.checkCutoff(appointment.DateTime, now); err != nil {
+		return err
+	}
+	if err := policy.checkNotice(newDateTime, now); err != nil {
+		return err
+	}
+	return s.RescheduleAppointment(appointment.ID, newDateTime, userId, reason, ScopeThisOccurrence)
+}
+
+// CancelOwnAppointment cancels one of the patient's appointments. Only that
+// occurrence of a series is cancelled.
+func (s *service) CancelOwnAppointment(userId, appointmentId, reason string) error {
+	appointment, policy, err := s.ownAppointment(userId, appointmentId)
+	if err != nil {
+		return err
+	}
+
+	if err := policy.checkCutoff(appointment.DateTime, time.Now()); err != nil {
+		return err
+	}
+	return s.CancelAppointment(appointment.ID, userId, reason, ScopeThisOccurrence)
+}
+
+// ownAppointment loads an appointment of the patient signed in as userId,
+// with the booking policy of its clinic. Other patients' appointments are
+// reported as not found.
+func (s *service) ownAppointment(userId, appointmentId string) (*MedicalAppointment, *BookingPolicy, error) {
+	patientId, err := s.repo.GetPatientIdByUserId(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	appointment, err := s.repo.GetAppointmentByID(appointmentId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if appointment.PatientId != patientId {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	clinicId, err := s.repo.GetPhysicianClinicId(appointment.PhysicianId)
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := s.GetBookingPolicy(clinicId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return appointment, policy, nil
+}
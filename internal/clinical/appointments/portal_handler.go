@@ -0,0 +1,91 @@
+package appointments
+
+import "github.com/gofiber/fiber/v2"
+
+func (h *Handler) GetBookingPolicy(c *fiber.Ctx) error {
+	policy, err := h.service.GetBookingPolicy(c.Params("clinicId"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(policy)
+}
+
+func (h *Handler) SetBookingPolicy(c *fiber.Ctx) error {
+	var dto BookingPolicyDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	policy, err := h.service.SetBookingPolicy(c.Params("clinicId"), dto)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(policy)
+}
+
+func (h *Handler) GetClinicPhysicians(c *fiber.Ctx) error {
+	physicians, err := h.service.GetClinicPhysicians(c.Params("clinicId"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(physicians)
+}
+
+// GetBookableSlots answers GET /portal/physicians/:id/slots?from=&to=.
+func (h *Handler) GetBookableSlots(c *fiber.Ctx) error {
+	from, to, err := h.slotRange(c, c.Params("id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+
+	slots, err := h.service.GetBookableSlots(c.Params("id"), from, to)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(slots)
+}
+
+func (h *Handler) BookOwnAppointment(c *fiber.Ctx) error {
+	var dto PortalBookingDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	userId, _ := c.Locals("user_id").(string)
+	appointment, err := h.service.BookOwnAppointment(userId, dto)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(appointment)
+}
+
+func (h *Handler) RescheduleOwnAppointment(c *fiber.Ctx) error {
+	var dto NewDateTimeDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	userId, _ := c.Locals("user_id").(string)
+	if err := h.service.RescheduleOwnAppointment(userId, c.Params("id"), dto.NewDateTime, dto.Reason); err != nil {
+		return appointmentError(c, err)
+	}
+	return nil
+}
+
+func (h *Handler) CancelOwnAppointment(c *fiber.Ctx) error {
+	// The reason is optional, so an empty body is fine.
+	var dto StatusChangeDTO
+	_ = c.BodyParser(&dto)
+
+	userId, _ := c.Locals("user_id").(string)
+	if err := h.service.CancelOwnAppointment(userId, c.Params("id"), dto.Reason); err != nil {
+		return appointmentError(c, err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"Altheia-Backend/internal/mail"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func (r *statusRepo) GetPatientIdByUserId(userId string) (string, error) {
+	if patientId, ok := r.patients[userId]; ok {
+		return patientId, nil
+	}
+	return "", gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) GetPhysicianClinicId(physicianId string) (string, error) {
+	return r.clinics[physicianId], nil
+}
+
+func (r *statusRepo) GetBookablePhysicianClinicId(physicianId string) (string, error) {
+	if clinicId, ok := r.clinics[physicianId]; ok {
+		return clinicId, nil
+	}
+	return "", gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) GetBookingPolicy(clinicId string) (*BookingPolicy, error) {
+	if policy, ok := r.policies[clinicId]; ok {
+		return policy, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) CreateOwnAppointment(dto CreateAppointmentDTO, endTime time.Time, policy *BookingPolicy, now time.Time, confirmation *StatusChange) (*MedicalAppointment, error) {
+	if err := policy.checkActive(r.countActive(dto.PatientId, policy.ClinicID, now)); err != nil {
+		return nil, err
+	}
+	appointment, err := r.CreateAppointment(dto, endTime)
+	if err != nil || confirmation == nil {
+		return appointment, err
+	}
+	change := *confirmation
+	change.AppointmentID = appointment.ID
+	if err := r.UpdateAppointmentStatus(change); err != nil {
+		return nil, err
+	}
+	appointment.Status = string(change.To)
+	return appointment, nil
+}
+
+func (r *statusRepo) countActive(patientId, clinicId string, now time.Time) int64 {
+	var count int64
+	for _, appointment := range r.appointments {
+		switch AppointmentStatus(appointment.Status) {
+		case AppointmentStatusPending, AppointmentStatusConfirmed, AppointmentStatusRescheduled:
+		default:
+			continue
+		}
+		if appointment.PatientId == patientId && r.clinics[appointment.PhysicianId] == clinicId && appointment.DateTime.After(now) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestBookingPolicyChecks(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	policy := &BookingPolicy{MinNoticeMinutes: 120, MaxActiveBookings: 2, CancellationCutoffMinutes: 24 * 60}
+
+	if err := policy.checkNotice(now.Add(time.Hour), now); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("booking 1h ahead: got %v, want ErrBookingPolicy", err)
+	}
+	if err := policy.checkNotice(now.Add(2*time.Hour), now); err != nil {
+		t.Errorf("booking 2h ahead: got %v, want nil", err)
+	}
+	if err := policy.checkCutoff(now.Add(23*time.Hour), now); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("cancelling 23h ahead: got %v, want ErrBookingPolicy", err)
+	}
+	if err := policy.checkCutoff(now.Add(25*time.Hour), now); err != nil {
+		t.Errorf("cancelling 25h ahead: got %v, want nil", err)
+	}
+	if err := policy.checkActive(2); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("third active booking: got %v, want ErrBookingPolicy", err)
+	}
+	if err := (&BookingPolicy{}).checkActive(50); err != nil {
+		t.Errorf("no active limit: got %v, want nil", err)
+	}
+}
+
+func TestPortalBooking(t *testing.T) {
+	mail.SetSMTPClient(discardSMTP{})
+	defer mail.SetSMTPClient(&mail.DefaultSMTPClient{})
+
+	loc, err := clinical.LoadTimezone(clinical.DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Now().In(loc).AddDate(0, 0, 8)
+	for day.Weekday() != time.Monday {
+		day = day.AddDate(0, 0, 1)
+	}
+	soon := time.Now().Add(3 * time.Hour).UTC()
+
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{
+			"soon": {ID: "soon", PatientId: "pa-ana", PhysicianId: "ph-1", DateTime: soon, EndTime: soon.Add(30 * time.Minute), Status: string(AppointmentStatusConfirmed)},
+		},
+		availability: []PhysicianAvailability{
+			{PhysicianID: "ph-1", Weekday: int(time.Monday), StartTime: "09:00", EndTime: "12:00", SlotMinutes: 30},
+		},
+		patients: map[string]string{"ana": "pa-ana", "luis": "pa-luis"},
+		clinics:  map[string]string{"ph-1": "cl-1"},
+		policies: map[string]*BookingPolicy{
+			"cl-1": {ClinicID: "cl-1", MinNoticeMinutes: 60, MaxActiveBookings: 2, CancellationCutoffMinutes: 24 * 60},
+		},
+	}
+	s := &service{repo: repo}
+
+	booking := PortalBookingDTO{PhysicianId: "ph-1", Date: day.Format("2006-01-02"), Time: "09:00", Reason: "Control"}
+	appointment, err := s.BookOwnAppointment("ana", booking)
+	if err != nil {
+		t.Fatalf("BookOwnAppointment() error = %v", err)
+	}
+	if appointment.PatientId != "pa-ana" {
+		t.Errorf("booked for %s, want the caller's patient record", appointment.PatientId)
+	}
+	if status := repo.appointments[appointment.ID].Status; status != string(AppointmentStatusConfirmed) {
+		t.Errorf("status = %s, want portal bookings confirmed", status)
+	}
+
+	// Ana now holds two upcoming appointments, the clinic's limit.
+	booking.Time = "10:00"
+	if _, err := s.BookOwnAppointment("ana", booking); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("third booking: got %v, want ErrBookingPolicy", err)
+	}
+
+	tooSoon := time.Now().In(loc).Add(30 * time.Minute)
+	if _, err := s.BookOwnAppointment("luis", PortalBookingDTO{PhysicianId: "ph-1", Date: tooSoon.Format("2006-01-02"), Time: tooSoon.Format("15:04")}); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("booking within the minimum notice: got %v, want ErrBookingPolicy", err)
+	}
+	if _, err := s.BookOwnAppointment("luis", PortalBookingDTO{PhysicianId: "ph-elsewhere", Date: booking.Date, Time: "10:00"}); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("booking a physician outside a clinic: got %v, want not found", err)
+	}
+
+	if err := s.CancelOwnAppointment("luis", appointment.ID, ""); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("cancelling another patient's appointment: got %v, want not found", err)
+	}
+	if err := s.CancelOwnAppointment("ana", "soon", ""); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("cancelling after the cutoff: got %v, want ErrBookingPolicy", err)
+	}
+	if err := s.RescheduleOwnAppointment("ana", "soon", appointment.DateTime.Add(time.Hour), ""); !errors.Is(err, ErrBookingPolicy) {
+		t.Errorf("rescheduling after the cutoff: got %v, want ErrBookingPolicy", err)
+	}
+	if err := s.CancelOwnAppointment("ana", appointment.ID, "Viaje"); err != nil {
+		t.Fatalf("CancelOwnAppointment() error = %v", err)
+	}
+	if status := repo.appointments[appointment.ID].Status; status != string(AppointmentStatusCancelled) {
+		t.Errorf("status = %s, want cancelled", status)
+	}
+}
@@ -0,0 +1,229 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSeriesOccurrences bounds how many appointments one series may create.
+const maxSeriesOccurrences = 104
+
+var (
+	ErrInvalidRecurrence = errors.New("invalid recurrence")
+	ErrInvalidScope      = errors.New(`scope must be "this", "following" or "all"`)
+	ErrNotInSeries       = errors.New("the appointment is not part of a series")
+)
+
+type RecurrenceFrequency string
+
+const (
+	FrequencyDaily   RecurrenceFrequency = "daily"
+	FrequencyWeekly  RecurrenceFrequency = "weekly"
+	FrequencyMonthly RecurrenceFrequency = "monthly"
+)
+
+// SeriesScope selects which occurrences of a series an edit applies to.
+type SeriesScope string
+
+const (
+	ScopeThisOccurrence SeriesScope = "this"
+	ScopeThisAndFollow  SeriesScope = "following"
+	ScopeWholeSeries    SeriesScope = "all"
+)
+
+// RecurrenceDTO describes a series in the spirit of an iCalendar RRULE: every
+// Interval days, weeks or months, on ByWeekday for weekly series, until Count
+// occurrences were made or the Until date (inclusive) is passed.
+type RecurrenceDTO struct {
+	Frequency string   `json:"frequency"`
+	Interval  int      `json:"interval"`
+	Count     int      `json:"count"`
+	Until     string   `json:"until"`
+	ByWeekday []string `json:"by_weekday"`
+}
+
+// AppointmentSeries is the recurrence rule the appointments sharing its ID
+// were created from.
+type AppointmentSeries struct {
+	ID          string     `gorm:"primaryKey" json:"id"`
+	PatientId   string     `gorm:"not null;index" json:"patient_id"`
+	PhysicianId string     `gorm:"not null;index" json:"physician_id"`
+	Frequency   string     `gorm:"not null" json:"frequency"`
+	Interval    int        `gorm:"not null" json:"interval"`
+	Count       int        `json:"count"`
+	Until       *time.Time `json:"until"`
+	ByWeekday   string     `json:"by_weekday"`
+	Reason      string     `json:"reason"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+type SeriesDTO struct {
+	Series       AppointmentSeries    `json:"series"`
+	Appointments []MedicalAppointment `json:"appointments"`
+}
+
+// SeriesUnavailableError lists the occurrences of a series that do not fall in
+// a free slot of the physician.
+type SeriesUnavailableError struct {
+	Unavailable []time.Time
+}
+
+func (e *SeriesUnavailableError) Error() string {
+	return fmt.Sprintf("%d occurrence(s) of the series are not available slots for this physician", len(e.Unavailable))
+}
+
+func (e *SeriesUnavailableError) Unwrap() error {
+	return ErrSlotUnavailable
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday,
+	"FR": time.Friday, "SA": time.Saturday, "SU": time.Sunday,
+}
+
+// ParseSeriesScope reads an edit scope, defaulting to this occurrence.
+func ParseSeriesScope(value string) (SeriesScope, error) {
+	switch scope := SeriesScope(strings.ToLower(strings.TrimSpace(value))); scope {
+	case "":
+		return ScopeThisOccurrence, nil
+	case ScopeThisOccurrence, ScopeThisAndFollow, ScopeWholeSeries:
+		return scope, nil
+	default:
+		return "", ErrInvalidScope
+	}
+}
+
+// occurrences expands the rule from start, returning the occurrences at or
+// after it. They all keep the wall-clock time of start in loc, across daylight
+// saving changes.
+func (r RecurrenceDTO) occurrences(start time.Time, loc *time.Location) ([]time.Time, error) {
+	frequency := r.frequency()
+	if frequency != FrequencyDaily && frequency != FrequencyWeekly && frequency != FrequencyMonthly {
+		return nil, fmt.Errorf("%w: frequency must be daily, weekly or monthly", ErrInvalidRecurrence)
+	}
+
+	interval := r.Interval
+	if interval == 0 {
+		interval = 1
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("%w: interval must be positive", ErrInvalidRecurrence)
+	}
+
+	if r.Count < 0 || r.Count > maxSeriesOccurrences {
+		return nil, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidRecurrence, maxSeriesOccurrences)
+	}
+
+	var until time.Time
+	if r.Until != "" {
+		day, err := time.ParseInLocation("2006-01-02", r.Until, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: until must use the 2006-01-02 format", ErrInvalidRecurrence)
+		}
+		until = day.AddDate(0, 0, 1)
+	}
+	if r.Count == 0 && until.IsZero() {
+		return nil, fmt.Errorf("%w: a series needs a count or an until date", ErrInvalidRecurrence)
+	}
+
+	weekdays, err := r.weekdays(frequency, start.In(loc).Weekday())
+	if err != nil {
+		return nil, err
+	}
+
+	start = start.In(loc)
+	var result []time.Time
+	// add reports whether the expansion should go on after t.
+	add := func(t time.Time) (bool, error) {
+		if t.Before(start) {
+			return true, nil
+		}
+		if !until.IsZero() && !t.Before(until) {
+			return false, nil
+		}
+		if len(result) == maxSeriesOccurrences {
+			return false, fmt.Errorf("%w: a series can have at most %d occurrences", ErrInvalidRecurrence, maxSeriesOccurrences)
+		}
+		result = append(result, t)
+		return r.Count == 0 || len(result) < r.Count, nil
+	}
+
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), 0, 0, loc)
+	}
+
+	for period := 0; ; period++ {
+		var candidates []time.Time
+		switch frequency {
+		case FrequencyDaily:
+			candidates = []time.Time{at(start.Year(), start.Month(), start.Day()+period*interval)}
+		case FrequencyWeekly:
+			monday := start.Day() - (int(start.Weekday())+6)%7 + period*interval*7
+			for _, weekday := range weekdays {
+				candidates = append(candidates, at(start.Year(), start.Month(), monday+(int(weekday)+6)%7))
+			}
+		case FrequencyMonthly:
+			t := at(start.Year(), start.Month()+time.Month(period*interval), start.Day())
+			// Months without the day, such as February 30th, are skipped.
+			if t.Day() == start.Day() {
+				candidates = []time.Time{t}
+			}
+			if period > maxSeriesOccurrences*2 {
+				return result, nil
+			}
+		}
+
+		for _, t := range candidates {
+			more, err := add(t)
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				return result, nil
+			}
+		}
+	}
+}
+
+func (r RecurrenceDTO) frequency() RecurrenceFrequency {
+	return RecurrenceFrequency(strings.ToLower(strings.TrimSpace(r.Frequency)))
+}
+
+// byWeekday renders the weekdays as stored on the series, such as "MO,TH".
+func (r RecurrenceDTO) byWeekday() string {
+	codes := make([]string, 0, len(r.ByWeekday))
+	for _, code := range r.ByWeekday {
+		codes = append(codes, strings.ToUpper(strings.TrimSpace(code)))
+	}
+	return strings.Join(codes, ",")
+}
+
+// weekdays returns the days of the week a weekly series repeats on, Monday
+// first, defaulting to the weekday of the first occurrence.
+func (r RecurrenceDTO) weekdays(frequency RecurrenceFrequency, first time.Weekday) ([]time.Weekday, error) {
+	if len(r.ByWeekday) == 0 {
+		return []time.Weekday{first}, nil
+	}
+	if frequency != FrequencyWeekly {
+		return nil, fmt.Errorf("%w: by_weekday only applies to weekly series", ErrInvalidRecurrence)
+	}
+
+	seen := map[time.Weekday]bool{}
+	var weekdays []time.Weekday
+	for _, code := range r.ByWeekday {
+		weekday, ok := weekdayCodes[strings.ToUpper(strings.TrimSpace(code))]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown weekday %q, use MO, TU, WE, TH, FR, SA or SU", ErrInvalidRecurrence, code)
+		}
+		if !seen[weekday] {
+			seen[weekday] = true
+			weekdays = append(weekdays, weekday)
+		}
+	}
+	sort.Slice(weekdays, func(i, j int) bool { return (weekdays[i]+6)%7 < (weekdays[j]+6)%7 })
+	return weekdays, nil
+}
@@ -0,0 +1,143 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecurrenceOccurrences(t *testing.T) {
+	loc, err := clinical.LoadTimezone(clinical.DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wednesday 2030-01-02 at 09:00.
+	start := time.Date(2030, 1, 2, 9, 0, 0, 0, loc)
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2030, month, d, 9, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name       string
+		recurrence RecurrenceDTO
+		want       []time.Time
+	}{
+		{
+			name:       "weekly by count",
+			recurrence: RecurrenceDTO{Frequency: "weekly", Count: 3},
+			want:       []time.Time{day(1, 2), day(1, 9), day(1, 16)},
+		},
+		{
+			name:       "every other week on monday and wednesday",
+			recurrence: RecurrenceDTO{Frequency: "WEEKLY", Interval: 2, ByWeekday: []string{"we", "MO"}, Count: 4},
+			want:       []time.Time{day(1, 2), day(1, 14), day(1, 16), day(1, 28)},
+		},
+		{
+			name:       "daily until a date",
+			recurrence: RecurrenceDTO{Frequency: "daily", Interval: 3, Until: "2030-01-11"},
+			want:       []time.Time{day(1, 2), day(1, 5), day(1, 8), day(1, 11)},
+		},
+		{
+			name:       "monthly",
+			recurrence: RecurrenceDTO{Frequency: "monthly", Count: 3},
+			want:       []time.Time{day(1, 2), day(2, 2), day(3, 2)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.recurrence.occurrences(start, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("occurrence %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	t.Run("monthly skips months without the day", func(t *testing.T) {
+		got, err := RecurrenceDTO{Frequency: "monthly", Count: 3}.occurrences(time.Date(2030, 1, 31, 9, 0, 0, 0, loc), loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []time.Time{day(1, 31), day(3, 31), day(5, 31)}
+		for i := range want {
+			if i >= len(got) || !got[i].Equal(want[i]) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	})
+
+	invalid := []RecurrenceDTO{
+		{Frequency: "yearly", Count: 2},
+		{Frequency: "weekly"},
+		{Frequency: "weekly", Count: maxSeriesOccurrences + 1},
+		{Frequency: "daily", Until: "2031-01-01"},
+		{Frequency: "daily", Count: 2, ByWeekday: []string{"MO"}},
+		{Frequency: "weekly", Count: 2, ByWeekday: []string{"XX"}},
+	}
+	for _, recurrence := range invalid {
+		if _, err := recurrence.occurrences(start, loc); !errors.Is(err, ErrInvalidRecurrence) {
+			t.Errorf("%+v: got %v, want ErrInvalidRecurrence", recurrence, err)
+		}
+	}
+}
+
+func TestCancelSeriesScopes(t *testing.T) {
+	series := "series-1"
+	newRepo := func() *statusRepo {
+		repo := &statusRepo{appointments: map[string]*MedicalAppointment{}}
+		for i, status := range []AppointmentStatus{
+			AppointmentStatusCompleted, AppointmentStatusConfirmed, AppointmentStatusPending, AppointmentStatusPending,
+		} {
+			id := string(rune('a' + i))
+			repo.appointments[id] = &MedicalAppointment{
+				ID:       id,
+				Status:   string(status),
+				DateTime: time.Date(2030, 1, 1+7*i, 9, 0, 0, 0, time.UTC),
+				SeriesID: &series,
+			}
+		}
+		return repo
+	}
+
+	tests := []struct {
+		scope     SeriesScope
+		cancelled []string
+	}{
+		{ScopeThisOccurrence, []string{"c"}},
+		{ScopeThisAndFollow, []string{"c", "d"}},
+		{ScopeWholeSeries, []string{"b", "c", "d"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.scope), func(t *testing.T) {
+			repo := newRepo()
+			if err := NewService(repo, nil).CancelAppointment("c", "user-1", "", tt.scope); err != nil {
+				t.Fatal(err)
+			}
+
+			for id, appointment := range repo.appointments {
+				want := appointment.Status == string(AppointmentStatusCancelled)
+				got := false
+				for _, cancelled := range tt.cancelled {
+					got = got || cancelled == id
+				}
+				if want != got {
+					t.Errorf("appointment %s status %s, cancelled expected = %v", id, appointment.Status, got)
+				}
+			}
+			if repo.appointments["a"].Status != string(AppointmentStatusCompleted) {
+				t.Error("a completed occurrence must not change")
+			}
+		})
+	}
+}
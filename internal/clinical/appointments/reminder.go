@@ -0,0 +1,271 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/mail"
+	"Altheia-Backend/pkg/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// ReminderJob is the job kind that emails one appointment reminder.
+const ReminderJob = "appointment_reminder"
+
+var ErrInvalidActionLink = errors.New("this link is invalid or has expired")
+
+// AppointmentActionToken lets the patient confirm or cancel an appointment from
+// a reminder email without signing in. It is valid until the appointment
+// starts.
+type AppointmentActionToken struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	AppointmentID string    `gorm:"not null;index" json:"appointment_id"`
+	TokenHash     string    `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt     time.Time `gorm:"not null" json:"expires_at"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// ReminderContact is what the reminder email needs to know about an
+// appointment.
+type ReminderContact struct {
+	PatientUserID string
+	PatientName   string
+	PatientEmail  string
+	PhysicianName string
+	ClinicName    string
+	ClinicAddress string
+	ClinicCity    string
+}
+
+type ActionLinkDTO struct {
+	Token string `json:"token"`
+}
+
+// JobQueue schedules persistent background jobs; jobs.Scheduler implements it.
+type JobQueue interface {
+	Enqueue(kind, key string, runAt time.Time, payload interface{}) error
+}
+
+type reminderPayload struct {
+	AppointmentID string    `json:"appointment_id"`
+	Offset        string    `json:"offset"`
+	StartsAt      time.Time `json:"starts_at"`
+}
+
+// Reminders emails patients ahead of their confirmed appointments, once per
+// configured offset. Plan enqueues a job per reminder under a key made of the
+// appointment, its start time and the offset, so a reminder that was sent is
+// never sent again, even across restarts, while a rescheduled appointment gets
+// fresh ones.
+type Reminders struct {
+	repo    Repository
+	queue   JobQueue
+	offsets []time.Duration
+	now     func() time.Time
+}
+
+func NewReminders(r Repository, queue JobQueue, offsets []time.Duration) *Reminders {
+	return &Reminders{repo: r, queue: queue, offsets: offsets, now: time.Now}
+}
+
+// Plan enqueues the reminders that are due for upcoming confirmed
+// appointments.
+func (r *Reminders) Plan() error {
+	if len(r.offsets) == 0 {
+		return nil
+	}
+
+	now := r.now()
+	appointments, err := r.repo.GetRemindableAppointments(now, now.Add(longest(r.offsets)))
+	if err != nil {
+		return err
+	}
+
+	for _, appointment := range appointments {
+		offset, ok := dueReminder(appointment.DateTime, now, r.offsets)
+		if !ok {
+			continue
+		}
+
+		key := fmt.Sprintf("%s:%s:%d:%s", ReminderJob, appointment.ID, appointment.DateTime.Unix(), offset)
+		payload := reminderPayload{AppointmentID: appointment.ID, Offset: offset.String(), StartsAt: appointment.DateTime}
+		if err := r.queue.Enqueue(ReminderJob, key, appointment.DateTime.Add(-offset), payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueReminder returns the smallest offset whose reminder time has passed for
+// an appointment starting at start. Only the closest reminder is sent, so an
+// appointment confirmed a few hours ahead does not get the 48h reminder right
+// before the 2h one.
+func dueReminder(start, now time.Time, offsets []time.Duration) (time.Duration, bool) {
+	if !start.After(now) {
+		return 0, false
+	}
+
+	var due time.Duration
+	found := false
+	for _, offset := range offsets {
+		if !now.Before(start.Add(-offset)) && (!found || offset < due) {
+			due, found = offset, true
+		}
+	}
+	return due, found
+}
+
+func longest(values []time.Duration) time.Duration {
+	largest := values[0]
+	for _, value := range values[1:] {
+		largest = max(largest, value)
+	}
+	return largest
+}
+
+// Send runs a reminder job. Appointments that are no longer confirmed, have
+// moved or have already started are skipped.
+func (r *Reminders) Send(data []byte) error {
+	var payload reminderPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid reminder payload: %w", err)
+	}
+
+	appointment, err := r.repo.GetAppointmentByID(payload.AppointmentID)
+	if err != nil {
+		return err
+	}
+	if AppointmentStatus(appointment.Status) != AppointmentStatusConfirmed ||
+		!appointment.DateTime.Equal(payload.StartsAt) || !appointment.DateTime.After(r.now()) {
+		return nil
+	}
+
+	contact, err := r.repo.GetReminderContact(appointment.ID)
+	if err != nil {
+		return err
+	}
+
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+	if err := r.repo.CreateActionToken(&AppointmentActionToken{
+		ID:            id,
+		AppointmentID: appointment.ID,
+		TokenHash:     utils.HashToken(raw),
+		ExpiresAt:     appointment.DateTime,
+	}); err != nil {
+		return err
+	}
+
+	loc, err := physicianLocation(r.repo, appointment.PhysicianId)
+	if err != nil {
+		return err
+	}
+
+	return mail.SendAppointmentReminderEmail(mail.AppointmentReminder{
+		Name:          contact.PatientName,
+		To:            contact.PatientEmail,
+		PhysicianName: contact.PhysicianName,
+		ClinicName:    contact.ClinicName,
+		ClinicAddress: contact.location(),
+		StartsAt:      appointment.DateTime.In(loc),
+		Token:         raw,
+		Calendar:      buildICS("", []calendarEvent{appointmentEvent(*appointment, contact)}, r.now()),
+	})
+}
+
+func (c *ReminderContact) location() string {
+	parts := []string{}
+	for _, part := range []string{c.ClinicAddress, c.ClinicCity} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// appointmentEvent is the calendar event attached to a reminder. Like the
+// calendar feeds it leaves the reason out, as it ends up in third-party
+// calendars.
+func appointmentEvent(appointment MedicalAppointment, contact *ReminderContact) calendarEvent {
+	location := contact.location()
+	if contact.ClinicName != "" {
+		location = strings.TrimSuffix(contact.ClinicName+", "+location, ", ")
+	}
+
+	return calendarEvent{
+		UID:      appointmentUID(appointment.ID),
+		Summary:  "Cita médica con " + contact.PhysicianName,
+		Location: location,
+		Start:    appointment.DateTime,
+		End:      appointment.EndTime,
+		Status:   appointmentEventStatus(AppointmentStatus(appointment.Status)),
+		Modified: appointment.UpdatedAt,
+	}
+}
+
+// ConfirmAttendance records that the patient will attend, from the link in a
+// reminder email.
+func (s *service) ConfirmAttendance(token string) (*MedicalAppointment, error) {
+	appointment, err := s.actionLinkAppointment(token)
+	if err != nil {
+		return nil, err
+	}
+	if AppointmentStatus(appointment.Status) != AppointmentStatusConfirmed {
+		return nil, fmt.Errorf("%w: the appointment is %s", ErrInvalidTransition, appointment.Status)
+	}
+
+	now := time.Now()
+	if err := s.repo.ConfirmAttendance(appointment.ID, now); err != nil {
+		return nil, err
+	}
+	appointment.AttendanceConfirmedAt = &now
+	return appointment, s.localize(appointment)
+}
+
+// CancelFromReminder cancels the appointment on behalf of its patient, from
+// the link in a reminder email. Only that occurrence of a series is cancelled,
+// and the clinic's cancellation cutoff applies as in the portal.
+func (s *service) CancelFromReminder(token string) error {
+	appointment, err := s.actionLinkAppointment(token)
+	if err != nil {
+		return err
+	}
+
+	clinicId, err := s.repo.GetPhysicianClinicId(appointment.PhysicianId)
+	if err != nil {
+		return err
+	}
+	policy, err := s.GetBookingPolicy(clinicId)
+	if err != nil {
+		return err
+	}
+	if err := policy.checkCutoff(appointment.DateTime, time.Now()); err != nil {
+		return err
+	}
+
+	contact, err := s.repo.GetReminderContact(appointment.ID)
+	if err != nil {
+		return err
+	}
+	return s.CancelAppointment(appointment.ID, contact.PatientUserID, "Cancelada por el paciente desde el recordatorio", ScopeThisOccurrence)
+}
+
+func (s *service) actionLinkAppointment(token string) (*MedicalAppointment, error) {
+	if token == "" {
+		return nil, ErrInvalidActionLink
+	}
+	actionToken, err := s.repo.GetActionToken(utils.HashToken(token), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetAppointmentByID(actionToken.AppointmentID)
+}
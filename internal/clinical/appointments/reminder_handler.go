@@ -0,0 +1,28 @@
+package appointments
+
+import "github.com/gofiber/fiber/v2"
+
+func (h *Handler) ConfirmAttendance(c *fiber.Ctx) error {
+	var dto ActionLinkDTO
+	if err := c.BodyParser(&dto); err != nil || dto.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	appointment, err := h.service.ConfirmAttendance(dto.Token)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(appointment)
+}
+
+func (h *Handler) CancelFromReminder(c *fiber.Ctx) error {
+	var dto ActionLinkDTO
+	if err := c.BodyParser(&dto); err != nil || dto.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	if err := h.service.CancelFromReminder(dto.Token); err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "appointment cancelled"})
+}
@@ -0,0 +1,233 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/mail"
+	"encoding/json"
+	"errors"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (r *statusRepo) GetRemindableAppointments(from, to time.Time) ([]MedicalAppointment, error) {
+	var due []MedicalAppointment
+	for _, appointment := range r.appointments {
+		if appointment.Status == string(AppointmentStatusConfirmed) && appointment.DateTime.After(from) && !appointment.DateTime.After(to) {
+			due = append(due, *appointment)
+		}
+	}
+	return due, nil
+}
+
+func (r *statusRepo) GetReminderContact(appointmentId string) (*ReminderContact, error) {
+	return &ReminderContact{
+		PatientUserID: "user-" + appointmentId,
+		PatientName:   "Ana",
+		PatientEmail:  "ana@example.com",
+		PhysicianName: "Dr. Ruiz",
+		ClinicName:    "Clínica Norte",
+		ClinicAddress: "Calle 1 #2-3",
+		ClinicCity:    "Bogotá",
+	}, nil
+}
+
+func (r *statusRepo) CreateActionToken(token *AppointmentActionToken) error {
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func (r *statusRepo) GetActionToken(tokenHash string, now time.Time) (*AppointmentActionToken, error) {
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash && token.ExpiresAt.After(now) {
+			return token, nil
+		}
+	}
+	return nil, ErrInvalidActionLink
+}
+
+func (r *statusRepo) ConfirmAttendance(appointmentId string, at time.Time) error {
+	r.appointments[appointmentId].AttendanceConfirmedAt = &at
+	return nil
+}
+
+// memoryQueue runs enqueued jobs on demand, once per key, like the jobs table.
+type memoryQueue struct {
+	keys    map[string]bool
+	pending [][]byte
+	runAts  []time.Time
+}
+
+func (q *memoryQueue) Enqueue(kind, key string, runAt time.Time, payload interface{}) error {
+	if q.keys[key] {
+		return nil
+	}
+	q.keys[key] = true
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	q.pending = append(q.pending, data)
+	q.runAts = append(q.runAts, runAt)
+	return nil
+}
+
+type captureSMTP struct{ messages []string }
+
+func (c *captureSMTP) SendMail(_ string, _ smtp.Auth, _ string, _ []string, msg []byte) error {
+	c.messages = append(c.messages, string(msg))
+	return nil
+}
+
+func TestDueReminder(t *testing.T) {
+	start := time.Date(2025, 3, 12, 15, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{48 * time.Hour, 2 * time.Hour}
+
+	tests := []struct {
+		name   string
+		now    time.Time
+		want   time.Duration
+		wantOk bool
+	}{
+		{"too early", start.Add(-72 * time.Hour), 0, false},
+		{"48h window", start.Add(-47 * time.Hour), 48 * time.Hour, true},
+		{"both passed sends only the closest", start.Add(-time.Hour), 2 * time.Hour, true},
+		{"exactly at the offset", start.Add(-2 * time.Hour), 2 * time.Hour, true},
+		{"already started", start.Add(time.Minute), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := dueReminder(start, tt.now, offsets)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("dueReminder() = %s, %v; want %s, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestBuildICS(t *testing.T) {
+	start := time.Date(2025, 3, 12, 10, 0, 0, 0, time.FixedZone("COT", -5*3600))
+	ics := string(buildICS("Agenda", []calendarEvent{{
+		UID:         appointmentUID("appt-1"),
+		Summary:     "Cita médica con Dr. Ruiz",
+		Description: "Control; revisar exámenes, traer fórmula\nen ayunas",
+		Location:    "Clínica Norte",
+		Start:       start,
+		End:         start.Add(30 * time.Minute),
+		Status:      appointmentEventStatus(AppointmentStatusCancelled),
+	}}, start))
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:appt-1@altheia\r\n",
+		"DTSTART:20250312T150000Z\r\n",
+		"DTEND:20250312T153000Z\r\n",
+		`DESCRIPTION:Control\; revisar exámenes\, traer fórmula\nen ayunas`,
+		"STATUS:CANCELLED\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(strings.ReplaceAll(ics, "\r\n ", ""), want) {
+			t.Errorf("calendar does not contain %q:\n%s", want, ics)
+		}
+	}
+
+	for _, line := range strings.Split(ics, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line is longer than 75 octets: %q", line)
+		}
+	}
+
+	folded := foldICSLine("DESCRIPTION:" + strings.Repeat("á", 60))
+	if !strings.Contains(folded, "\r\n ") || strings.ContainsRune(folded, '\uFFFD') {
+		t.Errorf("foldICSLine() split a character or did not fold: %q", folded)
+	}
+}
+
+func TestAppointmentEventLeavesReasonOut(t *testing.T) {
+	start := time.Date(2025, 3, 12, 15, 0, 0, 0, time.UTC)
+	contact, _ := (&statusRepo{}).GetReminderContact("appt-1")
+	event := appointmentEvent(MedicalAppointment{ID: "appt-1", DateTime: start, EndTime: start.Add(30 * time.Minute),
+		Status: string(AppointmentStatusConfirmed), Reason: "Control de diabetes"}, contact)
+
+	ics := string(buildICS("", []calendarEvent{event}, start))
+	if strings.Contains(ics, "DESCRIPTION") || strings.Contains(ics, "diabetes") {
+		t.Errorf("reminder calendar should leave the appointment reason out:\n%s", ics)
+	}
+	if !strings.Contains(ics, "LOCATION:Clínica Norte") {
+		t.Errorf("reminder calendar is missing the clinic:\n%s", ics)
+	}
+}
+
+func TestRemindersSendOncePerOffset(t *testing.T) {
+	smtpClient := &captureSMTP{}
+	mail.SetSMTPClient(smtpClient)
+	defer mail.SetSMTPClient(&mail.DefaultSMTPClient{})
+
+	start := time.Now().Add(30 * time.Hour).Truncate(time.Minute)
+	repo := &statusRepo{appointments: map[string]*MedicalAppointment{
+		"confirmed": {ID: "confirmed", PhysicianId: "ph-1", DateTime: start, EndTime: start.Add(30 * time.Minute), Status: string(AppointmentStatusConfirmed)},
+		"pending":   {ID: "pending", PhysicianId: "ph-1", DateTime: start, EndTime: start.Add(30 * time.Minute), Status: string(AppointmentStatusPending)},
+	}}
+	queue := &memoryQueue{keys: map[string]bool{}}
+	reminders := NewReminders(repo, queue, []time.Duration{48 * time.Hour, 2 * time.Hour})
+
+	run := func(now time.Time) {
+		t.Helper()
+		reminders.now = func() time.Time { return now }
+		if err := reminders.Plan(); err != nil {
+			t.Fatalf("Plan() error = %v", err)
+		}
+		for _, payload := range queue.pending {
+			if err := reminders.Send(payload); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+		}
+		queue.pending = nil
+	}
+
+	// Planning repeatedly, as after restarts, sends the 48h reminder once.
+	now := time.Now()
+	run(now)
+	run(now.Add(time.Minute))
+	if len(smtpClient.messages) != 1 {
+		t.Fatalf("sent %d reminders in the 48h window, want 1", len(smtpClient.messages))
+	}
+
+	run(start.Add(-90 * time.Minute))
+	run(start.Add(-80 * time.Minute))
+	if len(smtpClient.messages) != 2 {
+		t.Fatalf("sent %d reminders in total, want the 48h and 2h reminders", len(smtpClient.messages))
+	}
+
+	msg := smtpClient.messages[1]
+	if !strings.Contains(msg, "cita.ics") || !strings.Contains(msg, "/appointments/confirm?token=") {
+		t.Errorf("reminder is missing the calendar or the confirm link")
+	}
+
+	// The link in the email confirms attendance and then cancels.
+	token := regexp.MustCompile(`/appointments/confirm\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(msg)[1]
+	s := &service{repo: repo}
+	appointment, err := s.ConfirmAttendance(token)
+	if err != nil || appointment.AttendanceConfirmedAt == nil {
+		t.Fatalf("ConfirmAttendance() = %+v, %v; want the attendance recorded", appointment, err)
+	}
+
+	// The clinic's cancellation cutoff applies to the reminder link too.
+	repo.clinics = map[string]string{"ph-1": "clinic-1"}
+	repo.policies = map[string]*BookingPolicy{"clinic-1": {CancellationCutoffMinutes: 48 * 60}}
+	if err := s.CancelFromReminder(token); !errors.Is(err, ErrBookingPolicy) {
+		t.Fatalf("CancelFromReminder() within the cutoff error = %v, want ErrBookingPolicy", err)
+	}
+	delete(repo.policies, "clinic-1")
+
+	if err := s.CancelFromReminder(token); err != nil {
+		t.Fatalf("CancelFromReminder() error = %v", err)
+	}
+	if got := repo.appointments["confirmed"].Status; got != string(AppointmentStatusCancelled) {
+		t.Errorf("status after cancelling from the reminder = %s, want cancelled", got)
+	}
+	if _, err := s.ConfirmAttendance("not-a-token"); !errors.Is(err, ErrInvalidActionLink) {
+		t.Errorf("ConfirmAttendance(unknown token) error = %v, want ErrInvalidActionLink", err)
+	}
+}
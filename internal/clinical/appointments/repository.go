@@ -3,21 +3,82 @@ package appointments
 import (
 	"Altheia-Backend/internal/clinical"
 	"Altheia-Backend/internal/users"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	gonanoid "github.com/matoous/go-nanoid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Repository interface {
-	CreateAppointment(appointment CreateAppointmentDTO) error
+	CreateAppointment(appointment CreateAppointmentDTO, endTime time.Time) (*MedicalAppointment, error)
+	CreateOwnAppointment(appointment CreateAppointmentDTO, endTime time.Time, policy *BookingPolicy, now time.Time, confirmation *StatusChange) (*MedicalAppointment, error)
 	GetAllAppointments() ([]MedicalAppointment, error)
-	UpdateAppointmentStatus(appointmentId string, status AppointmentStatus) error
+	UpdateAppointmentStatus(change StatusChange) error
+	UpdateAppointmentStatuses(changes []StatusChange) error
+	CreateSeries(series *AppointmentSeries, appointments []MedicalAppointment) error
+	GetSeries(seriesId string) (*AppointmentSeries, error)
+	GetSeriesAppointments(seriesId string) ([]MedicalAppointment, error)
+
+	CreateWaitlistEntry(entry *WaitlistEntry) error
+	GetWaitlistEntry(entryId string) (*WaitlistEntry, error)
+	GetClinicWaitlist(clinicId string) ([]WaitlistEntry, error)
+	UpdateWaitlistStatus(entryId string, status WaitlistStatus, appointmentId *string) error
+	NextWaitlistEntry(physicianId string, slot Slot) (*WaitlistEntry, error)
+	ClinicOffersService(clinicId, serviceId string) (bool, error)
+	GetWaitlistContact(entryId, physicianId string) (*WaitlistContact, error)
+	CreateSlotOffer(offer *SlotOffer) error
+	GetPendingOffers(physicianId string, from, to time.Time) ([]SlotOffer, error)
+	ResolveSlotOffer(tokenHash string, status OfferStatus) (*SlotOffer, error)
+	ExpireSlotOffers(now time.Time) ([]SlotOffer, error)
+	GetStatusHistory(appointmentId string) ([]AppointmentStatusHistory, error)
+	GetRemindableAppointments(from, to time.Time) ([]MedicalAppointment, error)
+	GetReminderContact(appointmentId string) (*ReminderContact, error)
+	CreateActionToken(token *AppointmentActionToken) error
+	GetActionToken(tokenHash string, now time.Time) (*AppointmentActionToken, error)
+	ConfirmAttendance(appointmentId string, at time.Time) error
 	GetAllAppointmentsByMedicId(physicianId string) ([]AppointmentWithNamesDTO, error)
-	CancelAppointment(appointmentId string) error
 	GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNamesDTO, error)
-	ReScheduleAppointment(appointmentId string, newDateTime time.Time) error
+	SearchAppointments(q AppointmentSearch, limit int) ([]AppointmentWithNamesDTO, error)
+	GetClinicTimezone(clinicId string) (string, error)
+	GetAppointmentByID(appointmentId string) (*MedicalAppointment, error)
+	GetAppointmentWithNames(appointmentId string) (*AppointmentWithNamesDTO, error)
+	GetAppointmentConsultation(appointmentId string) (*clinical.MedicalConsultation, error)
+	CompleteWithConsultation(tx *gorm.DB, link clinical.ConsultationLink) error
+
+	GetAvailability(physicianId string) ([]PhysicianAvailability, error)
+	ReplaceAvailability(physicianId string, blocks []PhysicianAvailability) error
+	GetAvailabilityExceptions(physicianId string, from, to time.Time) ([]AvailabilityException, error)
+	CreateAvailabilityException(exception *AvailabilityException) error
+	DeleteAvailabilityException(physicianId, exceptionId string) error
+	GetPhysicianClinicSchedule(physicianId string) ([]clinical.ClinicSchedule, error)
+	GetPhysicianTimezones(physicianIds []string) (map[string]string, error)
+	GetPhysicianIdByUserId(userId string) (string, error)
+	GetPatientIdByUserId(userId string) (string, error)
+	GetPhysicianClinicId(physicianId string) (string, error)
+	GetBookablePhysicianClinicId(physicianId string) (string, error)
+	GetClinicPhysicians(clinicId string) ([]PortalPhysician, error)
+	GetBookingPolicy(clinicId string) (*BookingPolicy, error)
+	SaveBookingPolicy(policy *BookingPolicy) error
+	GetMissedAppointments(endedBefore time.Time, limit int) ([]MedicalAppointment, error)
+	GetPatientAttendance(patientId, clinicId string, since time.Time) (*AttendanceCounts, error)
+	GetNoShowsByPhysician(clinicId string, from, to time.Time) ([]NoShowRate, error)
+	GetNoShowsByWeekday(clinicId string, from, to time.Time) (map[int]AttendanceCounts, error)
+	CreateCheckIn(checkIn *AppointmentCheckIn, change StatusChange) error
+	UpdateTriagePriority(appointmentId string, priority TriagePriority) (*AppointmentCheckIn, error)
+	CallCheckIn(change StatusChange, at time.Time) error
+	GetPhysicianQueue(physicianId string, since time.Time) ([]QueueEntry, error)
+	GetClinicCheckIns(clinicId string, from, to time.Time) ([]QueueEntry, error)
+
+	CreateCalendarFeed(feed *CalendarFeed) error
+	GetCalendarFeeds(userId string) ([]CalendarFeed, error)
+	GetCalendarFeedByToken(tokenHash string) (*CalendarFeed, error)
+	RevokeCalendarFeed(userId, feedId string, at time.Time) error
+	TouchCalendarFeed(feedId string, at time.Time) error
+	GetActiveAppointmentsInRange(physicianId string, from, to time.Time) ([]MedicalAppointment, error)
 }
 
 type repository struct {
@@ -27,214 +88,1154 @@ type repository struct {
 func NewRepository(db *gorm.DB) Repository { return &repository{db} }
 
 func (r *repository) GetAllAppointmentsByMedicId(physicianId string) ([]AppointmentWithNamesDTO, error) {
-	var appointments []MedicalAppointment
-	err := r.db.Model(&MedicalAppointment{}).
-		Where("physician_id = ?", physicianId).
-		Preload("Patient").
-		Preload("Physician").
-		Find(&appointments).Error
+	appointments, err := r.scanWithNames(r.withNames().Where("ma.physician_id = ?", physicianId).Order("ma.date_time, ma.id"))
 	if err != nil {
 		return nil, fmt.Errorf("error al obtener las citas médicas por ID de médico: %w", err)
 	}
+	return appointments, nil
+}
 
-	var result []AppointmentWithNamesDTO
-	for _, appt := range appointments {
-		var patient users.User
-		var physician users.User
-		var clinic clinical.ClinicInformation
-		// Buscar nombre del paciente
-		if err := r.db.Model(&users.User{}).Where("id = ?", appt.Patient.UserID).First(&patient).Error; err != nil {
-			patient.Name = ""
-		}
-		// Buscar nombre del médico
-		if err := r.db.Model(&users.User{}).Where("id = ?", appt.Physician.UserID).First(&physician).Error; err != nil {
-			physician.Name = ""
-		}
-		// Buscar nombre de la clínica del médico
-		if err := r.db.Model(&clinical.ClinicInformation{}).Where("clinic_id = ?", appt.Physician.ClinicID).First(&clinic).Error; err != nil {
-			clinic.ClinicName = ""
-			clinic.City = ""
-			clinic.Address = ""
-			clinic.ClinicID = ""
-		}
+func (r *repository) CreateAppointment(appointment CreateAppointmentDTO, endTime time.Time) (*MedicalAppointment, error) {
+	created, err := newAppointment(r.db, appointment, endTime)
+	if err != nil {
+		return nil, err
+	}
 
-		result = append(result, AppointmentWithNamesDTO{
-			MedicalAppointment: appt,
-			PatientName:        patient.Name,
-			PatientGender:      patient.Gender,
-			PatientEmail:       patient.Email,
-			PatientPhone:       patient.Phone,
-			PhysicianName:      physician.Name,
-			PhysicianGender:    physician.Gender,
-			PhysicianEmail:     physician.Email,
-			PhysicianPhone:     physician.Phone,
-			ClinicName:         clinic.ClinicName,
-			ClinicCity:         clinic.City,
-			ClinicAddress:      clinic.Address,
-			ClinicId:           clinic.ClinicID,
-		})
+	if err := r.db.Create(created).Error; err != nil {
+		if conflict := r.conflictError(err, *created); conflict != nil {
+			return nil, conflict
+		}
+		return nil, fmt.Errorf("error al crear la cita médica: %w", err)
 	}
-	return result, nil
+
+	return created, nil
 }
 
-func (r *repository) CreateAppointment(appointment CreateAppointmentDTO) error {
+// newAppointment builds the pending appointment booking the DTO's slot, which
+// ends at endTime, once the patient and the physician are found.
+func newAppointment(tx *gorm.DB, appointment CreateAppointmentDTO, endTime time.Time) (*MedicalAppointment, error) {
 	nanoId, _ := gonanoid.Nanoid()
 
-	// Configurar la zona horaria de Bogotá
-	loc, err := time.LoadLocation("America/Bogota")
+	loc, err := physicianLocation(&repository{tx}, appointment.PhysicianId)
 	if err != nil {
-		return fmt.Errorf("error al cargar la zona horaria: %w", err)
+		return nil, err
 	}
 
-	dateTimeStr := fmt.Sprintf("%s %s", appointment.Date, appointment.Time)
-
-	// Parsear la fecha especificando la zona horaria de Bogotá
-	dateTime, err := time.ParseInLocation("2006-01-02 15:04", dateTimeStr, loc)
+	dateTime, err := appointment.dateTime(loc)
 	if err != nil {
-		return fmt.Errorf("invalid date or time format: %w", err)
+		return nil, err
 	}
 
 	// Primero verificamos que existan el paciente y el médico
 	var patient users.Patient
 	var physician users.Physician
 
-	if err := r.db.Where("id = ?", appointment.PatientId).First(&patient).Error; err != nil {
-		return fmt.Errorf("paciente no encontrado: %w", err)
+	if err := tx.Where("id = ?", appointment.PatientId).First(&patient).Error; err != nil {
+		return nil, fmt.Errorf("paciente no encontrado: %w", err)
 	}
 
-	if err := r.db.Where("id = ?", appointment.PhysicianId).First(&physician).Error; err != nil {
-		return fmt.Errorf("médico no encontrado: %w", err)
+	if err := tx.Where("id = ?", appointment.PhysicianId).First(&physician).Error; err != nil {
+		return nil, fmt.Errorf("médico no encontrado: %w", err)
 	}
 
-	newAppointment := MedicalAppointment{
+	return &MedicalAppointment{
 		ID:          nanoId,
 		PatientId:   appointment.PatientId,
 		PhysicianId: appointment.PhysicianId,
-		DateTime:    dateTime,
+		DateTime:    dateTime.UTC(),
+		EndTime:     endTime.UTC(),
 		Status:      string(AppointmentStatusPending),
 		Reason:      appointment.Reason,
 		Patient:     patient,
 		Physician:   physician,
+	}, nil
+}
+
+// CreateOwnAppointment books an appointment a patient made from the portal.
+// The patient's row stays locked while their upcoming appointments at the
+// policy's clinic are counted and the booking is inserted, so concurrent
+// bookings cannot get past the policy's limit. With a confirmation the booking
+// is stored with its status and the change is recorded in the status history,
+// all or nothing.
+func (r *repository) CreateOwnAppointment(appointment CreateAppointmentDTO, endTime time.Time, policy *BookingPolicy, now time.Time, confirmation *StatusChange) (*MedicalAppointment, error) {
+	var booked *MedicalAppointment
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var patient users.Patient
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("id = ?", appointment.PatientId).
+			First(&patient).Error
+		if err != nil {
+			return fmt.Errorf("paciente no encontrado: %w", err)
+		}
+
+		active, err := countActiveAppointments(tx, appointment.PatientId, policy.ClinicID, now)
+		if err != nil {
+			return err
+		}
+		if err := policy.checkActive(active); err != nil {
+			return err
+		}
+
+		booked, err = newAppointment(tx, appointment, endTime)
+		if err != nil {
+			return err
+		}
+		if confirmation != nil {
+			booked.Status = string(confirmation.To)
+		}
+		if err := tx.Create(booked).Error; err != nil {
+			if conflict := r.conflictError(err, *booked); conflict != nil {
+				return conflict
+			}
+			return fmt.Errorf("error al crear la cita médica: %w", err)
+		}
+
+		if confirmation == nil {
+			return nil
+		}
+		change := *confirmation
+		change.AppointmentID = booked.ID
+		return recordStatusChange(tx, change)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return booked, nil
+}
 
-	if err := r.db.Create(&newAppointment).Error; err != nil {
-		return fmt.Errorf("error al crear la cita médica: %w", err)
+func (r *repository) GetAllAppointments() ([]MedicalAppointment, error) {
+	var appointments []MedicalAppointment
+
+	err := r.db.Model(&MedicalAppointment{}).
+		Preload("Patient").
+		Preload("Physician").
+		Find(&appointments).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las citas médicas: %w", err)
 	}
 
-	return nil
+	return appointments, nil
+}
+
+// UpdateAppointmentStatus applies change and records it in the status history
+// in one transaction. The update only applies while the appointment is still in
+// change.From, so concurrent changes cannot skip the lifecycle.
+func (r *repository) UpdateAppointmentStatus(change StatusChange) error {
+	return r.UpdateAppointmentStatuses([]StatusChange{change})
+}
+
+// UpdateAppointmentStatuses applies several changes, such as those to the
+// occurrences of a series, all or none.
+func (r *repository) UpdateAppointmentStatuses(changes []StatusChange) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, change := range changes {
+			if err := applyStatusChange(tx, change); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil || errors.Is(err, ErrStatusChanged) {
+		return err
+	}
+
+	if conflict := r.statusChangeConflict(err, changes); conflict != nil {
+		return conflict
+	}
+	return fmt.Errorf("error al actualizar el estado de la cita médica: %w", err)
+}
+
+func applyStatusChange(tx *gorm.DB, change StatusChange) error {
+	updates := map[string]interface{}{"status": string(change.To)}
+	if !change.NewStart.IsZero() {
+		updates["date_time"] = change.NewStart.UTC()
+		updates["end_time"] = change.NewEnd.UTC()
+	}
+
+	result := tx.Model(&MedicalAppointment{}).
+		Where("id = ? AND status = ?", change.AppointmentID, string(change.From)).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+	return recordStatusChange(tx, change)
+}
+
+// recordStatusChange adds change to the appointment's status history.
+func recordStatusChange(tx *gorm.DB, change StatusChange) error {
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+
+	entry := AppointmentStatusHistory{
+		ID:            id,
+		AppointmentID: change.AppointmentID,
+		FromStatus:    string(change.From),
+		ToStatus:      string(change.To),
+		ActorUserID:   change.ActorUserID,
+		Reason:        change.Reason,
+	}
+	return tx.Create(&entry).Error
+}
+
+// statusChangeConflict maps an exclusion violation raised by changes to the
+// appointment they collided with.
+func (r *repository) statusChangeConflict(err error, changes []StatusChange) error {
+	if !isExclusionViolation(err) {
+		return nil
+	}
+
+	moved := make([]MedicalAppointment, 0, len(changes))
+	for _, change := range changes {
+		var appointment MedicalAppointment
+		if r.db.Where("id = ?", change.AppointmentID).First(&appointment).Error != nil {
+			continue
+		}
+		if !change.NewStart.IsZero() {
+			appointment.DateTime, appointment.EndTime = change.NewStart, change.NewEnd
+		}
+		moved = append(moved, appointment)
+	}
+	return r.conflictError(err, moved...)
+}
+
+func (r *repository) GetStatusHistory(appointmentId string) ([]AppointmentStatusHistory, error) {
+	var history []AppointmentStatusHistory
+	err := r.db.Where("appointment_id = ?", appointmentId).
+		Order("created_at").
+		Find(&history).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener el historial de la cita médica: %w", err)
+	}
+	return history, nil
+}
+
+func (r *repository) GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNamesDTO, error) {
+	// The user may attend the appointment as patient or hold it as physician.
+	appointments, err := r.scanWithNames(r.withNames().
+		Where("p.user_id = ? OR ph.user_id = ?", userId, userId).
+		Order("ma.date_time, ma.id"))
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las citas médicas: %w", err)
+	}
+	return appointments, nil
+}
+
+func (r *repository) SearchAppointments(q AppointmentSearch, limit int) ([]AppointmentWithNamesDTO, error) {
+	query := r.withNames()
+	if q.ClinicID != "" {
+		query = query.Where("ph.clinic_id = ?", q.ClinicID)
+	}
+	if q.PhysicianID != "" {
+		query = query.Where("ma.physician_id = ?", q.PhysicianID)
+	}
+	if q.PatientID != "" {
+		query = query.Where("ma.patient_id = ?", q.PatientID)
+	}
+	if q.PatientUserID != "" {
+		query = query.Where("p.user_id = ?", q.PatientUserID)
+	}
+	if len(q.Statuses) > 0 {
+		query = query.Where("ma.status IN ?", q.Statuses)
+	}
+	if !q.From.IsZero() {
+		query = query.Where("ma.date_time >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		query = query.Where("ma.date_time < ?", q.To)
+	}
+	if reason := strings.TrimSpace(q.Reason); reason != "" {
+		query = query.Where("ma.reason ILIKE ?", "%"+escapeLike(reason)+"%")
+	}
+
+	column, direction, after := q.Sort.column(), "ASC", ">"
+	if q.Sort.descending() {
+		direction, after = "DESC", "<"
+	}
+	if q.after != nil {
+		query = query.Where(fmt.Sprintf("(%s, ma.id) %s (?, ?)", column, after), q.after.At, q.after.ID)
+	}
+
+	appointments, err := r.scanWithNames(query.
+		Order(fmt.Sprintf("%s %s, ma.id %s", column, direction, direction)).
+		Limit(limit))
+	if err != nil {
+		return nil, fmt.Errorf("error al buscar las citas médicas: %w", err)
+	}
+	return appointments, nil
+}
+
+// appointmentRow is an appointment read together with the names and contact
+// details of its patient, physician and clinic.
+type appointmentRow struct {
+	MedicalAppointment
+	PatientUserID     string
+	PhysicianUserID   string
+	PhysicianClinicID *string
+	PatientName       string
+	PatientGender     string
+	PatientEmail      string
+	PatientPhone      string
+	PhysicianName     string
+	PhysicianGender   string
+	PhysicianEmail    string
+	PhysicianPhone    string
+	ClinicName        string
+	ClinicCity        string
+	ClinicAddress     string
+	ClinicId          string
+	ConsultationId    *string
+}
+
+// withNames selects appointments joined to their patient, physician and
+// clinic, so a list is read in a single query.
+func (r *repository) withNames() *gorm.DB {
+	return r.db.Table("medical_appointments AS ma").
+		Select(`ma.*,
+			p.user_id AS patient_user_id, ph.user_id AS physician_user_id, ph.clinic_id AS physician_clinic_id,
+			COALESCE(pu.name, '') AS patient_name, COALESCE(pu.gender, '') AS patient_gender,
+			COALESCE(pu.email, '') AS patient_email, COALESCE(pu.phone, '') AS patient_phone,
+			COALESCE(phu.name, '') AS physician_name, COALESCE(phu.gender, '') AS physician_gender,
+			COALESCE(phu.email, '') AS physician_email, COALESCE(phu.phone, '') AS physician_phone,
+			COALESCE(ci.clinic_name, '') AS clinic_name, COALESCE(ci.city, '') AS clinic_city,
+			COALESCE(ci.address, '') AS clinic_address, COALESCE(ci.clinic_id, '') AS clinic_id,
+			mc.id AS consultation_id`).
+		Joins("JOIN patients p ON p.id = ma.patient_id").
+		Joins("JOIN physicians ph ON ph.id = ma.physician_id").
+		Joins("LEFT JOIN users pu ON pu.id = p.user_id").
+		Joins("LEFT JOIN users phu ON phu.id = ph.user_id").
+		Joins("LEFT JOIN clinic_informations ci ON ci.clinic_id = ph.clinic_id").
+		Joins("LEFT JOIN medical_consultations mc ON mc.appointment_id = ma.id AND mc.deleted_at IS NULL").
+		Where("ma.deleted_at IS NULL")
 }
 
-func (r *repository) ReScheduleAppointment(appointmentId string, newDateTime time.Time) error {
-	// Configurar la zona horaria de Bogotá
-	loc, err := time.LoadLocation("America/Bogota")
+func (r *repository) scanWithNames(query *gorm.DB) ([]AppointmentWithNamesDTO, error) {
+	var rows []appointmentRow
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]AppointmentWithNamesDTO, 0, len(rows))
+	for _, row := range rows {
+		appointment := row.MedicalAppointment
+		appointment.Patient = users.Patient{ID: row.PatientId, UserID: row.PatientUserID}
+		appointment.Physician = users.Physician{ID: row.PhysicianId, UserID: row.PhysicianUserID, ClinicID: row.PhysicianClinicID}
+
+		result = append(result, AppointmentWithNamesDTO{
+			MedicalAppointment: appointment,
+			PatientName:        row.PatientName,
+			PatientGender:      row.PatientGender,
+			PatientEmail:       row.PatientEmail,
+			PatientPhone:       row.PatientPhone,
+			PhysicianName:      row.PhysicianName,
+			PhysicianGender:    row.PhysicianGender,
+			PhysicianEmail:     row.PhysicianEmail,
+			PhysicianPhone:     row.PhysicianPhone,
+			ClinicName:         row.ClinicName,
+			ClinicCity:         row.ClinicCity,
+			ClinicAddress:      row.ClinicAddress,
+			ClinicId:           row.ClinicId,
+			ConsultationId:     row.ConsultationId,
+		})
+	}
+	return result, nil
+}
+
+func (r *repository) GetClinicTimezone(clinicId string) (string, error) {
+	var zones []string
+	err := r.db.Model(&clinical.ClinicInformation{}).
+		Where("clinic_id = ?", clinicId).
+		Limit(1).
+		Pluck("timezone", &zones).Error
 	if err != nil {
-		return fmt.Errorf("error al cargar la zona horaria: %w", err)
+		return "", fmt.Errorf("error al obtener la zona horaria de la clínica: %w", err)
+	}
+	if len(zones) == 0 {
+		return "", nil
+	}
+	return zones[0], nil
+}
+
+func (r *repository) GetAppointmentByID(appointmentId string) (*MedicalAppointment, error) {
+	var appointment MedicalAppointment
+	if err := r.db.Where("id = ?", appointmentId).First(&appointment).Error; err != nil {
+		return nil, err
 	}
+	return &appointment, nil
+}
 
-	// Convertir la nueva fecha y hora a la zona horaria de Bogotá
-	newDateTime = newDateTime.In(loc)
+func (r *repository) GetAvailability(physicianId string) ([]PhysicianAvailability, error) {
+	var blocks []PhysicianAvailability
+	err := r.db.Where("physician_id = ?", physicianId).
+		Order("weekday, start_time").
+		Find(&blocks).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener la disponibilidad del médico: %w", err)
+	}
+	return blocks, nil
+}
 
-	err = r.db.Model(&MedicalAppointment{}).
-		Where("id = ?", appointmentId).
-		Update("date_time", newDateTime).
-		Update("status", string(AppointmentStatusRescheduled)).Error
+// ReplaceAvailability swaps the physician's whole weekly template in one
+// transaction.
+func (r *repository) ReplaceAvailability(physicianId string, blocks []PhysicianAvailability) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("physician_id = ?", physicianId).Delete(&PhysicianAvailability{}).Error; err != nil {
+			return fmt.Errorf("error al actualizar la disponibilidad del médico: %w", err)
+		}
+		if len(blocks) == 0 {
+			return nil
+		}
+		if err := tx.Create(&blocks).Error; err != nil {
+			return fmt.Errorf("error al actualizar la disponibilidad del médico: %w", err)
+		}
+		return nil
+	})
+}
 
+// GetAvailabilityExceptions returns the exceptions that overlap [from, to).
+func (r *repository) GetAvailabilityExceptions(physicianId string, from, to time.Time) ([]AvailabilityException, error) {
+	var exceptions []AvailabilityException
+	err := r.db.Where("physician_id = ? AND starts_at < ? AND ends_at > ?", physicianId, to, from).
+		Order("starts_at").
+		Find(&exceptions).Error
 	if err != nil {
-		return fmt.Errorf("error al reprogramar la cita médica: %w", err)
+		return nil, fmt.Errorf("error al obtener las excepciones de disponibilidad: %w", err)
 	}
+	return exceptions, nil
+}
 
+func (r *repository) CreateAvailabilityException(exception *AvailabilityException) error {
+	if err := r.db.Create(exception).Error; err != nil {
+		return fmt.Errorf("error al crear la excepción de disponibilidad: %w", err)
+	}
 	return nil
 }
 
-func (r *repository) GetAllAppointments() ([]MedicalAppointment, error) {
+func (r *repository) DeleteAvailabilityException(physicianId, exceptionId string) error {
+	result := r.db.Where("id = ? AND physician_id = ?", exceptionId, physicianId).Delete(&AvailabilityException{})
+	if result.Error != nil {
+		return fmt.Errorf("error al eliminar la excepción de disponibilidad: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetPhysicianClinicSchedule returns the opening hours of the physician's
+// clinic.
+func (r *repository) GetPhysicianClinicSchedule(physicianId string) ([]clinical.ClinicSchedule, error) {
+	var schedule []clinical.ClinicSchedule
+	err := r.db.Model(&clinical.ClinicSchedule{}).
+		Joins("JOIN physicians ON physicians.clinic_id = clinic_schedules.clinic_id").
+		Where("physicians.id = ?", physicianId).
+		Find(&schedule).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener el horario de la clínica: %w", err)
+	}
+	return schedule, nil
+}
+
+// GetActiveAppointmentsInRange returns the physician's appointments that
+// overlap [from, to) and still hold their time, that is, all but cancelled
+// ones.
+func (r *repository) GetActiveAppointmentsInRange(physicianId string, from, to time.Time) ([]MedicalAppointment, error) {
 	var appointments []MedicalAppointment
+	err := r.db.Where("physician_id = ? AND date_time < ? AND end_time > ? AND status <> ?",
+		physicianId, to, from, string(AppointmentStatusCancelled)).
+		Order("date_time").
+		Find(&appointments).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las citas médicas del médico: %w", err)
+	}
+	return appointments, nil
+}
 
-	err := r.db.Model(&MedicalAppointment{}).
-		Preload("Patient").
-		Preload("Physician").
+// CreateSeries stores a series and its occurrences, all or none.
+func (r *repository) CreateSeries(series *AppointmentSeries, appointments []MedicalAppointment) error {
+	var patient users.Patient
+	if err := r.db.Where("id = ?", series.PatientId).First(&patient).Error; err != nil {
+		return fmt.Errorf("paciente no encontrado: %w", err)
+	}
+
+	var physician users.Physician
+	if err := r.db.Where("id = ?", series.PhysicianId).First(&physician).Error; err != nil {
+		return fmt.Errorf("médico no encontrado: %w", err)
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(series).Error; err != nil {
+			return err
+		}
+		return tx.Omit("Patient", "Physician").Create(&appointments).Error
+	})
+	if err != nil {
+		if conflict := r.conflictError(err, appointments...); conflict != nil {
+			return conflict
+		}
+		return fmt.Errorf("error al crear la serie de citas médicas: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) GetSeries(seriesId string) (*AppointmentSeries, error) {
+	var series AppointmentSeries
+	if err := r.db.Where("id = ?", seriesId).First(&series).Error; err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+func (r *repository) GetSeriesAppointments(seriesId string) ([]MedicalAppointment, error) {
+	var appointments []MedicalAppointment
+	err := r.db.Where("series_id = ?", seriesId).
+		Order("date_time").
 		Find(&appointments).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las citas de la serie: %w", err)
+	}
+	return appointments, nil
+}
+
+func (r *repository) CreateWaitlistEntry(entry *WaitlistEntry) error {
+	if err := r.db.Create(entry).Error; err != nil {
+		return fmt.Errorf("error al crear la entrada en la lista de espera: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) GetWaitlistEntry(entryId string) (*WaitlistEntry, error) {
+	var entry WaitlistEntry
+	if err := r.db.Where("id = ?", entryId).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
 
+// GetClinicWaitlist returns the clinic's open entries, oldest first.
+func (r *repository) GetClinicWaitlist(clinicId string) ([]WaitlistEntry, error) {
+	var entries []WaitlistEntry
+	err := r.db.Where("clinic_id = ? AND status IN ?", clinicId,
+		[]string{string(WaitlistStatusWaiting), string(WaitlistStatusOffered)}).
+		Order("created_at").
+		Find(&entries).Error
 	if err != nil {
-		return nil, fmt.Errorf("error al obtener las citas médicas: %w", err)
+		return nil, fmt.Errorf("error al obtener la lista de espera: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *repository) UpdateWaitlistStatus(entryId string, status WaitlistStatus, appointmentId *string) error {
+	updates := map[string]interface{}{"status": string(status)}
+	if appointmentId != nil {
+		updates["appointment_id"] = *appointmentId
+	}
+	if err := r.db.Model(&WaitlistEntry{}).Where("id = ?", entryId).Updates(updates).Error; err != nil {
+		return fmt.Errorf("error al actualizar la lista de espera: %w", err)
+	}
+	return nil
+}
+
+// NextWaitlistEntry returns the oldest waiting entry the slot suits: one for
+// the physician, or one for any physician of the clinic whose service, if
+// given, matches the physician's specialty. Entries already offered this slot
+// are skipped.
+func (r *repository) NextWaitlistEntry(physicianId string, slot Slot) (*WaitlistEntry, error) {
+	var entry WaitlistEntry
+	err := r.db.Raw(`
+		SELECT w.* FROM waitlist_entries w
+		JOIN physicians ph ON ph.id = ?
+		LEFT JOIN services_offereds s ON s.id = w.service_id
+		WHERE w.status = ?
+		  AND w.preferred_from <= ? AND w.preferred_to >= ?
+		  AND w.clinic_id = ph.clinic_id
+		  AND (w.physician_id = ph.id OR (w.physician_id IS NULL
+		       AND (w.service_id IS NULL OR LOWER(s.name) = LOWER(ph.physician_specialty))))
+		  AND NOT EXISTS (SELECT 1 FROM slot_offers o
+		       WHERE o.waitlist_entry_id = w.id AND o.physician_id = ph.id AND o.starts_at = ?)
+		ORDER BY w.created_at
+		LIMIT 1`,
+		physicianId, string(WaitlistStatusWaiting), slot.Start, slot.End, slot.Start).
+		Scan(&entry).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al consultar la lista de espera: %w", err)
+	}
+	if entry.ID == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &entry, nil
+}
+
+// ClinicOffersService reports whether the clinic lists the service among the
+// services it offers.
+func (r *repository) ClinicOffersService(clinicId, serviceId string) (bool, error) {
+	var offered bool
+	err := r.db.Raw(`SELECT EXISTS (SELECT 1 FROM clinic_services
+		WHERE clinic_information_clinic_id = ? AND services_offered_id = ?)`, clinicId, serviceId).
+		Scan(&offered).Error
+	if err != nil {
+		return false, fmt.Errorf("error al verificar los servicios de la clínica: %w", err)
+	}
+	return offered, nil
+}
+
+func (r *repository) GetWaitlistContact(entryId, physicianId string) (*WaitlistContact, error) {
+	var contact WaitlistContact
+	result := r.db.Raw(`
+		SELECT pu.name AS patient_name, pu.email AS patient_email, phu.name AS physician_name,
+		       COALESCE(ci.clinic_name, '') AS clinic_name
+		FROM waitlist_entries w
+		JOIN patients p ON p.id = w.patient_id
+		JOIN users pu ON pu.id = p.user_id
+		JOIN physicians ph ON ph.id = ?
+		JOIN users phu ON phu.id = ph.user_id
+		LEFT JOIN clinic_informations ci ON ci.clinic_id = ph.clinic_id
+		WHERE w.id = ?`, physicianId, entryId).
+		Scan(&contact)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error al obtener los datos de contacto: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &contact, nil
+}
+
+// CreateSlotOffer stores the offer and marks its entry as offered.
+func (r *repository) CreateSlotOffer(offer *SlotOffer) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(offer).Error; err != nil {
+			return fmt.Errorf("error al crear la oferta de cita: %w", err)
+		}
+		return tx.Model(&WaitlistEntry{}).
+			Where("id = ?", offer.WaitlistEntryID).
+			Update("status", string(WaitlistStatusOffered)).Error
+	})
+}
+
+// GetPendingOffers returns the unexpired offers holding slots of the physician
+// that overlap [from, to).
+func (r *repository) GetPendingOffers(physicianId string, from, to time.Time) ([]SlotOffer, error) {
+	var offers []SlotOffer
+	err := r.db.Where("physician_id = ? AND status = ? AND expires_at > ? AND starts_at < ? AND ends_at > ?",
+		physicianId, string(OfferStatusPending), time.Now(), to, from).
+		Find(&offers).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las ofertas de citas: %w", err)
+	}
+	return offers, nil
+}
+
+// ResolveSlotOffer moves a pending, unexpired offer to status. Only one caller
+// can resolve an offer; later ones get ErrOfferUnavailable.
+func (r *repository) ResolveSlotOffer(tokenHash string, status OfferStatus) (*SlotOffer, error) {
+	var offer SlotOffer
+	result := r.db.Model(&offer).
+		Clauses(clause.Returning{}).
+		Where("token_hash = ? AND status = ? AND expires_at > ?", tokenHash, string(OfferStatusPending), time.Now()).
+		Update("status", string(status))
+	if result.Error != nil {
+		return nil, fmt.Errorf("error al actualizar la oferta de cita: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrOfferUnavailable
 	}
+	return &offer, nil
+}
 
+// ExpireSlotOffers marks the pending offers that ran out before now as expired
+// and puts their entries back on the waitlist.
+func (r *repository) ExpireSlotOffers(now time.Time) ([]SlotOffer, error) {
+	var offers []SlotOffer
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&offers).
+			Clauses(clause.Returning{}).
+			Where("status = ? AND expires_at <= ?", string(OfferStatusPending), now).
+			Update("status", string(OfferStatusExpired)).Error; err != nil {
+			return err
+		}
+		if len(offers) == 0 {
+			return nil
+		}
+
+		entryIds := make([]string, 0, len(offers))
+		for _, offer := range offers {
+			entryIds = append(entryIds, offer.WaitlistEntryID)
+		}
+		return tx.Model(&WaitlistEntry{}).
+			Where("id IN ? AND status = ?", entryIds, string(WaitlistStatusOffered)).
+			Update("status", string(WaitlistStatusWaiting)).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al expirar las ofertas de citas: %w", err)
+	}
+	return offers, nil
+}
+
+// GetRemindableAppointments returns the confirmed appointments starting after
+// from and no later than to.
+func (r *repository) GetRemindableAppointments(from, to time.Time) ([]MedicalAppointment, error) {
+	var appointments []MedicalAppointment
+	err := r.db.
+		Where("status = ? AND date_time > ? AND date_time <= ?", string(AppointmentStatusConfirmed), from, to).
+		Order("date_time").
+		Find(&appointments).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las citas por recordar: %w", err)
+	}
 	return appointments, nil
 }
 
-func (r *repository) UpdateAppointmentStatus(appointmentId string, status AppointmentStatus) error {
-	err := r.db.Model(&MedicalAppointment{}).
-		Where("id = ?", appointmentId).
-		Update("status", status).Error
+func (r *repository) GetReminderContact(appointmentId string) (*ReminderContact, error) {
+	var contact ReminderContact
+	result := r.db.Raw(`
+		SELECT pu.id AS patient_user_id, pu.name AS patient_name, pu.email AS patient_email,
+		       phu.name AS physician_name, COALESCE(ci.clinic_name, '') AS clinic_name,
+		       COALESCE(ci.address, '') AS clinic_address, COALESCE(ci.city, '') AS clinic_city
+		FROM medical_appointments a
+		JOIN patients p ON p.id = a.patient_id
+		JOIN users pu ON pu.id = p.user_id
+		JOIN physicians ph ON ph.id = a.physician_id
+		JOIN users phu ON phu.id = ph.user_id
+		LEFT JOIN clinic_informations ci ON ci.clinic_id = ph.clinic_id
+		WHERE a.id = ?`, appointmentId).
+		Scan(&contact)
+	if result.Error != nil {
+		return nil, fmt.Errorf("error al obtener los datos de contacto: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &contact, nil
+}
+
+func (r *repository) CreateActionToken(token *AppointmentActionToken) error {
+	if err := r.db.Create(token).Error; err != nil {
+		return fmt.Errorf("error al crear el enlace de la cita: %w", err)
+	}
+	return nil
+}
 
+// GetActionToken returns the unexpired token with the hash, or
+// ErrInvalidActionLink.
+func (r *repository) GetActionToken(tokenHash string, now time.Time) (*AppointmentActionToken, error) {
+	var token AppointmentActionToken
+	err := r.db.Where("token_hash = ? AND expires_at > ?", tokenHash, now).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidActionLink
+	}
 	if err != nil {
-		return fmt.Errorf("error al actualizar el estado de la cita médica: %w", err)
+		return nil, err
 	}
+	return &token, nil
+}
 
+func (r *repository) ConfirmAttendance(appointmentId string, at time.Time) error {
+	result := r.db.Model(&MedicalAppointment{}).
+		Where("id = ? AND status = ?", appointmentId, string(AppointmentStatusConfirmed)).
+		Update("attendance_confirmed_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("error al confirmar la asistencia: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
 	return nil
 }
 
-func (r *repository) CancelAppointment(appointmentId string) error {
-	err := r.db.Model(&MedicalAppointment{}).
-		Where("id = ?", appointmentId).
-		Update("status", AppointmentStatusCancelled).Error
+// GetPhysicianTimezones returns the time zone of each physician's clinic. It is
+// empty for physicians without a clinic or clinics without a zone.
+func (r *repository) GetPhysicianTimezones(physicianIds []string) (map[string]string, error) {
+	var rows []struct {
+		PhysicianID string
+		Timezone    string
+	}
+	err := r.db.Raw(`
+		SELECT ph.id AS physician_id, COALESCE(ci.timezone, '') AS timezone
+		FROM physicians ph
+		LEFT JOIN clinic_informations ci ON ci.clinic_id = ph.clinic_id
+		WHERE ph.id IN ?`, physicianIds).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener la zona horaria de la clínica: %w", err)
+	}
 
+	zones := make(map[string]string, len(rows))
+	for _, row := range rows {
+		zones[row.PhysicianID] = row.Timezone
+	}
+	return zones, nil
+}
+
+func (r *repository) GetPhysicianIdByUserId(userId string) (string, error) {
+	var physician users.Physician
+	if err := r.db.Select("id").Where("user_id = ?", userId).First(&physician).Error; err != nil {
+		return "", err
+	}
+	return physician.ID, nil
+}
+
+func (r *repository) GetPatientIdByUserId(userId string) (string, error) {
+	var patient users.Patient
+	if err := r.db.Select("id").Where("user_id = ?", userId).First(&patient).Error; err != nil {
+		return "", err
+	}
+	return patient.ID, nil
+}
+
+// GetPhysicianClinicId returns the physician's clinic, or "" when they have
+// none.
+func (r *repository) GetPhysicianClinicId(physicianId string) (string, error) {
+	var physician users.Physician
+	if err := r.db.Select("id", "clinic_id").Where("id = ?", physicianId).First(&physician).Error; err != nil {
+		return "", err
+	}
+	if physician.ClinicID == nil {
+		return "", nil
+	}
+	return *physician.ClinicID, nil
+}
+
+// GetBookablePhysicianClinicId returns the clinic of an active physician who
+// works at one, and gorm.ErrRecordNotFound for anyone else.
+func (r *repository) GetBookablePhysicianClinicId(physicianId string) (string, error) {
+	var physician users.Physician
+	err := r.db.Select("id", "clinic_id").
+		Where("id = ? AND status = ? AND clinic_id IS NOT NULL AND clinic_id <> ''", physicianId, true).
+		First(&physician).Error
 	if err != nil {
-		return fmt.Errorf("error al cancelar la cita médica: %w", err)
+		return "", err
+	}
+	return *physician.ClinicID, nil
+}
+
+// GetClinicPhysicians returns the clinic's active physicians, by name.
+func (r *repository) GetClinicPhysicians(clinicId string) ([]PortalPhysician, error) {
+	physicians := []PortalPhysician{}
+	err := r.db.Table("physicians ph").
+		Select("ph.id, u.name, u.gender, ph.physician_specialty").
+		Joins("JOIN users u ON u.id = ph.user_id AND u.deleted_at IS NULL").
+		Where("ph.clinic_id = ? AND ph.status = ? AND u.status = ? AND ph.deleted_at IS NULL", clinicId, true, true).
+		Order("u.name").
+		Scan(&physicians).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener los médicos de la clínica: %w", err)
+	}
+	return physicians, nil
+}
+
+func (r *repository) GetBookingPolicy(clinicId string) (*BookingPolicy, error) {
+	var policy BookingPolicy
+	if err := r.db.Where("clinic_id = ?", clinicId).First(&policy).Error; err != nil {
+		return nil, err
 	}
+	return &policy, nil
+}
 
+func (r *repository) SaveBookingPolicy(policy *BookingPolicy) error {
+	err := r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "clinic_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"min_notice_minutes", "max_active_bookings", "cancellation_cutoff_minutes",
+			"no_show_action", "no_show_threshold", "no_show_window_months", "updated_at",
+		}),
+	}).Create(policy).Error
+	if err != nil {
+		return fmt.Errorf("error al guardar la política de reservas: %w", err)
+	}
 	return nil
 }
 
-func (r *repository) GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNamesDTO, error) {
-	var appointments []MedicalAppointment
+// countActiveAppointments counts the patient's upcoming appointments at the
+// clinic that are still going to happen.
+func countActiveAppointments(tx *gorm.DB, patientId, clinicId string, now time.Time) (int64, error) {
+	var count int64
+	err := tx.Model(&MedicalAppointment{}).
+		Joins("JOIN physicians ON physicians.id = medical_appointments.physician_id").
+		Where("medical_appointments.patient_id = ? AND physicians.clinic_id = ?", patientId, clinicId).
+		Where("medical_appointments.status IN ?", []AppointmentStatus{AppointmentStatusPending, AppointmentStatusConfirmed, AppointmentStatusRescheduled}).
+		Where("medical_appointments.date_time > ?", now).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("error al contar las citas activas: %w", err)
+	}
+	return count, nil
+}
 
-	// Buscar todas las citas donde el usuario es paciente o médico usando joins
-	err := r.db.Joins("JOIN patients ON medical_appointments.patient_id = patients.id").
-		Joins("JOIN physicians ON medical_appointments.physician_id = physicians.id").
-		Where("patients.user_id = ? OR physicians.user_id = ?", userId, userId).
-		Preload("Patient").
-		Preload("Physician").
+// GetMissedAppointments returns confirmed appointments that ended before
+// endedBefore, oldest first.
+func (r *repository) GetMissedAppointments(endedBefore time.Time, limit int) ([]MedicalAppointment, error) {
+	var appointments []MedicalAppointment
+	err := r.db.Where("status = ? AND end_time < ?", AppointmentStatusConfirmed, endedBefore).
+		Order("end_time").
+		Limit(limit).
 		Find(&appointments).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las citas sin asistencia: %w", err)
+	}
+	return appointments, nil
+}
+
+// attendanceSelect counts completed and missed appointments.
+const attendanceSelect = `COUNT(*) FILTER (WHERE ma.status = 'completed') AS completed,
+	COUNT(*) FILTER (WHERE ma.status = 'no_show') AS no_shows`
+
+// GetPatientAttendance counts the patient's completed and missed appointments
+// since since, at clinicId or, when it is empty, anywhere.
+func (r *repository) GetPatientAttendance(patientId, clinicId string, since time.Time) (*AttendanceCounts, error) {
+	query := r.db.Table("medical_appointments ma").
+		Select(attendanceSelect).
+		Where("ma.deleted_at IS NULL AND ma.patient_id = ?", patientId)
+	if clinicId != "" {
+		query = query.Joins("JOIN physicians ph ON ph.id = ma.physician_id").Where("ph.clinic_id = ?", clinicId)
+	}
+	if !since.IsZero() {
+		query = query.Where("ma.date_time >= ?", since)
+	}
+
+	var counts AttendanceCounts
+	if err := query.Scan(&counts).Error; err != nil {
+		return nil, fmt.Errorf("error al obtener la asistencia del paciente: %w", err)
+	}
+	return &counts, nil
+}
 
+func (r *repository) GetNoShowsByPhysician(clinicId string, from, to time.Time) ([]NoShowRate, error) {
+	rows := []NoShowRate{}
+	err := r.db.Table("medical_appointments ma").
+		Select("ph.id AS key, COALESCE(u.name, '') AS label, "+attendanceSelect).
+		Joins("JOIN physicians ph ON ph.id = ma.physician_id").
+		Joins("LEFT JOIN users u ON u.id = ph.user_id").
+		Where("ma.deleted_at IS NULL AND ph.clinic_id = ? AND ma.date_time >= ? AND ma.date_time < ?", clinicId, from, to).
+		Group("ph.id, u.name").
+		Order("u.name").
+		Scan(&rows).Error
 	if err != nil {
-		return nil, fmt.Errorf("error al obtener las citas médicas: %w", err)
+		return nil, fmt.Errorf("error al obtener las inasistencias por médico: %w", err)
 	}
+	return rows, nil
+}
 
-	var result []AppointmentWithNamesDTO
-	for _, appt := range appointments {
-		var patientUser users.User
-		var physicianUser users.User
-		var clinic clinical.ClinicInformation
+// GetNoShowsByWeekday groups the clinic's attendance by the weekday, in the
+// clinic's time zone, the appointments fell on; 0 is Sunday.
+func (r *repository) GetNoShowsByWeekday(clinicId string, from, to time.Time) (map[int]AttendanceCounts, error) {
+	var rows []struct {
+		Weekday int
+		AttendanceCounts
+	}
+	err := r.db.Table("medical_appointments ma").
+		Select("EXTRACT(DOW FROM ma.date_time AT TIME ZONE COALESCE(ci.timezone, ?))::int AS weekday, "+attendanceSelect, clinical.DefaultTimezone).
+		Joins("JOIN physicians ph ON ph.id = ma.physician_id").
+		Joins("LEFT JOIN clinic_informations ci ON ci.clinic_id = ph.clinic_id").
+		Where("ma.deleted_at IS NULL AND ph.clinic_id = ? AND ma.date_time >= ? AND ma.date_time < ?", clinicId, from, to).
+		Group("weekday").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las inasistencias por día: %w", err)
+	}
 
-		// Obtener datos del paciente
-		if err := r.db.Model(&users.User{}).Where("id = ?", appt.Patient.UserID).First(&patientUser).Error; err != nil {
-			continue
+	byWeekday := make(map[int]AttendanceCounts, len(rows))
+	for _, row := range rows {
+		byWeekday[row.Weekday] = row.AttendanceCounts
+	}
+	return byWeekday, nil
+}
+
+func (r *repository) GetAppointmentWithNames(appointmentId string) (*AppointmentWithNamesDTO, error) {
+	appointments, err := r.scanWithNames(r.withNames().Where("ma.id = ?", appointmentId))
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener la cita médica: %w", err)
+	}
+	if len(appointments) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &appointments[0], nil
+}
+
+// GetAppointmentConsultation returns the consultation that fulfilled the
+// appointment, with its prescriptions.
+func (r *repository) GetAppointmentConsultation(appointmentId string) (*clinical.MedicalConsultation, error) {
+	var consultation clinical.MedicalConsultation
+	err := r.db.Preload("Prescriptions").
+		Where("appointment_id = ?", appointmentId).
+		First(&consultation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &consultation, nil
+}
+
+// CompleteWithConsultation completes the appointment a new consultation
+// fulfils. It runs in the transaction creating the consultation and locks the
+// appointment, so it is completed by one consultation only.
+func (r *repository) CompleteWithConsultation(tx *gorm.DB, link clinical.ConsultationLink) error {
+	var appointment MedicalAppointment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", link.AppointmentID).
+		First(&appointment).Error
+	if err != nil {
+		return err
+	}
+	if appointment.PatientId != link.PatientID || appointment.PhysicianId != link.PhysicianID {
+		return fmt.Errorf("%w: the appointment is for another patient or physician", clinical.ErrAppointmentLink)
+	}
+
+	current := AppointmentStatus(appointment.Status)
+	if err := checkTransition(current, AppointmentStatusCompleted); err != nil {
+		return fmt.Errorf("%w: %v", clinical.ErrAppointmentLink, err)
+	}
+	return applyStatusChange(tx, StatusChange{
+		AppointmentID: appointment.ID,
+		From:          current,
+		To:            AppointmentStatusCompleted,
+		ActorUserID:   link.ActorUserID,
+		Reason:        "Completada con la consulta " + link.ConsultationID,
+	})
+}
+
+// CreateCheckIn records the arrival and moves the appointment to checked_in
+// in one transaction.
+func (r *repository) CreateCheckIn(checkIn *AppointmentCheckIn, change StatusChange) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := applyStatusChange(tx, change); err != nil {
+			return err
 		}
+		return tx.Create(checkIn).Error
+	})
+	if err == nil || errors.Is(err, ErrStatusChanged) {
+		return err
+	}
+	return fmt.Errorf("error al registrar la llegada del paciente: %w", err)
+}
 
-		// Obtener datos del médico
-		if err := r.db.Model(&users.User{}).Where("id = ?", appt.Physician.UserID).First(&physicianUser).Error; err != nil {
-			continue
+// UpdateTriagePriority changes the priority of a check-in whose appointment is
+// still waiting to be called.
+func (r *repository) UpdateTriagePriority(appointmentId string, priority TriagePriority) (*AppointmentCheckIn, error) {
+	var checkIn AppointmentCheckIn
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&AppointmentCheckIn{}).
+			Where("appointment_id = ? AND appointment_id IN (?)", appointmentId,
+				tx.Model(&MedicalAppointment{}).Select("id").Where("status = ?", string(AppointmentStatusCheckedIn))).
+			Update("priority", priority)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotCheckedIn
+		}
+		return tx.Where("appointment_id = ?", appointmentId).First(&checkIn).Error
+	})
+	if errors.Is(err, ErrNotCheckedIn) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error al actualizar la prioridad del paciente: %w", err)
+	}
+	return &checkIn, nil
+}
+
+// CallCheckIn moves a waiting appointment to in_progress and records when the
+// patient was called, in one transaction.
+func (r *repository) CallCheckIn(change StatusChange, at time.Time) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := applyStatusChange(tx, change); err != nil {
+			return err
 		}
+		return tx.Model(&AppointmentCheckIn{}).
+			Where("appointment_id = ?", change.AppointmentID).
+			Updates(map[string]interface{}{"called_at": at, "called_by": change.ActorUserID}).Error
+	})
+	if err == nil || errors.Is(err, ErrStatusChanged) {
+		return err
+	}
+	return fmt.Errorf("error al llamar al paciente: %w", err)
+}
 
-		// Obtener datos de la clínica
-		_ = r.db.Model(&clinical.ClinicInformation{}).Where("clinic_id = ?", appt.Physician.ClinicID).First(&clinic)
+// queueEntries selects check-ins with their appointment and patient.
+func (r *repository) queueEntries() *gorm.DB {
+	return r.db.Table("appointment_check_ins ci").
+		Select(`ci.appointment_id, ma.patient_id, COALESCE(u.name, '') AS patient_name, ci.physician_id,
+			ma.status, ma.date_time, ci.priority, ci.arrived_at, ci.called_at`).
+		Joins("JOIN medical_appointments ma ON ma.id = ci.appointment_id AND ma.deleted_at IS NULL").
+		Joins("LEFT JOIN patients p ON p.id = ma.patient_id").
+		Joins("LEFT JOIN users u ON u.id = p.user_id")
+}
 
-		result = append(result, AppointmentWithNamesDTO{
-			MedicalAppointment: appt,
-			PatientName:        patientUser.Name,
-			PatientGender:      patientUser.Gender,
-			PatientEmail:       patientUser.Email,
-			PatientPhone:       patientUser.Phone,
-			PhysicianName:      physicianUser.Name,
-			PhysicianGender:    physicianUser.Gender,
-			PhysicianEmail:     physicianUser.Email,
-			PhysicianPhone:     physicianUser.Phone,
-			ClinicName:         clinic.ClinicName,
-			ClinicCity:         clinic.City,
-			ClinicAddress:      clinic.Address,
-			ClinicId:           clinic.ClinicID,
-		})
+// GetPhysicianQueue returns the physician's appointments that were checked in
+// since since and are waiting or being seen.
+func (r *repository) GetPhysicianQueue(physicianId string, since time.Time) ([]QueueEntry, error) {
+	entries := []QueueEntry{}
+	err := r.queueEntries().
+		Where("ci.physician_id = ? AND ci.arrived_at >= ?", physicianId, since).
+		Where("ma.status IN ?", []AppointmentStatus{AppointmentStatusCheckedIn, AppointmentStatusInProgress}).
+		Order("ci.arrived_at").
+		Scan(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener la sala de espera: %w", err)
 	}
+	return entries, nil
+}
 
-	return result, nil
+// GetClinicCheckIns returns every check-in at the clinic in [from, to),
+// whatever became of the appointment.
+func (r *repository) GetClinicCheckIns(clinicId string, from, to time.Time) ([]QueueEntry, error) {
+	entries := []QueueEntry{}
+	err := r.queueEntries().
+		Where("ci.clinic_id = ? AND ci.arrived_at >= ? AND ci.arrived_at < ?", clinicId, from, to).
+		Order("ci.arrived_at").
+		Scan(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las llegadas de la clínica: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *repository) CreateCalendarFeed(feed *CalendarFeed) error {
+	if err := r.db.Create(feed).Error; err != nil {
+		return fmt.Errorf("error al crear el calendario: %w", err)
+	}
+	return nil
+}
+
+// GetCalendarFeeds returns the user's feeds that have not been revoked.
+func (r *repository) GetCalendarFeeds(userId string) ([]CalendarFeed, error) {
+	feeds := []CalendarFeed{}
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userId).
+		Order("created_at").
+		Find(&feeds).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener los calendarios: %w", err)
+	}
+	return feeds, nil
+}
+
+func (r *repository) GetCalendarFeedByToken(tokenHash string) (*CalendarFeed, error) {
+	var feed CalendarFeed
+	if err := r.db.Where("token_hash = ? AND revoked_at IS NULL", tokenHash).First(&feed).Error; err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+func (r *repository) RevokeCalendarFeed(userId, feedId string, at time.Time) error {
+	result := r.db.Model(&CalendarFeed{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", feedId, userId).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("error al revocar el calendario: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) TouchCalendarFeed(feedId string, at time.Time) error {
+	return r.db.Model(&CalendarFeed{}).Where("id = ?", feedId).Update("last_accessed_at", at).Error
 }
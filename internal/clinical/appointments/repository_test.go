@@ -1,6 +1,8 @@
 package appointments
 
 import (
+	"Altheia-Backend/internal/clinical"
+	"errors"
 	"testing"
 	"time"
 
@@ -32,6 +34,9 @@ func TestRepository_CreateAppointment(t *testing.T) {
 	db, mock := setupTestDB(t)
 	repo := NewRepository(db)
 
+	// 14:30 in Bogotá (UTC-5) is stored as 19:30 UTC.
+	endTime := time.Date(2024, 3, 20, 20, 0, 0, 0, time.UTC)
+
 	tests := []struct {
 		name        string
 		appointment CreateAppointmentDTO
@@ -74,27 +79,48 @@ func TestRepository_CreateAppointment(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			// The date is read in the time zone of the physician's clinic.
+			mock.ExpectQuery(`SELECT ph.id AS physician_id, COALESCE\(ci.timezone, ''\) AS timezone .+ WHERE ph.id IN \(\$1\)`).
+				WithArgs(tt.appointment.PhysicianId).
+				WillReturnRows(sqlmock.NewRows([]string{"physician_id", "timezone"}).
+					AddRow(tt.appointment.PhysicianId, "America/Bogota"))
 			if !tt.wantErr {
+				mock.ExpectQuery(`SELECT \* FROM "patients" WHERE id = \$1`).
+					WithArgs(tt.appointment.PatientId, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(tt.appointment.PatientId, "user-1"))
+				mock.ExpectQuery(`SELECT \* FROM "physicians" WHERE id = \$1`).
+					WithArgs(tt.appointment.PhysicianId, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(tt.appointment.PhysicianId, "user-2"))
 				mock.ExpectBegin()
-				mock.ExpectExec(`INSERT INTO "medical_appointments" ("id","patient_id","physician_id","date_time","status","reason") VALUES ($1,$2,$3,$4,$5,$6)`).
+				mock.ExpectExec(`INSERT INTO "patients"`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`INSERT INTO "physicians"`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`INSERT INTO "medical_appointments"`).
 					WithArgs(
 						sqlmock.AnyArg(), // ID
 						tt.appointment.PatientId,
 						tt.appointment.PhysicianId,
-						sqlmock.AnyArg(), // DateTime
+						time.Date(2024, 3, 20, 19, 30, 0, 0, time.UTC),
+						endTime,
 						string(AppointmentStatusPending),
 						tt.appointment.Reason,
+						nil,              // SeriesID
+						nil,              // AttendanceConfirmedAt
+						sqlmock.AnyArg(), // CreatedAt
+						sqlmock.AnyArg(), // UpdatedAt
+						nil,              // DeletedAt
 					).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			}
 
-			err := repo.CreateAppointment(tt.appointment)
+			_, err := repo.CreateAppointment(tt.appointment, endTime)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("CreateAppointment() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
-			if err := mock.ExpectationsWereMet(); err != nil && !tt.wantErr {
+			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("Unfulfilled expectations: %v", err)
 			}
 		})
@@ -134,6 +160,16 @@ func TestRepository_GetAllAppointments(t *testing.T) {
 
 	mock.ExpectQuery("SELECT \\* FROM \"medical_appointments\" WHERE \"medical_appointments\".\"deleted_at\" IS NULL").
 		WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT \* FROM "patients" WHERE "patients"."id" IN \(\$1,\$2\)`).
+		WithArgs("patient-1", "patient-2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).
+			AddRow("patient-1", "user-1").
+			AddRow("patient-2", "user-2"))
+	mock.ExpectQuery(`SELECT \* FROM "physicians" WHERE "physicians"."id" IN \(\$1,\$2\)`).
+		WithArgs("physician-1", "physician-2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).
+			AddRow("physician-1", "user-3").
+			AddRow("physician-2", "user-4"))
 
 	appointments, err := repo.GetAllAppointments()
 	if err != nil {
@@ -175,26 +211,36 @@ func TestRepository_UpdateAppointmentStatus(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
 			if !tt.wantErr {
-				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE medical_appointments").
-					WithArgs(string(tt.status), sqlmock.AnyArg(), tt.appointmentId).
+				mock.ExpectExec(`UPDATE "medical_appointments" SET "status"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND status = \$4\)`).
+					WithArgs(string(tt.status), sqlmock.AnyArg(), tt.appointmentId, string(AppointmentStatusPending)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO "appointment_status_history"`).
+					WithArgs(sqlmock.AnyArg(), tt.appointmentId, string(AppointmentStatusPending), string(tt.status), "", "", sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			} else {
-				mock.ExpectBegin()
-				mock.ExpectExec("UPDATE medical_appointments").
-					WithArgs(string(tt.status), sqlmock.AnyArg(), tt.appointmentId).
+				// The guarded update finds no pending appointment to change.
+				mock.ExpectExec(`UPDATE "medical_appointments" SET "status"=\$1,"updated_at"=\$2 WHERE \(id = \$3 AND status = \$4\)`).
+					WithArgs(string(tt.status), sqlmock.AnyArg(), tt.appointmentId, string(AppointmentStatusPending)).
 					WillReturnResult(sqlmock.NewResult(0, 0))
-				mock.ExpectCommit()
+				mock.ExpectRollback()
 			}
 
-			err := repo.UpdateAppointmentStatus(tt.appointmentId, tt.status)
+			err := repo.UpdateAppointmentStatus(StatusChange{
+				AppointmentID: tt.appointmentId,
+				From:          AppointmentStatusPending,
+				To:            tt.status,
+			})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("UpdateAppointmentStatus() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantErr && !errors.Is(err, ErrStatusChanged) {
+				t.Errorf("UpdateAppointmentStatus() error = %v, want %v", err, ErrStatusChanged)
+			}
 
-			if err := mock.ExpectationsWereMet(); err != nil && !tt.wantErr {
+			if err := mock.ExpectationsWereMet(); err != nil {
 				t.Errorf("Unfulfilled expectations: %v", err)
 			}
 		})
@@ -221,30 +267,17 @@ func TestRepository_GetAllAppointmentsByMedicId(t *testing.T) {
 		},
 	}
 
-	// Mock the main appointments query
-	rows := sqlmock.NewRows([]string{"id", "patient_id", "physician_id", "date_time", "status", "reason", "created_at", "updated_at", "deleted_at"}).
+	// The appointments are read with their names in a single joined query
+	rows := sqlmock.NewRows([]string{"id", "patient_id", "physician_id", "date_time", "status", "reason", "created_at", "updated_at", "deleted_at",
+		"patient_user_id", "physician_user_id", "patient_name", "physician_name"}).
 		AddRow(expectedAppointments[0].ID, expectedAppointments[0].PatientId, expectedAppointments[0].PhysicianId,
 			expectedAppointments[0].DateTime, expectedAppointments[0].Status, expectedAppointments[0].Reason,
-			time.Now(), time.Now(), nil)
+			time.Now(), time.Now(), nil, "user-1", "user-2", expectedAppointments[0].PatientName, expectedAppointments[0].PhysicianName)
 
-	mock.ExpectQuery("SELECT \\* FROM \"medical_appointments\" WHERE physician_id = \\$1 AND \"medical_appointments\".\"deleted_at\" IS NULL").
+	mock.ExpectQuery("(?s)SELECT ma\\.\\*,.+FROM medical_appointments AS ma JOIN patients p .+ WHERE ma\\.deleted_at IS NULL AND ma\\.physician_id = \\$1 ORDER BY ma\\.date_time, ma\\.id").
 		WithArgs(physicianId).
 		WillReturnRows(rows)
 
-	// Mock the patient user query
-	patientRows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow("user-1", expectedAppointments[0].PatientName)
-	mock.ExpectQuery("SELECT \\* FROM \"users\" WHERE \"users\".\"deleted_at\" IS NULL AND \"users\".\"id\" = \\$1").
-		WithArgs(expectedAppointments[0].PatientId).
-		WillReturnRows(patientRows)
-
-	// Mock the physician user query
-	physicianRows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow("user-2", expectedAppointments[0].PhysicianName)
-	mock.ExpectQuery("SELECT \\* FROM \"users\" WHERE \"users\".\"deleted_at\" IS NULL AND \"users\".\"id\" = \\$1").
-		WithArgs(expectedAppointments[0].PhysicianId).
-		WillReturnRows(physicianRows)
-
 	appointments, err := repo.GetAllAppointmentsByMedicId(physicianId)
 	if err != nil {
 		t.Errorf("GetAllAppointmentsByMedicId() error = %v", err)
@@ -259,7 +292,131 @@ func TestRepository_GetAllAppointmentsByMedicId(t *testing.T) {
 			appointments[0].PatientName, expectedAppointments[0].PatientName)
 	}
 
+	if len(appointments) > 0 && appointments[0].Patient.UserID != "user-1" {
+		t.Errorf("GetAllAppointmentsByMedicId() got patient user %q, want user-1", appointments[0].Patient.UserID)
+	}
+
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unfulfilled expectations: %v", err)
 	}
 }
+
+func TestRepository_CompleteWithConsultation_Rejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		patientId string
+		status    AppointmentStatus
+	}{
+		{name: "Another Patient", patientId: "patient-2", status: AppointmentStatusConfirmed},
+		{name: "Already Cancelled", patientId: "patient-1", status: AppointmentStatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := setupTestDB(t)
+			repo := NewRepository(db)
+
+			rows := sqlmock.NewRows([]string{"id", "patient_id", "physician_id", "date_time", "status"}).
+				AddRow("appt-1", tt.patientId, "physician-1", time.Now(), string(tt.status))
+			mock.ExpectQuery("SELECT \\* FROM \"medical_appointments\" WHERE id = \\$1 .+ FOR UPDATE").
+				WithArgs("appt-1", 1).
+				WillReturnRows(rows)
+
+			err := repo.CompleteWithConsultation(db, clinical.ConsultationLink{
+				AppointmentID:  "appt-1",
+				ConsultationID: "consultation-1",
+				PatientID:      "patient-1",
+				PhysicianID:    "physician-1",
+			})
+			if !errors.Is(err, clinical.ErrAppointmentLink) {
+				t.Errorf("CompleteWithConsultation() error = %v, want ErrAppointmentLink", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestRepository_CreateOwnAppointment(t *testing.T) {
+	policy := &BookingPolicy{ClinicID: "clinic-1", MaxActiveBookings: 2}
+	booking := CreateAppointmentDTO{
+		PatientId:   "patient-1",
+		PhysicianId: "physician-1",
+		Date:        "2030-03-20",
+		Time:        "09:00",
+		Reason:      "Control",
+	}
+	now := time.Date(2030, 3, 1, 12, 0, 0, 0, time.UTC)
+	endTime := time.Date(2030, 3, 20, 14, 30, 0, 0, time.UTC)
+
+	lockAndCount := func(mock sqlmock.Sqlmock, active int) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT "id" FROM "patients" WHERE id = \$1 .+ FOR UPDATE`).
+			WithArgs(booking.PatientId, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(booking.PatientId))
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "medical_appointments" JOIN physicians .+ WHERE \(medical_appointments.patient_id = \$1 AND physicians.clinic_id = \$2\)`).
+			WithArgs(booking.PatientId, policy.ClinicID, AppointmentStatusPending, AppointmentStatusConfirmed, AppointmentStatusRescheduled, now).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(active))
+	}
+
+	t.Run("the limit is checked with the patient locked", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		repo := NewRepository(db)
+
+		lockAndCount(mock, 2)
+		mock.ExpectRollback()
+
+		if _, err := repo.CreateOwnAppointment(booking, endTime, policy, now, nil); !errors.Is(err, ErrBookingPolicy) {
+			t.Errorf("CreateOwnAppointment() error = %v, want %v", err, ErrBookingPolicy)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("a confirmed booking is stored with its history in one transaction", func(t *testing.T) {
+		db, mock := setupTestDB(t)
+		repo := NewRepository(db)
+
+		lockAndCount(mock, 1)
+		mock.ExpectQuery(`SELECT ph.id AS physician_id, COALESCE\(ci.timezone, ''\) AS timezone`).
+			WithArgs(booking.PhysicianId).
+			WillReturnRows(sqlmock.NewRows([]string{"physician_id", "timezone"}).AddRow(booking.PhysicianId, "America/Bogota"))
+		mock.ExpectQuery(`SELECT \* FROM "patients" WHERE id = \$1`).
+			WithArgs(booking.PatientId, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(booking.PatientId, "user-1"))
+		mock.ExpectQuery(`SELECT \* FROM "physicians" WHERE id = \$1`).
+			WithArgs(booking.PhysicianId, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(booking.PhysicianId, "user-2"))
+		mock.ExpectExec(`INSERT INTO "patients"`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO "physicians"`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO "medical_appointments"`).
+			WithArgs(sqlmock.AnyArg(), booking.PatientId, booking.PhysicianId,
+				time.Date(2030, 3, 20, 14, 0, 0, 0, time.UTC), endTime, string(AppointmentStatusConfirmed), booking.Reason,
+				nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO "appointment_status_history"`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), string(AppointmentStatusPending), string(AppointmentStatusConfirmed), "user-1", "Reservada por el paciente", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		appointment, err := repo.CreateOwnAppointment(booking, endTime, policy, now, &StatusChange{
+			From:        AppointmentStatusPending,
+			To:          AppointmentStatusConfirmed,
+			ActorUserID: "user-1",
+			Reason:      "Reservada por el paciente",
+		})
+		if err != nil {
+			t.Fatalf("CreateOwnAppointment() error = %v", err)
+		}
+		if appointment.Status != string(AppointmentStatusConfirmed) {
+			t.Errorf("status = %s, want %s", appointment.Status, AppointmentStatusConfirmed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled expectations: %v", err)
+		}
+	})
+}
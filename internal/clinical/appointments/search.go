@@ -0,0 +1,179 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSearch = errors.New("invalid appointment search")
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 100
+)
+
+// AppointmentSort orders search results. A leading "-" sorts descending; ties
+// are broken by the appointment id so pages never overlap.
+type AppointmentSort string
+
+const (
+	SortDateTimeAsc   AppointmentSort = "date_time"
+	SortDateTimeDesc  AppointmentSort = "-date_time"
+	SortCreatedAtAsc  AppointmentSort = "created_at"
+	SortCreatedAtDesc AppointmentSort = "-created_at"
+)
+
+func (s AppointmentSort) column() string {
+	return "ma." + strings.TrimPrefix(string(s), "-")
+}
+
+func (s AppointmentSort) descending() bool {
+	return strings.HasPrefix(string(s), "-")
+}
+
+// AppointmentSearch filters appointments. Empty fields do not filter; From is
+// inclusive and To exclusive, and Reason matches any part of the reason,
+// ignoring case.
+type AppointmentSearch struct {
+	ClinicID    string
+	PhysicianID string
+	PatientID   string
+	// PatientUserID limits the results to the appointments of the patient with
+	// this user account.
+	PatientUserID string
+	Statuses      []AppointmentStatus
+	From          time.Time
+	To            time.Time
+	Reason        string
+	Sort          AppointmentSort
+	Limit         int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+
+	after *searchCursor
+}
+
+// AppointmentPage is one page of search results. NextCursor is empty on the
+// last page.
+type AppointmentPage struct {
+	Items      []AppointmentWithNamesDTO `json:"items"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+// searchCursor points after the last appointment of a page: its sort value and
+// id. It records the sort so a cursor cannot be replayed under another order.
+type searchCursor struct {
+	Sort AppointmentSort `json:"s"`
+	At   time.Time       `json:"t"`
+	ID   string          `json:"id"`
+}
+
+func encodeCursor(cursor searchCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidSearch)
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidSearch)
+	}
+	return &cursor, nil
+}
+
+// normalize validates the search and fills in the default sort and limit.
+func (q *AppointmentSearch) normalize() error {
+	switch q.Sort {
+	case "":
+		q.Sort = SortDateTimeAsc
+	case SortDateTimeAsc, SortDateTimeDesc, SortCreatedAtAsc, SortCreatedAtDesc:
+	default:
+		return fmt.Errorf("%w: sort must be one of date_time, -date_time, created_at or -created_at", ErrInvalidSearch)
+	}
+
+	switch {
+	case q.Limit == 0:
+		q.Limit = defaultSearchLimit
+	case q.Limit < 0 || q.Limit > maxSearchLimit:
+		return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidSearch, maxSearchLimit)
+	}
+
+	for _, status := range q.Statuses {
+		if !status.IsValid() {
+			return fmt.Errorf("%w: unknown status %q", ErrInvalidSearch, status)
+		}
+	}
+
+	if !q.From.IsZero() && !q.To.IsZero() && !q.To.After(q.From) {
+		return fmt.Errorf("%w: to must be after from", ErrInvalidSearch)
+	}
+
+	q.after = nil
+	if q.Cursor != "" {
+		cursor, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return err
+		}
+		if cursor.Sort != q.Sort {
+			return fmt.Errorf("%w: the cursor belongs to a search sorted by %s", ErrInvalidSearch, cursor.Sort)
+		}
+		q.after = cursor
+	}
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in value so it matches literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// SearchAppointments returns a page of the appointments matching the search,
+// with the patient, physician and clinic details of each.
+func (s *service) SearchAppointments(q AppointmentSearch) (*AppointmentPage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	// One extra row tells whether there is a next page.
+	items, err := s.repo.SearchAppointments(q, q.Limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AppointmentPage{Items: items}
+	if len(items) > q.Limit {
+		page.Items = items[:q.Limit]
+		last := page.Items[q.Limit-1]
+		at := last.DateTime
+		if q.Sort == SortCreatedAtAsc || q.Sort == SortCreatedAtDesc {
+			at = last.CreatedAt
+		}
+		page.NextCursor = encodeCursor(searchCursor{Sort: q.Sort, At: at, ID: last.ID})
+	}
+	if page.Items == nil {
+		page.Items = []AppointmentWithNamesDTO{}
+	}
+	return page, s.localizeWithNames(page.Items)
+}
+
+// ClinicLocation returns the time zone of the clinic.
+func (s *service) ClinicLocation(clinicId string) (*time.Location, error) {
+	zone, err := s.repo.GetClinicTimezone(clinicId)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := clinical.LoadTimezone(zone)
+	if err != nil {
+		return nil, fmt.Errorf("error al cargar la zona horaria: %w", err)
+	}
+	return loc, nil
+}
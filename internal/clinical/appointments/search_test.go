@@ -0,0 +1,144 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// SearchAppointments filters by physician and status and pages like the
+// keyset query: ordered by the sort column and then id, resuming after the
+// cursor.
+func (r *statusRepo) SearchAppointments(q AppointmentSearch, limit int) ([]AppointmentWithNamesDTO, error) {
+	statuses := map[AppointmentStatus]bool{}
+	for _, status := range q.Statuses {
+		statuses[status] = true
+	}
+	items := r.withNames(func(a *MedicalAppointment) bool {
+		return (q.PhysicianID == "" || a.PhysicianId == q.PhysicianID) &&
+			(len(statuses) == 0 || statuses[AppointmentStatus(a.Status)])
+	})
+
+	key := func(a AppointmentWithNamesDTO) time.Time {
+		if q.Sort == SortCreatedAtAsc || q.Sort == SortCreatedAtDesc {
+			return a.CreatedAt
+		}
+		return a.DateTime
+	}
+	before := func(at time.Time, id string, other AppointmentWithNamesDTO) bool {
+		if !at.Equal(key(other)) {
+			return at.Before(key(other)) != q.Sort.descending()
+		}
+		return id != other.ID && (id < other.ID) != q.Sort.descending()
+	}
+	sort.Slice(items, func(i, j int) bool { return before(key(items[i]), items[i].ID, items[j]) })
+
+	var page []AppointmentWithNamesDTO
+	for _, item := range items {
+		if q.after != nil && !before(q.after.At, q.after.ID, item) {
+			continue
+		}
+		if len(page) < limit {
+			page = append(page, item)
+		}
+	}
+	return page, nil
+}
+
+func TestAppointmentSearchNormalize(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	cursor := encodeCursor(searchCursor{Sort: SortDateTimeAsc, At: now, ID: "a1"})
+
+	tests := []struct {
+		name    string
+		search  AppointmentSearch
+		wantErr bool
+	}{
+		{"defaults", AppointmentSearch{}, false},
+		{"descending with a cursor", AppointmentSearch{Sort: SortDateTimeDesc, Cursor: encodeCursor(searchCursor{Sort: SortDateTimeDesc, At: now, ID: "a1"})}, false},
+		{"unknown sort", AppointmentSearch{Sort: "reason"}, true},
+		{"limit too large", AppointmentSearch{Limit: maxSearchLimit + 1}, true},
+		{"negative limit", AppointmentSearch{Limit: -1}, true},
+		{"unknown status", AppointmentSearch{Statuses: []AppointmentStatus{"done"}}, true},
+		{"empty range", AppointmentSearch{From: now, To: now}, true},
+		{"malformed cursor", AppointmentSearch{Cursor: "not a cursor"}, true},
+		{"cursor of another sort", AppointmentSearch{Sort: SortCreatedAtAsc, Cursor: cursor}, true},
+	}
+	for _, tt := range tests {
+		search := tt.search
+		err := search.normalize()
+		if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, ErrInvalidSearch)) {
+			t.Errorf("%s: normalize() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	search := AppointmentSearch{}
+	_ = search.normalize()
+	if search.Sort != SortDateTimeAsc || search.Limit != defaultSearchLimit {
+		t.Errorf("defaults = %s/%d, want %s/%d", search.Sort, search.Limit, SortDateTimeAsc, defaultSearchLimit)
+	}
+}
+
+func TestSearchAppointmentsPages(t *testing.T) {
+	start := time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC)
+	repo := &statusRepo{appointments: map[string]*MedicalAppointment{}}
+	// Pairs of appointments share a start time, so pages must break ties by id.
+	for i := 0; i < 7; i++ {
+		id := fmt.Sprintf("a%d", i)
+		status := AppointmentStatusConfirmed
+		if i == 3 {
+			status = AppointmentStatusCancelled
+		}
+		repo.appointments[id] = &MedicalAppointment{
+			ID: id, PhysicianId: "ph-1", Status: string(status),
+			DateTime: start.Add(time.Duration(i/2) * time.Hour), CreatedAt: start.Add(-time.Duration(i) * time.Hour),
+		}
+	}
+	s := &service{repo: repo}
+
+	walk := func(search AppointmentSearch) []string {
+		var ids []string
+		for pages := 0; pages < 10; pages++ {
+			page, err := s.SearchAppointments(search)
+			if err != nil {
+				t.Fatalf("SearchAppointments() error = %v", err)
+			}
+			if len(page.Items) > search.Limit {
+				t.Fatalf("page of %d items, want at most %d", len(page.Items), search.Limit)
+			}
+			for _, item := range page.Items {
+				ids = append(ids, item.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			search.Cursor = page.NextCursor
+		}
+		t.Fatalf("search did not end after 10 pages")
+		return nil
+	}
+
+	tests := []struct {
+		search AppointmentSearch
+		want   string
+	}{
+		{AppointmentSearch{Limit: 2}, "[a0 a1 a2 a3 a4 a5 a6]"},
+		{AppointmentSearch{Limit: 3, Sort: SortDateTimeDesc}, "[a6 a5 a4 a3 a2 a1 a0]"},
+		{AppointmentSearch{Limit: 2, Sort: SortCreatedAtAsc}, "[a6 a5 a4 a3 a2 a1 a0]"},
+		{AppointmentSearch{Limit: 2, Statuses: []AppointmentStatus{AppointmentStatusConfirmed}}, "[a0 a1 a2 a4 a5 a6]"},
+		{AppointmentSearch{Limit: 7}, "[a0 a1 a2 a3 a4 a5 a6]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(walk(tt.search)); got != tt.want {
+			t.Errorf("search %+v = %s, want %s", tt.search, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Errorf("escapeLike() = %s", got)
+	}
+}
@@ -0,0 +1,136 @@
+package appointments
+
+import (
+	"fmt"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// CreateSeries books every occurrence of the recurrence in the DTO, or none of
+// them when any occurrence is not a free slot of the physician.
+func (s *service) CreateSeries(dto CreateAppointmentDTO) (*SeriesDTO, error) {
+	if dto.Recurrence == nil {
+		return nil, fmt.Errorf("%w: recurrence is required", ErrInvalidRecurrence)
+	}
+
+	loc, err := physicianLocation(s.repo, dto.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := dto.dateTime(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	starts, err := dto.Recurrence.occurrences(start, loc)
+	if err != nil {
+		return nil, err
+	}
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("%w: the series has no occurrences", ErrInvalidRecurrence)
+	}
+
+	slots, unavailable, err := s.freeSlots(dto.PhysicianId, starts)
+	if err != nil {
+		return nil, err
+	}
+	if len(unavailable) > 0 {
+		return nil, &SeriesUnavailableError{Unavailable: unavailable}
+	}
+
+	seriesId, err := gonanoid.Nanoid()
+	if err != nil {
+		return nil, err
+	}
+
+	series := &AppointmentSeries{
+		ID:          seriesId,
+		PatientId:   dto.PatientId,
+		PhysicianId: dto.PhysicianId,
+		Frequency:   string(dto.Recurrence.frequency()),
+		Interval:    max(dto.Recurrence.Interval, 1),
+		Count:       dto.Recurrence.Count,
+		ByWeekday:   dto.Recurrence.byWeekday(),
+		Reason:      dto.Reason,
+	}
+	if dto.Recurrence.Until != "" {
+		until, _ := time.ParseInLocation("2006-01-02", dto.Recurrence.Until, loc)
+		series.Until = &until
+	}
+
+	appointments := make([]MedicalAppointment, 0, len(slots))
+	for _, slot := range slots {
+		id, err := gonanoid.Nanoid()
+		if err != nil {
+			return nil, err
+		}
+		appointments = append(appointments, MedicalAppointment{
+			ID:          id,
+			PatientId:   dto.PatientId,
+			PhysicianId: dto.PhysicianId,
+			DateTime:    slot.Start.UTC(),
+			EndTime:     slot.End.UTC(),
+			Status:      string(AppointmentStatusPending),
+			Reason:      dto.Reason,
+			SeriesID:    &series.ID,
+		})
+	}
+
+	if err := s.repo.CreateSeries(series, appointments); err != nil {
+		return nil, err
+	}
+	return &SeriesDTO{Series: *series, Appointments: appointments}, s.localizeAll(appointments)
+}
+
+// GetSeries returns the series the appointment belongs to.
+func (s *service) GetSeries(appointmentId string) (*SeriesDTO, error) {
+	appointment, err := s.repo.GetAppointmentByID(appointmentId)
+	if err != nil {
+		return nil, err
+	}
+	if appointment.SeriesID == nil {
+		return nil, ErrNotInSeries
+	}
+
+	series, err := s.repo.GetSeries(*appointment.SeriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	appointments, err := s.repo.GetSeriesAppointments(series.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SeriesDTO{Series: *series, Appointments: appointments}, s.localizeAll(appointments)
+}
+
+// scopedAppointments returns the appointments an edit of appointment applies
+// to. Beyond the appointment itself, occurrences that already reached a final
+// status are left alone.
+func (s *service) scopedAppointments(appointment *MedicalAppointment, scope SeriesScope) ([]MedicalAppointment, error) {
+	if scope == "" || scope == ScopeThisOccurrence || appointment.SeriesID == nil {
+		return []MedicalAppointment{*appointment}, nil
+	}
+
+	occurrences, err := s.repo.GetSeriesAppointments(*appointment.SeriesID)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []MedicalAppointment{*appointment}
+	for _, occurrence := range occurrences {
+		if occurrence.ID == appointment.ID {
+			continue
+		}
+		if scope == ScopeThisAndFollow && occurrence.DateTime.Before(appointment.DateTime) {
+			continue
+		}
+		if len(statusTransitions[AppointmentStatus(occurrence.Status)]) == 0 {
+			continue
+		}
+		targets = append(targets, occurrence)
+	}
+	return targets, nil
+}
@@ -1,54 +1,337 @@
 package appointments
 
-import "time"
+import (
+	"Altheia-Backend/config"
+	"Altheia-Backend/internal/clinical"
+	"fmt"
+	"time"
+)
 
 type Service interface {
-	CreateAppointment(createAppointmentDTO CreateAppointmentDTO) error
+	CreateAppointment(createAppointmentDTO CreateAppointmentDTO) (*MedicalAppointment, error)
 	GetAllAppointments() ([]MedicalAppointment, error)
-	UpdateAppointmentStatus(appointmentId string, status AppointmentStatus) error
+	UpdateAppointmentStatus(appointmentId string, status AppointmentStatus, actorId, reason string) error
 	GetAllAppointmentsByMedicId(medicId string) ([]AppointmentWithNamesDTO, error)
 	GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNamesDTO, error)
-	CancelAppointment(appointmentId string) error
-	RescheduleAppointment(appointmentId string, newDateTime time.Time) error
+	SearchAppointments(q AppointmentSearch) (*AppointmentPage, error)
+	CancelAppointment(appointmentId, actorId, reason string, scope SeriesScope) error
+	RescheduleAppointment(appointmentId string, newDateTime time.Time, actorId, reason string, scope SeriesScope) error
+	CreateSeries(createAppointmentDTO CreateAppointmentDTO) (*SeriesDTO, error)
+	GetSeries(appointmentId string) (*SeriesDTO, error)
+
+	JoinWaitlist(dto JoinWaitlistDTO) (*WaitlistEntry, error)
+	LeaveWaitlist(entryId string) error
+	GetClinicWaitlist(clinicId string) ([]WaitlistEntry, error)
+	ClaimOffer(token string) (*MedicalAppointment, error)
+	DeclineOffer(token string) error
+	ExpireWaitlistOffers() error
+	GetStatusHistory(appointmentId string) ([]AppointmentStatusHistory, error)
+	GetAppointment(appointmentId string) (*AppointmentWithNamesDTO, error)
+	GetAppointmentConsultation(appointmentId string) (*clinical.MedicalConsultation, error)
+	ConfirmAttendance(token string) (*MedicalAppointment, error)
+	CancelFromReminder(token string) error
+
+	GetAvailability(physicianId string) ([]PhysicianAvailability, error)
+	SetAvailability(physicianId string, blocks []AvailabilityBlockDTO) ([]PhysicianAvailability, error)
+	GetAvailabilityExceptions(physicianId string, from, to time.Time) ([]AvailabilityException, error)
+	AddAvailabilityException(physicianId string, dto AvailabilityExceptionDTO) (*AvailabilityException, error)
+	DeleteAvailabilityException(physicianId, exceptionId string) error
+	GetAvailableSlots(physicianId string, from, to time.Time) ([]Slot, error)
+	PhysicianLocation(physicianId string) (*time.Location, error)
+	ClinicLocation(clinicId string) (*time.Location, error)
+
+	GetBookingPolicy(clinicId string) (*BookingPolicy, error)
+	SetBookingPolicy(clinicId string, dto BookingPolicyDTO) (*BookingPolicy, error)
+	GetClinicPhysicians(clinicId string) ([]PortalPhysician, error)
+	GetBookableSlots(physicianId string, from, to time.Time) ([]Slot, error)
+	BookOwnAppointment(userId string, dto PortalBookingDTO) (*MedicalAppointment, error)
+	RescheduleOwnAppointment(userId, appointmentId string, newDateTime time.Time, reason string) error
+	CancelOwnAppointment(userId, appointmentId, reason string) error
+
+	MarkNoShows() error
+	GetPatientReliability(patientId, clinicId string) (*PatientReliability, error)
+	GetNoShowReport(clinicId string, from, to time.Time) (*NoShowReport, error)
+
+	CheckIn(appointmentId, actorId string, priority TriagePriority) (*AppointmentCheckIn, error)
+	SetTriagePriority(appointmentId string, priority TriagePriority) error
+	GetPhysicianQueue(physicianId string) (*PhysicianQueue, error)
+	CallNextPatient(physicianId, actorId string) (*QueueEntry, error)
+	GetWaitMetrics(clinicId string, from, to time.Time) (*WaitMetrics, error)
+
+	CreateCalendarFeed(userId, role string) (*CalendarFeed, string, error)
+	GetCalendarFeeds(userId string) ([]CalendarFeed, error)
+	RevokeCalendarFeed(userId, feedId string) error
+	RenderCalendarFeed(token string) ([]byte, error)
 }
 type service struct {
 	repo Repository
+	// offerHold is how long a freed slot is held for a waitlisted patient.
+	offerHold time.Duration
+	// noShowGrace is how long after its end a confirmed appointment that was
+	// not completed is marked as a no-show.
+	noShowGrace time.Duration
+	// notifier pushes waiting room changes to the clinic's screens; it may be
+	// nil.
+	notifier Notifier
 }
 
-func (s *service) UpdateAppointmentStatus(appointmentId string, status AppointmentStatus) error {
-	err := s.repo.UpdateAppointmentStatus(appointmentId, status)
+// UpdateAppointmentStatus moves the appointment along its lifecycle.
+// Rescheduling needs a new time and goes through RescheduleAppointment.
+func (s *service) UpdateAppointmentStatus(appointmentId string, status AppointmentStatus, actorId, reason string) error {
+	switch status {
+	case AppointmentStatusRescheduled:
+		return fmt.Errorf("%w: use the reschedule endpoint to move an appointment", ErrInvalidTransition)
+	case AppointmentStatusCheckedIn, AppointmentStatusInProgress:
+		return fmt.Errorf("%w: use the check-in and call next endpoints of the waiting room", ErrInvalidTransition)
+	}
+	return s.changeStatus(appointmentId, status, actorId, reason)
+}
+
+func (s *service) changeStatus(appointmentId string, status AppointmentStatus, actorId, reason string) error {
+	appointment, err := s.repo.GetAppointmentByID(appointmentId)
 	if err != nil {
 		return err
 	}
+
+	current := AppointmentStatus(appointment.Status)
+	if err := checkTransition(current, status); err != nil {
+		return err
+	}
+
+	err = s.repo.UpdateAppointmentStatus(StatusChange{
+		AppointmentID: appointment.ID,
+		From:          current,
+		To:            status,
+		ActorUserID:   actorId,
+		Reason:        reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.queueChanged([]MedicalAppointment{*appointment})
 	return nil
 }
 
-func NewService(r Repository) Service { return &service{r} }
+func NewService(r Repository, notifier Notifier) Service {
+	return &service{
+		repo:        r,
+		notifier:    notifier,
+		offerHold:   config.GetEnvDuration("WAITLIST_OFFER_HOLD", 30*time.Minute),
+		noShowGrace: config.GetEnvDuration("NO_SHOW_GRACE", 30*time.Minute),
+	}
+}
+
+func (s *service) CreateAppointment(createAppointmentDTO CreateAppointmentDTO) (*MedicalAppointment, error) {
+	return s.createAppointment(createAppointmentDTO)
+}
 
-func (s *service) CreateAppointment(createAppointmentDTO CreateAppointmentDTO) error {
-	err := s.repo.CreateAppointment(createAppointmentDTO)
+// createAppointment books the DTO's slot. ignoreIds lets a waitlist offer
+// claim the slot it holds.
+func (s *service) createAppointment(createAppointmentDTO CreateAppointmentDTO, ignoreIds ...string) (*MedicalAppointment, error) {
+	loc, err := physicianLocation(s.repo, createAppointmentDTO.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+
+	dateTime, err := createAppointmentDTO.dateTime(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	slot, err := s.freeSlot(createAppointmentDTO.PhysicianId, dateTime, ignoreIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	appointment, err := s.repo.CreateAppointment(createAppointmentDTO, slot.End)
+	if err != nil {
+		return nil, err
+	}
+	return appointment, s.localize(appointment)
+}
+
+// PhysicianLocation is the time zone of the physician's clinic.
+func (s *service) PhysicianLocation(physicianId string) (*time.Location, error) {
+	return physicianLocation(s.repo, physicianId)
+}
+
+// localize renders the appointments' times, stored in UTC, in the time zone
+// of each one's clinic.
+func (s *service) localize(appointments ...*MedicalAppointment) error {
+	if len(appointments) == 0 {
+		return nil
+	}
+
+	physicianIds := make([]string, 0, len(appointments))
+	for _, appointment := range appointments {
+		physicianIds = append(physicianIds, appointment.PhysicianId)
+	}
+	locations, err := physicianLocations(s.repo, physicianIds...)
 	if err != nil {
 		return err
 	}
+
+	for _, appointment := range appointments {
+		loc := locations[appointment.PhysicianId]
+		appointment.DateTime = appointment.DateTime.In(loc)
+		appointment.EndTime = appointment.EndTime.In(loc)
+	}
 	return nil
 }
 
-func (s *service) RescheduleAppointment(appointmentId string, newDateTime time.Time) error {
-	err := s.repo.ReScheduleAppointment(appointmentId, newDateTime)
+func (s *service) localizeAll(appointments []MedicalAppointment) error {
+	refs := make([]*MedicalAppointment, 0, len(appointments))
+	for i := range appointments {
+		refs = append(refs, &appointments[i])
+	}
+	return s.localize(refs...)
+}
+
+func (s *service) localizeWithNames(appointments []AppointmentWithNamesDTO) error {
+	refs := make([]*MedicalAppointment, 0, len(appointments))
+	for i := range appointments {
+		refs = append(refs, &appointments[i].MedicalAppointment)
+	}
+	return s.localize(refs...)
+}
+
+// RescheduleAppointment moves the appointment, and with a wider scope the
+// other occurrences of its series, to newDateTime's time of day, keeping the
+// days between occurrences. Every moved occurrence must land in a free slot.
+func (s *service) RescheduleAppointment(appointmentId string, newDateTime time.Time, actorId, reason string, scope SeriesScope) error {
+	appointment, err := s.repo.GetAppointmentByID(appointmentId)
+	if err != nil {
+		return err
+	}
+
+	targets, err := s.scopedAppointments(appointment, scope)
 	if err != nil {
 		return err
 	}
+
+	loc, err := physicianLocation(s.repo, appointment.PhysicianId)
+	if err != nil {
+		return err
+	}
+
+	anchor, moved := appointment.DateTime.In(loc), newDateTime.In(loc)
+	anchorDay := time.Date(anchor.Year(), anchor.Month(), anchor.Day(), 0, 0, 0, 0, time.UTC)
+	movedDay := time.Date(moved.Year(), moved.Month(), moved.Day(), 0, 0, 0, 0, time.UTC)
+	dayShift := int(movedDay.Sub(anchorDay).Hours() / 24)
+
+	ids := make([]string, 0, len(targets))
+	starts := make([]time.Time, 0, len(targets))
+	for _, target := range targets {
+		if err := checkTransition(AppointmentStatus(target.Status), AppointmentStatusRescheduled); err != nil {
+			return err
+		}
+
+		local := target.DateTime.In(loc)
+		ids = append(ids, target.ID)
+		starts = append(starts, time.Date(local.Year(), local.Month(), local.Day()+dayShift,
+			moved.Hour(), moved.Minute(), moved.Second(), 0, loc))
+	}
+	if len(targets) == 1 {
+		starts[0] = newDateTime
+	}
+
+	slots, unavailable, err := s.freeSlots(appointment.PhysicianId, starts, ids...)
+	if err != nil {
+		return err
+	}
+	if len(unavailable) > 0 {
+		if len(targets) == 1 {
+			return ErrSlotUnavailable
+		}
+		return &SeriesUnavailableError{Unavailable: unavailable}
+	}
+
+	changes := make([]StatusChange, 0, len(targets))
+	for i, target := range targets {
+		changes = append(changes, StatusChange{
+			AppointmentID: target.ID,
+			From:          AppointmentStatus(target.Status),
+			To:            AppointmentStatusRescheduled,
+			ActorUserID:   actorId,
+			Reason:        reason,
+			NewStart:      slots[i].Start,
+			NewEnd:        slots[i].End,
+		})
+	}
+	if err := s.repo.UpdateAppointmentStatuses(changes); err != nil {
+		return err
+	}
+
+	s.offerFreedSlots(targets)
+	s.queueChanged(targets)
 	return nil
 }
 
-func (s *service) CancelAppointment(appointmentId string) error {
-	err := s.repo.CancelAppointment(appointmentId)
+// CancelAppointment cancels the appointment, and with a wider scope the other
+// pending occurrences of its series.
+func (s *service) CancelAppointment(appointmentId, actorId, reason string, scope SeriesScope) error {
+	appointment, err := s.repo.GetAppointmentByID(appointmentId)
+	if err != nil {
+		return err
+	}
+
+	targets, err := s.scopedAppointments(appointment, scope)
 	if err != nil {
 		return err
 	}
+
+	changes := make([]StatusChange, 0, len(targets))
+	for _, target := range targets {
+		current := AppointmentStatus(target.Status)
+		if err := checkTransition(current, AppointmentStatusCancelled); err != nil {
+			return err
+		}
+		changes = append(changes, StatusChange{
+			AppointmentID: target.ID,
+			From:          current,
+			To:            AppointmentStatusCancelled,
+			ActorUserID:   actorId,
+			Reason:        reason,
+		})
+	}
+	if err := s.repo.UpdateAppointmentStatuses(changes); err != nil {
+		return err
+	}
+
+	s.offerFreedSlots(targets)
+	s.queueChanged(targets)
 	return nil
 }
 
+// GetAppointment returns the appointment with its patient, physician and
+// clinic, and the consultation that fulfilled it, if any.
+func (s *service) GetAppointment(appointmentId string) (*AppointmentWithNamesDTO, error) {
+	appointment, err := s.repo.GetAppointmentWithNames(appointmentId)
+	if err != nil {
+		return nil, err
+	}
+	appointments := []AppointmentWithNamesDTO{*appointment}
+	if err := s.localizeWithNames(appointments); err != nil {
+		return nil, err
+	}
+	return &appointments[0], nil
+}
+
+func (s *service) GetAppointmentConsultation(appointmentId string) (*clinical.MedicalConsultation, error) {
+	if _, err := s.repo.GetAppointmentByID(appointmentId); err != nil {
+		return nil, err
+	}
+	return s.repo.GetAppointmentConsultation(appointmentId)
+}
+
+func (s *service) GetStatusHistory(appointmentId string) ([]AppointmentStatusHistory, error) {
+	if _, err := s.repo.GetAppointmentByID(appointmentId); err != nil {
+		return nil, err
+	}
+	return s.repo.GetStatusHistory(appointmentId)
+}
+
 func (s *service) GetAllAppointments() ([]MedicalAppointment, error) {
 	var appointment []MedicalAppointment
 
@@ -58,7 +341,7 @@ func (s *service) GetAllAppointments() ([]MedicalAppointment, error) {
 		return appointment, err
 	}
 
-	return appointment, nil
+	return appointment, s.localizeAll(appointment)
 }
 
 func (s *service) GetAllAppointmentsByMedicId(medicId string) ([]AppointmentWithNamesDTO, error) {
@@ -70,7 +353,7 @@ func (s *service) GetAllAppointmentsByMedicId(medicId string) ([]AppointmentWith
 		return appointment, err
 	}
 
-	return appointment, nil
+	return appointment, s.localizeWithNames(appointment)
 }
 
 func (s *service) GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNamesDTO, error) {
@@ -82,5 +365,5 @@ func (s *service) GetAllAppointmentsByUserId(userId string) ([]AppointmentWithNa
 		return appointment, err
 	}
 
-	return appointment, nil
+	return appointment, s.localizeWithNames(appointment)
 }
@@ -0,0 +1,102 @@
+package appointments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidStatus     = errors.New("invalid appointment status")
+	ErrInvalidTransition = errors.New("invalid appointment status transition")
+	ErrStatusChanged     = errors.New("the appointment status changed in the meantime, reload it and try again")
+)
+
+// statusTransitions is the appointment lifecycle: the statuses each status may
+// move to. Patients checked in at the front desk wait in the physician's queue
+// until they are called in. Completed, cancelled and no-show appointments are
+// final.
+var statusTransitions = map[AppointmentStatus][]AppointmentStatus{
+	AppointmentStatusPending: {
+		AppointmentStatusConfirmed, AppointmentStatusCancelled, AppointmentStatusNoShow, AppointmentStatusRescheduled,
+		AppointmentStatusCheckedIn,
+	},
+	AppointmentStatusRescheduled: {
+		AppointmentStatusConfirmed, AppointmentStatusCancelled, AppointmentStatusNoShow, AppointmentStatusRescheduled,
+		AppointmentStatusCheckedIn,
+	},
+	AppointmentStatusConfirmed: {
+		AppointmentStatusCompleted, AppointmentStatusCancelled, AppointmentStatusNoShow, AppointmentStatusRescheduled,
+		AppointmentStatusCheckedIn,
+	},
+	AppointmentStatusCheckedIn: {
+		AppointmentStatusInProgress, AppointmentStatusCompleted, AppointmentStatusCancelled,
+	},
+	AppointmentStatusInProgress: {
+		AppointmentStatusCompleted,
+	},
+	AppointmentStatusCompleted: {},
+	AppointmentStatusCancelled: {},
+	AppointmentStatusNoShow:    {},
+}
+
+// IsValid reports whether s is one of the known appointment statuses.
+func (s AppointmentStatus) IsValid() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an appointment in status s may move to next.
+func (s AppointmentStatus) CanTransitionTo(next AppointmentStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition explains why an appointment cannot move from one status to
+// another, or returns nil when it can.
+func checkTransition(from, to AppointmentStatus) error {
+	if !to.IsValid() {
+		return fmt.Errorf("%w: %q", ErrInvalidStatus, to)
+	}
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: cannot move from %s to %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
+
+// AppointmentStatusHistory records one status change of an appointment.
+type AppointmentStatusHistory struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	AppointmentID string    `gorm:"not null;index" json:"appointment_id"`
+	FromStatus    string    `json:"from_status"`
+	ToStatus      string    `gorm:"not null" json:"to_status"`
+	ActorUserID   string    `json:"actor_user_id"`
+	Reason        string    `json:"reason"`
+	CreatedAt     time.Time `gorm:"index" json:"createdAt"`
+}
+
+func (AppointmentStatusHistory) TableName() string {
+	return "appointment_status_history"
+}
+
+// StatusChange is a move of an appointment along the lifecycle. When NewStart
+// is set the appointment is also moved to [NewStart, NewEnd).
+type StatusChange struct {
+	AppointmentID string
+	From          AppointmentStatus
+	To            AppointmentStatus
+	ActorUserID   string
+	Reason        string
+	NewStart      time.Time
+	NewEnd        time.Time
+}
+
+type StatusChangeDTO struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Scope  string `json:"scope"`
+}
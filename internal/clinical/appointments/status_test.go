@@ -0,0 +1,164 @@
+package appointments
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to AppointmentStatus
+		allowed  bool
+	}{
+		{AppointmentStatusPending, AppointmentStatusConfirmed, true},
+		{AppointmentStatusConfirmed, AppointmentStatusCompleted, true},
+		{AppointmentStatusPending, AppointmentStatusCancelled, true},
+		{AppointmentStatusConfirmed, AppointmentStatusNoShow, true},
+		{AppointmentStatusRescheduled, AppointmentStatusConfirmed, true},
+		{AppointmentStatusPending, AppointmentStatusCompleted, false},
+		{AppointmentStatusCompleted, AppointmentStatusCancelled, false},
+		{AppointmentStatusCancelled, AppointmentStatusConfirmed, false},
+		{AppointmentStatusNoShow, AppointmentStatusPending, false},
+		{AppointmentStatusConfirmed, AppointmentStatusPending, false},
+		{AppointmentStatusConfirmed, AppointmentStatusCheckedIn, true},
+		{AppointmentStatusCheckedIn, AppointmentStatusInProgress, true},
+		{AppointmentStatusInProgress, AppointmentStatusCompleted, true},
+		{AppointmentStatusCheckedIn, AppointmentStatusNoShow, false},
+		{AppointmentStatusInProgress, AppointmentStatusCancelled, false},
+		{AppointmentStatusCheckedIn, AppointmentStatusRescheduled, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.allowed {
+			t.Errorf("%s -> %s allowed = %v, want %v", tt.from, tt.to, got, tt.allowed)
+		}
+	}
+
+	if err := checkTransition(AppointmentStatusPending, "done"); !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("unknown status: got %v, want ErrInvalidStatus", err)
+	}
+	if err := checkTransition(AppointmentStatusCompleted, AppointmentStatusCancelled); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("final status: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+// statusRepo keeps appointments and their history in memory. Methods the
+// tests do not use panic through the embedded interface.
+type statusRepo struct {
+	Repository
+	appointments map[string]*MedicalAppointment
+	history      []AppointmentStatusHistory
+	availability []PhysicianAvailability
+	waitlist     []*WaitlistEntry
+	offers       []*SlotOffer
+	tokens       []*AppointmentActionToken
+	// timezones maps physicians to their clinic's zone; unset physicians use
+	// the default zone.
+	timezones map[string]string
+	feeds     []*CalendarFeed
+	// patients maps user accounts to their patient records, clinics
+	// physicians to their clinic, and services to the clinic offering them.
+	patients map[string]string
+	clinics  map[string]string
+	services map[string]string
+	policies map[string]*BookingPolicy
+	checkIns map[string]*AppointmentCheckIn
+}
+
+func (r *statusRepo) GetAppointmentByID(id string) (*MedicalAppointment, error) {
+	appointment, ok := r.appointments[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *appointment
+	return &copied, nil
+}
+
+func (r *statusRepo) UpdateAppointmentStatus(change StatusChange) error {
+	appointment := r.appointments[change.AppointmentID]
+	if appointment == nil || appointment.Status != string(change.From) {
+		return ErrStatusChanged
+	}
+	appointment.Status = string(change.To)
+	r.history = append(r.history, AppointmentStatusHistory{
+		AppointmentID: change.AppointmentID,
+		FromStatus:    string(change.From),
+		ToStatus:      string(change.To),
+		ActorUserID:   change.ActorUserID,
+		Reason:        change.Reason,
+		CreatedAt:     time.Now(),
+	})
+	return nil
+}
+
+func (r *statusRepo) UpdateAppointmentStatuses(changes []StatusChange) error {
+	for _, change := range changes {
+		if err := r.UpdateAppointmentStatus(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *statusRepo) GetSeriesAppointments(seriesId string) ([]MedicalAppointment, error) {
+	var occurrences []MedicalAppointment
+	for _, appointment := range r.appointments {
+		if appointment.SeriesID != nil && *appointment.SeriesID == seriesId {
+			occurrences = append(occurrences, *appointment)
+		}
+	}
+	return occurrences, nil
+}
+
+func (r *statusRepo) GetStatusHistory(id string) ([]AppointmentStatusHistory, error) {
+	var history []AppointmentStatusHistory
+	for _, entry := range r.history {
+		if entry.AppointmentID == id {
+			history = append(history, entry)
+		}
+	}
+	return history, nil
+}
+
+func TestServiceStatusChanges(t *testing.T) {
+	repo := &statusRepo{appointments: map[string]*MedicalAppointment{
+		"appt-1": {ID: "appt-1", Status: string(AppointmentStatusPending)},
+	}}
+	svc := NewService(repo, nil)
+
+	if err := svc.UpdateAppointmentStatus("appt-1", AppointmentStatusCompleted, "user-1", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("pending -> completed: got %v, want ErrInvalidTransition", err)
+	}
+	if err := svc.UpdateAppointmentStatus("appt-1", AppointmentStatusRescheduled, "user-1", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("rescheduling without a new time: got %v, want ErrInvalidTransition", err)
+	}
+
+	if err := svc.UpdateAppointmentStatus("appt-1", AppointmentStatusConfirmed, "user-1", "called the patient"); err != nil {
+		t.Fatalf("pending -> confirmed: %v", err)
+	}
+	if err := svc.CancelAppointment("appt-1", "user-2", "patient is travelling", ScopeThisOccurrence); err != nil {
+		t.Fatalf("confirmed -> cancelled: %v", err)
+	}
+	if err := svc.UpdateAppointmentStatus("appt-1", AppointmentStatusConfirmed, "user-1", ""); !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("cancelled -> confirmed: got %v, want ErrInvalidTransition", err)
+	}
+
+	history, err := svc.GetStatusHistory("appt-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("got %d history entries, want 2", len(history))
+	}
+	if history[1].FromStatus != "confirmed" || history[1].ToStatus != "cancelled" ||
+		history[1].ActorUserID != "user-2" || history[1].Reason != "patient is travelling" {
+		t.Errorf("unexpected history entry %+v", history[1])
+	}
+
+	if _, err := svc.GetStatusHistory("missing"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("missing appointment: got %v, want ErrRecordNotFound", err)
+	}
+}
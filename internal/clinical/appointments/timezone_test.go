@@ -0,0 +1,164 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"Altheia-Backend/internal/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := clinical.LoadTimezone("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestComputeSlotsAcrossDST(t *testing.T) {
+	loc := newYork(t)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	night := []PhysicianAvailability{{Weekday: int(time.Sunday), StartTime: "00:00", EndTime: "04:00", SlotMinutes: 60}}
+
+	t.Run("the skipped hour has no slot", func(t *testing.T) {
+		day := time.Date(2025, 3, 9, 0, 0, 0, 0, loc)
+		slots := computeSlots(night, nil, nil, nil, day, day.AddDate(0, 0, 1), now, loc)
+
+		want := []string{"00:00", "01:00", "03:00"}
+		if len(slots) != len(want) {
+			t.Fatalf("got %d slots %v, want %v", len(slots), slots, want)
+		}
+		for i, slot := range slots {
+			if got := slot.Start.Format("15:04"); got != want[i] {
+				t.Errorf("slot %d starts at %s, want %s", i, got, want[i])
+			}
+			if slot.End.Sub(slot.Start) != time.Hour {
+				t.Errorf("slot %d lasts %s, want 1h", i, slot.End.Sub(slot.Start))
+			}
+		}
+	})
+
+	t.Run("the repeated hour does not overlap", func(t *testing.T) {
+		day := time.Date(2025, 11, 2, 0, 0, 0, 0, loc)
+		slots := computeSlots(night, nil, nil, nil, day, day.AddDate(0, 0, 1), now, loc)
+		if len(slots) != 4 {
+			t.Fatalf("got %d slots %v, want 4", len(slots), slots)
+		}
+		for i, slot := range slots {
+			if slot.End.Sub(slot.Start) != time.Hour {
+				t.Errorf("slot %d lasts %s, want 1h", i, slot.End.Sub(slot.Start))
+			}
+			if i > 0 && slot.Start.Before(slots[i-1].End) {
+				t.Errorf("slot %d overlaps the previous one", i)
+			}
+		}
+	})
+
+	t.Run("wall-clock times hold across the change", func(t *testing.T) {
+		mornings := []PhysicianAvailability{{Weekday: int(time.Monday), StartTime: "09:00", EndTime: "09:30", SlotMinutes: 30}}
+		from := time.Date(2025, 3, 3, 0, 0, 0, 0, loc)
+		slots := computeSlots(mornings, nil, nil, nil, from, from.AddDate(0, 0, 8), now, loc)
+		if len(slots) != 2 {
+			t.Fatalf("got %d slots %v, want 2", len(slots), slots)
+		}
+		if got := slots[0].Start.UTC().Hour(); got != 14 {
+			t.Errorf("slot before DST starts at %d:00 UTC, want 14:00", got)
+		}
+		if got := slots[1].Start.UTC().Hour(); got != 13 {
+			t.Errorf("slot after DST starts at %d:00 UTC, want 13:00", got)
+		}
+	})
+}
+
+func TestRecurrenceAcrossDST(t *testing.T) {
+	loc := newYork(t)
+	start := time.Date(2025, 3, 3, 9, 0, 0, 0, loc)
+
+	got, err := RecurrenceDTO{Frequency: "weekly", Count: 2}.occurrences(start, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[1].Format("2006-01-02 15:04") != "2025-03-10 09:00" || got[1].Sub(got[0]) != 7*24*time.Hour-time.Hour {
+		t.Errorf("occurrences = %v, want 09:00 on both Mondays", got)
+	}
+}
+
+func TestClinicTimezone(t *testing.T) {
+	mail.SetSMTPClient(discardSMTP{})
+	defer mail.SetSMTPClient(&mail.DefaultSMTPClient{})
+
+	loc := newYork(t)
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{},
+		timezones:    map[string]string{"ph-ny": "America/New_York"},
+		availability: []PhysicianAvailability{
+			{PhysicianID: "ph-ny", Weekday: int(time.Monday), StartTime: "09:00", EndTime: "10:00", SlotMinutes: 30},
+		},
+	}
+	s := &service{repo: repo}
+
+	// 2030-03-11 is the first Monday after DST starts in New York.
+	appointment, err := s.CreateAppointment(CreateAppointmentDTO{
+		PatientId: "pa-1", PhysicianId: "ph-ny", Date: "2030-03-11", Time: "09:00",
+	})
+	if err != nil {
+		t.Fatalf("CreateAppointment() error = %v", err)
+	}
+
+	stored := repo.appointments[appointment.ID]
+	if stored.DateTime.Location() != time.UTC || stored.DateTime.Hour() != 13 {
+		t.Errorf("stored start = %v, want 13:00 UTC", stored.DateTime)
+	}
+	if appointment.DateTime.Location().String() != loc.String() || appointment.DateTime.Format("15:04 MST") != "09:00 EDT" {
+		t.Errorf("returned start = %v, want 09:00 in the clinic's zone", appointment.DateTime)
+	}
+
+	slots, err := s.GetAvailableSlots("ph-ny", time.Date(2030, 3, 11, 0, 0, 0, 0, loc), time.Date(2030, 3, 12, 0, 0, 0, 0, loc))
+	if err != nil {
+		t.Fatalf("GetAvailableSlots() error = %v", err)
+	}
+	if len(slots) != 1 || slots[0].Start.Format("15:04") != "09:30" {
+		t.Errorf("slots = %v, want only 09:30 left", slots)
+	}
+}
+
+func TestRemindersAcrossDST(t *testing.T) {
+	smtpClient := &captureSMTP{}
+	mail.SetSMTPClient(smtpClient)
+	defer mail.SetSMTPClient(&mail.DefaultSMTPClient{})
+
+	loc := newYork(t)
+	start := time.Date(2025, 3, 10, 9, 0, 0, 0, loc)
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{
+			"appt": {ID: "appt", PhysicianId: "ph-ny", DateTime: start.UTC(), EndTime: start.Add(30 * time.Minute).UTC(), Status: string(AppointmentStatusConfirmed)},
+		},
+		timezones: map[string]string{"ph-ny": "America/New_York"},
+	}
+	queue := &memoryQueue{keys: map[string]bool{}}
+	reminders := NewReminders(repo, queue, []time.Duration{48 * time.Hour})
+	reminders.now = func() time.Time { return start.Add(-47 * time.Hour) }
+
+	if err := reminders.Plan(); err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(queue.runAts) != 1 || !queue.runAts[0].Equal(start.Add(-48*time.Hour)) {
+		t.Fatalf("reminder scheduled at %v, want exactly 48 hours before the appointment", queue.runAts)
+	}
+	// 48 hours before 09:00 EDT is 08:00 EST, across the DST change.
+	if got := queue.runAts[0].In(loc).Format("15:04"); got != "08:00" {
+		t.Errorf("reminder runs at %s local time, want 08:00", got)
+	}
+
+	if err := reminders.Send(queue.pending[0]); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(smtpClient.messages) != 1 {
+		t.Fatalf("sent %d reminders, want 1", len(smtpClient.messages))
+	}
+	if !strings.Contains(smtpClient.messages[0], "10/03/2025 a las 09:00") {
+		t.Errorf("reminder does not show the clinic's local time")
+	}
+}
@@ -0,0 +1,97 @@
+package appointments
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidWaitlistEntry = errors.New("invalid waitlist entry")
+	ErrOfferUnavailable     = errors.New("this offer is no longer available")
+)
+
+type WaitlistStatus string
+
+const (
+	WaitlistStatusWaiting   WaitlistStatus = "waiting"
+	WaitlistStatusOffered   WaitlistStatus = "offered"
+	WaitlistStatusBooked    WaitlistStatus = "booked"
+	WaitlistStatusCancelled WaitlistStatus = "cancelled"
+)
+
+type OfferStatus string
+
+const (
+	OfferStatusPending  OfferStatus = "pending"
+	OfferStatusAccepted OfferStatus = "accepted"
+	OfferStatusDeclined OfferStatus = "declined"
+	OfferStatusExpired  OfferStatus = "expired"
+)
+
+// WaitlistEntry is a patient waiting for a slot between PreferredFrom and
+// PreferredTo, either with a given physician or with any physician of the
+// clinic, optionally for one service. SelfJoined entries were added by the
+// patient, and like portal bookings are subject to the clinic's no-show policy.
+type WaitlistEntry struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	PatientId     string    `gorm:"not null;index" json:"patient_id"`
+	PhysicianId   *string   `gorm:"index" json:"physician_id"`
+	ClinicId      string    `gorm:"not null;index" json:"clinic_id"`
+	ServiceId     *string   `json:"service_id"`
+	PreferredFrom time.Time `gorm:"not null" json:"preferred_from"`
+	PreferredTo   time.Time `gorm:"not null" json:"preferred_to"`
+	Reason        string    `json:"reason"`
+	Status        string    `gorm:"not null;index" json:"status"`
+	SelfJoined    bool      `gorm:"not null;default:false" json:"self_joined"`
+	AppointmentID *string   `json:"appointment_id,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// SlotOffer holds a freed slot for one waitlisted patient until ExpiresAt.
+// While it is pending the slot cannot be booked by anyone else.
+type SlotOffer struct {
+	ID              string    `gorm:"primaryKey" json:"id"`
+	WaitlistEntryID string    `gorm:"not null;index" json:"waitlist_entry_id"`
+	PhysicianId     string    `gorm:"not null;index" json:"physician_id"`
+	StartsAt        time.Time `gorm:"not null" json:"starts_at"`
+	EndsAt          time.Time `gorm:"not null" json:"ends_at"`
+	TokenHash       string    `gorm:"not null;uniqueIndex" json:"-"`
+	Status          string    `gorm:"not null;index" json:"status"`
+	ExpiresAt       time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+type JoinWaitlistDTO struct {
+	PatientId     string    `json:"patient_id"`
+	PhysicianId   string    `json:"physician_id"`
+	ClinicId      string    `json:"clinic_id"`
+	ServiceId     string    `json:"service_id"`
+	PreferredFrom time.Time `json:"preferred_from"`
+	PreferredTo   time.Time `json:"preferred_to"`
+	Reason        string    `json:"reason"`
+	SelfJoined    bool      `json:"-"`
+}
+
+type OfferTokenDTO struct {
+	Token string `json:"token"`
+}
+
+// WaitlistContact is what the offer email needs to know about an entry.
+type WaitlistContact struct {
+	PatientName   string
+	PatientEmail  string
+	PhysicianName string
+	ClinicName    string
+}
+
+// heldSlots turns pending offers into placeholder appointments, so that slot
+// computation treats held slots as taken.
+func heldSlots(offers []SlotOffer) []MedicalAppointment {
+	held := make([]MedicalAppointment, 0, len(offers))
+	for _, offer := range offers {
+		held = append(held, MedicalAppointment{ID: offer.ID, DateTime: offer.StartsAt, EndTime: offer.EndsAt})
+	}
+	return held
+}
@@ -0,0 +1,64 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/users"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handler) JoinWaitlist(c *fiber.Ctx) error {
+	var dto JoinWaitlistDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	role, _ := c.Locals("user_role").(string)
+	dto.SelfJoined = role == users.RolePatient
+
+	entry, err := h.service.JoinWaitlist(dto)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(entry)
+}
+
+func (h *Handler) LeaveWaitlist(c *fiber.Ctx) error {
+	if err := h.service.LeaveWaitlist(c.Params("id")); err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "removed from the waitlist"})
+}
+
+func (h *Handler) GetClinicWaitlist(c *fiber.Ctx) error {
+	entries, err := h.service.GetClinicWaitlist(c.Query("clinic_id"))
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(entries)
+}
+
+func (h *Handler) ClaimOffer(c *fiber.Ctx) error {
+	var dto OfferTokenDTO
+	if err := c.BodyParser(&dto); err != nil || dto.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	appointment, err := h.service.ClaimOffer(dto.Token)
+	if err != nil {
+		return appointmentError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(appointment)
+}
+
+func (h *Handler) DeclineOffer(c *fiber.Ctx) error {
+	var dto OfferTokenDTO
+	if err := c.BodyParser(&dto); err != nil || dto.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	if err := h.service.DeclineOffer(dto.Token); err != nil {
+		return appointmentError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "offer declined"})
+}
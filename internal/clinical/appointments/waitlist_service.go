@@ -0,0 +1,266 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/mail"
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"gorm.io/gorm"
+)
+
+func (s *service) JoinWaitlist(dto JoinWaitlistDTO) (*WaitlistEntry, error) {
+	if dto.PatientId == "" || dto.ClinicId == "" {
+		return nil, fmt.Errorf("%w: patient_id and clinic_id are required", ErrInvalidWaitlistEntry)
+	}
+	if !dto.PreferredTo.After(dto.PreferredFrom) || !dto.PreferredTo.After(time.Now()) {
+		return nil, fmt.Errorf("%w: the preferred window must end after it starts and in the future", ErrInvalidWaitlistEntry)
+	}
+	if err := s.checkWaitlistClinic(dto); err != nil {
+		return nil, err
+	}
+	if dto.SelfJoined {
+		if err := s.checkWaitlistRestriction(dto.PatientId, dto.ClinicId); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &WaitlistEntry{
+		ID:            id,
+		PatientId:     dto.PatientId,
+		ClinicId:      dto.ClinicId,
+		PreferredFrom: dto.PreferredFrom.UTC(),
+		PreferredTo:   dto.PreferredTo.UTC(),
+		Reason:        dto.Reason,
+		Status:        string(WaitlistStatusWaiting),
+		SelfJoined:    dto.SelfJoined,
+	}
+	if dto.PhysicianId != "" {
+		entry.PhysicianId = &dto.PhysicianId
+	}
+	if dto.ServiceId != "" {
+		entry.ServiceId = &dto.ServiceId
+	}
+
+	if err := s.repo.CreateWaitlistEntry(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// checkWaitlistClinic rejects an entry naming a physician who does not work
+// at its clinic, or a service the clinic does not offer.
+func (s *service) checkWaitlistClinic(dto JoinWaitlistDTO) error {
+	if dto.PhysicianId != "" {
+		clinicId, err := s.repo.GetPhysicianClinicId(dto.PhysicianId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if clinicId != dto.ClinicId {
+			return fmt.Errorf("%w: the physician does not work at the clinic", ErrInvalidWaitlistEntry)
+		}
+	}
+	if dto.ServiceId != "" {
+		offered, err := s.repo.ClinicOffersService(dto.ClinicId, dto.ServiceId)
+		if err != nil {
+			return err
+		}
+		if !offered {
+			return fmt.Errorf("%w: the clinic does not offer the service", ErrInvalidWaitlistEntry)
+		}
+	}
+	return nil
+}
+
+func (s *service) LeaveWaitlist(entryId string) error {
+	if _, err := s.repo.GetWaitlistEntry(entryId); err != nil {
+		return err
+	}
+	return s.repo.UpdateWaitlistStatus(entryId, WaitlistStatusCancelled, nil)
+}
+
+func (s *service) GetClinicWaitlist(clinicId string) ([]WaitlistEntry, error) {
+	return s.repo.GetClinicWaitlist(clinicId)
+}
+
+// ClaimOffer books the slot held by the offer for its waitlisted patient. If
+// the booking fails the patient goes back on the waitlist and the slot is
+// offered to the next one. The booking stays pending until staff confirm it.
+// A patient who joined the waitlist themselves and has since been blocked by
+// the clinic's no-show policy is taken off the waitlist instead.
+func (s *service) ClaimOffer(token string) (*MedicalAppointment, error) {
+	offer, err := s.repo.ResolveSlotOffer(utils.HashToken(token), OfferStatusAccepted)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := s.repo.GetWaitlistEntry(offer.WaitlistEntryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.SelfJoined {
+		if err := s.checkWaitlistRestriction(entry.PatientId, entry.ClinicId); err != nil {
+			if statusErr := s.repo.UpdateWaitlistStatus(entry.ID, WaitlistStatusCancelled, nil); statusErr != nil {
+				log.Printf("failed to take waitlist entry %s off the waitlist: %v", entry.ID, statusErr)
+			}
+			s.offerSlot(offer.PhysicianId, Slot{Start: offer.StartsAt, End: offer.EndsAt})
+			return nil, err
+		}
+	}
+
+	loc, err := physicianLocation(s.repo, offer.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+	start := offer.StartsAt.In(loc)
+
+	appointment, err := s.createAppointment(CreateAppointmentDTO{
+		PatientId:   entry.PatientId,
+		PhysicianId: offer.PhysicianId,
+		ClinicId:    entry.ClinicId,
+		Date:        start.Format("2006-01-02"),
+		Time:        start.Format("15:04"),
+		Reason:      entry.Reason,
+	}, offer.ID)
+	if err != nil {
+		if statusErr := s.repo.UpdateWaitlistStatus(entry.ID, WaitlistStatusWaiting, nil); statusErr != nil {
+			log.Printf("failed to return waitlist entry %s to the waitlist: %v", entry.ID, statusErr)
+		}
+		s.offerSlot(offer.PhysicianId, Slot{Start: offer.StartsAt, End: offer.EndsAt})
+		return nil, err
+	}
+
+	if err := s.repo.UpdateWaitlistStatus(entry.ID, WaitlistStatusBooked, &appointment.ID); err != nil {
+		return nil, err
+	}
+	return appointment, nil
+}
+
+// checkWaitlistRestriction rejects patients the clinic's no-show policy blocks
+// from booking themselves. Those who need their bookings confirmed may wait:
+// bookings from the waitlist are pending until staff confirm them.
+func (s *service) checkWaitlistRestriction(patientId, clinicId string) error {
+	policy, err := s.GetBookingPolicy(clinicId)
+	if err != nil {
+		return err
+	}
+	restriction, noShows, err := s.noShowRestriction(patientId, policy, time.Now())
+	if err != nil {
+		return err
+	}
+	if restriction == NoShowActionBlockSelfBooking {
+		return policy.blockedError(noShows)
+	}
+	return nil
+}
+
+// DeclineOffer releases the held slot and offers it to the next patient. The
+// declining patient stays on the waitlist for other slots.
+func (s *service) DeclineOffer(token string) error {
+	offer, err := s.repo.ResolveSlotOffer(utils.HashToken(token), OfferStatusDeclined)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdateWaitlistStatus(offer.WaitlistEntryID, WaitlistStatusWaiting, nil); err != nil {
+		return err
+	}
+	s.offerSlot(offer.PhysicianId, Slot{Start: offer.StartsAt, End: offer.EndsAt})
+	return nil
+}
+
+// ExpireWaitlistOffers releases the holds that ran out and offers their slots
+// to the next patients.
+func (s *service) ExpireWaitlistOffers() error {
+	expired, err := s.repo.ExpireSlotOffers(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, offer := range expired {
+		s.offerSlot(offer.PhysicianId, Slot{Start: offer.StartsAt, End: offer.EndsAt})
+	}
+	return nil
+}
+
+// offerFreedSlots offers the future time of cancelled or moved appointments to
+// the waitlist.
+func (s *service) offerFreedSlots(freed []MedicalAppointment) {
+	for _, appointment := range freed {
+		s.offerSlot(appointment.PhysicianId, Slot{Start: appointment.DateTime, End: appointment.EndTime})
+	}
+}
+
+// offerSlot holds the slot for the first eligible waitlisted patient and emails
+// them the offer. Slots in the past or no longer free are not offered. Errors
+// are logged: the change that freed the slot has already succeeded.
+func (s *service) offerSlot(physicianId string, slot Slot) {
+	if err := s.tryOfferSlot(physicianId, slot); err != nil {
+		log.Printf("failed to offer slot %s of physician %s to the waitlist: %v", slot.Start, physicianId, err)
+	}
+}
+
+func (s *service) tryOfferSlot(physicianId string, slot Slot) error {
+	if !slot.Start.After(time.Now()) {
+		return nil
+	}
+
+	free, err := s.freeSlot(physicianId, slot.Start)
+	if errors.Is(err, ErrSlotUnavailable) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entry, err := s.repo.NextWaitlistEntry(physicianId, free)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	contact, err := s.repo.GetWaitlistContact(entry.ID, physicianId)
+	if err != nil {
+		return err
+	}
+
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+
+	offer := &SlotOffer{
+		ID:              id,
+		WaitlistEntryID: entry.ID,
+		PhysicianId:     physicianId,
+		StartsAt:        free.Start,
+		EndsAt:          free.End,
+		TokenHash:       utils.HashToken(raw),
+		Status:          string(OfferStatusPending),
+		ExpiresAt:       time.Now().Add(s.offerHold),
+	}
+	if err := s.repo.CreateSlotOffer(offer); err != nil {
+		return err
+	}
+
+	loc, err := physicianLocation(s.repo, physicianId)
+	if err != nil {
+		return err
+	}
+	return mail.SendWaitlistOfferEmail(contact.PatientName, contact.PatientEmail, contact.PhysicianName, contact.ClinicName,
+		free.Start.In(loc), s.offerHold, raw)
+}
@@ -0,0 +1,343 @@
+package appointments
+
+import (
+	"Altheia-Backend/internal/clinical"
+	"Altheia-Backend/internal/mail"
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"net/smtp"
+	"sort"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type discardSMTP struct{}
+
+func (discardSMTP) SendMail(string, smtp.Auth, string, []string, []byte) error { return nil }
+
+func (r *statusRepo) GetAvailability(string) ([]PhysicianAvailability, error) {
+	return r.availability, nil
+}
+
+func (r *statusRepo) GetAvailabilityExceptions(string, time.Time, time.Time) ([]AvailabilityException, error) {
+	return nil, nil
+}
+
+func (r *statusRepo) GetPhysicianClinicSchedule(string) ([]clinical.ClinicSchedule, error) {
+	return nil, nil
+}
+
+func (r *statusRepo) GetPhysicianTimezones(physicianIds []string) (map[string]string, error) {
+	zones := map[string]string{}
+	for _, physicianId := range physicianIds {
+		zones[physicianId] = r.timezones[physicianId]
+	}
+	return zones, nil
+}
+
+func (r *statusRepo) GetActiveAppointmentsInRange(physicianId string, from, to time.Time) ([]MedicalAppointment, error) {
+	var active []MedicalAppointment
+	for _, appointment := range r.appointments {
+		if appointment.PhysicianId == physicianId && appointment.Status != string(AppointmentStatusCancelled) &&
+			appointment.DateTime.Before(to) && appointment.EndTime.After(from) {
+			active = append(active, *appointment)
+		}
+	}
+	return active, nil
+}
+
+func (r *statusRepo) CreateAppointment(dto CreateAppointmentDTO, endTime time.Time) (*MedicalAppointment, error) {
+	loc, err := physicianLocation(r, dto.PhysicianId)
+	if err != nil {
+		return nil, err
+	}
+	start, err := dto.dateTime(loc)
+	if err != nil {
+		return nil, err
+	}
+	appointment := &MedicalAppointment{
+		ID:          "appt-" + dto.PatientId,
+		PatientId:   dto.PatientId,
+		PhysicianId: dto.PhysicianId,
+		DateTime:    start.UTC(),
+		EndTime:     endTime.UTC(),
+		Status:      string(AppointmentStatusPending),
+	}
+	r.appointments[appointment.ID] = appointment
+	copied := *appointment
+	return &copied, nil
+}
+
+func (r *statusRepo) CreateWaitlistEntry(entry *WaitlistEntry) error {
+	r.waitlist = append(r.waitlist, entry)
+	return nil
+}
+
+func (r *statusRepo) ClinicOffersService(clinicId, serviceId string) (bool, error) {
+	return r.services[serviceId] == clinicId, nil
+}
+
+func (r *statusRepo) GetWaitlistEntry(id string) (*WaitlistEntry, error) {
+	for _, entry := range r.waitlist {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) UpdateWaitlistStatus(id string, status WaitlistStatus, appointmentId *string) error {
+	entry, err := r.GetWaitlistEntry(id)
+	if err != nil {
+		return err
+	}
+	entry.Status = string(status)
+	if appointmentId != nil {
+		entry.AppointmentID = appointmentId
+	}
+	return nil
+}
+
+func (r *statusRepo) NextWaitlistEntry(physicianId string, slot Slot) (*WaitlistEntry, error) {
+	sort.Slice(r.waitlist, func(i, j int) bool { return r.waitlist[i].CreatedAt.Before(r.waitlist[j].CreatedAt) })
+
+next:
+	for _, entry := range r.waitlist {
+		if entry.Status != string(WaitlistStatusWaiting) || *entry.PhysicianId != physicianId ||
+			entry.PreferredFrom.After(slot.Start) || entry.PreferredTo.Before(slot.End) {
+			continue
+		}
+		for _, offer := range r.offers {
+			if offer.WaitlistEntryID == entry.ID && offer.StartsAt.Equal(slot.Start) {
+				continue next
+			}
+		}
+		return entry, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *statusRepo) GetWaitlistContact(string, string) (*WaitlistContact, error) {
+	return &WaitlistContact{PatientName: "Ana", PatientEmail: "ana@example.com", PhysicianName: "Dr. Ruiz"}, nil
+}
+
+func (r *statusRepo) CreateSlotOffer(offer *SlotOffer) error {
+	r.offers = append(r.offers, offer)
+	return r.UpdateWaitlistStatus(offer.WaitlistEntryID, WaitlistStatusOffered, nil)
+}
+
+func (r *statusRepo) GetPendingOffers(physicianId string, from, to time.Time) ([]SlotOffer, error) {
+	var pending []SlotOffer
+	for _, offer := range r.offers {
+		if offer.PhysicianId == physicianId && offer.Status == string(OfferStatusPending) && offer.ExpiresAt.After(time.Now()) &&
+			offer.StartsAt.Before(to) && offer.EndsAt.After(from) {
+			pending = append(pending, *offer)
+		}
+	}
+	return pending, nil
+}
+
+func (r *statusRepo) ResolveSlotOffer(tokenHash string, status OfferStatus) (*SlotOffer, error) {
+	for _, offer := range r.offers {
+		if offer.TokenHash == tokenHash && offer.Status == string(OfferStatusPending) && offer.ExpiresAt.After(time.Now()) {
+			offer.Status = string(status)
+			return offer, nil
+		}
+	}
+	return nil, ErrOfferUnavailable
+}
+
+func (r *statusRepo) ExpireSlotOffers(now time.Time) ([]SlotOffer, error) {
+	var expired []SlotOffer
+	for _, offer := range r.offers {
+		if offer.Status == string(OfferStatusPending) && !offer.ExpiresAt.After(now) {
+			offer.Status = string(OfferStatusExpired)
+			expired = append(expired, *offer)
+			if err := r.UpdateWaitlistStatus(offer.WaitlistEntryID, WaitlistStatusWaiting, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return expired, nil
+}
+
+func TestWaitlistBackfill(t *testing.T) {
+	mail.SetSMTPClient(discardSMTP{})
+
+	loc, err := clinical.LoadTimezone(clinical.DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Monday 2030-01-07 at 08:00.
+	start := time.Date(2030, 1, 7, 8, 0, 0, 0, loc)
+	physician := "ph-1"
+
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{
+			"appt-1": {ID: "appt-1", PatientId: "pt-1", PhysicianId: physician, Status: string(AppointmentStatusConfirmed),
+				DateTime: start, EndTime: start.Add(30 * time.Minute)},
+		},
+		availability: []PhysicianAvailability{
+			{PhysicianID: physician, Weekday: int(time.Monday), StartTime: "08:00", EndTime: "09:00", SlotMinutes: 30},
+		},
+	}
+	for i, patient := range []string{"pt-2", "pt-3"} {
+		repo.waitlist = append(repo.waitlist, &WaitlistEntry{
+			ID:            "wait-" + patient,
+			PatientId:     patient,
+			PhysicianId:   &physician,
+			ClinicId:      "clinic-1",
+			PreferredFrom: start.AddDate(0, 0, -1),
+			PreferredTo:   start.AddDate(0, 0, 1),
+			Status:        string(WaitlistStatusWaiting),
+			CreatedAt:     time.Now().Add(time.Duration(i) * time.Minute),
+		})
+	}
+	svc := NewService(repo, nil)
+
+	if err := svc.CancelAppointment("appt-1", "desk-1", "patient cancelled", ScopeThisOccurrence); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.offers) != 1 || repo.offers[0].WaitlistEntryID != "wait-pt-2" || !repo.offers[0].StartsAt.Equal(start) {
+		t.Fatalf("expected the freed slot to be offered to the first patient, got %+v", repo.offers)
+	}
+
+	// The held slot cannot be booked by anyone else.
+	slots, err := svc.GetAvailableSlots(physician, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) != 1 || !slots[0].Start.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("held slot should not be available, got %v", slots)
+	}
+
+	// When the hold runs out the slot goes to the next patient.
+	repo.offers[0].ExpiresAt = time.Now().Add(-time.Second)
+	if err := svc.ExpireWaitlistOffers(); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.offers) != 2 || repo.offers[1].WaitlistEntryID != "wait-pt-3" {
+		t.Fatalf("expected the slot to be offered to the next patient, got %+v", repo.offers)
+	}
+	if repo.waitlist[0].Status != string(WaitlistStatusWaiting) {
+		t.Errorf("the first patient should be back on the waitlist, got %s", repo.waitlist[0].Status)
+	}
+
+	repo.offers[1].TokenHash = utils.HashToken("offer-token")
+	appointment, err := svc.ClaimOffer("offer-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appointment.PatientId != "pt-3" || !appointment.DateTime.Equal(start) {
+		t.Errorf("unexpected appointment %+v", appointment)
+	}
+	if repo.waitlist[1].Status != string(WaitlistStatusBooked) {
+		t.Errorf("the claiming entry should be booked, got %s", repo.waitlist[1].Status)
+	}
+
+	if _, err := svc.ClaimOffer("offer-token"); !errors.Is(err, ErrOfferUnavailable) {
+		t.Errorf("claiming twice: got %v, want ErrOfferUnavailable", err)
+	}
+}
+
+func TestJoinWaitlistChecksClinic(t *testing.T) {
+	repo := &statusRepo{
+		clinics:  map[string]string{"ph-1": "cl-1", "ph-2": "cl-2"},
+		services: map[string]string{"svc-1": "cl-1", "svc-2": "cl-2"},
+	}
+	svc := &service{repo: repo}
+	from := time.Now().Add(24 * time.Hour)
+
+	tests := []struct {
+		name        string
+		physicianId string
+		serviceId   string
+		wantErr     bool
+	}{
+		{name: "physician and service of the clinic", physicianId: "ph-1", serviceId: "svc-1"},
+		{name: "physician of another clinic", physicianId: "ph-2", wantErr: true},
+		{name: "unknown physician", physicianId: "ph-unknown", wantErr: true},
+		{name: "service of another clinic", serviceId: "svc-2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.JoinWaitlist(JoinWaitlistDTO{PatientId: "pa-ana", ClinicId: "cl-1",
+				PhysicianId: tt.physicianId, ServiceId: tt.serviceId, PreferredFrom: from, PreferredTo: from.Add(time.Hour)})
+			if tt.wantErr != errors.Is(err, ErrInvalidWaitlistEntry) || (!tt.wantErr && err != nil) {
+				t.Errorf("JoinWaitlist() error = %v, want invalid entry %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitlistNoShowPolicy(t *testing.T) {
+	mail.SetSMTPClient(discardSMTP{})
+	defer mail.SetSMTPClient(&mail.DefaultSMTPClient{})
+
+	loc, err := clinical.LoadTimezone(clinical.DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Monday 2030-01-07 at 08:00.
+	start := time.Date(2030, 1, 7, 8, 0, 0, 0, loc)
+	physician := "ph-1"
+	missed := func(id string) *MedicalAppointment {
+		at := time.Now().AddDate(0, 0, -7)
+		return &MedicalAppointment{ID: id, PatientId: "pa-ana", PhysicianId: physician, DateTime: at, EndTime: at.Add(30 * time.Minute), Status: string(AppointmentStatusNoShow)}
+	}
+	repo := &statusRepo{
+		appointments: map[string]*MedicalAppointment{"m1": missed("m1"), "m2": missed("m2")},
+		availability: []PhysicianAvailability{
+			{PhysicianID: physician, Weekday: int(time.Monday), StartTime: "08:00", EndTime: "09:00", SlotMinutes: 30},
+		},
+		clinics: map[string]string{physician: "cl-1"},
+		policies: map[string]*BookingPolicy{
+			"cl-1": {ClinicID: "cl-1", NoShowAction: string(NoShowActionBlockSelfBooking), NoShowThreshold: 2, NoShowWindowMonths: 3},
+		},
+	}
+	svc := &service{repo: repo}
+
+	join := JoinWaitlistDTO{PatientId: "pa-ana", PhysicianId: physician, ClinicId: "cl-1",
+		PreferredFrom: start.AddDate(0, 0, -1), PreferredTo: start.AddDate(0, 0, 1), SelfJoined: true}
+	if _, err := svc.JoinWaitlist(join); !errors.Is(err, ErrBookingPolicy) {
+		t.Fatalf("joining while blocked: got %v, want ErrBookingPolicy", err)
+	}
+	join.SelfJoined = false
+	if _, err := svc.JoinWaitlist(join); err != nil {
+		t.Fatalf("staff adding the patient: got %v, want nil", err)
+	}
+
+	// The patient joined before they were blocked, and is then offered a slot.
+	entry := &WaitlistEntry{ID: "wait-ana", PatientId: "pa-ana", PhysicianId: &physician, ClinicId: "cl-1",
+		PreferredFrom: join.PreferredFrom, PreferredTo: join.PreferredTo, Status: string(WaitlistStatusOffered), SelfJoined: true}
+	repo.waitlist = append(repo.waitlist, entry)
+	offer := func(token string) {
+		repo.offers = append(repo.offers, &SlotOffer{ID: "offer-" + token, WaitlistEntryID: entry.ID, PhysicianId: physician,
+			StartsAt: start, EndsAt: start.Add(30 * time.Minute), TokenHash: utils.HashToken(token),
+			Status: string(OfferStatusPending), ExpiresAt: time.Now().Add(time.Hour)})
+	}
+
+	offer("blocked")
+	if _, err := svc.ClaimOffer("blocked"); !errors.Is(err, ErrBookingPolicy) {
+		t.Fatalf("claiming while blocked: got %v, want ErrBookingPolicy", err)
+	}
+	if entry.Status != string(WaitlistStatusCancelled) {
+		t.Errorf("blocked entry status = %s, want cancelled", entry.Status)
+	}
+	if _, ok := repo.appointments["appt-pa-ana"]; ok {
+		t.Error("a blocked patient's claim should not book the slot")
+	}
+
+	repo.policies["cl-1"].NoShowAction = string(NoShowActionRequireConfirmation)
+	entry.Status = string(WaitlistStatusOffered)
+	offer("confirm")
+	appointment, err := svc.ClaimOffer("confirm")
+	if err != nil {
+		t.Fatalf("ClaimOffer() error = %v", err)
+	}
+	if appointment.Status != string(AppointmentStatusPending) {
+		t.Errorf("status = %s, want pending until staff confirm it", appointment.Status)
+	}
+}
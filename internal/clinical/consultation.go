@@ -0,0 +1,27 @@
+package clinical
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrAppointmentLink is returned when a consultation cannot fulfil the
+// appointment it names.
+var ErrAppointmentLink = errors.New("the consultation cannot fulfil this appointment")
+
+// ConsultationLink ties a new consultation to the appointment it fulfils.
+type ConsultationLink struct {
+	AppointmentID  string
+	ConsultationID string
+	PatientID      string
+	PhysicianID    string
+	ActorUserID    string
+}
+
+// AppointmentCompleter completes the appointment a consultation fulfils inside
+// the transaction that creates the consultation, so both are saved or neither
+// is. The appointments package implements it.
+type AppointmentCompleter interface {
+	CompleteWithConsultation(tx *gorm.DB, link ConsultationLink) error
+}
@@ -1,9 +1,12 @@
 package clinical
 
 import (
+	"Altheia-Backend/internal/audit"
+	"errors"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
@@ -24,6 +27,11 @@ func (h *Handler) CreateClinical(c *fiber.Ctx) error {
 
 	createClinicError := h.service.CreateClinical(createClinicDto)
 
+	if errors.Is(createClinicError, ErrInvalidTimezone) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": createClinicError.Error(),
+		})
+	}
 	if createClinicError != nil {
 		return createClinicError
 	}
@@ -140,6 +148,36 @@ func (h *Handler) GetClinicByID(c *fiber.Ctx) error {
 	return c.JSON(clinicInfo)
 }
 
+func (h *Handler) UpdateClinicTimezone(c *fiber.Ctx) error {
+	var dto UpdateTimezoneDTO
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	err := h.service.UpdateClinicTimezone(c.Params("clinicId"), dto.Timezone)
+	switch {
+	case errors.Is(err, ErrInvalidTimezone):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "clinic not found",
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "clinic timezone updated successfully",
+		"timezone": dto.Timezone,
+	})
+}
+
 func (h *Handler) AssignServicesToClinic(c *fiber.Ctx) error {
 	var dto AssignServicesClinicDTO
 	if err := c.BodyParser(&dto); err != nil {
@@ -192,6 +230,33 @@ func (h *Handler) GetClinicsByEps(c *fiber.Ctx) error {
 	return c.JSON(clinics)
 }
 
+// SearchClinics answers GET /portal/clinics?eps_id=&service=&city=, the
+// directory patients pick a clinic from. Only active clinics are listed.
+func (h *Handler) SearchClinics(c *fiber.Ctx) error {
+	page, errPage := strconv.Atoi(c.Query("page", "1"))
+	size, errSize := strconv.Atoi(c.Query("size", "10"))
+
+	if errPage != nil || errSize != nil || page < 1 || size < 1 || size > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "invalid pagination parameters",
+		})
+	}
+
+	clinics, err := h.service.SearchClinics(ClinicSearch{
+		EpsID:      c.Query("eps_id"),
+		Service:    c.Query("service"),
+		City:       c.Query("city"),
+		ActiveOnly: true,
+	}, page, size)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(clinics)
+}
+
 func (h *Handler) GetClinicPersonnel(c *fiber.Ctx) error {
 	clinicID := c.Params("clinicId")
 	if clinicID == "" {
@@ -250,6 +315,7 @@ func (h *Handler) CreateMedicalHistory(c *fiber.Ctx) error {
 		})
 	}
 
+	dto.AuthorUserId, _ = c.Locals("user_id").(string)
 	response, err := h.service.CreateMedicalHistoryComprehensive(dto)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -275,16 +341,26 @@ func (h *Handler) CreateConsultation(c *fiber.Ctx) error {
 		})
 	}
 
-	err := h.service.CreateConsultation(dto)
+	dto.ActorUserId, _ = c.Locals("user_id").(string)
+	consultation, err := h.service.CreateConsultation(dto)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(err, ErrAppointmentLink):
+			status = fiber.StatusConflict
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
 	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Consultation created successfully",
+		"success":         true,
+		"message":         "Consultation created successfully",
+		"consultation_id": consultation.ID,
+		"appointment_id":  consultation.AppointmentId,
 	})
 }
 
@@ -305,16 +381,86 @@ func (h *Handler) UpdateMedicalHistory(c *fiber.Ctx) error {
 		})
 	}
 
-	err := h.service.UpdateMedicalHistory(historyID, dto)
+	dto.AuthorUserId, _ = c.Locals("user_id").(string)
+	version, err := h.service.UpdateMedicalHistory(historyID, dto)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return versionError(c, err)
 	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Medical history updated successfully",
+		"version": version.Version,
+	})
+}
+
+func (h *Handler) AmendConsultation(c *fiber.Ctx) error {
+	var dto AmendConsultationDTO
+
+	if err := c.BodyParser(&dto); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	dto.AuthorUserId, _ = c.Locals("user_id").(string)
+	version, err := h.service.AmendConsultation(c.Params("consultationId"), dto)
+	if err != nil {
+		return versionError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "Consultation amended successfully",
+		"version": version.Version,
+	})
+}
+
+func (h *Handler) GetMedicalHistoryVersions(c *fiber.Ctx) error {
+	versions, err := h.service.GetMedicalHistoryVersions(c.Params("historyId"))
+	if err != nil {
+		return versionError(c, err)
+	}
+	return c.JSON(versions)
+}
+
+// DiffMedicalHistoryVersions answers GET /medical-history/versions/:historyId/diff?from=&to=.
+func (h *Handler) DiffMedicalHistoryVersions(c *fiber.Ctx) error {
+	diff, err := h.service.DiffMedicalHistoryVersions(c.Params("historyId"), c.QueryInt("from"), c.QueryInt("to"))
+	if err != nil {
+		return versionError(c, err)
+	}
+	return c.JSON(diff)
+}
+
+func (h *Handler) GetConsultationVersions(c *fiber.Ctx) error {
+	versions, err := h.service.GetConsultationVersions(c.Params("consultationId"))
+	if err != nil {
+		return versionError(c, err)
+	}
+	return c.JSON(versions)
+}
+
+// DiffConsultationVersions answers GET /medical-history/consultation/versions/:consultationId/diff?from=&to=.
+func (h *Handler) DiffConsultationVersions(c *fiber.Ctx) error {
+	diff, err := h.service.DiffConsultationVersions(c.Params("consultationId"), c.QueryInt("from"), c.QueryInt("to"))
+	if err != nil {
+		return versionError(c, err)
+	}
+	return c.JSON(diff)
+}
+
+// versionError answers an amendment or version request that failed.
+func versionError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrAmendmentReason), errors.Is(err, ErrNoChanges), errors.Is(err, ErrInvalidVersions):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		status = fiber.StatusNotFound
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
 	})
 }
 
@@ -343,6 +489,12 @@ func (h *Handler) GetClinicMedicalHistoriesPaginated(c *fiber.Ctx) error {
 		})
 	}
 
+	patientIds := make([]string, 0, len(response.Data))
+	for _, record := range response.Data {
+		patientIds = append(patientIds, record.Patient.ID)
+	}
+	audit.SetPatients(c, patientIds)
+
 	return c.JSON(response)
 }
 
@@ -21,6 +21,7 @@ type CreateClinicDTO struct {
 	City       string `json:"city"`
 	State      string `json:"state"`
 	PostalCode string `json:"postal_code"`
+	Timezone   string `json:"timezone"`
 
 	MemberCount     int      `json:"member_count"`
 	ServicesOffered []string `json:"services_offered"`
@@ -28,6 +29,10 @@ type CreateClinicDTO struct {
 	AcceptedEPS []string `json:"accepted_eps"`
 }
 
+type UpdateTimezoneDTO struct {
+	Timezone string `json:"timezone"`
+}
+
 type CreateEpsDto struct {
 	Eps []string `json:"eps"`
 }
@@ -42,6 +47,17 @@ type ClinicCompleteInfoResponse struct {
 	Information ClinicInformation `json:"information"`
 }
 
+// ClinicSearch filters the clinic directory. Empty fields do not filter.
+type ClinicSearch struct {
+	EpsID string
+	// Service matches a service offered by id or by name, ignoring case.
+	Service string
+	// City matches the clinic's city, ignoring case.
+	City string
+	// ActiveOnly leaves out deactivated clinics.
+	ActiveOnly bool
+}
+
 type AssignServicesClinicDTO struct {
 	ClinicID string   `json:"clinic_id"`
 	Services []string `json:"services"`
@@ -16,6 +16,8 @@ type MedicalHistory struct {
 	Allergies     string    `json:"allergies"`
 	Observations  string    `json:"observations"`
 	LastUpdate    time.Time `json:"last_update"`
+	// Version is the number of the current MedicalHistoryVersion.
+	Version int `gorm:"not null;default:1" json:"version"`
 
 	Patient       users.Patient         `gorm:"foreignKey:PatientId"`
 	Consultations []MedicalConsultation `gorm:"foreignKey:MedicalHistoryId"`
@@ -26,14 +28,18 @@ type MedicalHistory struct {
 }
 
 type MedicalConsultation struct {
-	ID               string    `gorm:"primaryKey" json:"id"`
-	MedicalHistoryId string    `json:"medical_history_id"`
-	PhysicianId      string    `json:"physician_id"`
-	ConsultDate      time.Time `json:"consult_date"`
-	Symptoms         string    `json:"symptoms"`
-	Diagnosis        string    `json:"diagnosis"`
-	Treatment        string    `json:"treatment"`
-	Notes            string    `json:"notes"`
+	ID               string `gorm:"primaryKey" json:"id"`
+	MedicalHistoryId string `json:"medical_history_id"`
+	PhysicianId      string `json:"physician_id"`
+	// AppointmentId is the appointment the consultation fulfils, if any.
+	AppointmentId *string   `gorm:"uniqueIndex" json:"appointment_id"`
+	ConsultDate   time.Time `json:"consult_date"`
+	Symptoms      string    `json:"symptoms"`
+	Diagnosis     string    `json:"diagnosis"`
+	Treatment     string    `json:"treatment"`
+	Notes         string    `json:"notes"`
+	// Version is the number of the current MedicalConsultationVersion.
+	Version int `gorm:"not null;default:1" json:"version"`
 
 	MedicalHistory MedicalHistory        `gorm:"foreignKey:MedicalHistoryId"`
 	Physician      users.Physician       `gorm:"foreignKey:PhysicianId"`
@@ -102,6 +108,8 @@ type ClinicInformation struct {
 	State      string `json:"state"`
 	PostalCode string `json:"postal_code,omitempty"`
 	Country    string `json:"country"`
+	// Timezone is the IANA zone the clinic's wall-clock times are in.
+	Timezone string `gorm:"not null;default:'America/Bogota'" json:"timezone"`
 }
 
 type EPS struct {
@@ -150,26 +158,37 @@ type CreateMedicalHistoryDTO struct {
 	Observations  string                  `json:"observations"`
 	Prescriptions []CreatePrescriptionDTO `json:"prescriptions,omitempty"`
 	Documents     []CreateDocumentDTO     `json:"documents,omitempty"`
+
+	// AuthorUserId is the signed-in user creating the history.
+	AuthorUserId string `json:"-"`
 }
 
+// UpdateMedicalHistoryDTO amends a medical history. Every field is stored as
+// given, and Reason explains the correction.
 type UpdateMedicalHistoryDTO struct {
 	ConsultReason string `json:"consult_reason"`
 	PersonalInfo  string `json:"personal_info"`
 	FamilyInfo    string `json:"family_info"`
 	Allergies     string `json:"allergies"`
 	Observations  string `json:"observations"`
+	Reason        string `json:"reason"`
+
+	// AuthorUserId is the signed-in user making the amendment.
+	AuthorUserId string `json:"-"`
 }
 
 type CreateConsultationDTO struct {
-	MedicalHistoryId *string                 `json:"medical_history_id"`
-	PatientId        string                  `json:"patient_id" validate:"required"`
-	PhysicianId      string                  `json:"physician_id" validate:"required"`
-	Symptoms         string                  `json:"symptoms"`
-	Diagnosis        string                  `json:"diagnosis"`
-	Treatment        string                  `json:"treatment"`
-	Notes            string                  `json:"notes"`
-	Prescriptions    []CreatePrescriptionDTO `json:"prescriptions,omitempty"`
-	Documents        []CreateDocumentDTO     `json:"documents,omitempty"`
+	MedicalHistoryId *string `json:"medical_history_id"`
+	PatientId        string  `json:"patient_id" validate:"required"`
+	PhysicianId      string  `json:"physician_id" validate:"required"`
+	// AppointmentId, when set, is completed together with the consultation.
+	AppointmentId *string                 `json:"appointment_id,omitempty"`
+	Symptoms      string                  `json:"symptoms"`
+	Diagnosis     string                  `json:"diagnosis"`
+	Treatment     string                  `json:"treatment"`
+	Notes         string                  `json:"notes"`
+	Prescriptions []CreatePrescriptionDTO `json:"prescriptions,omitempty"`
+	Documents     []CreateDocumentDTO     `json:"documents,omitempty"`
 
 	UpdateMedicalHistory bool   `json:"update_medical_history,omitempty"`
 	ConsultReason        string `json:"consult_reason,omitempty"`
@@ -177,6 +196,9 @@ type CreateConsultationDTO struct {
 	FamilyInfo           string `json:"family_info,omitempty"`
 	Allergies            string `json:"allergies,omitempty"`
 	Observations         string `json:"observations,omitempty"`
+
+	// ActorUserId is the signed-in user creating the consultation.
+	ActorUserId string `json:"-"`
 }
 
 type CreatePrescriptionDTO struct {
@@ -206,6 +228,7 @@ type MedicalHistoryResponseDTO struct {
 	Allergies          string                            `json:"allergies"`
 	Observations       string                            `json:"observations"`
 	LastUpdate         time.Time                         `json:"last_update"`
+	Version            int                               `json:"version"`
 	CreatedAt          time.Time                         `json:"created_at"`
 	UpdatedAt          time.Time                         `json:"updated_at"`
 	Consultations      []EnhancedConsultationResponseDTO `json:"consultations"`
@@ -254,10 +277,12 @@ type ConsultationMetadata struct {
 type EnhancedConsultationResponseDTO struct {
 	ID               string                    `json:"id"`
 	MedicalHistoryId string                    `json:"medical_history_id"`
+	AppointmentId    *string                   `json:"appointment_id"`
 	Symptoms         string                    `json:"symptoms"`
 	Diagnosis        string                    `json:"diagnosis"`
 	Treatment        string                    `json:"treatment"`
 	Notes            string                    `json:"notes"`
+	Version          int                       `json:"version"`
 	PhysicianInfo    PhysicianInfoDTO          `json:"physician_info"`
 	Metadata         ConsultationMetadata      `json:"metadata"`
 	Prescriptions    []PrescriptionResponseDTO `json:"prescriptions"`
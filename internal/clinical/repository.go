@@ -1,9 +1,14 @@
 package clinical
 
 import (
+	"Altheia-Backend/internal/audit"
+	"Altheia-Backend/internal/mail"
 	"Altheia-Backend/internal/users"
 	"Altheia-Backend/pkg/utils"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	gonanoid "github.com/matoous/go-nanoid"
@@ -12,6 +17,7 @@ import (
 
 type Repository interface {
 	CreateClinic(createClinicDto CreateClinicDTO) error
+	UpdateClinicTimezone(clinicID, timezone string) error
 	CreateEps(epsDto CreateEpsDto) error
 	GetAllEps(page int, pagSize int) ([]EPS, error)
 	GetAllServices(page int, pagSize int) ([]ServicesOffered, error)
@@ -20,15 +26,21 @@ type Repository interface {
 	GetClinicByID(clinicID string) (*ClinicCompleteInfoResponse, error)
 	AssignServicesToClinic(dto AssignServicesClinicDTO) error
 	GetClinicsByEps(epsID string, page int, pageSize int) ([]Clinic, error)
+	SearchClinics(search ClinicSearch, page int, pageSize int) ([]Clinic, error)
 	GetClinicPersonnel(clinicID string) ([]users.User, error)
 
 	GetMedicalHistoryByPatientID(patientID string) (*MedicalHistoryResponseDTO, error)
 	GetMedicalHistoryComprehensive(patientID string) (*ComprehensiveMedicalRecordsResponse, error)
 	CreateMedicalHistory(dto CreateMedicalHistoryDTO) error
 	CreateMedicalHistoryComprehensive(dto CreateMedicalHistoryDTO) (*ComprehensiveMedicalRecordsResponse, error)
-	CreateConsultation(dto CreateConsultationDTO) error
+	CreateConsultation(dto CreateConsultationDTO) (*MedicalConsultation, error)
 	GetOrCreateMedicalHistory(patientID string) (*MedicalHistory, error)
-	UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) error
+	UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) (*MedicalHistoryVersion, error)
+	AmendConsultation(consultationID string, dto AmendConsultationDTO) (*MedicalConsultationVersion, error)
+	GetMedicalHistoryVersions(historyID string) ([]MedicalHistoryVersion, error)
+	GetMedicalHistoryVersion(historyID string, version int) (*MedicalHistoryVersion, error)
+	GetConsultationVersions(consultationID string) ([]MedicalConsultationVersion, error)
+	GetConsultationVersion(consultationID string, version int) (*MedicalConsultationVersion, error)
 	GetClinicMedicalHistoriesPaginated(clinicID string, page int, pageSize int) (*PaginatedMedicalHistoriesResponse, error)
 
 	// Document methods
@@ -40,20 +52,29 @@ type Repository interface {
 
 type repository struct {
 	db *gorm.DB
+	// appointments completes the appointments consultations fulfil.
+	appointments AppointmentCompleter
+	audit        audit.Repository
 }
 
-func NewRepository(db *gorm.DB) Repository {
-	return &repository{db}
+func NewRepository(db *gorm.DB, appointments AppointmentCompleter) Repository {
+	return &repository{db: db, appointments: appointments, audit: audit.NewRepository(db)}
 }
 
 func (r *repository) CreateClinic(createClinicDto CreateClinicDTO) error {
 
-	tempUserPassword, _ := utils.GeneratePassword(10)
+	// The owner chooses their own password through the emailed link; the
+	// random one only keeps the account unusable until then.
+	tempUserPassword, _ := utils.GeneratePassword(32)
 	nanoid, _ := gonanoid.Nanoid()
-	fmt.Println(tempUserPassword)
 	hashed, _ := utils.HashPassword(tempUserPassword)
 
-	err := r.db.Transaction(func(tx *gorm.DB) error {
+	setPasswordToken, setPassword, err := users.NewUserToken(nanoid, users.TokenPurposeSetPassword, users.SetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	err = r.db.Transaction(func(tx *gorm.DB) error {
 
 		newUser := &users.User{
 			ID:             nanoid,
@@ -102,6 +123,7 @@ func (r *repository) CreateClinic(createClinicDto CreateClinicDTO) error {
 				State:             createClinicDto.State,
 				PostalCode:        createClinicDto.PostalCode,
 				Country:           createClinicDto.Country,
+				Timezone:          createClinicDto.Timezone,
 			},
 		}
 
@@ -121,6 +143,10 @@ func (r *repository) CreateClinic(createClinicDto CreateClinicDTO) error {
 			return userError
 		}
 
+		if tokenError := tx.Create(setPassword).Error; tokenError != nil {
+			return tokenError
+		}
+
 		err := tx.Create(newClinic).Error
 		if err != nil {
 			return err
@@ -175,6 +201,12 @@ func (r *repository) CreateClinic(createClinicDto CreateClinicDTO) error {
 		return err
 	}
 
+	// The clinic exists at this point, so a failed email must not make the
+	// request fail and be retried: the owner can ask for a new link through
+	// /auth/resend-verification.
+	if err := mail.SendSetPasswordEmail(createClinicDto.OwnerName, createClinicDto.OwnerEmail, setPasswordToken); err != nil {
+		log.Printf("failed to send the set-password link to clinic owner %s: %v", nanoid, err)
+	}
 	return nil
 }
 
@@ -294,6 +326,17 @@ func (r *repository) GetClinicByID(clinicID string) (*ClinicCompleteInfoResponse
 	return response, nil
 }
 
+func (r *repository) UpdateClinicTimezone(clinicID, timezone string) error {
+	result := r.db.Model(&ClinicInformation{}).Where("clinic_id = ?", clinicID).Update("timezone", timezone)
+	if result.Error != nil {
+		return fmt.Errorf("error al actualizar la zona horaria de la clínica: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
 func (r *repository) AssignServicesToClinic(dto AssignServicesClinicDTO) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
 
@@ -318,20 +361,44 @@ func (r *repository) AssignServicesToClinic(dto AssignServicesClinicDTO) error {
 }
 
 func (r *repository) GetClinicsByEps(epsID string, page int, pageSize int) ([]Clinic, error) {
+	return r.SearchClinics(ClinicSearch{EpsID: epsID}, page, pageSize)
+}
+
+func (r *repository) SearchClinics(search ClinicSearch, page int, pageSize int) ([]Clinic, error) {
 	var clinics []Clinic
 
 	offset := (page - 1) * pageSize
 
-	subQuery := r.db.
-		Table("clinic_eps").
-		Select("clinic_information_clinic_id").
-		Where("eps_id = ?", epsID)
-
-	err := r.db.
+	query := r.db.
 		Preload("ClinicInformation.ServicesOffered").
 		Preload("ClinicInformation.EpsOffered").
-		Preload("ClinicInformation.Photos").
-		Where("id IN (?)", subQuery).
+		Preload("ClinicInformation.Photos")
+
+	if search.EpsID != "" {
+		query = query.Where("id IN (?)", r.db.
+			Table("clinic_eps").
+			Select("clinic_information_clinic_id").
+			Where("eps_id = ?", search.EpsID))
+	}
+	if search.Service != "" {
+		query = query.Where("id IN (?)", r.db.
+			Table("clinic_services cs").
+			Select("cs.clinic_information_clinic_id").
+			Joins("JOIN services_offereds so ON so.id = cs.services_offered_id").
+			Where("so.id = ? OR LOWER(so.name) = LOWER(?)", search.Service, search.Service))
+	}
+	if search.City != "" {
+		query = query.Where("id IN (?)", r.db.
+			Model(&ClinicInformation{}).
+			Select("clinic_id").
+			Where("LOWER(city) = LOWER(?)", strings.TrimSpace(search.City)))
+	}
+	if search.ActiveOnly {
+		query = query.Where("status = ?", true)
+	}
+
+	err := query.
+		Order("created_at").
 		Limit(pageSize).
 		Offset(offset).
 		Find(&clinics).Error
@@ -423,6 +490,7 @@ func (r *repository) GetMedicalHistoryByPatientID(patientID string) (*MedicalHis
 				Allergies:          "",
 				Observations:       "",
 				LastUpdate:         time.Now(),
+				Version:            0,
 				CreatedAt:          time.Now(),
 				UpdatedAt:          time.Now(),
 				Consultations:      []EnhancedConsultationResponseDTO{},
@@ -442,6 +510,7 @@ func (r *repository) GetMedicalHistoryByPatientID(patientID string) (*MedicalHis
 		Allergies:          medicalHistory.Allergies,
 		Observations:       medicalHistory.Observations,
 		LastUpdate:         medicalHistory.LastUpdate,
+		Version:            medicalHistory.Version,
 		CreatedAt:          medicalHistory.CreatedAt,
 		UpdatedAt:          medicalHistory.UpdatedAt,
 		Consultations:      []EnhancedConsultationResponseDTO{},
@@ -452,10 +521,12 @@ func (r *repository) GetMedicalHistoryByPatientID(patientID string) (*MedicalHis
 		consultationDTO := EnhancedConsultationResponseDTO{
 			ID:               consultation.ID,
 			MedicalHistoryId: consultation.MedicalHistoryId,
+			AppointmentId:    consultation.AppointmentId,
 			Symptoms:         consultation.Symptoms,
 			Diagnosis:        consultation.Diagnosis,
 			Treatment:        consultation.Treatment,
 			Notes:            consultation.Notes,
+			Version:          consultation.Version,
 			PhysicianInfo:    PhysicianInfoDTO{},
 			Metadata: ConsultationMetadata{
 				CreatedAt:   consultation.CreatedAt,
@@ -532,11 +603,15 @@ func (r *repository) CreateMedicalHistory(dto CreateMedicalHistoryDTO) error {
 			Allergies:     dto.Allergies,
 			Observations:  dto.Observations,
 			LastUpdate:    time.Now(),
+			Version:       1,
 		}
 
 		if err := tx.Create(&medicalHistory).Error; err != nil {
 			return fmt.Errorf("error creating medical history: %v", err)
 		}
+		if err := createHistoryVersion(tx, historyVersion(&medicalHistory, dto.AuthorUserId, reasonHistoryCreated)); err != nil {
+			return err
+		}
 
 		if len(dto.Prescriptions) > 0 || dto.PhysicianId != "" {
 			if len(dto.Prescriptions) > 0 && dto.PhysicianId == "" {
@@ -565,11 +640,15 @@ func (r *repository) CreateMedicalHistory(dto CreateMedicalHistoryDTO) error {
 				Diagnosis:        "",
 				Treatment:        "Initial medical history",
 				Notes:            fmt.Sprintf("Medical history created with %d prescriptions", len(dto.Prescriptions)),
+				Version:          1,
 			}
 
 			if err := tx.Create(&consultation).Error; err != nil {
 				return fmt.Errorf("error creating consultation: %v", err)
 			}
+			if err := createConsultationVersion(tx, consultationVersion(&consultation, dto.AuthorUserId, reasonConsultationCreated)); err != nil {
+				return err
+			}
 
 			for _, prescDto := range dto.Prescriptions {
 				prescriptionID, _ := gonanoid.Nanoid()
@@ -626,11 +705,42 @@ func (r *repository) buildComprehensiveResponseWithRealData(patientID string) *C
 		Data: MedicalRecordsData{
 			Patients:       patients,
 			MedicalRecords: medicalRecords,
+			AuditTrail:     r.getRealAuditTrail(patientID),
 			Metadata:       metadata,
 		},
 	}
 }
 
+// auditTrailSize is how many of the latest accesses the audit trail lists.
+const auditTrailSize = 50
+
+// getRealAuditTrail lists the latest requests to the patient's clinical data
+// recorded in the audit log.
+func (r *repository) getRealAuditTrail(patientID string) []AuditEntry {
+	result, err := r.audit.GetPatientAccesses(patientID, "", 1, auditTrailSize)
+	if err != nil {
+		return []AuditEntry{}
+	}
+	accesses, _ := result.Result.([]audit.Access)
+
+	trail := make([]AuditEntry, 0, len(accesses))
+	for _, access := range accesses {
+		user := access.UserName
+		if user == "" {
+			user = access.UserRole
+		}
+		trail = append(trail, AuditEntry{
+			ID:        access.ID,
+			RecordID:  access.ResourceID,
+			Action:    access.Action,
+			User:      user,
+			Timestamp: access.CreatedAt.Format("2006-01-02T15:04:05"),
+			Details:   fmt.Sprintf("%s (%s): %s", access.Resource, access.UserRole, access.Outcome),
+		})
+	}
+	return trail
+}
+
 func (r *repository) getRealPatients(patientID string) []PatientBasicInfo {
 	var patient users.Patient
 	if err := r.db.Preload("User").Where("id = ?", patientID).First(&patient).Error; err != nil {
@@ -757,6 +867,7 @@ func (r *repository) getRealMedicalRecords(patientID string) []MedicalRecord {
 					"description":    fmt.Sprintf("Consulta médica. Síntomas: %s. Diagnóstico: %s", consultation.Symptoms, consultation.Diagnosis),
 					"observations":   consultation.Notes,
 					"consult_reason": consultation.Treatment,
+					"appointment_id": consultation.AppointmentId,
 				},
 				Documents: []Document{},
 			}
@@ -786,8 +897,12 @@ func (r *repository) getRealMetadata(patientID string) Metadata {
 	}
 }
 
-func (r *repository) CreateConsultation(dto CreateConsultationDTO) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
+// CreateConsultation saves the consultation with its prescriptions and
+// documents and, when it fulfils an appointment, completes the appointment in
+// the same transaction.
+func (r *repository) CreateConsultation(dto CreateConsultationDTO) (*MedicalConsultation, error) {
+	var consultation MedicalConsultation
+	err := r.db.Transaction(func(tx *gorm.DB) error {
 
 		var patient users.Patient
 		if err := tx.Where("id = ?", dto.PatientId).First(&patient).Error; err != nil {
@@ -799,52 +914,72 @@ func (r *repository) CreateConsultation(dto CreateConsultationDTO) error {
 			return fmt.Errorf("physician not found: %v", err)
 		}
 
-		medicalHistory, err := r.getOrCreateMedicalHistoryTx(tx, dto.PatientId)
+		medicalHistory, err := r.getOrCreateMedicalHistoryTx(tx, dto.PatientId, dto.ActorUserId)
 		if err != nil {
 			return err
 		}
 
+		consultationID, _ := gonanoid.Nanoid()
 		if dto.UpdateMedicalHistory {
-			updates := map[string]interface{}{}
+			if medicalHistory, err = lockMedicalHistory(tx, medicalHistory.ID); err != nil {
+				return err
+			}
+
+			// Only the fields given replace the current text.
+			next := historyVersion(medicalHistory, dto.ActorUserId, "Actualizada en la consulta "+consultationID)
 			if dto.ConsultReason != "" {
-				updates["consult_reason"] = dto.ConsultReason
+				next.ConsultReason = dto.ConsultReason
 			}
 			if dto.PersonalInfo != "" {
-				updates["personal_info"] = dto.PersonalInfo
+				next.PersonalInfo = dto.PersonalInfo
 			}
 			if dto.FamilyInfo != "" {
-				updates["family_info"] = dto.FamilyInfo
+				next.FamilyInfo = dto.FamilyInfo
 			}
 			if dto.Allergies != "" {
-				updates["allergies"] = dto.Allergies
+				next.Allergies = dto.Allergies
 			}
 			if dto.Observations != "" {
-				updates["observations"] = dto.Observations
+				next.Observations = dto.Observations
 			}
 
-			if len(updates) > 0 {
-				updates["last_update"] = time.Now()
-				if err := tx.Model(&medicalHistory).Updates(updates).Error; err != nil {
-					return fmt.Errorf("error updating medical history: %v", err)
-				}
+			if _, err := amendHistoryTx(tx, medicalHistory, next); err != nil && !errors.Is(err, ErrNoChanges) {
+				return err
 			}
 		}
 
-		consultationID, _ := gonanoid.Nanoid()
-		consultation := MedicalConsultation{
+		consultation = MedicalConsultation{
 			ID:               consultationID,
 			MedicalHistoryId: medicalHistory.ID,
 			PhysicianId:      dto.PhysicianId,
+			AppointmentId:    dto.AppointmentId,
 			ConsultDate:      time.Now(),
 			Symptoms:         dto.Symptoms,
 			Diagnosis:        dto.Diagnosis,
 			Treatment:        dto.Treatment,
 			Notes:            dto.Notes,
+			Version:          1,
 		}
 
 		if err := tx.Create(&consultation).Error; err != nil {
 			return fmt.Errorf("error creating consultation: %v", err)
 		}
+		if err := createConsultationVersion(tx, consultationVersion(&consultation, dto.ActorUserId, reasonConsultationCreated)); err != nil {
+			return err
+		}
+
+		if dto.AppointmentId != nil {
+			err := r.appointments.CompleteWithConsultation(tx, ConsultationLink{
+				AppointmentID:  *dto.AppointmentId,
+				ConsultationID: consultationID,
+				PatientID:      dto.PatientId,
+				PhysicianID:    dto.PhysicianId,
+				ActorUserID:    dto.ActorUserId,
+			})
+			if err != nil {
+				return err
+			}
+		}
 
 		for _, prescDto := range dto.Prescriptions {
 			prescriptionID, _ := gonanoid.Nanoid()
@@ -880,13 +1015,17 @@ func (r *repository) CreateConsultation(dto CreateConsultationDTO) error {
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &consultation, nil
 }
 
 func (r *repository) GetOrCreateMedicalHistory(patientID string) (*MedicalHistory, error) {
-	return r.getOrCreateMedicalHistoryTx(r.db, patientID)
+	return r.getOrCreateMedicalHistoryTx(r.db, patientID, "")
 }
 
-func (r *repository) getOrCreateMedicalHistoryTx(tx *gorm.DB, patientID string) (*MedicalHistory, error) {
+func (r *repository) getOrCreateMedicalHistoryTx(tx *gorm.DB, patientID, authorUserId string) (*MedicalHistory, error) {
 	var medicalHistory MedicalHistory
 
 	err := tx.Where("patient_id = ?", patientID).First(&medicalHistory).Error
@@ -903,41 +1042,19 @@ func (r *repository) getOrCreateMedicalHistoryTx(tx *gorm.DB, patientID string)
 		ID:         nanoid,
 		PatientId:  patientID,
 		LastUpdate: time.Now(),
+		Version:    1,
 	}
 
 	if err := tx.Create(&medicalHistory).Error; err != nil {
 		return nil, fmt.Errorf("error creating medical history: %v", err)
 	}
+	if err := createHistoryVersion(tx, historyVersion(&medicalHistory, authorUserId, reasonHistoryCreated)); err != nil {
+		return nil, err
+	}
 
 	return &medicalHistory, nil
 }
 
-func (r *repository) UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-
-		var medicalHistory MedicalHistory
-		if err := tx.Where("id = ?", historyID).First(&medicalHistory).Error; err != nil {
-			return fmt.Errorf("medical history not found: %v", err)
-		}
-
-		updates := map[string]interface{}{
-			"last_update": time.Now(),
-		}
-
-		updates["consult_reason"] = dto.ConsultReason
-		updates["personal_info"] = dto.PersonalInfo
-		updates["family_info"] = dto.FamilyInfo
-		updates["allergies"] = dto.Allergies
-		updates["observations"] = dto.Observations
-
-		if err := tx.Model(&medicalHistory).Updates(updates).Error; err != nil {
-			return fmt.Errorf("error updating medical history: %v", err)
-		}
-
-		return nil
-	})
-}
-
 func (r *repository) GetClinicMedicalHistoriesPaginated(clinicID string, page int, pageSize int) (*PaginatedMedicalHistoriesResponse, error) {
 
 	var patients []users.Patient
@@ -2,6 +2,7 @@ package clinical
 
 type Service interface {
 	CreateClinical(createClinicDto CreateClinicDTO) error
+	UpdateClinicTimezone(clinicID, timezone string) error
 	CreateEps(epsDto CreateEpsDto) error
 	GetAllEps(page int, pagSize int) ([]EPS, error)
 	CreateServicesOffered(servicesOffered CreateServicesDto) error
@@ -10,14 +11,20 @@ type Service interface {
 	GetClinicByID(clinicID string) (*ClinicCompleteInfoResponse, error)
 	AssignServicesToClinic(dto AssignServicesClinicDTO) error
 	GetClinicsByEps(epsID string, page int, pageSize int) ([]Clinic, error)
+	SearchClinics(search ClinicSearch, page int, pageSize int) ([]Clinic, error)
 	GetClinicPersonnel(clinicID string) (ClinicPersonnelResponse, error)
 
 	GetMedicalHistoryByPatientID(patientID string) (*MedicalHistoryResponseDTO, error)
 	GetMedicalHistoryComprehensive(patientID string) (*ComprehensiveMedicalRecordsResponse, error)
 	CreateMedicalHistory(dto CreateMedicalHistoryDTO) error
 	CreateMedicalHistoryComprehensive(dto CreateMedicalHistoryDTO) (*ComprehensiveMedicalRecordsResponse, error)
-	CreateConsultation(dto CreateConsultationDTO) error
-	UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) error
+	CreateConsultation(dto CreateConsultationDTO) (*MedicalConsultation, error)
+	UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) (*MedicalHistoryVersion, error)
+	AmendConsultation(consultationID string, dto AmendConsultationDTO) (*MedicalConsultationVersion, error)
+	GetMedicalHistoryVersions(historyID string) ([]MedicalHistoryVersion, error)
+	DiffMedicalHistoryVersions(historyID string, from, to int) (*VersionDiff, error)
+	GetConsultationVersions(consultationID string) ([]MedicalConsultationVersion, error)
+	DiffConsultationVersions(consultationID string, from, to int) (*VersionDiff, error)
 	GetClinicMedicalHistoriesPaginated(clinicID string, page int, pageSize int) (*PaginatedMedicalHistoriesResponse, error)
 
 	// Document methods
@@ -36,6 +43,13 @@ func NewService(r Repository) Service {
 }
 
 func (s *service) CreateClinical(createClinicDto CreateClinicDTO) error {
+	if createClinicDto.Timezone == "" {
+		createClinicDto.Timezone = DefaultTimezone
+	}
+	if _, err := LoadTimezone(createClinicDto.Timezone); err != nil {
+		return err
+	}
+
 	err := s.repo.CreateClinic(createClinicDto)
 	if err != nil {
 		return err
@@ -85,6 +99,15 @@ func (s *service) GetClinicByID(clinicID string) (*ClinicCompleteInfoResponse, e
 	return s.repo.GetClinicByID(clinicID)
 }
 
+// UpdateClinicTimezone changes the zone the clinic's schedule and appointments
+// are read and shown in. Appointments keep their instant in time.
+func (s *service) UpdateClinicTimezone(clinicID, timezone string) error {
+	if _, err := LoadTimezone(timezone); err != nil || timezone == "" {
+		return ErrInvalidTimezone
+	}
+	return s.repo.UpdateClinicTimezone(clinicID, timezone)
+}
+
 func (s *service) AssignServicesToClinic(dto AssignServicesClinicDTO) error {
 	err := s.repo.AssignServicesToClinic(dto)
 	if err != nil {
@@ -97,6 +120,10 @@ func (s *service) GetClinicsByEps(epsID string, page int, pageSize int) ([]Clini
 	return s.repo.GetClinicsByEps(epsID, page, pageSize)
 }
 
+func (s *service) SearchClinics(search ClinicSearch, page int, pageSize int) ([]Clinic, error) {
+	return s.repo.SearchClinics(search, page, pageSize)
+}
+
 func (s *service) GetClinicPersonnel(clinicID string) (ClinicPersonnelResponse, error) {
 	users, err := s.repo.GetClinicPersonnel(clinicID)
 	if err != nil {
@@ -186,20 +213,67 @@ func (s *service) CreateMedicalHistoryComprehensive(dto CreateMedicalHistoryDTO)
 	return response, nil
 }
 
-func (s *service) CreateConsultation(dto CreateConsultationDTO) error {
-	err := s.repo.CreateConsultation(dto)
+func (s *service) CreateConsultation(dto CreateConsultationDTO) (*MedicalConsultation, error) {
+	if dto.AppointmentId != nil && *dto.AppointmentId == "" {
+		dto.AppointmentId = nil
+	}
+	return s.repo.CreateConsultation(dto)
+}
+
+// UpdateMedicalHistory amends the medical history. The text it replaces is
+// kept as an earlier version.
+func (s *service) UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) (*MedicalHistoryVersion, error) {
+	if err := checkAmendment(dto.Reason); err != nil {
+		return nil, err
+	}
+	return s.repo.UpdateMedicalHistory(historyID, dto)
+}
+
+// AmendConsultation corrects the consultation. The text it replaces is kept
+// as an earlier version.
+func (s *service) AmendConsultation(consultationID string, dto AmendConsultationDTO) (*MedicalConsultationVersion, error) {
+	if err := checkAmendment(dto.Reason); err != nil {
+		return nil, err
+	}
+	return s.repo.AmendConsultation(consultationID, dto)
+}
+
+func (s *service) GetMedicalHistoryVersions(historyID string) ([]MedicalHistoryVersion, error) {
+	return s.repo.GetMedicalHistoryVersions(historyID)
+}
+
+func (s *service) DiffMedicalHistoryVersions(historyID string, from, to int) (*VersionDiff, error) {
+	if err := checkVersions(from, to); err != nil {
+		return nil, err
+	}
+	fromVersion, err := s.repo.GetMedicalHistoryVersion(historyID, from)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	toVersion, err := s.repo.GetMedicalHistoryVersion(historyID, to)
+	if err != nil {
+		return nil, err
+	}
+	return &VersionDiff{From: from, To: to, Changes: diffFields(fromVersion.fields(), toVersion.fields())}, nil
 }
 
-func (s *service) UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) error {
-	err := s.repo.UpdateMedicalHistory(historyID, dto)
+func (s *service) GetConsultationVersions(consultationID string) ([]MedicalConsultationVersion, error) {
+	return s.repo.GetConsultationVersions(consultationID)
+}
+
+func (s *service) DiffConsultationVersions(consultationID string, from, to int) (*VersionDiff, error) {
+	if err := checkVersions(from, to); err != nil {
+		return nil, err
+	}
+	fromVersion, err := s.repo.GetConsultationVersion(consultationID, from)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	toVersion, err := s.repo.GetConsultationVersion(consultationID, to)
+	if err != nil {
+		return nil, err
+	}
+	return &VersionDiff{From: from, To: to, Changes: diffFields(fromVersion.fields(), toVersion.fields())}, nil
 }
 
 func (s *service) GetClinicMedicalHistoriesPaginated(clinicID string, page int, pageSize int) (*PaginatedMedicalHistoriesResponse, error) {
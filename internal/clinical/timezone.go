@@ -0,0 +1,25 @@
+package clinical
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultTimezone is the time zone of clinics that have not set one. Every
+// clinic was in it before clinics had their own.
+const DefaultTimezone = "America/Bogota"
+
+var ErrInvalidTimezone = errors.New("invalid time zone: use an IANA name such as America/Bogota")
+
+// LoadTimezone loads a clinic's IANA time zone. An empty name is the default
+// zone.
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		name = DefaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, ErrInvalidTimezone
+	}
+	return loc, nil
+}
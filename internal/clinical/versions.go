@@ -0,0 +1,414 @@
+package clinical
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAmendmentReason = errors.New("an amendment needs a reason")
+	ErrNoChanges       = errors.New("the amendment does not change anything")
+	ErrInvalidVersions = errors.New("invalid versions")
+)
+
+// Reasons recorded for versions the system writes itself.
+const (
+	reasonHistoryCreated      = "Creación de la historia clínica"
+	reasonConsultationCreated = "Registro de la consulta"
+	reasonBackfilled          = "Registro anterior al historial de versiones"
+)
+
+// MedicalHistoryVersion is one recorded state of a medical history. Versions
+// are only ever added: an amendment stores the corrected history as a new
+// version and every earlier one is kept as it was.
+type MedicalHistoryVersion struct {
+	ID               string `gorm:"primaryKey" json:"id"`
+	MedicalHistoryId string `gorm:"not null;uniqueIndex:idx_medical_history_version" json:"medical_history_id"`
+	Version          int    `gorm:"not null;uniqueIndex:idx_medical_history_version" json:"version"`
+	ConsultReason    string `json:"consult_reason"`
+	PersonalInfo     string `json:"personal_info"`
+	FamilyInfo       string `json:"family_info"`
+	Allergies        string `json:"allergies"`
+	Observations     string `json:"observations"`
+	// AuthorUserID is the user who recorded the version; it is empty for
+	// versions written by the system.
+	AuthorUserID string    `json:"author_user_id"`
+	Reason       string    `gorm:"not null" json:"reason"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// MedicalConsultationVersion is one recorded state of a consultation, kept
+// like MedicalHistoryVersion.
+type MedicalConsultationVersion struct {
+	ID             string    `gorm:"primaryKey" json:"id"`
+	ConsultationId string    `gorm:"not null;uniqueIndex:idx_medical_consultation_version" json:"consultation_id"`
+	Version        int       `gorm:"not null;uniqueIndex:idx_medical_consultation_version" json:"version"`
+	Symptoms       string    `json:"symptoms"`
+	Diagnosis      string    `json:"diagnosis"`
+	Treatment      string    `json:"treatment"`
+	Notes          string    `json:"notes"`
+	AuthorUserID   string    `json:"author_user_id"`
+	Reason         string    `gorm:"not null" json:"reason"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// AmendConsultationDTO corrects the clinical text of a consultation. Every
+// field is stored as given.
+type AmendConsultationDTO struct {
+	Symptoms  string `json:"symptoms"`
+	Diagnosis string `json:"diagnosis"`
+	Treatment string `json:"treatment"`
+	Notes     string `json:"notes"`
+	Reason    string `json:"reason"`
+
+	// AuthorUserId is the signed-in user making the amendment.
+	AuthorUserId string `json:"-"`
+}
+
+// FieldChange is a field that differs between two versions.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// VersionDiff lists the fields changed from one version to another.
+type VersionDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// versionField is a named field of a version, in display order.
+type versionField struct {
+	name  string
+	value string
+}
+
+func (v *MedicalHistoryVersion) fields() []versionField {
+	return []versionField{
+		{"consult_reason", v.ConsultReason},
+		{"personal_info", v.PersonalInfo},
+		{"family_info", v.FamilyInfo},
+		{"allergies", v.Allergies},
+		{"observations", v.Observations},
+	}
+}
+
+func (v *MedicalConsultationVersion) fields() []versionField {
+	return []versionField{
+		{"symptoms", v.Symptoms},
+		{"diagnosis", v.Diagnosis},
+		{"treatment", v.Treatment},
+		{"notes", v.Notes},
+	}
+}
+
+// diffFields returns the fields whose value changed from one version to the
+// other. Both must list the same fields in the same order.
+func diffFields(from, to []versionField) []FieldChange {
+	changes := []FieldChange{}
+	for i := range from {
+		if from[i].value != to[i].value {
+			changes = append(changes, FieldChange{Field: from[i].name, From: from[i].value, To: to[i].value})
+		}
+	}
+	return changes
+}
+
+// historyVersion snapshots the current state of the medical history.
+func historyVersion(history *MedicalHistory, authorUserId, reason string) MedicalHistoryVersion {
+	return MedicalHistoryVersion{
+		MedicalHistoryId: history.ID,
+		Version:          history.Version,
+		ConsultReason:    history.ConsultReason,
+		PersonalInfo:     history.PersonalInfo,
+		FamilyInfo:       history.FamilyInfo,
+		Allergies:        history.Allergies,
+		Observations:     history.Observations,
+		AuthorUserID:     authorUserId,
+		Reason:           reason,
+	}
+}
+
+// consultationVersion snapshots the current state of the consultation.
+func consultationVersion(consultation *MedicalConsultation, authorUserId, reason string) MedicalConsultationVersion {
+	return MedicalConsultationVersion{
+		ConsultationId: consultation.ID,
+		Version:        consultation.Version,
+		Symptoms:       consultation.Symptoms,
+		Diagnosis:      consultation.Diagnosis,
+		Treatment:      consultation.Treatment,
+		Notes:          consultation.Notes,
+		AuthorUserID:   authorUserId,
+		Reason:         reason,
+	}
+}
+
+func createHistoryVersion(tx *gorm.DB, version MedicalHistoryVersion) error {
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+	version.ID = id
+	if err := tx.Create(&version).Error; err != nil {
+		return fmt.Errorf("error al guardar la versión de la historia clínica: %w", err)
+	}
+	return nil
+}
+
+func createConsultationVersion(tx *gorm.DB, version MedicalConsultationVersion) error {
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+	version.ID = id
+	if err := tx.Create(&version).Error; err != nil {
+		return fmt.Errorf("error al guardar la versión de la consulta: %w", err)
+	}
+	return nil
+}
+
+// amendHistoryTx records next as the new version of the medical history and
+// makes it the current state. The history must be locked by the caller.
+func amendHistoryTx(tx *gorm.DB, history *MedicalHistory, next MedicalHistoryVersion) (*MedicalHistoryVersion, error) {
+	current := historyVersion(history, "", "")
+	if len(diffFields(current.fields(), next.fields())) == 0 {
+		return nil, ErrNoChanges
+	}
+
+	next.MedicalHistoryId = history.ID
+	next.Version = history.Version + 1
+	if err := createHistoryVersion(tx, next); err != nil {
+		return nil, err
+	}
+
+	err := tx.Model(history).Updates(map[string]interface{}{
+		"consult_reason": next.ConsultReason,
+		"personal_info":  next.PersonalInfo,
+		"family_info":    next.FamilyInfo,
+		"allergies":      next.Allergies,
+		"observations":   next.Observations,
+		"version":        next.Version,
+		"last_update":    time.Now(),
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("error updating medical history: %v", err)
+	}
+	return &next, nil
+}
+
+func lockMedicalHistory(tx *gorm.DB, historyID string) (*MedicalHistory, error) {
+	var history MedicalHistory
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", historyID).
+		First(&history).Error
+	if err != nil {
+		return nil, err
+	}
+	return &history, nil
+}
+
+// UpdateMedicalHistory amends the medical history with the fields of dto. The
+// previous text stays available as an earlier version.
+func (r *repository) UpdateMedicalHistory(historyID string, dto UpdateMedicalHistoryDTO) (*MedicalHistoryVersion, error) {
+	var version *MedicalHistoryVersion
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		history, err := lockMedicalHistory(tx, historyID)
+		if err != nil {
+			return err
+		}
+
+		version, err = amendHistoryTx(tx, history, MedicalHistoryVersion{
+			ConsultReason: dto.ConsultReason,
+			PersonalInfo:  dto.PersonalInfo,
+			FamilyInfo:    dto.FamilyInfo,
+			Allergies:     dto.Allergies,
+			Observations:  dto.Observations,
+			AuthorUserID:  dto.AuthorUserId,
+			Reason:        dto.Reason,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// AmendConsultation records the corrected consultation as a new version and
+// makes it the current state.
+func (r *repository) AmendConsultation(consultationID string, dto AmendConsultationDTO) (*MedicalConsultationVersion, error) {
+	var next MedicalConsultationVersion
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var consultation MedicalConsultation
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", consultationID).
+			First(&consultation).Error
+		if err != nil {
+			return err
+		}
+
+		current := consultationVersion(&consultation, "", "")
+		next = MedicalConsultationVersion{
+			ConsultationId: consultation.ID,
+			Version:        consultation.Version + 1,
+			Symptoms:       dto.Symptoms,
+			Diagnosis:      dto.Diagnosis,
+			Treatment:      dto.Treatment,
+			Notes:          dto.Notes,
+			AuthorUserID:   dto.AuthorUserId,
+			Reason:         dto.Reason,
+		}
+		if len(diffFields(current.fields(), next.fields())) == 0 {
+			return ErrNoChanges
+		}
+		if err := createConsultationVersion(tx, next); err != nil {
+			return err
+		}
+
+		err = tx.Model(&consultation).Updates(map[string]interface{}{
+			"symptoms":  next.Symptoms,
+			"diagnosis": next.Diagnosis,
+			"treatment": next.Treatment,
+			"notes":     next.Notes,
+			"version":   next.Version,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("error al actualizar la consulta: %w", err)
+		}
+
+		return tx.Model(&MedicalHistory{}).
+			Where("id = ?", consultation.MedicalHistoryId).
+			Update("last_update", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
+
+// GetMedicalHistoryVersions returns every version of the medical history,
+// oldest first.
+func (r *repository) GetMedicalHistoryVersions(historyID string) ([]MedicalHistoryVersion, error) {
+	if err := r.db.Select("id").Where("id = ?", historyID).First(&MedicalHistory{}).Error; err != nil {
+		return nil, err
+	}
+
+	var versions []MedicalHistoryVersion
+	err := r.db.Where("medical_history_id = ?", historyID).
+		Order("version").
+		Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las versiones de la historia clínica: %w", err)
+	}
+	return versions, nil
+}
+
+func (r *repository) GetMedicalHistoryVersion(historyID string, version int) (*MedicalHistoryVersion, error) {
+	var result MedicalHistoryVersion
+	err := r.db.Where("medical_history_id = ? AND version = ?", historyID, version).
+		First(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetConsultationVersions returns every version of the consultation, oldest
+// first.
+func (r *repository) GetConsultationVersions(consultationID string) ([]MedicalConsultationVersion, error) {
+	if err := r.db.Select("id").Where("id = ?", consultationID).First(&MedicalConsultation{}).Error; err != nil {
+		return nil, err
+	}
+
+	var versions []MedicalConsultationVersion
+	err := r.db.Where("consultation_id = ?", consultationID).
+		Order("version").
+		Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("error al obtener las versiones de la consulta: %w", err)
+	}
+	return versions, nil
+}
+
+func (r *repository) GetConsultationVersion(consultationID string, version int) (*MedicalConsultationVersion, error) {
+	var result MedicalConsultationVersion
+	err := r.db.Where("consultation_id = ? AND version = ?", consultationID, version).
+		First(&result).Error
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// EnsureVersionHistory records the current state of medical histories and
+// consultations saved before versions existed as their first version, and
+// installs the triggers that keep versions from being changed or deleted. It
+// is safe to run on every start.
+func EnsureVersionHistory(db *gorm.DB) error {
+	statements := []string{
+		`INSERT INTO medical_history_versions
+			(id, medical_history_id, version, consult_reason, personal_info, family_info, allergies, observations, author_user_id, reason, created_at)
+			SELECT mh.id || '-v1', mh.id, 1, mh.consult_reason, mh.personal_info, mh.family_info, mh.allergies, mh.observations, '', '` + reasonBackfilled + `', mh.updated_at
+			FROM medical_histories mh
+			WHERE NOT EXISTS (SELECT 1 FROM medical_history_versions v WHERE v.medical_history_id = mh.id)`,
+		`INSERT INTO medical_consultation_versions
+			(id, consultation_id, version, symptoms, diagnosis, treatment, notes, author_user_id, reason, created_at)
+			SELECT mc.id || '-v1', mc.id, 1, mc.symptoms, mc.diagnosis, mc.treatment, mc.notes, '', '` + reasonBackfilled + `', mc.updated_at
+			FROM medical_consultations mc
+			WHERE NOT EXISTS (SELECT 1 FROM medical_consultation_versions v WHERE v.consultation_id = mc.id)`,
+		`CREATE OR REPLACE FUNCTION reject_record_version_change() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'las versiones de la historia clínica no se pueden modificar ni eliminar';
+			END;
+			$$ LANGUAGE plpgsql`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("error al preparar el historial de versiones: %w", err)
+		}
+	}
+
+	for name, table := range map[string]string{
+		"medical_history_versions_immutable":      "medical_history_versions",
+		"medical_consultation_versions_immutable": "medical_consultation_versions",
+	} {
+		var exists bool
+		if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = ?)`, name).Scan(&exists).Error; err != nil {
+			return fmt.Errorf("error al verificar el disparador %s: %w", name, err)
+		}
+		if exists {
+			continue
+		}
+
+		statement := fmt.Sprintf(`CREATE TRIGGER %s BEFORE UPDATE OR DELETE ON %s
+			FOR EACH ROW EXECUTE FUNCTION reject_record_version_change()`, name, table)
+		if err := db.Exec(statement).Error; err != nil {
+			return fmt.Errorf("error al crear el disparador %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkAmendment rejects amendments without a reason.
+func checkAmendment(reason string) error {
+	if strings.TrimSpace(reason) == "" {
+		return ErrAmendmentReason
+	}
+	return nil
+}
+
+// checkVersions rejects version numbers that cannot exist.
+func checkVersions(from, to int) error {
+	if from < 1 || to < 1 {
+		return fmt.Errorf("%w: from and to must be version numbers starting at 1", ErrInvalidVersions)
+	}
+	return nil
+}
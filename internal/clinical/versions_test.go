@@ -0,0 +1,203 @@
+package clinical
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	dialector := postgres.New(postgres.Config{
+		Conn:       db,
+		DriverName: "postgres",
+	})
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open GORM DB: %v", err)
+	}
+
+	return gormDB, mock
+}
+
+var historyColumns = []string{"id", "patient_id", "consult_reason", "personal_info", "family_info", "allergies", "observations", "version"}
+
+func TestDiffFields(t *testing.T) {
+	from := &MedicalHistoryVersion{ConsultReason: "Control", Allergies: "Ninguna", Observations: "Estable"}
+	to := &MedicalHistoryVersion{ConsultReason: "Control", Allergies: "Penicilina", Observations: ""}
+
+	want := []FieldChange{
+		{Field: "allergies", From: "Ninguna", To: "Penicilina"},
+		{Field: "observations", From: "Estable", To: ""},
+	}
+	if got := diffFields(from.fields(), to.fields()); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFields() = %+v, want %+v", got, want)
+	}
+
+	if got := diffFields(from.fields(), from.fields()); got == nil || len(got) != 0 {
+		t.Errorf("diffFields() of equal versions = %#v, want an empty list", got)
+	}
+}
+
+func TestCheckVersions(t *testing.T) {
+	tests := []struct {
+		from, to int
+		wantErr  bool
+	}{
+		{1, 2, false},
+		{3, 1, false},
+		{2, 2, false},
+		{0, 1, true},
+		{1, 0, true},
+		{-1, 2, true},
+	}
+	for _, tt := range tests {
+		err := checkVersions(tt.from, tt.to)
+		if tt.wantErr != errors.Is(err, ErrInvalidVersions) {
+			t.Errorf("checkVersions(%d, %d) error = %v, want invalid %v", tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
+
+// unusedRepository fails the test through a nil pointer panic if the
+// service reaches the repository.
+type unusedRepository struct {
+	Repository
+}
+
+func TestServiceRejectsInvalidAmendments(t *testing.T) {
+	s := NewService(unusedRepository{})
+
+	if _, err := s.UpdateMedicalHistory("mh-1", UpdateMedicalHistoryDTO{Allergies: "Penicilina", Reason: "  "}); !errors.Is(err, ErrAmendmentReason) {
+		t.Errorf("UpdateMedicalHistory() without reason error = %v, want %v", err, ErrAmendmentReason)
+	}
+	if _, err := s.AmendConsultation("mc-1", AmendConsultationDTO{Diagnosis: "Gripa"}); !errors.Is(err, ErrAmendmentReason) {
+		t.Errorf("AmendConsultation() without reason error = %v, want %v", err, ErrAmendmentReason)
+	}
+	if _, err := s.DiffMedicalHistoryVersions("mh-1", 0, 1); !errors.Is(err, ErrInvalidVersions) {
+		t.Errorf("DiffMedicalHistoryVersions(0, 1) error = %v, want %v", err, ErrInvalidVersions)
+	}
+	if _, err := s.DiffConsultationVersions("mc-1", 1, -2); !errors.Is(err, ErrInvalidVersions) {
+		t.Errorf("DiffConsultationVersions(1, -2) error = %v, want %v", err, ErrInvalidVersions)
+	}
+}
+
+func TestRepository_UpdateMedicalHistory(t *testing.T) {
+	db, mock := setupTestDB(t)
+	repo := NewRepository(db, nil)
+
+	lockHistory := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "medical_histories" WHERE id = \$1 .+ FOR UPDATE`).
+			WithArgs("mh-1", 1).
+			WillReturnRows(sqlmock.NewRows(historyColumns).
+				AddRow("mh-1", "pt-1", "Control", "", "", "Ninguna", "", 2))
+	}
+
+	t.Run("an amendment becomes the next version", func(t *testing.T) {
+		lockHistory()
+		mock.ExpectExec(`INSERT INTO "medical_history_versions"`).
+			WithArgs(sqlmock.AnyArg(), "mh-1", 3, "Control", "", "", "Penicilina", "", "doc-1", "Alergia reportada por el paciente", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE "medical_histories" SET .*"allergies"=.*"version"=.* WHERE .*"id" = `).
+			WithArgs("Penicilina", "Control", "", sqlmock.AnyArg(), "", "", 3, sqlmock.AnyArg(), "mh-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		version, err := repo.UpdateMedicalHistory("mh-1", UpdateMedicalHistoryDTO{
+			ConsultReason: "Control",
+			Allergies:     "Penicilina",
+			Reason:        "Alergia reportada por el paciente",
+			AuthorUserId:  "doc-1",
+		})
+		if err != nil {
+			t.Fatalf("UpdateMedicalHistory() error = %v", err)
+		}
+		if version.Version != 3 || version.MedicalHistoryId != "mh-1" || version.AuthorUserID != "doc-1" {
+			t.Errorf("version = %+v, want version 3 of mh-1 by doc-1", version)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("an amendment that changes nothing is rejected", func(t *testing.T) {
+		lockHistory()
+		mock.ExpectRollback()
+
+		_, err := repo.UpdateMedicalHistory("mh-1", UpdateMedicalHistoryDTO{
+			ConsultReason: "Control",
+			Allergies:     "Ninguna",
+			Reason:        "Revisión",
+		})
+		if !errors.Is(err, ErrNoChanges) {
+			t.Errorf("UpdateMedicalHistory() error = %v, want %v", err, ErrNoChanges)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Unfulfilled expectations: %v", err)
+		}
+	})
+}
+
+func TestRepository_CreateConsultationUpdatesMedicalHistory(t *testing.T) {
+	db, mock := setupTestDB(t)
+	repo := NewRepository(db, nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "patients" WHERE id = \$1`).
+		WithArgs("pt-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("pt-1", "user-pt-1"))
+	mock.ExpectQuery(`SELECT \* FROM "physicians" WHERE id = \$1`).
+		WithArgs("ph-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("ph-1", "doc-1"))
+	mock.ExpectQuery(`SELECT \* FROM "medical_histories" WHERE patient_id = \$1`).
+		WithArgs("pt-1", 1).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow("mh-1", "pt-1", "Control", "", "", "Ninguna", "", 1))
+	mock.ExpectQuery(`SELECT \* FROM "medical_histories" WHERE id = \$1 .+ FOR UPDATE`).
+		WithArgs("mh-1", 1).
+		WillReturnRows(sqlmock.NewRows(historyColumns).
+			AddRow("mh-1", "pt-1", "Control", "", "", "Ninguna", "", 1))
+	// Only the fields given replace the history's text.
+	mock.ExpectExec(`INSERT INTO "medical_history_versions"`).
+		WithArgs(sqlmock.AnyArg(), "mh-1", 2, "Control", "", "", "Penicilina", "", "doc-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE "medical_histories" SET .*"version"=.* WHERE .*"id" = `).
+		WithArgs("Penicilina", "Control", "", sqlmock.AnyArg(), "", "", 2, sqlmock.AnyArg(), "mh-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "medical_consultations"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO "medical_consultation_versions"`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE "medical_histories" SET "last_update"=`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	consultation, err := repo.CreateConsultation(CreateConsultationDTO{
+		PatientId:            "pt-1",
+		PhysicianId:          "ph-1",
+		Symptoms:             "Erupción tras antibiótico",
+		Diagnosis:            "Alergia a la penicilina",
+		UpdateMedicalHistory: true,
+		Allergies:            "Penicilina",
+		ActorUserId:          "doc-1",
+	})
+	if err != nil {
+		t.Fatalf("CreateConsultation() error = %v", err)
+	}
+	if consultation.MedicalHistoryId != "mh-1" || consultation.Version != 1 {
+		t.Errorf("consultation = %+v, want version 1 in mh-1", consultation)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
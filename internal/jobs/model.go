@@ -0,0 +1,33 @@
+package jobs
+
+import "time"
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// Job is a unit of background work that survives restarts. Key identifies the
+// work itself, so enqueueing the same key twice runs it once: a job that is
+// done stays in the table as the record that it happened.
+type Job struct {
+	ID          string     `gorm:"primaryKey" json:"id"`
+	Kind        string     `gorm:"not null;index" json:"kind"`
+	Key         string     `gorm:"not null;uniqueIndex" json:"key"`
+	Payload     string     `gorm:"type:text" json:"payload"`
+	Status      string     `gorm:"not null;index" json:"status"`
+	RunAt       time.Time  `gorm:"not null;index" json:"run_at"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	// LockedBy identifies the claim holding the lease; only it may record the
+	// job's outcome.
+	LockedBy    string     `json:"locked_by,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
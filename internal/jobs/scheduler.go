@@ -0,0 +1,169 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid"
+)
+
+// HandlerFunc runs one job of a kind with its JSON payload. Returning an error
+// retries the job later.
+type HandlerFunc func(payload []byte) error
+
+type periodicTask struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+// Scheduler runs persistent jobs from a Store and periodic tasks. Jobs are
+// delivered at least once: a job whose process died while running it is run
+// again when its lease expires, so handlers should be safe to repeat. Each run
+// counts as an attempt, whether it failed or its process died.
+type Scheduler struct {
+	store    Store
+	handlers map[string]HandlerFunc
+	periodic []periodicTask
+	now      func() time.Time
+
+	// PollInterval is how often due jobs are looked for.
+	PollInterval time.Duration
+	// Lease is how long a claimed job is reserved for the instance running it.
+	Lease time.Duration
+	// MaxAttempts is how many times a failing job, or one whose lease keeps
+	// expiring, is tried before it is marked failed.
+	MaxAttempts int
+	// BatchSize is the most jobs claimed per poll.
+	BatchSize int
+}
+
+func NewScheduler(store Store) *Scheduler {
+	return &Scheduler{
+		store:        store,
+		handlers:     map[string]HandlerFunc{},
+		now:          time.Now,
+		PollInterval: 15 * time.Second,
+		Lease:        5 * time.Minute,
+		MaxAttempts:  5,
+		BatchSize:    20,
+	}
+}
+
+// Handle registers the handler of a job kind. Register every kind before
+// calling Start.
+func (s *Scheduler) Handle(kind string, handler HandlerFunc) {
+	s.handlers[kind] = handler
+}
+
+// Every runs task every interval once the scheduler starts. Periodic tasks are
+// not persisted; they usually decide what to Enqueue.
+func (s *Scheduler) Every(name string, interval time.Duration, task func() error) {
+	s.periodic = append(s.periodic, periodicTask{name: name, interval: interval, run: task})
+}
+
+// Enqueue schedules a job to run at runAt. Enqueueing a key that already
+// exists, whatever its status, does nothing.
+func (s *Scheduler) Enqueue(kind, key string, runAt time.Time, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error al serializar el trabajo %s: %w", key, err)
+	}
+
+	id, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+
+	return s.store.Insert(&Job{
+		ID:      id,
+		Kind:    kind,
+		Key:     key,
+		Payload: string(data),
+		Status:  string(StatusPending),
+		RunAt:   runAt.UTC(),
+	})
+}
+
+// Start runs the periodic tasks and the job poller in the background.
+func (s *Scheduler) Start() {
+	for _, task := range s.periodic {
+		go s.loop(task.name, task.interval, task.run)
+	}
+	go s.loop("jobs", s.PollInterval, s.RunDue)
+}
+
+func (s *Scheduler) loop(name string, interval time.Duration, run func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := run(); err != nil {
+			log.Printf("background task %s failed: %v", name, err)
+		}
+	}
+}
+
+// RunDue claims the jobs that are due and runs them concurrently, waiting for
+// all of them to finish.
+func (s *Scheduler) RunDue() error {
+	lease, err := gonanoid.Nanoid()
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	claimed, err := s.store.ClaimDue(lease, now, now.Add(s.Lease), s.BatchSize, s.MaxAttempts)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, job := range claimed {
+		wg.Add(1)
+		go func(job Job) {
+			defer wg.Done()
+			s.run(job)
+		}(job)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) run(job Job) {
+	handler, ok := s.handlers[job.Kind]
+	if !ok {
+		s.record(job, s.store.Fail(job.ID, job.LockedBy, fmt.Sprintf("no handler for job kind %q", job.Kind)))
+		return
+	}
+
+	err := handler([]byte(job.Payload))
+	if err == nil {
+		s.record(job, s.store.Complete(job.ID, job.LockedBy, s.now()))
+		return
+	}
+
+	if job.Attempts >= s.MaxAttempts {
+		log.Printf("job %s (%s) failed after %d attempts: %v", job.ID, job.Kind, job.Attempts, err)
+		s.record(job, s.store.Fail(job.ID, job.LockedBy, err.Error()))
+		return
+	}
+	s.record(job, s.store.Retry(job.ID, job.LockedBy, s.now().Add(retryDelay(job.Attempts)), err.Error()))
+}
+
+func (s *Scheduler) record(job Job, err error) {
+	if err != nil {
+		log.Printf("failed to record the outcome of job %s (%s): %v", job.ID, job.Kind, err)
+	}
+}
+
+// retryDelay backs off exponentially from 30 seconds, capped at an hour.
+func retryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
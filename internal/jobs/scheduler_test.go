@@ -0,0 +1,292 @@
+package jobs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func newMemoryStore() *memoryStore { return &memoryStore{jobs: map[string]*Job{}} }
+
+func (m *memoryStore) Insert(job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.Key]; !ok {
+		copied := *job
+		m.jobs[job.Key] = &copied
+	}
+	return nil
+}
+
+func (m *memoryStore) ClaimDue(lockedBy string, now, lockedUntil time.Time, limit, maxAttempts int) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var claimed []Job
+	for _, job := range m.jobs {
+		due := job.Status == string(StatusPending) && !job.RunAt.After(now)
+		expired := job.Status == string(StatusRunning) && job.LockedUntil.Before(now)
+		if expired && job.Attempts >= maxAttempts {
+			job.Status, job.LastError, job.LockedUntil = string(StatusFailed), "lease expired", nil
+			continue
+		}
+		if (due || expired) && len(claimed) < limit {
+			job.Status = string(StatusRunning)
+			job.LockedUntil = &lockedUntil
+			job.LockedBy = lockedBy
+			job.Attempts++
+			claimed = append(claimed, *job)
+		}
+	}
+	return claimed, nil
+}
+
+// leased returns the job if it is still running under lockedBy's lease.
+func (m *memoryStore) leased(jobId, lockedBy string) (*Job, error) {
+	for _, job := range m.jobs {
+		if job.ID == jobId && job.Status == string(StatusRunning) && job.LockedBy == lockedBy {
+			return job, nil
+		}
+	}
+	return nil, ErrLeaseLost
+}
+
+func (m *memoryStore) holder(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[key].LockedBy
+}
+
+func (m *memoryStore) Complete(jobId, lockedBy string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, err := m.leased(jobId, lockedBy)
+	if err != nil {
+		return err
+	}
+	job.Status, job.CompletedAt, job.LockedUntil = string(StatusDone), &at, nil
+	return nil
+}
+
+func (m *memoryStore) Retry(jobId, lockedBy string, runAt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, err := m.leased(jobId, lockedBy)
+	if err != nil {
+		return err
+	}
+	job.Status, job.RunAt, job.LastError, job.LockedUntil = string(StatusPending), runAt, lastError, nil
+	return nil
+}
+
+func (m *memoryStore) Fail(jobId, lockedBy string, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, err := m.leased(jobId, lockedBy)
+	if err != nil {
+		return err
+	}
+	job.Status, job.LastError, job.LockedUntil = string(StatusFailed), lastError, nil
+	return nil
+}
+
+func TestSchedulerRunsJobsOnce(t *testing.T) {
+	store := newMemoryStore()
+	scheduler := NewScheduler(store)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+
+	var mu sync.Mutex
+	runs := map[string]int{}
+	scheduler.Handle("greet", func(payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		runs[string(payload)]++
+		return nil
+	})
+
+	for _, key := range []string{"a", "a", "b"} {
+		if err := scheduler.Enqueue("greet", key, now.Add(-time.Minute), key); err != nil {
+			t.Fatalf("Enqueue(%s) error = %v", key, err)
+		}
+	}
+	if err := scheduler.Enqueue("greet", "later", now.Add(time.Hour), "later"); err != nil {
+		t.Fatalf("Enqueue(later) error = %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := scheduler.RunDue(); err != nil {
+			t.Fatalf("RunDue() error = %v", err)
+		}
+	}
+
+	if runs[`"a"`] != 1 || runs[`"b"`] != 1 {
+		t.Errorf("runs = %v, want a and b run once each", runs)
+	}
+	if runs[`"later"`] != 0 {
+		t.Errorf("a job due in the future ran")
+	}
+	if store.jobs["a"].Status != string(StatusDone) {
+		t.Errorf("job a status = %s, want done", store.jobs["a"].Status)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := scheduler.RunDue(); err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	if runs[`"later"`] != 1 {
+		t.Errorf("the later job did not run once due")
+	}
+}
+
+func TestSchedulerRetriesAndFails(t *testing.T) {
+	store := newMemoryStore()
+	scheduler := NewScheduler(store)
+	scheduler.MaxAttempts = 2
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+	scheduler.Handle("flaky", func([]byte) error { return errors.New("smtp down") })
+
+	if err := scheduler.Enqueue("flaky", "flaky-1", now, nil); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	if err := scheduler.RunDue(); err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	job := store.jobs["flaky-1"]
+	if job.Status != string(StatusPending) || !job.RunAt.Equal(now.Add(30*time.Second)) || job.LastError != "smtp down" {
+		t.Fatalf("after the first failure job = %+v, want pending and retried in 30s", job)
+	}
+
+	now = now.Add(time.Minute)
+	if err := scheduler.RunDue(); err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	if job.Status != string(StatusFailed) || job.Attempts != 2 {
+		t.Errorf("after the last attempt job = %+v, want failed after 2 attempts", job)
+	}
+}
+
+func TestSchedulerReclaimsExpiredLeases(t *testing.T) {
+	store := newMemoryStore()
+	scheduler := NewScheduler(store)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+
+	runs := 0
+	scheduler.Handle("work", func([]byte) error { runs++; return nil })
+	if err := scheduler.Enqueue("work", "work-1", now, nil); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// A previous process claimed the job and died before finishing it.
+	if _, err := store.ClaimDue("dead-process", now, now.Add(scheduler.Lease), 10, scheduler.MaxAttempts); err != nil {
+		t.Fatalf("ClaimDue() error = %v", err)
+	}
+	if err := scheduler.RunDue(); err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	if runs != 0 {
+		t.Fatalf("a job leased to another process ran")
+	}
+
+	now = now.Add(scheduler.Lease + time.Second)
+	if err := scheduler.RunDue(); err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	if runs != 1 || store.jobs["work-1"].Status != string(StatusDone) {
+		t.Errorf("runs = %d, status = %s; want the expired lease reclaimed and run", runs, store.jobs["work-1"].Status)
+	}
+}
+
+func TestSchedulerFailsJobsWhoseLeaseKeepsExpiring(t *testing.T) {
+	store := newMemoryStore()
+	scheduler := NewScheduler(store)
+	scheduler.MaxAttempts = 2
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+
+	runs := 0
+	scheduler.Handle("crash", func([]byte) error { runs++; return nil })
+	if err := scheduler.Enqueue("crash", "crash-1", now, nil); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	// Every process that claims the job dies while running it.
+	for i := 0; i < scheduler.MaxAttempts; i++ {
+		if _, err := store.ClaimDue("dead-process", now, now.Add(scheduler.Lease), 10, scheduler.MaxAttempts); err != nil {
+			t.Fatalf("ClaimDue() error = %v", err)
+		}
+		now = now.Add(scheduler.Lease + time.Second)
+	}
+
+	if err := scheduler.RunDue(); err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	job := store.jobs["crash-1"]
+	if runs != 0 || job.Status != string(StatusFailed) || job.Attempts != 2 {
+		t.Errorf("runs = %d, job = %+v; want the job failed after 2 attempts without running again", runs, job)
+	}
+}
+
+func TestStaleLeaseCannotRecordOutcome(t *testing.T) {
+	store := newMemoryStore()
+	scheduler := NewScheduler(store)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	scheduler.now = func() time.Time { return now }
+
+	release := make(chan struct{})
+	scheduler.Handle("slow", func([]byte) error { <-release; return nil })
+	if err := scheduler.Enqueue("slow", "slow-1", now, nil); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	stale, err := store.ClaimDue("slow-process", now, now.Add(scheduler.Lease), 10, scheduler.MaxAttempts)
+	if err != nil || len(stale) != 1 {
+		t.Fatalf("ClaimDue() = %v, %v; want the job claimed", stale, err)
+	}
+
+	// The lease runs out and another process reclaims the job.
+	now = now.Add(scheduler.Lease + time.Second)
+	done := make(chan error)
+	go func() { done <- scheduler.RunDue() }()
+
+	// Meanwhile the first process finishes late and tries to record it.
+	for store.holder("slow-1") == "slow-process" {
+		time.Sleep(time.Millisecond)
+	}
+	if err := store.Fail(stale[0].ID, stale[0].LockedBy, "too slow"); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Fail() with a stale lease error = %v, want ErrLeaseLost", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("RunDue() error = %v", err)
+	}
+	if job := store.jobs["slow-1"]; job.Status != string(StatusDone) || job.LastError != "" {
+		t.Errorf("job = %+v, want done by the process holding the lease", job)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
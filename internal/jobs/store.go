@@ -0,0 +1,121 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrLeaseLost is returned when a job's outcome is recorded by a claim that no
+// longer holds its lease, because it expired and another claim took the job.
+var ErrLeaseLost = errors.New("the job's lease is held by another claim")
+
+// Store persists jobs for the scheduler.
+type Store interface {
+	// Insert adds the job unless one with the same key exists.
+	Insert(job *Job) error
+	// ClaimDue leases up to limit jobs that are due, or whose lease expired,
+	// to lockedBy until the given time. Jobs whose lease expired after their
+	// maxAttempts-th attempt are marked failed instead of claimed again.
+	ClaimDue(lockedBy string, now, lockedUntil time.Time, limit, maxAttempts int) ([]Job, error)
+	// Complete, Retry and Fail record the outcome of a job leased to
+	// lockedBy, or return ErrLeaseLost.
+	Complete(jobId, lockedBy string, at time.Time) error
+	Retry(jobId, lockedBy string, runAt time.Time, lastError string) error
+	Fail(jobId, lockedBy string, lastError string) error
+}
+
+type store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) Store { return &store{db} }
+
+func (s *store) Insert(job *Job) error {
+	err := s.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(job).Error
+	if err != nil {
+		return fmt.Errorf("error al programar el trabajo %s: %w", job.Key, err)
+	}
+	return nil
+}
+
+// ClaimDue locks the rows it leases with SKIP LOCKED, so several instances of
+// the API can share the table without running a job twice.
+func (s *store) ClaimDue(lockedBy string, now, lockedUntil time.Time, limit, maxAttempts int) ([]Job, error) {
+	var claimed []Job
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// A job whose lease keeps expiring crashes the process running it;
+		// it is given up once it used its attempts.
+		err := tx.Exec(`
+			UPDATE jobs SET status = ?, locked_until = NULL, last_error = ?, updated_at = ?
+			WHERE status = ? AND locked_until < ? AND attempts >= ?`,
+			StatusFailed, fmt.Sprintf("the lease expired on each of %d attempts", maxAttempts), now,
+			StatusRunning, now, maxAttempts).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Raw(`
+			UPDATE jobs SET status = ?, locked_until = ?, locked_by = ?, attempts = attempts + 1, updated_at = ?
+			WHERE id IN (
+				SELECT id FROM jobs
+				WHERE (status = ? AND run_at <= ?) OR (status = ? AND locked_until < ?)
+				ORDER BY run_at
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *`,
+			StatusRunning, lockedUntil, lockedBy, now,
+			StatusPending, now, StatusRunning, now,
+			limit).
+			Scan(&claimed).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error al reservar trabajos pendientes: %w", err)
+	}
+	return claimed, nil
+}
+
+func (s *store) Complete(jobId, lockedBy string, at time.Time) error {
+	return s.update(jobId, lockedBy, map[string]interface{}{
+		"status":       StatusDone,
+		"completed_at": at,
+		"locked_until": nil,
+		"last_error":   "",
+	})
+}
+
+func (s *store) Retry(jobId, lockedBy string, runAt time.Time, lastError string) error {
+	return s.update(jobId, lockedBy, map[string]interface{}{
+		"status":       StatusPending,
+		"run_at":       runAt,
+		"locked_until": nil,
+		"last_error":   lastError,
+	})
+}
+
+func (s *store) Fail(jobId, lockedBy string, lastError string) error {
+	return s.update(jobId, lockedBy, map[string]interface{}{
+		"status":       StatusFailed,
+		"locked_until": nil,
+		"last_error":   lastError,
+	})
+}
+
+// update changes the job while it is still running under lockedBy's lease.
+func (s *store) update(jobId, lockedBy string, values map[string]interface{}) error {
+	result := s.db.Model(&Job{}).
+		Where("id = ? AND status = ? AND locked_by = ?", jobId, StatusRunning, lockedBy).
+		Updates(values)
+	if result.Error != nil {
+		return fmt.Errorf("error al actualizar el trabajo %s: %w", jobId, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
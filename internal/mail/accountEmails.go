@@ -0,0 +1,100 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ClientURL builds a link into the web client configured in CLIENT, carrying
+// token as a query parameter.
+func ClientURL(path, token string) string {
+	return clientLink(path) + "?token=" + url.QueryEscape(token)
+}
+
+func clientLink(path string) string {
+	return strings.TrimRight(os.Getenv("CLIENT"), "/") + path
+}
+
+// send delivers an HTML email in the background. Delivery errors are logged,
+// matching SendWelcomeMessage.
+func send(to, subject, body string) error {
+	if to == "" {
+		return fmt.Errorf("invalid parameters for sending email")
+	}
+
+	go func() {
+		if err := deliver(to, subject, body); err != nil {
+			log.Printf("Error al enviar correo %q a %s: %v", subject, to, err)
+		}
+	}()
+
+	return nil
+}
+
+// deliver sends an HTML email, with optional attachments, and waits for the
+// SMTP server to accept it.
+func deliver(to, subject, body string, attachments ...Attachment) error {
+	if to == "" {
+		return fmt.Errorf("invalid parameters for sending email")
+	}
+
+	auth, emailUsername, emailHost := EmailConfig()
+	msg, err := buildMessage(emailUsername, to, subject, body, attachments)
+	if err != nil {
+		return err
+	}
+	return smtpClient.SendMail(emailHost+":587", auth, emailUsername, []string{to}, msg)
+}
+
+func SendPasswordResetEmail(name, to, token string) error {
+	return send(to, "🔑 Restablece tu contraseña de Altheia EHR", ActionEmailTemplate(ActionEmail{
+		Preview:     "Solicitud para restablecer tu contraseña",
+		Title:       "Restablece tu contraseña",
+		Name:        name,
+		Message:     "Recibimos una solicitud para restablecer la contraseña de tu cuenta. El enlace es válido durante una hora y solo puede usarse una vez.",
+		ActionLabel: "Restablecer contraseña",
+		ActionURL:   ClientURL("/reset-password", token),
+		Footer:      "Si no solicitaste este cambio, ignora este correo; tu contraseña actual sigue siendo válida.",
+	}))
+}
+
+func SendEmailVerification(name, to, token string) error {
+	return send(to, "✉️ Confirma tu correo en Altheia EHR", ActionEmailTemplate(ActionEmail{
+		Preview:     "Confirma tu correo para activar tu cuenta",
+		Title:       "Confirma tu correo electrónico",
+		Name:        name,
+		Message:     "Para activar tu cuenta en Altheia EHR confirma que esta dirección de correo te pertenece.",
+		ActionLabel: "Confirmar correo",
+		ActionURL:   ClientURL("/verify-email", token),
+		Footer:      "Si no creaste una cuenta en Altheia EHR, ignora este correo.",
+	}))
+}
+
+func SendSetPasswordEmail(name, to, token string) error {
+	return send(to, "👋 Activa tu cuenta de Altheia EHR", ActionEmailTemplate(ActionEmail{
+		Preview:     "Define tu contraseña para activar tu cuenta",
+		Title:       "Activa tu cuenta",
+		Name:        name,
+		Message:     "Se creó una cuenta de Altheia EHR para ti. Define tu contraseña para activarla e ingresar por primera vez.",
+		ActionLabel: "Definir contraseña",
+		ActionURL:   ClientURL("/set-password", token),
+		Footer:      "El enlace es válido durante 72 horas y solo puede usarse una vez.",
+	}))
+}
+
+func SendAccountLockedEmail(name, to string, lockout time.Duration, ipAddress string) error {
+	return send(to, "⚠️ Bloqueamos temporalmente tu cuenta de Altheia EHR", ActionEmailTemplate(ActionEmail{
+		Preview: "Detectamos varios intentos fallidos de inicio de sesión",
+		Title:   "Cuenta bloqueada temporalmente",
+		Name:    name,
+		Message: fmt.Sprintf("Detectamos varios intentos fallidos de inicio de sesión en tu cuenta desde la dirección IP %s. "+
+			"Por seguridad bloqueamos el acceso durante %s.", ipAddress, lockout.Round(time.Minute)),
+		ActionLabel: "Restablecer contraseña",
+		ActionURL:   clientLink("/forgot-password"),
+		Footer:      "Si fuiste tú, espera a que termine el bloqueo. Si no reconoces estos intentos, restablece tu contraseña.",
+	}))
+}
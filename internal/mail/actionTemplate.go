@@ -0,0 +1,106 @@
+package mail
+
+import "html"
+
+// ActionEmail is the content of a transactional email that asks the recipient
+// to follow a link. The optional secondary link is shown under the button for
+// the alternative answer, such as cancelling instead of confirming.
+type ActionEmail struct {
+	Preview        string
+	Title          string
+	Name           string
+	Message        string
+	ActionLabel    string
+	ActionURL      string
+	SecondaryLabel string
+	SecondaryURL   string
+	Footer         string
+}
+
+// ActionEmailTemplate renders an ActionEmail with the same header and palette
+// as the welcome email. All values are HTML-escaped.
+func ActionEmailTemplate(email ActionEmail) string {
+	action := ""
+	if email.ActionURL != "" {
+		action = `
+                    <table align="center" width="100%" border="0" cellpadding="0" cellspacing="0" role="presentation" style="margin-bottom:32px;text-align:center">
+                      <tbody>
+                        <tr>
+                          <td>
+                            <a href="` + html.EscapeString(email.ActionURL) + `"
+                              style="background-color:rgb(37,99,235);color:rgb(255,255,255);font-weight:700;padding:12px 32px;border-radius:8px;text-decoration-line:none;display:inline-block"
+                              target="_blank">` + html.EscapeString(email.ActionLabel) + `</a>
+                          </td>
+                        </tr>
+                      </tbody>
+                    </table>
+                    <p style="font-size:14px;line-height:22px;color:rgb(100,116,139);margin-bottom:24px;word-break:break-all">
+                      Si el botón no funciona, copia este enlace en tu navegador:<br />
+                      ` + html.EscapeString(email.ActionURL) + `
+                    </p>`
+	}
+	if email.SecondaryURL != "" {
+		action += `
+                    <p style="font-size:14px;line-height:22px;color:rgb(100,116,139);margin-bottom:24px;text-align:center">
+                      <a href="` + html.EscapeString(email.SecondaryURL) + `" style="color:rgb(185,28,28)" target="_blank">` +
+			html.EscapeString(email.SecondaryLabel) + `</a>
+                    </p>`
+	}
+
+	return `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html dir="ltr" lang="es">
+  <head>
+    <meta content="text/html; charset=UTF-8" http-equiv="Content-Type" />
+    <meta name="x-apple-disable-message-reformatting" />
+  </head>
+  <body
+    style='background-color:rgb(240,244,248);font-family:ui-sans-serif, system-ui, sans-serif, "Apple Color Emoji", "Segoe UI Emoji", "Segoe UI Symbol", "Noto Color Emoji";padding-top:40px;padding-bottom:40px'>
+    <div style="display:none;overflow:hidden;line-height:1px;opacity:0;max-height:0;max-width:0">
+      ` + html.EscapeString(email.Preview) + `
+    </div>
+    <table align="center" width="100%" border="0" cellpadding="0" cellspacing="0" role="presentation"
+      style="background-color:rgb(255,255,255);border-radius:12px;margin-left:auto;margin-right:auto;padding:0px;max-width:600px">
+      <tbody>
+        <tr style="width:100%">
+          <td>
+            <table align="center" width="100%" border="0" cellpadding="0" cellspacing="0" role="presentation"
+              style="background-color:rgb(30,64,175);padding:32px;text-align:center">
+              <tbody>
+                <tr>
+                  <td>
+                    <h1 style="font-size:32px;font-weight:700;color:rgb(255,255,255);margin:0px">Altheia EHR</h1>
+                    <p style="font-size:18px;color:rgb(255,255,255);opacity:0.9;margin-top:8px;margin-bottom:0px;line-height:24px">
+                      Tu plataforma de salud digital
+                    </p>
+                  </td>
+                </tr>
+              </tbody>
+            </table>
+            <table align="center" width="100%" border="0" cellpadding="0" cellspacing="0" role="presentation"
+              style="padding-left:32px;padding-right:32px;padding-top:40px;padding-bottom:32px">
+              <tbody>
+                <tr>
+                  <td>
+                    <h1 style="font-size:24px;font-weight:700;color:rgb(30,58,138);margin-bottom:24px;text-align:center">
+                      ` + html.EscapeString(email.Title) + `
+                    </h1>
+                    <p style="font-size:16px;line-height:26px;color:rgb(51,65,85);margin-bottom:24px;margin-top:16px">
+                      Hola <span style="font-weight:700">` + html.EscapeString(email.Name) + `</span>,
+                    </p>
+                    <p style="font-size:16px;line-height:26px;color:rgb(51,65,85);margin-bottom:24px;margin-top:16px">
+                      ` + html.EscapeString(email.Message) + `
+                    </p>` + action + `
+                    <p style="font-size:14px;line-height:22px;color:rgb(100,116,139);margin-bottom:0px">
+                      ` + html.EscapeString(email.Footer) + `
+                    </p>
+                  </td>
+                </tr>
+              </tbody>
+            </table>
+          </td>
+        </tr>
+      </tbody>
+    </table>
+  </body>
+</html>`
+}
@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+)
+
+// SendWaitlistOfferEmail offers a freed slot to a waitlisted patient. The link
+// leads to the client page where the patient claims or declines the slot.
+func SendWaitlistOfferEmail(name, to, physicianName, clinicName string, startsAt time.Time, hold time.Duration, token string) error {
+	where := physicianName
+	if clinicName != "" {
+		where = fmt.Sprintf("%s en %s", physicianName, clinicName)
+	}
+
+	return send(to, "📅 Se liberó una cita para ti en Altheia EHR", ActionEmailTemplate(ActionEmail{
+		Preview: "Hay un espacio disponible que coincide con tu lista de espera",
+		Title:   "Tenemos una cita disponible",
+		Name:    name,
+		Message: fmt.Sprintf("Se liberó una cita con %s el %s a las %s. La reservamos para ti durante %s.",
+			where, startsAt.Format("02/01/2006"), startsAt.Format("15:04"), hold.Round(time.Minute)),
+		ActionLabel: "Ver la oferta",
+		ActionURL:   ClientURL("/waitlist/offer", token),
+		Footer:      "Si no la reservas a tiempo se ofrecerá al siguiente paciente de la lista de espera.",
+	}))
+}
+
+// AppointmentReminder is the content of an appointment reminder email.
+// StartsAt is already in the clinic's time zone.
+type AppointmentReminder struct {
+	Name          string
+	To            string
+	PhysicianName string
+	ClinicName    string
+	ClinicAddress string
+	StartsAt      time.Time
+	Token         string
+	Calendar      []byte
+}
+
+// SendAppointmentReminderEmail reminds a patient of a confirmed appointment,
+// with links to confirm attendance or cancel and the appointment as an .ics
+// attachment. Unlike the other emails it waits for delivery and returns its
+// error, so the reminder job can retry.
+func SendAppointmentReminderEmail(reminder AppointmentReminder) error {
+	where := reminder.PhysicianName
+	if reminder.ClinicName != "" {
+		where = fmt.Sprintf("%s en %s", reminder.PhysicianName, reminder.ClinicName)
+	}
+	footer := "Adjuntamos la cita para que la agregues a tu calendario."
+	if reminder.ClinicAddress != "" {
+		footer = fmt.Sprintf("Dirección: %s. %s", reminder.ClinicAddress, footer)
+	}
+
+	body := ActionEmailTemplate(ActionEmail{
+		Preview: "Recordatorio de tu próxima cita médica",
+		Title:   "Recordatorio de tu cita",
+		Name:    reminder.Name,
+		Message: fmt.Sprintf("Te recordamos tu cita con %s el %s a las %s. Confirma tu asistencia o cancela si no puedes asistir para liberar el espacio.",
+			where, reminder.StartsAt.Format("02/01/2006"), reminder.StartsAt.Format("15:04")),
+		ActionLabel:    "Confirmar asistencia",
+		ActionURL:      ClientURL("/appointments/confirm", reminder.Token),
+		SecondaryLabel: "No puedo asistir, cancelar la cita",
+		SecondaryURL:   ClientURL("/appointments/cancel", reminder.Token),
+		Footer:         footer,
+	})
+
+	return deliver(reminder.To, "⏰ Recordatorio de tu cita en Altheia EHR", body, Attachment{
+		Filename:    "cita.ics",
+		ContentType: "text/calendar; charset=UTF-8; method=PUBLISH",
+		Data:        reminder.Calendar,
+	})
+}
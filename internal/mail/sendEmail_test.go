@@ -1,11 +1,13 @@
 package mail
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/smtp"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
@@ -142,3 +144,63 @@ func TestEmailTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestActionEmailTemplate(t *testing.T) {
+	os.Setenv("CLIENT", "https://app.example.com/")
+	link := ClientURL("/reset-password", "a+b/c")
+	if link != "https://app.example.com/reset-password?token=a%2Bb%2Fc" {
+		t.Errorf("ClientURL() = %v", link)
+	}
+
+	got := ActionEmailTemplate(ActionEmail{
+		Title:       "Restablece tu contraseña",
+		Name:        "<script>alert(1)</script>",
+		ActionLabel: "Restablecer",
+		ActionURL:   link,
+	})
+
+	if strings.Contains(got, "<script>") {
+		t.Error("ActionEmailTemplate() must escape user supplied values")
+	}
+	if !strings.Contains(got, `href="https://app.example.com/reset-password?token=a%2Bb%2Fc"`) {
+		t.Errorf("ActionEmailTemplate() does not contain the action link")
+	}
+}
+
+func TestSendAppointmentReminderEmail(t *testing.T) {
+	mock := &mockSMTPClient{success: true}
+	SetSMTPClient(mock)
+	defer SetSMTPClient(&DefaultSMTPClient{})
+
+	calendar := []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")
+	err := SendAppointmentReminderEmail(AppointmentReminder{
+		Name:          "Ana",
+		To:            "ana@example.com",
+		PhysicianName: "Dr. Ruiz",
+		StartsAt:      time.Date(2025, 3, 10, 9, 30, 0, 0, time.UTC),
+		Token:         "secret-token",
+		Calendar:      calendar,
+	})
+	if err != nil {
+		t.Fatalf("SendAppointmentReminderEmail() error = %v", err)
+	}
+
+	msg := string(mock.msg)
+	for _, want := range []string{
+		"Content-Type: multipart/mixed; boundary=",
+		"/appointments/confirm?token=secret-token",
+		"/appointments/cancel?token=secret-token",
+		`Content-Disposition: attachment; filename="cita.ics"`,
+		base64.StdEncoding.EncodeToString(calendar),
+		"10/03/2025 a las 09:30",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("reminder message does not contain %q", want)
+		}
+	}
+
+	mock.success = false
+	if err := SendAppointmentReminderEmail(AppointmentReminder{To: "ana@example.com"}); err == nil {
+		t.Errorf("expected the delivery error to be returned")
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"Altheia-Backend/internal/audit"
+	"Altheia-Backend/internal/db"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Locals used to pass the audited request's scope from RequireClinic to Audit.
+const (
+	auditedLocal = "audited"
+	scopeLocal   = "resource_scope"
+)
+
+// AuditLog stores the entries written by Audit.
+type AuditLog interface {
+	Record(entry *audit.Entry) error
+}
+
+var (
+	auditLogOnce sync.Once
+	auditLog     AuditLog
+)
+
+// SetAuditLog replaces the log Audit writes to (used in tests).
+func SetAuditLog(l AuditLog) {
+	auditLog = l
+}
+
+func currentAuditLog() AuditLog {
+	auditLogOnce.Do(func() {
+		if auditLog == nil {
+			auditLog = audit.NewRepository(db.GetDB())
+		}
+	})
+	return auditLog
+}
+
+// Audit records every request of a signed-in user to the route in the audit
+// log, with its outcome, so it must come first in the chain: requests denied
+// by the policies after it are recorded too. The patient and clinic are taken
+// from the scope RequireClinic resolved; a handler listing several patients
+// names them with audit.SetPatients and gets one entry per patient. Requests
+// without a valid session are not recorded.
+func Audit(resource string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals(auditedLocal, true)
+		err := c.Next()
+
+		userID, _ := c.Locals("user_id").(string)
+		if userID == "" {
+			return err
+		}
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+
+		role, _ := c.Locals("user_role").(string)
+		clinicID, _ := c.Locals("clinic_id").(string)
+		entry := &audit.Entry{
+			UserID:    userID,
+			UserRole:  role,
+			ClinicID:  clinicID,
+			Action:    audit.ActionFor(c.Method()),
+			Resource:  resource,
+			Method:    c.Method(),
+			Path:      c.Path(),
+			IP:        c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Status:    status,
+			Outcome:   audit.OutcomeFor(status),
+		}
+		if scope, ok := c.Locals(scopeLocal).(Scope); ok {
+			entry.PatientID = scope.PatientID
+			if scope.ClinicID != "" {
+				entry.ClinicID = scope.ClinicID
+			}
+			if scope.Resource == resource {
+				entry.ResourceID = scope.ResourceID
+			}
+		}
+
+		entries := []*audit.Entry{entry}
+		if patientIds := audit.Patients(c); len(patientIds) > 0 {
+			entries = make([]*audit.Entry, 0, len(patientIds))
+			for _, patientId := range patientIds {
+				perPatient := *entry
+				perPatient.PatientID = patientId
+				entries = append(entries, &perPatient)
+			}
+		}
+
+		for _, entry := range entries {
+			if recordErr := currentAuditLog().Record(entry); recordErr != nil {
+				log.Printf("audit: could not record %s %s by %s: %v", entry.Method, entry.Path, userID, recordErr)
+			}
+		}
+		return err
+	}
+}
+
+func audited(c *fiber.Ctx) bool {
+	audited, _ := c.Locals(auditedLocal).(bool)
+	return audited
+}
+
+// rememberScope keeps the scope of the request for Audit. When a chain checks
+// several resources, the first one naming a patient is kept.
+func rememberScope(c *fiber.Ctx, scope Scope) {
+	if current, ok := c.Locals(scopeLocal).(Scope); ok && current.PatientID != "" {
+		return
+	}
+	c.Locals(scopeLocal, scope)
+}
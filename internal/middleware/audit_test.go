@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"Altheia-Backend/internal/audit"
+	"Altheia-Backend/internal/users"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type recordingAuditLog struct {
+	entries []audit.Entry
+}
+
+func (l *recordingAuditLog) Record(entry *audit.Entry) error {
+	l.entries = append(l.entries, *entry)
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	log := &recordingAuditLog{}
+	SetAuditLog(log)
+
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app := fiber.New()
+	app.Get("/medical-history/patient/:patientId", Audit(audit.ResourceMedicalHistory),
+		RequireRoles(users.RolePatient, users.RolePhysician, users.RoleOwner, users.RoleSuperAdmin),
+		RequireClinic(PatientParam("patientId")), ok)
+	app.Put("/medical-history/update/:historyId", Audit(audit.ResourceMedicalHistory),
+		RequireRoles(users.RolePhysician, users.RoleSuperAdmin),
+		RequireClinic(MedicalHistoryParam("historyId")), ok)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		as             string
+		wantRecorded   bool
+		wantOutcome    string
+		wantAction     string
+		wantPatient    string
+		wantClinic     string
+		wantResourceID string
+	}{
+		{"read by a physician of the clinic", "GET", "/medical-history/patient/pt-a", "doc-a", true, audit.OutcomeSuccess, audit.ActionRead, "pt-a", "clinic-a", ""},
+		{"denied read by another clinic", "GET", "/medical-history/patient/pt-a", "doc-b", true, audit.OutcomeDenied, audit.ActionRead, "pt-a", "clinic-a", ""},
+		{"denied by role", "PUT", "/medical-history/update/mh-a", "desk-a", true, audit.OutcomeDenied, audit.ActionUpdate, "", "clinic-a", ""},
+		{"update by a super-admin", "PUT", "/medical-history/update/mh-a", "admin", true, audit.OutcomeSuccess, audit.ActionUpdate, "pt-a", "clinic-a", "mh-a"},
+		{"unknown record", "PUT", "/medical-history/update/missing", "doc-a", true, audit.OutcomeFailed, audit.ActionUpdate, "", "clinic-a", ""},
+		{"no session is not recorded", "GET", "/medical-history/patient/pt-a", "", false, "", "", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log.entries = nil
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("User-Agent", "audit-test")
+			if tt.as != "" {
+				req.Header.Set("Cookie", "access_token="+tokenFor(t, tt.as))
+			}
+
+			if _, err := app.Test(req); err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+
+			if !tt.wantRecorded {
+				if len(log.entries) != 0 {
+					t.Fatalf("recorded %d entries, want none", len(log.entries))
+				}
+				return
+			}
+			if len(log.entries) != 1 {
+				t.Fatalf("recorded %d entries, want 1", len(log.entries))
+			}
+
+			entry := log.entries[0]
+			if entry.UserID != tt.as || entry.Outcome != tt.wantOutcome || entry.Action != tt.wantAction {
+				t.Errorf("entry = %s %s %s, want %s %s %s", entry.UserID, entry.Action, entry.Outcome, tt.as, tt.wantAction, tt.wantOutcome)
+			}
+			if entry.PatientID != tt.wantPatient || entry.ClinicID != tt.wantClinic || entry.ResourceID != tt.wantResourceID {
+				t.Errorf("entry patient/clinic/resource = %q/%q/%q, want %q/%q/%q",
+					entry.PatientID, entry.ClinicID, entry.ResourceID, tt.wantPatient, tt.wantClinic, tt.wantResourceID)
+			}
+			if entry.Path != tt.path || entry.UserAgent != "audit-test" || entry.Resource != audit.ResourceMedicalHistory {
+				t.Errorf("entry request = %s %s %q, want %s %s audit-test", entry.Resource, entry.Path, entry.UserAgent, audit.ResourceMedicalHistory, tt.path)
+			}
+		})
+	}
+}
+
+func TestAuditRecordsEachListedPatient(t *testing.T) {
+	log := &recordingAuditLog{}
+	SetAuditLog(log)
+
+	app := fiber.New()
+	app.Get("/medical-history/clinic/:clinicId", Audit(audit.ResourceMedicalHistory),
+		RequireRoles(users.RolePhysician, users.RoleOwner, users.RoleSuperAdmin),
+		RequireClinic(ClinicParam("clinicId")),
+		func(c *fiber.Ctx) error {
+			audit.SetPatients(c, []string{"pt-a", "pt-a2"})
+			return c.SendString("ok")
+		})
+
+	req := httptest.NewRequest("GET", "/medical-history/clinic/clinic-a", nil)
+	req.Header.Set("Cookie", "access_token="+tokenFor(t, "doc-a"))
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("app.Test() error = %v", err)
+	}
+
+	if len(log.entries) != 2 {
+		t.Fatalf("recorded %d entries, want one per listed patient", len(log.entries))
+	}
+	for i, want := range []string{"pt-a", "pt-a2"} {
+		entry := log.entries[i]
+		if entry.PatientID != want || entry.ClinicID != "clinic-a" || entry.UserID != "doc-a" || entry.Outcome != audit.OutcomeSuccess {
+			t.Errorf("entry %d = %+v, want a successful read of %s by doc-a in clinic-a", i, entry, want)
+		}
+	}
+}
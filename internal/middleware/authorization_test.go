@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func TestMain(m *testing.M) {
+	keyring, err := utils.NewEphemeralKeyring()
+	if err != nil {
+		panic(err)
+	}
+	utils.SetKeyring(keyring)
+	SetDirectory(newStubDirectory())
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+func strPtr(s string) *string { return &s }
+
+type stubDirectory struct {
+	users        map[string]*users.User
+	patients     map[string]Scope
+	physicians   map[string]Scope
+	histories    map[string]Scope
+	appointments map[string]Scope
+	revoked      map[string]bool
+}
+
+func newStubDirectory() *stubDirectory {
+	return &stubDirectory{
+		users: map[string]*users.User{
+			"admin":       {ID: "admin", Rol: users.RoleSuperAdmin, Status: true},
+			"owner-a":     {ID: "owner-a", Rol: users.RoleOwner, Status: true, ClinicOwner: users.ClinicOwner{ClinicID: "clinic-a"}},
+			"doc-a":       {ID: "doc-a", Rol: users.RolePhysician, Status: true, Physician: users.Physician{ID: "ph-a", ClinicID: strPtr("clinic-a")}},
+			"doc-b":       {ID: "doc-b", Rol: users.RolePhysician, Status: true, Physician: users.Physician{ID: "ph-b", ClinicID: strPtr("clinic-b")}},
+			"desk-a":      {ID: "desk-a", Rol: users.RoleReceptionist, Status: true, Receptionist: users.Receptionist{ClinicID: strPtr("clinic-a")}},
+			"patient-a":   {ID: "patient-a", Rol: users.RolePatient, Status: true, Patient: users.Patient{ID: "pt-a", ClinicID: strPtr("clinic-a")}},
+			"patient-a2":  {ID: "patient-a2", Rol: users.RolePatient, Status: true, Patient: users.Patient{ID: "pt-a2", ClinicID: strPtr("clinic-a")}},
+			"disabled-a":  {ID: "disabled-a", Rol: users.RolePhysician, Status: false, Physician: users.Physician{ClinicID: strPtr("clinic-a")}},
+			"unscoped-dr": {ID: "unscoped-dr", Rol: users.RolePhysician, Status: true},
+		},
+		patients: map[string]Scope{
+			"pt-a":  {ClinicID: "clinic-a", OwnerUserID: "patient-a", PatientID: "pt-a"},
+			"pt-a2": {ClinicID: "clinic-a", OwnerUserID: "patient-a2", PatientID: "pt-a2"},
+		},
+		physicians: map[string]Scope{
+			"ph-a": {ClinicID: "clinic-a", OwnerUserID: "doc-a"},
+			"ph-b": {ClinicID: "clinic-b", OwnerUserID: "doc-b"},
+		},
+		histories: map[string]Scope{
+			"mh-a": {ClinicID: "clinic-a", OwnerUserID: "patient-a", PatientID: "pt-a"},
+		},
+		appointments: map[string]Scope{
+			"appt-a": {ClinicID: "clinic-a", OwnerUserID: "patient-a", PatientID: "pt-a"},
+		},
+		revoked: map[string]bool{"session-revoked": true},
+	}
+}
+
+func (d *stubDirectory) FindByID(id string) (*users.User, error) {
+	if u, ok := d.users[id]; ok {
+		return u, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (d *stubDirectory) IsSessionActive(sessionID string) (bool, error) {
+	return !d.revoked[sessionID], nil
+}
+
+func (d *stubDirectory) UserScope(id string) (Scope, error) {
+	u, err := d.FindByID(id)
+	if err != nil {
+		return Scope{}, err
+	}
+	clinicID := ""
+	switch {
+	case u.Patient.ClinicID != nil:
+		clinicID = *u.Patient.ClinicID
+	case u.Physician.ClinicID != nil:
+		clinicID = *u.Physician.ClinicID
+	case u.Receptionist.ClinicID != nil:
+		clinicID = *u.Receptionist.ClinicID
+	default:
+		clinicID = u.ClinicOwner.ClinicID
+	}
+	return Scope{ClinicID: clinicID, OwnerUserID: id}, nil
+}
+
+func lookup(m map[string]Scope, id string) (Scope, error) {
+	if s, ok := m[id]; ok {
+		return s, nil
+	}
+	return Scope{}, gorm.ErrRecordNotFound
+}
+
+func (d *stubDirectory) PatientScope(id string) (Scope, error)   { return lookup(d.patients, id) }
+func (d *stubDirectory) PhysicianScope(id string) (Scope, error) { return lookup(d.physicians, id) }
+func (d *stubDirectory) MedicalHistoryScope(id string) (Scope, error) {
+	return lookup(d.histories, id)
+}
+func (d *stubDirectory) ConsultationScope(id string) (Scope, error) {
+	return Scope{}, gorm.ErrRecordNotFound
+}
+func (d *stubDirectory) AppointmentScope(id string) (Scope, error) {
+	return lookup(d.appointments, id)
+}
+func (d *stubDirectory) WaitlistScope(id string) (Scope, error) {
+	return Scope{}, gorm.ErrRecordNotFound
+}
+
+func newTestApp() *fiber.App {
+	ok := func(c *fiber.Ctx) error { return c.SendString("ok") }
+	app := fiber.New()
+
+	app.Get("/super", SuperAdminOnly(), ok)
+	app.Get("/owner-or-admin", SuperAdminOrOwner(), ok)
+	app.Get("/medical-history/clinic/:clinicId",
+		RequireRoles(users.RolePhysician, users.RoleOwner, users.RoleSuperAdmin),
+		RequireClinic(ClinicParam("clinicId")), ok)
+	app.Get("/medical-history/patient/:patientId",
+		RequireRoles(users.RolePatient, users.RolePhysician, users.RoleOwner, users.RoleSuperAdmin),
+		RequireClinic(PatientParam("patientId")), ok)
+	app.Put("/medical-history/update/:historyId",
+		RequireRoles(users.RolePhysician, users.RoleSuperAdmin),
+		RequireClinic(MedicalHistoryParam("historyId")), ok)
+	app.Post("/appointments/create",
+		RequireRoles(users.RolePhysician, users.RoleReceptionist, users.RoleOwner, users.RoleSuperAdmin),
+		RequireClinic(PatientBody("patient_id")),
+		RequireClinic(PhysicianBody("physician_id")), ok)
+	app.Patch("/appointments/cancel/:id", RequireClinic(AppointmentParam("id")), ok)
+	app.Patch("/physician/update/:id",
+		RequireSelfOrRoles("id", users.RoleOwner, users.RoleSuperAdmin),
+		RequireClinic(UserParam("id")), ok)
+
+	return app
+}
+
+// tokenFor issues an access token for a stub user, carrying its role and clinic
+// as claims the way login does. Unknown users get a token without claims.
+func tokenFor(t *testing.T, userID string) string {
+	t.Helper()
+	var role, clinicID string
+	d := newStubDirectory()
+	if user, err := d.FindByID(userID); err == nil {
+		scope, _ := d.UserScope(userID)
+		role, clinicID = user.Rol, scope.ClinicID
+	}
+	token, err := utils.GenerateJWT(userID, role, clinicID, "session-"+userID, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	return token
+}
+
+func TestAuthorizationPolicies(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		as         string
+		wantStatus int
+	}{
+		{"no token is rejected", "GET", "/super", "", "", 401},
+		{"unknown user is rejected", "GET", "/super", "", "ghost", 401},
+		{"super-admin only denies owner", "GET", "/super", "", "owner-a", 403},
+		{"super-admin only allows super-admin", "GET", "/super", "", "admin", 200},
+		{"owner-or-admin denies physician", "GET", "/owner-or-admin", "", "doc-a", 403},
+		{"owner-or-admin allows owner", "GET", "/owner-or-admin", "", "owner-a", 200},
+
+		{"physician reads own clinic histories", "GET", "/medical-history/clinic/clinic-a", "", "doc-a", 200},
+		{"physician cannot read another clinic histories", "GET", "/medical-history/clinic/clinic-a", "", "doc-b", 403},
+		{"physician without clinic is denied", "GET", "/medical-history/clinic/clinic-a", "", "unscoped-dr", 403},
+		{"owner cannot read another clinic histories", "GET", "/medical-history/clinic/clinic-b", "", "owner-a", 403},
+		{"receptionist cannot read clinic histories", "GET", "/medical-history/clinic/clinic-a", "", "desk-a", 403},
+		{"patient cannot read clinic histories", "GET", "/medical-history/clinic/clinic-a", "", "patient-a", 403},
+		{"super-admin reads any clinic histories", "GET", "/medical-history/clinic/clinic-b", "", "admin", 200},
+
+		{"patient reads own history", "GET", "/medical-history/patient/pt-a", "", "patient-a", 200},
+		{"patient cannot read another patient of same clinic", "GET", "/medical-history/patient/pt-a", "", "patient-a2", 403},
+		{"physician of other clinic cannot read patient", "GET", "/medical-history/patient/pt-a", "", "doc-b", 403},
+		{"unknown patient is not found", "GET", "/medical-history/patient/missing", "", "doc-a", 404},
+
+		{"physician of other clinic cannot update history", "PUT", "/medical-history/update/mh-a", "", "doc-b", 403},
+		{"physician updates history in own clinic", "PUT", "/medical-history/update/mh-a", "", "doc-a", 200},
+
+		{"receptionist books within clinic", "POST", "/appointments/create", `{"patient_id":"pt-a","physician_id":"ph-a"}`, "desk-a", 200},
+		{"receptionist cannot book other clinic physician", "POST", "/appointments/create", `{"patient_id":"pt-a","physician_id":"ph-b"}`, "desk-a", 403},
+		{"missing patient id is a bad request", "POST", "/appointments/create", `{"physician_id":"ph-a"}`, "desk-a", 400},
+		{"patient cannot book through staff endpoint", "POST", "/appointments/create", `{"patient_id":"pt-a","physician_id":"ph-a"}`, "patient-a", 403},
+
+		{"patient cancels own appointment", "PATCH", "/appointments/cancel/appt-a", "", "patient-a", 200},
+		{"other patient cannot cancel appointment", "PATCH", "/appointments/cancel/appt-a", "", "patient-a2", 403},
+
+		{"physician updates own profile", "PATCH", "/physician/update/doc-a", "", "doc-a", 200},
+		{"physician cannot update colleague", "PATCH", "/physician/update/doc-a", "", "doc-b", 403},
+		{"owner updates physician of own clinic", "PATCH", "/physician/update/doc-a", "", "owner-a", 200},
+		{"owner cannot update physician of other clinic", "PATCH", "/physician/update/doc-b", "", "owner-a", 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.as != "" {
+				req.Header.Set("Cookie", "access_token="+tokenFor(t, tt.as))
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("%s %s as %q: status = %d, want %d", tt.method, tt.path, tt.as, resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestClaimsAndDirectoryFallback(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name       string
+		path       string
+		userID     string
+		role       string
+		clinicID   string
+		wantStatus int
+	}{
+		{"role claim takes precedence over directory", "/super", "owner-a", users.RoleSuperAdmin, "", 200},
+		{"clinic claim takes precedence over directory", "/medical-history/clinic/clinic-b", "doc-a", users.RolePhysician, "clinic-b", 200},
+		{"clinic claim denies other clinic", "/medical-history/clinic/clinic-a", "doc-a", users.RolePhysician, "clinic-b", 403},
+		{"token without claims falls back to directory", "/medical-history/clinic/clinic-a", "doc-a", "", "", 200},
+		{"deactivated account without claims is rejected", "/medical-history/clinic/clinic-a", "disabled-a", "", "", 403},
+		{"deactivated account with claims is rejected", "/medical-history/clinic/clinic-a", "disabled-a", users.RolePhysician, "clinic-a", 403},
+		{"removed account with claims is rejected", "/super", "not-in-directory", users.RoleSuperAdmin, "", 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := utils.GenerateJWT(tt.userID, tt.role, tt.clinicID, "session-"+tt.userID, time.Hour)
+			if err != nil {
+				t.Fatalf("GenerateJWT() error = %v", err)
+			}
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Cookie", "access_token="+token)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("app.Test() error = %v", err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("GET %s: status = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRevokedSessionIsRejected(t *testing.T) {
+	app := fiber.New()
+	app.Get("/protected", JWTProtected(), func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+	for _, tt := range []struct {
+		sessionID  string
+		wantStatus int
+	}{
+		{"session-live", 200},
+		{"session-revoked", 401},
+	} {
+		token, err := utils.GenerateJWT("doc-a", users.RolePhysician, "clinic-a", tt.sessionID, time.Hour)
+		if err != nil {
+			t.Fatalf("GenerateJWT() error = %v", err)
+		}
+		req := httptest.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Cookie", "access_token="+token)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("app.Test() error = %v", err)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("session %q: status = %d, want %d", tt.sessionID, resp.StatusCode, tt.wantStatus)
+		}
+	}
+}
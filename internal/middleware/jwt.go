@@ -1,28 +1,51 @@
 package middleware
 
 import (
+	"Altheia-Backend/internal/auth"
 	"Altheia-Backend/pkg/utils"
-	"fmt"
+
 	"github.com/gofiber/fiber/v2"
 )
 
 func JWTProtected() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if _, err := authenticate(c); err != nil {
+			return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Next()
+	}
+}
 
-		token := c.Cookies("access_token")
+// authenticate validates the access token cookie once per request and stores
+// the caller id in c.Locals("user_id"), along with the role, clinic and session
+// carried by the token claims. Tokens whose session was revoked are rejected
+// even before they expire. Later middleware in the same chain reuse the
+// stored values instead of parsing the token again.
+func authenticate(c *fiber.Ctx) (string, error) {
+	if userID, ok := c.Locals("user_id").(string); ok && userID != "" {
+		return userID, nil
+	}
 
-		fmt.Print("Cookies: ", token)
+	token := c.Cookies("access_token")
+	if token == "" {
+		return "", errMissingToken
+	}
 
-		if token == "" {
-			return c.Status(401).JSON(fiber.Map{"error": "unauthorized: missing cookie"})
-		}
+	claims, err := utils.ValidateJWT(token)
+	if err != nil {
+		return "", errInvalidToken
+	}
 
-		userID, err := utils.ValidateJWT(token)
-		if err != nil {
-			return c.Status(401).JSON(fiber.Map{"error": "invalid token"})
-		}
+	active, err := currentDirectory().IsSessionActive(claims.SessionID())
+	if err != nil || !active {
+		return "", auth.ErrSessionRevoked
+	}
 
-		c.Locals("user_id", userID)
-		return c.Next()
+	c.Locals("user_id", claims.UserID())
+	c.Locals("session_id", claims.SessionID())
+	if claims.Role != "" {
+		c.Locals("user_role", claims.Role)
+		c.Locals("clinic_id", claims.ClinicID)
 	}
+	return claims.UserID(), nil
 }
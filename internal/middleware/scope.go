@@ -0,0 +1,303 @@
+package middleware
+
+import (
+	"Altheia-Backend/internal/auth"
+	"Altheia-Backend/internal/db"
+	"Altheia-Backend/internal/users"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Scope describes who a requested resource belongs to: the clinic that holds
+// it and, for patient data, the patient and their user account.
+type Scope struct {
+	ClinicID    string
+	OwnerUserID string
+	PatientID   string
+	// Resource and ResourceID name the record the scope was looked up from.
+	Resource   string
+	ResourceID string
+}
+
+// Directory is the lookup used by the authorization middleware to resolve the
+// caller and the scope of the resources it asks for.
+type Directory interface {
+	FindByID(id string) (*users.User, error)
+	IsSessionActive(sessionID string) (bool, error)
+	UserScope(userID string) (Scope, error)
+	PatientScope(patientID string) (Scope, error)
+	PhysicianScope(physicianID string) (Scope, error)
+	MedicalHistoryScope(historyID string) (Scope, error)
+	ConsultationScope(consultationID string) (Scope, error)
+	AppointmentScope(appointmentID string) (Scope, error)
+	WaitlistScope(entryID string) (Scope, error)
+}
+
+var (
+	directoryOnce sync.Once
+	directory     Directory
+)
+
+// SetDirectory replaces the directory used by the authorization middleware
+// (used in tests).
+func SetDirectory(d Directory) {
+	directory = d
+}
+
+// currentDirectory opens the database-backed directory on first use, so that
+// importing the middleware package does not require a connection.
+func currentDirectory() Directory {
+	directoryOnce.Do(func() {
+		if directory == nil {
+			directory = NewDirectory(db.GetDB())
+		}
+	})
+	return directory
+}
+
+type directoryDB struct {
+	db       *gorm.DB
+	authRepo auth.Repository
+}
+
+func NewDirectory(db *gorm.DB) Directory {
+	return &directoryDB{db: db, authRepo: auth.NewRepository(db)}
+}
+
+func (d *directoryDB) FindByID(id string) (*users.User, error) {
+	return d.authRepo.FindByID(id)
+}
+
+func (d *directoryDB) IsSessionActive(sessionID string) (bool, error) {
+	return d.authRepo.IsSessionActive(sessionID)
+}
+
+func (d *directoryDB) UserScope(userID string) (Scope, error) {
+	user, err := d.authRepo.FindByID(userID)
+	if err != nil {
+		return Scope{}, err
+	}
+	return Scope{ClinicID: auth.ClinicIDFromUser(user), OwnerUserID: user.ID}, nil
+}
+
+func (d *directoryDB) PatientScope(patientID string) (Scope, error) {
+	return d.scan(`
+		SELECT COALESCE(p.clinic_id, '') AS clinic_id, p.user_id AS owner_user_id, p.id AS patient_id
+		FROM patients p
+		WHERE p.id = ? AND p.deleted_at IS NULL`, patientID)
+}
+
+func (d *directoryDB) PhysicianScope(physicianID string) (Scope, error) {
+	return d.scan(`
+		SELECT COALESCE(ph.clinic_id, '') AS clinic_id, ph.user_id AS owner_user_id
+		FROM physicians ph
+		WHERE ph.id = ? AND ph.deleted_at IS NULL`, physicianID)
+}
+
+func (d *directoryDB) MedicalHistoryScope(historyID string) (Scope, error) {
+	return d.scan(`
+		SELECT COALESCE(p.clinic_id, '') AS clinic_id, p.user_id AS owner_user_id, p.id AS patient_id
+		FROM medical_histories mh
+		JOIN patients p ON p.id = mh.patient_id
+		WHERE mh.id = ? AND mh.deleted_at IS NULL`, historyID)
+}
+
+func (d *directoryDB) ConsultationScope(consultationID string) (Scope, error) {
+	return d.scan(`
+		SELECT COALESCE(p.clinic_id, '') AS clinic_id, p.user_id AS owner_user_id, p.id AS patient_id
+		FROM medical_consultations mc
+		JOIN medical_histories mh ON mh.id = mc.medical_history_id
+		JOIN patients p ON p.id = mh.patient_id
+		WHERE mc.id = ? AND mc.deleted_at IS NULL`, consultationID)
+}
+
+func (d *directoryDB) AppointmentScope(appointmentID string) (Scope, error) {
+	return d.scan(`
+		SELECT COALESCE(ph.clinic_id, '') AS clinic_id, p.user_id AS owner_user_id, p.id AS patient_id
+		FROM medical_appointments ma
+		JOIN physicians ph ON ph.id = ma.physician_id
+		JOIN patients p ON p.id = ma.patient_id
+		WHERE ma.id = ? AND ma.deleted_at IS NULL`, appointmentID)
+}
+
+func (d *directoryDB) WaitlistScope(entryID string) (Scope, error) {
+	return d.scan(`
+		SELECT w.clinic_id AS clinic_id, p.user_id AS owner_user_id, p.id AS patient_id
+		FROM waitlist_entries w
+		JOIN patients p ON p.id = w.patient_id
+		WHERE w.id = ?`, entryID)
+}
+
+func (d *directoryDB) scan(query string, id string) (Scope, error) {
+	var row struct {
+		ClinicID    string
+		OwnerUserID string
+		PatientID   string
+	}
+	result := d.db.Raw(query, id).Scan(&row)
+	if result.Error != nil {
+		return Scope{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return Scope{}, gorm.ErrRecordNotFound
+	}
+	return Scope{ClinicID: row.ClinicID, OwnerUserID: row.OwnerUserID, PatientID: row.PatientID}, nil
+}
+
+// ClinicResolver extracts the scope of the resource targeted by a request.
+type ClinicResolver func(c *fiber.Ctx) (Scope, error)
+
+// RequireClinic restricts a route to callers that share the clinic of the
+// requested resource. Super-admins are never clinic-bound, and a user always
+// has access to resources that belong to their own account. Patients never get
+// access through clinic membership alone.
+func RequireClinic(resolve ClinicResolver) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		caller, err := loadCaller(c)
+		if err != nil {
+			return deny(c, err)
+		}
+
+		if caller.role == users.RoleSuperAdmin {
+			// The scope is only resolved to tell the audit log whose data
+			// was requested.
+			if audited(c) {
+				if scope, err := resolve(c); err == nil {
+					rememberScope(c, scope)
+				}
+			}
+			return c.Next()
+		}
+
+		scope, err := resolve(c)
+		if err != nil {
+			return deny(c, err)
+		}
+		rememberScope(c, scope)
+
+		if scope.OwnerUserID != "" && scope.OwnerUserID == caller.userID {
+			return c.Next()
+		}
+
+		if caller.role != users.RolePatient && scope.ClinicID != "" && scope.ClinicID == caller.clinicID {
+			return c.Next()
+		}
+
+		return c.Status(403).JSON(fiber.Map{
+			"error": "access denied: resource belongs to another clinic",
+		})
+	}
+}
+
+// ClinicParam scopes a request by the clinic id in a route parameter.
+func ClinicParam(name string) ClinicResolver {
+	return func(c *fiber.Ctx) (Scope, error) {
+		return clinicScope(c.Params(name))
+	}
+}
+
+// ClinicQuery scopes a request by the clinic id in a query string parameter.
+func ClinicQuery(name string) ClinicResolver {
+	return func(c *fiber.Ctx) (Scope, error) {
+		return clinicScope(c.Query(name))
+	}
+}
+
+// ClinicBody scopes a request by the clinic id in a JSON body field.
+func ClinicBody(field string) ClinicResolver {
+	return func(c *fiber.Ctx) (Scope, error) {
+		return clinicScope(bodyField(c, field))
+	}
+}
+
+func UserParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "user", Directory.UserScope)
+}
+
+func PatientParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "patient", Directory.PatientScope)
+}
+
+func PatientBody(field string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return bodyField(c, field) }, "patient", Directory.PatientScope)
+}
+
+func PhysicianParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "physician", Directory.PhysicianScope)
+}
+
+func PhysicianQuery(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Query(name) }, "physician", Directory.PhysicianScope)
+}
+
+func PhysicianBody(field string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return bodyField(c, field) }, "physician", Directory.PhysicianScope)
+}
+
+func MedicalHistoryParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "medical history", Directory.MedicalHistoryScope)
+}
+
+func MedicalHistoryBody(field string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return bodyField(c, field) }, "medical history", Directory.MedicalHistoryScope)
+}
+
+func ConsultationParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "consultation", Directory.ConsultationScope)
+}
+
+func ConsultationBody(field string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return bodyField(c, field) }, "consultation", Directory.ConsultationScope)
+}
+
+func AppointmentParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "appointment", Directory.AppointmentScope)
+}
+
+func WaitlistParam(name string) ClinicResolver {
+	return lookupScope(func(c *fiber.Ctx) string { return c.Params(name) }, "waitlist entry", Directory.WaitlistScope)
+}
+
+func clinicScope(clinicID string) (Scope, error) {
+	if clinicID == "" {
+		return Scope{}, fiber.NewError(400, "clinic ID is required")
+	}
+	return Scope{ClinicID: clinicID}, nil
+}
+
+func lookupScope(id func(c *fiber.Ctx) string, kind string, find func(Directory, string) (Scope, error)) ClinicResolver {
+	return func(c *fiber.Ctx) (Scope, error) {
+		value := id(c)
+		if value == "" {
+			return Scope{}, fiber.NewError(400, kind+" ID is required")
+		}
+
+		scope, err := find(currentDirectory(), value)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return Scope{}, fiber.NewError(404, kind+" not found")
+			}
+			return Scope{}, fmt.Errorf("error resolving %s scope: %v", kind, err)
+		}
+		scope.Resource = strings.ReplaceAll(kind, " ", "_")
+		scope.ResourceID = value
+		return scope, nil
+	}
+}
+
+// bodyField reads a top-level string field from the JSON body without
+// consuming it, so the handler can still parse the body afterwards.
+func bodyField(c *fiber.Ctx, field string) string {
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return ""
+	}
+	value, _ := body[field].(string)
+	return value
+}
@@ -6,6 +6,15 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	RolePatient       = "patient"
+	RolePhysician     = "physician"
+	RoleReceptionist  = "receptionist"
+	RoleOwner         = "owner"
+	RoleLabTechnician = "lab_technician"
+	RoleSuperAdmin    = "super-admin"
+)
+
 type User struct {
 	ID             string         `gorm:"primaryKey" json:"id"`
 	Name           string         `json:"name"`
@@ -20,6 +29,12 @@ type User struct {
 	UpdatedAt      time.Time      `json:"updatedAt"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 	LastLogin      time.Time      `json:"lastLogin"`
+	// EmailVerifiedAt is set once the user proves ownership of Email, either
+	// through the verification link or by setting their first password.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+	// PasswordChangedAt is when Password was last set. Accounts created before
+	// it was tracked fall back to CreatedAt for password expiry.
+	PasswordChangedAt *time.Time `json:"passwordChangedAt,omitempty"`
 
 	Patient       Patient       `gorm:"foreignKey:UserID;references:ID" json:"patient,omitempty"`
 	Physician     Physician     `gorm:"foreignKey:UserID;references:ID" json:"physician,omitempty"`
@@ -98,20 +113,33 @@ type LabTechnician struct {
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
+// LoginActivity records a login attempt. Successful attempts are also the
+// login session the issued tokens belong to. Failed attempts against an email
+// that matches no account have no UserID and are only kept for per-IP
+// throttling.
 type LoginActivity struct {
 	ID               string    `gorm:"primaryKey" json:"id"`
-	UserID           string    `gorm:"not null;index" json:"user_id"`
+	UserID           *string   `gorm:"index" json:"user_id"`
 	User             *User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	DeviceType       string    `json:"device_type"`
-	IPAddress        string    `json:"ip_address"`
+	IPAddress        string    `gorm:"index" json:"ip_address"`
 	Location         string    `json:"location"`
-	LoginTime        time.Time `json:"login_time"`
+	LoginTime        time.Time `gorm:"index" json:"login_time"`
 	IsCurrentSession bool      `json:"is_current_session"`
 	UserAgent        string    `json:"user_agent"`
+	Success          bool      `gorm:"not null;default:true" json:"success"`
+	FailureReason    string    `json:"failure_reason,omitempty"`
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureInvalidMFACode     = "invalid_mfa_code"
+	LoginFailureLocked             = "locked"
+	LoginFailureInactive           = "account_inactive"
+)
+
 type SuperAdmin struct {
 	ID          string         `gorm:"primaryKey" json:"id"`
 	UserID      string         `gorm:"not null;index" json:"user_id"`
@@ -122,3 +150,80 @@ type SuperAdmin struct {
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 }
+
+// RefreshToken is a server-side record of an issued refresh token. Only the
+// SHA-256 hash of the token is stored. Every token belongs to the login session
+// (LoginActivity) that created it; rotating a token marks it as rotated and
+// issues a successor in the same session.
+type RefreshToken struct {
+	ID            string     `gorm:"primaryKey" json:"id"`
+	UserID        string     `gorm:"not null;index" json:"user_id"`
+	SessionID     string     `gorm:"not null;index" json:"session_id"`
+	TokenHash     string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RotatedAt     *time.Time `json:"rotated_at,omitempty"`
+	ReplacedByID  string     `json:"replaced_by_id,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// PasswordHistory keeps the hash of every password a user has set, so the
+// password policy can stop them from going back to a recent one.
+type PasswordHistory struct {
+	ID           string    `gorm:"primaryKey" json:"id"`
+	UserID       string    `gorm:"not null;index" json:"user_id"`
+	PasswordHash string    `gorm:"not null" json:"-"`
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+}
+
+// MFACredential holds the TOTP secret of a user. The credential only protects
+// logins once ConfirmedAt is set, i.e. after the user proved their
+// authenticator produces valid codes. LastUsedStep stops a code from being
+// replayed within its validity window.
+type MFACredential struct {
+	UserID       string     `gorm:"primaryKey" json:"user_id"`
+	Secret       string     `gorm:"not null" json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// MFARecoveryCode is a single-use fallback for a lost authenticator. Only the
+// SHA-256 hash of the code is stored.
+type MFARecoveryCode struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFARolePolicy records whether a role must log in with a second factor.
+type MFARolePolicy struct {
+	Role      string    `gorm:"primaryKey" json:"role"`
+	Required  bool      `json:"required"`
+	UpdatedBy string    `json:"updated_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeSetPassword       = "set_password"
+)
+
+// UserToken is a single-use token emailed to a user, such as a password reset
+// or email verification link. Only the SHA-256 hash of the token is stored.
+type UserToken struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"not null;index" json:"purpose"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,198 @@
+package users
+
+import (
+	"Altheia-Backend/config"
+	"Altheia-Backend/pkg/utils"
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed breached_passwords.txt
+var defaultBreachedPasswords string
+
+// PasswordPolicy is the set of rules every new password must follow. The
+// character classes are the sets used by utils.GeneratePassword.
+type PasswordPolicy struct {
+	MinLength      int
+	RequireLower   bool
+	RequireUpper   bool
+	RequireDigit   bool
+	RequireSpecial bool
+	// HistorySize is how many previous passwords cannot be reused.
+	HistorySize int
+	// StaffMaxAge is how long staff passwords stay valid. Zero disables expiry.
+	StaffMaxAge time.Duration
+
+	breached map[string]bool
+}
+
+// PasswordPolicyError lists every rule a rejected password broke.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password does not meet the policy: " + strings.Join(e.Violations, "; ")
+}
+
+var (
+	passwordPolicyOnce sync.Once
+	passwordPolicy     *PasswordPolicy
+)
+
+// SetPasswordPolicy replaces the policy in use (used in tests).
+func SetPasswordPolicy(p *PasswordPolicy) {
+	passwordPolicy = p
+}
+
+// CurrentPasswordPolicy loads the policy from the environment on first use.
+func CurrentPasswordPolicy() *PasswordPolicy {
+	passwordPolicyOnce.Do(func() {
+		if passwordPolicy == nil {
+			passwordPolicy = PasswordPolicyFromEnv()
+		}
+	})
+	return passwordPolicy
+}
+
+// PasswordPolicyFromEnv reads the policy from PASSWORD_* variables. The
+// breached-password list is the bundled one plus the file named by
+// PASSWORD_BREACHED_LIST, if any.
+func PasswordPolicyFromEnv() *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:      config.GetEnvInt("PASSWORD_MIN_LENGTH", 10),
+		RequireLower:   config.GetEnvBool("PASSWORD_REQUIRE_LOWER", true),
+		RequireUpper:   config.GetEnvBool("PASSWORD_REQUIRE_UPPER", true),
+		RequireDigit:   config.GetEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSpecial: config.GetEnvBool("PASSWORD_REQUIRE_SPECIAL", true),
+		HistorySize:    config.GetEnvInt("PASSWORD_HISTORY", 5),
+		StaffMaxAge:    time.Duration(config.GetEnvInt("PASSWORD_STAFF_MAX_AGE_DAYS", 0)) * 24 * time.Hour,
+	}
+	policy.AddBreachedPasswords(strings.NewReader(defaultBreachedPasswords))
+
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("failed to open breached password list %s: %v", path, err)
+		} else {
+			policy.AddBreachedPasswords(file)
+			file.Close()
+		}
+	}
+	return policy
+}
+
+// AddBreachedPasswords adds one password per line from r to the breached
+// list. Blank lines and lines starting with # are skipped.
+func (p *PasswordPolicy) AddBreachedPasswords(r io.Reader) {
+	if p.breached == nil {
+		p.breached = map[string]bool{}
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = true
+	}
+}
+
+// Validate checks password against the policy for user, who may not be stored
+// yet. previousHashes are the hashes of the user's current and recent
+// passwords; matching any of them is rejected as reuse.
+func (p *PasswordPolicy) Validate(password string, user *User, previousHashes []string) error {
+	var violations []string
+
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	lower, upper, digit, special := utils.PasswordCharClasses(password)
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSpecial && !special {
+		violations = append(violations, "must contain a special character")
+	}
+
+	if p.breached[strings.ToLower(password)] {
+		violations = append(violations, "is too common, it appears in known data breaches")
+	}
+
+	if user != nil && containsPersonalData(password, user) {
+		violations = append(violations, "must not contain your name, email or document number")
+	}
+
+	// Checking the history hashes is slow, so it is only done for passwords
+	// that pass every other rule.
+	if len(violations) == 0 {
+		for _, hash := range previousHashes {
+			if hash != "" && utils.CheckPasswordHash(password, hash) {
+				violations = append(violations, fmt.Sprintf("must not match any of your last %d passwords", p.HistorySize))
+				break
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// containsPersonalData reports whether password contains a part of the user's
+// name, the local part of their email or their document number.
+func containsPersonalData(password string, user *User) bool {
+	password = strings.ToLower(password)
+
+	terms := strings.Fields(strings.ToLower(user.Name))
+	if local, _, found := strings.Cut(strings.ToLower(user.Email), "@"); found {
+		terms = append(terms, local)
+	}
+	terms = append(terms, strings.ToLower(user.DocumentNumber))
+
+	for _, term := range terms {
+		if len([]rune(term)) >= 3 && strings.Contains(password, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordExpired reports whether user has to change their password before
+// logging in. Only staff passwords expire.
+func (p *PasswordPolicy) PasswordExpired(user *User, now time.Time) bool {
+	if p.StaffMaxAge <= 0 || user.Rol == RolePatient {
+		return false
+	}
+
+	changedAt := user.CreatedAt
+	if user.PasswordChangedAt != nil {
+		changedAt = *user.PasswordChangedAt
+	}
+	return now.Sub(changedAt) > p.StaffMaxAge
+}
+
+// NewPasswordHistory records hash as a password set by userID.
+func NewPasswordHistory(userID, hash string) *PasswordHistory {
+	return &PasswordHistory{
+		ID:           utils.GenerateNanoID(),
+		UserID:       userID,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+}
@@ -0,0 +1,111 @@
+package users
+
+import (
+	"Altheia-Backend/pkg/utils"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPolicy() *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:      10,
+		RequireLower:   true,
+		RequireUpper:   true,
+		RequireDigit:   true,
+		RequireSpecial: true,
+		HistorySize:    5,
+		StaffMaxAge:    90 * 24 * time.Hour,
+	}
+	policy.AddBreachedPasswords(strings.NewReader("# comment\nP@ssw0rd1234\n"))
+	return policy
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := testPolicy()
+	user := &User{Name: "Laura Gomez", Email: "lgomez@example.com", DocumentNumber: "1020304050"}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"valid", "Blue-Tiger-42", false},
+		{"too short", "Bt-42", true},
+		{"missing uppercase", "blue-tiger-42", true},
+		{"missing digit", "Blue-Tiger-xx", true},
+		{"missing special character", "BlueTiger42x", true},
+		{"breached regardless of case", "p@ssw0rd1234", true},
+		{"contains name", "Laura-Tiger-42", true},
+		{"contains email local part", "Lgomez-Tiger-42", true},
+		{"contains document number", "Tiger-1020304050", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password, user, nil)
+			var policyErr *PasswordPolicyError
+			if tt.wantErr != errors.As(err, &policyErr) {
+				t.Errorf("Validate(%q) error = %v, wantErr %v", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyHistory(t *testing.T) {
+	policy := testPolicy()
+	previous, err := utils.HashPassword("Blue-Tiger-42")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	if err := policy.Validate("Blue-Tiger-42", nil, []string{previous}); err == nil {
+		t.Error("Validate() accepted a password from the history")
+	}
+	if err := policy.Validate("Green-Tiger-42", nil, []string{previous}); err != nil {
+		t.Errorf("Validate() with a new password error = %v", err)
+	}
+}
+
+func TestGeneratedPasswordsSatisfyPolicy(t *testing.T) {
+	policy := testPolicy()
+	for i := 0; i < 20; i++ {
+		password, err := utils.GeneratePassword(policy.MinLength)
+		if err != nil {
+			t.Fatalf("GeneratePassword() error = %v", err)
+		}
+		if err := policy.Validate(password, nil, nil); err != nil {
+			t.Errorf("GeneratePassword() = %q does not satisfy the policy: %v", password, err)
+		}
+	}
+}
+
+func TestPasswordExpired(t *testing.T) {
+	policy := testPolicy()
+	now := time.Now()
+	old := now.Add(-100 * 24 * time.Hour)
+	recent := now.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		user User
+		want bool
+	}{
+		{"staff with old password", User{Rol: RolePhysician, PasswordChangedAt: &old}, true},
+		{"staff with recent password", User{Rol: RolePhysician, PasswordChangedAt: &recent}, false},
+		{"staff without change date uses creation", User{Rol: RoleOwner, CreatedAt: old}, true},
+		{"patients never expire", User{Rol: RolePatient, PasswordChangedAt: &old}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.PasswordExpired(&tt.user, now); got != tt.want {
+				t.Errorf("PasswordExpired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	policy.StaffMaxAge = 0
+	if policy.PasswordExpired(&User{Rol: RolePhysician, PasswordChangedAt: &old}, now) {
+		t.Error("PasswordExpired() must be false when expiry is disabled")
+	}
+}
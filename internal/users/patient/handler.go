@@ -2,6 +2,7 @@ package patient
 
 import (
 	"Altheia-Backend/internal/users"
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -24,6 +25,10 @@ func (h *Handler) RegisterPatient(c *fiber.Ctx) error {
 	}
 
 	if err := h.service.RegisterPatient(patient); err != nil {
+		var policy *users.PasswordPolicyError
+		if errors.As(err, &policy) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error(), "violations": policy.Violations})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(fiber.Map{"message": "registered successfully"})
@@ -37,6 +42,10 @@ func (h *Handler) UpdatePatient(c *fiber.Ctx) error {
 
 	id := c.Params("id")
 	if err := h.service.UpdatePatient(id, patient); err != nil {
+		var policy *users.PasswordPolicyError
+		if errors.As(err, &policy) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error(), "violations": policy.Violations})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(fiber.Map{"message": "updated successfully"})
@@ -8,8 +8,9 @@ import (
 )
 
 type Repository interface {
-	Create(user *users.User) error
+	Create(user *users.User, verification *users.UserToken) error
 	UpdateUserAndPatient(UserId string, Info UpdatePatientInfo) error
+	FindUserByID(userId string) (*users.User, error)
 	SoftDelete(userId string) error
 	GetAllPatientsPaginated(page, limit int) (users.Pagination, error)
 	GetAllPatients() ([]users.Patient, error)
@@ -25,8 +26,18 @@ func NewRepository(db *gorm.DB) Repository {
 	return &repository{db}
 }
 
-func (r *repository) Create(user *users.User) error {
-	return r.db.Session(&gorm.Session{FullSaveAssociations: true}).Create(user).Error
+// Create stores the patient account together with the token of the email
+// verification link that activates it, and starts its password history.
+func (r *repository) Create(user *users.User, verification *users.UserToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Session(&gorm.Session{FullSaveAssociations: true}).Create(user).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(users.NewPasswordHistory(user.ID, user.Password)).Error; err != nil {
+			return err
+		}
+		return tx.Create(verification).Error
+	})
 }
 
 func (r *repository) UpdateUserAndPatient(UserId string, Info UpdatePatientInfo) error {
@@ -39,6 +50,7 @@ func (r *repository) UpdateUserAndPatient(UserId string, Info UpdatePatientInfo)
 
 		if Info.Password != "" {
 			userUpdates["password"] = Info.Password
+			userUpdates["password_changed_at"] = time.Now()
 		}
 
 		if err := tx.Model(&users.User{}).Where("id = ?", UserId).
@@ -46,6 +58,12 @@ func (r *repository) UpdateUserAndPatient(UserId string, Info UpdatePatientInfo)
 			return err
 		}
 
+		if Info.Password != "" {
+			if err := tx.Create(users.NewPasswordHistory(UserId, Info.Password)).Error; err != nil {
+				return err
+			}
+		}
+
 		if err := tx.Model(&users.Patient{}).Where("user_id = ?", UserId).
 			Updates(map[string]interface{}{
 				"eps":     Info.Eps,
@@ -58,6 +76,12 @@ func (r *repository) UpdateUserAndPatient(UserId string, Info UpdatePatientInfo)
 	})
 }
 
+func (r *repository) FindUserByID(userId string) (*users.User, error) {
+	var user users.User
+	err := r.db.Where("id = ?", userId).First(&user).Error
+	return &user, err
+}
+
 func (r *repository) SoftDelete(userId string) error {
 	patient := users.Patient{
 		DeletedAt: gorm.DeletedAt{
@@ -1,8 +1,10 @@
 package patient
 
 import (
+	"Altheia-Backend/internal/mail"
 	"Altheia-Backend/internal/users"
 	"Altheia-Backend/pkg/utils"
+	"log"
 	"time"
 
 	gonanoid "github.com/matoous/go-nanoid"
@@ -19,20 +21,35 @@ type Service interface {
 	GetPatientByClinicIdPaginated(clinicId string, page, limit int) (users.Pagination, error)
 }
 
+// Credentials keeps the password history and sessions of every account; the
+// auth repository implements it.
+type Credentials interface {
+	RecentPasswordHashes(userID string, limit int) ([]string, error)
+	RevokeUserRefreshTokens(userID string, reason string) error
+}
+
 type service struct {
-	repository Repository
+	repository  Repository
+	credentials Credentials
 }
 
-func NewService(repository Repository) Service {
+func NewService(repository Repository, credentials Credentials) Service {
 	return &service{
-		repository: repository,
+		repository:  repository,
+		credentials: credentials,
 	}
 }
 
 func (s *service) RegisterPatient(patient CreatePatientInfo) error {
+	candidate := &users.User{Name: patient.Name, Email: patient.Email, DocumentNumber: patient.DocumentNumber}
+	if err := users.CurrentPasswordPolicy().Validate(patient.Password, candidate, nil); err != nil {
+		return err
+	}
+
 	nanoid, _ := gonanoid.Nanoid()
 	patientNanoid, _ := gonanoid.Nanoid()
 	hashed, _ := utils.HashPassword(patient.Password)
+	passwordChangedAt := time.Now()
 
 	newUser := users.User{
 		ID:             nanoid,
@@ -42,12 +59,14 @@ func (s *service) RegisterPatient(patient CreatePatientInfo) error {
 		Rol:            "patient",
 		Phone:          patient.Phone,
 		DocumentNumber: patient.DocumentNumber,
-		Status:         true,
+		Status:         false,
 		Gender:         patient.Gender,
 		CreatedAt:      time.Time{},
 		UpdatedAt:      time.Time{},
 		DeletedAt:      gorm.DeletedAt{},
 		LastLogin:      time.Time{},
+
+		PasswordChangedAt: &passwordChangedAt,
 		Patient: users.Patient{
 			ID:     patientNanoid,
 			UserID: nanoid,
@@ -67,10 +86,22 @@ func (s *service) RegisterPatient(patient CreatePatientInfo) error {
 		},
 	}
 
-	newPatient := s.repository.Create(&newUser)
+	verificationToken, verification, err := users.NewUserToken(nanoid, users.TokenPurposeEmailVerification, users.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
 
-	return newPatient
+	if err := s.repository.Create(&newUser, verification); err != nil {
+		return err
+	}
 
+	// The account exists at this point, so a failed email must not make the
+	// request fail and be retried as a duplicate: the patient can ask for a
+	// new link through /auth/resend-verification.
+	if err := mail.SendEmailVerification(newUser.Name, newUser.Email, verificationToken); err != nil {
+		log.Printf("failed to send the verification link to patient %s: %v", nanoid, err)
+	}
+	return nil
 }
 
 func (s *service) UpdatePatient(userId string, patientData UpdatePatientInfo) error {
@@ -82,13 +113,38 @@ func (s *service) UpdatePatient(userId string, patientData UpdatePatientInfo) er
 	}
 
 	if patientData.Password != "" {
+		if err := s.checkNewPassword(userId, patientData.Password); err != nil {
+			return err
+		}
 		hashed, _ := utils.HashPassword(patientData.Password)
 		updatedPatient.Password = hashed
 	}
 
-	patient := s.repository.UpdateUserAndPatient(userId, updatedPatient)
+	if err := s.repository.UpdateUserAndPatient(userId, updatedPatient); err != nil {
+		return err
+	}
+
+	if updatedPatient.Password != "" {
+		return s.credentials.RevokeUserRefreshTokens(userId, "password_changed")
+	}
+	return nil
+}
+
+// checkNewPassword validates password against the password policy for the
+// user, including the current and recent passwords it must not repeat.
+func (s *service) checkNewPassword(userId, password string) error {
+	user, err := s.repository.FindUserByID(userId)
+	if err != nil {
+		return err
+	}
+
+	policy := users.CurrentPasswordPolicy()
+	history, err := s.credentials.RecentPasswordHashes(userId, policy.HistorySize)
+	if err != nil {
+		return err
+	}
 
-	return patient
+	return policy.Validate(password, user, append([]string{user.Password}, history...))
 }
 
 func (s *service) SoftDeletePatient(userId string) error {
@@ -0,0 +1,62 @@
+package patient
+
+import (
+	"Altheia-Backend/internal/users"
+	"Altheia-Backend/pkg/utils"
+	"testing"
+)
+
+type sessionRepo struct {
+	Repository
+	user    *users.User
+	updated *UpdatePatientInfo
+}
+
+type credentials struct {
+	revoked []string
+}
+
+func (r *sessionRepo) FindUserByID(string) (*users.User, error) {
+	return r.user, nil
+}
+
+func (c *credentials) RecentPasswordHashes(string, int) ([]string, error) {
+	return nil, nil
+}
+
+func (r *sessionRepo) UpdateUserAndPatient(userId string, info UpdatePatientInfo) error {
+	r.updated = &info
+	return nil
+}
+
+func (c *credentials) RevokeUserRefreshTokens(userId string, reason string) error {
+	c.revoked = append(c.revoked, userId+":"+reason)
+	return nil
+}
+
+func TestUpdatePatient_RevokesSessionsOnPasswordChange(t *testing.T) {
+	current, err := utils.HashPassword("Viejo#Clave2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := &sessionRepo{user: &users.User{ID: "user-1", Name: "Ana Gómez", Email: "ana@example.com", Password: current}}
+	creds := &credentials{}
+	s := NewService(repo, creds)
+
+	if err := s.UpdatePatient("user-1", UpdatePatientInfo{Name: "Ana Gómez", Phone: "3001234567"}); err != nil {
+		t.Fatalf("UpdatePatient() without a password error = %v", err)
+	}
+	if len(creds.revoked) != 0 {
+		t.Errorf("sessions revoked without a password change: %v", creds.revoked)
+	}
+
+	if err := s.UpdatePatient("user-1", UpdatePatientInfo{Name: "Ana Gómez", Password: "Zq9!kT#v2Lm$"}); err != nil {
+		t.Fatalf("UpdatePatient() with a password error = %v", err)
+	}
+	if repo.updated.Password == "" || repo.updated.Password == "Zq9!kT#v2Lm$" {
+		t.Errorf("stored password = %q, want the hash of the new password", repo.updated.Password)
+	}
+	if len(creds.revoked) != 1 || creds.revoked[0] != "user-1:password_changed" {
+		t.Errorf("revoked = %v, want the sessions of user-1 revoked as password_changed", creds.revoked)
+	}
+}
@@ -0,0 +1,31 @@
+package users
+
+import (
+	"Altheia-Backend/pkg/utils"
+	"time"
+)
+
+// NewUserToken generates a single-use token for userID. It returns the raw
+// token, which is only ever sent to the user, and the record to store.
+func NewUserToken(userID, purpose string, ttl time.Duration) (string, *UserToken, error) {
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	return raw, &UserToken{
+		ID:        utils.GenerateNanoID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(raw),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, nil
+}
+
+const (
+	PasswordResetTTL     = time.Hour
+	EmailVerificationTTL = 48 * time.Hour
+	SetPasswordTTL       = 72 * time.Hour
+)
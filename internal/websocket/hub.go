@@ -8,10 +8,11 @@ import (
 
 func NewHub() *Hub {
 	return &Hub{
-		Clients:    make(map[*Client]bool),
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
+		Clients:         make(map[*Client]bool),
+		Broadcast:       make(chan []byte),
+		ClinicBroadcast: make(chan ClinicMessage),
+		Register:        make(chan *Client),
+		Unregister:      make(chan *Client),
 	}
 }
 
@@ -53,21 +54,27 @@ func (h *Hub) Run() {
 					delete(h.Clients, client)
 				}
 			}
+
+		case message := <-h.ClinicBroadcast:
+			for client := range h.Clients {
+				if client.ClinicID != message.ClinicID {
+					continue
+				}
+				select {
+				case client.Send <- message.Data:
+				default:
+					close(client.Send)
+					delete(h.Clients, client)
+				}
+			}
 		}
 	}
 }
 
+// BroadcastToClinic sends the message to the clients of one clinic. The hub's
+// goroutine delivers it, so the clients are never touched concurrently.
 func (h *Hub) BroadcastToClinic(clinicID string, message []byte) {
-	for client := range h.Clients {
-		if client.ClinicID == clinicID {
-			select {
-			case client.Send <- message:
-			default:
-				close(client.Send)
-				delete(h.Clients, client)
-			}
-		}
-	}
+	h.ClinicBroadcast <- ClinicMessage{ClinicID: clinicID, Data: message}
 }
 
 func (h *Hub) GetConnectedClients() int {
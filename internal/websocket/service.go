@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"Altheia-Backend/internal/clinical"
 	"encoding/json"
 	"log"
 	"time"
@@ -65,6 +66,8 @@ func (s *Service) broadcastAppointmentStats() {
 	appointmentsByMonth := s.getAppointmentsByMonth()
 	totalAppointments := s.getTotalAppointments()
 	todayAppointments := s.getTodayAppointments()
+	noShowRate := s.getNoShowRate()
+	noShowsByWeekday := s.getNoShowsByWeekday()
 
 	message := AppointmentStatsMessage{
 		Type: "appointment_stats",
@@ -73,6 +76,8 @@ func (s *Service) broadcastAppointmentStats() {
 	message.Data.AppointmentsByMonth = appointmentsByMonth
 	message.Data.TotalAppointments = totalAppointments
 	message.Data.TodayAppointments = todayAppointments
+	message.Data.NoShowRate = noShowRate
+	message.Data.NoShowsByWeekday = noShowsByWeekday
 	message.Data.Timestamp = time.Now()
 
 	if data, err := json.Marshal(message); err == nil {
@@ -294,6 +299,66 @@ func (s *Service) getTodayAppointments() int {
 	return int(count)
 }
 
+// getNoShowRate is the share of the last 90 days' attended or missed
+// appointments that were missed.
+func (s *Service) getNoShowRate() float64 {
+	var result struct {
+		Completed int64
+		NoShows   int64
+	}
+
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'completed') as completed,
+			COUNT(*) FILTER (WHERE status = 'no_show') as no_shows
+		FROM medical_appointments
+		WHERE deleted_at IS NULL
+		AND date_time >= CURRENT_DATE - INTERVAL '90 days'
+	`
+
+	s.db.Raw(query).Scan(&result)
+
+	if result.Completed+result.NoShows == 0 {
+		return 0
+	}
+	return float64(result.NoShows) / float64(result.Completed+result.NoShows)
+}
+
+func (s *Service) getNoShowsByWeekday() []ChartData {
+	var results []struct {
+		Weekday int
+		Count   int
+	}
+
+	query := `
+		SELECT
+			EXTRACT(DOW FROM ma.date_time AT TIME ZONE COALESCE(ci.timezone, ?))::int as weekday,
+			COUNT(*) as count
+		FROM medical_appointments ma
+		JOIN physicians ph ON ph.id = ma.physician_id
+		LEFT JOIN clinic_informations ci ON ci.clinic_id = ph.clinic_id
+		WHERE ma.deleted_at IS NULL
+		AND ma.status = 'no_show'
+		AND ma.date_time >= CURRENT_DATE - INTERVAL '90 days'
+		GROUP BY weekday
+	`
+
+	s.db.Raw(query, clinical.DefaultTimezone).Scan(&results)
+
+	labels := []string{"Domingo", "Lunes", "Martes", "Miércoles", "Jueves", "Viernes", "Sábado"}
+	chartData := make([]ChartData, len(labels))
+	for i, label := range labels {
+		chartData[i] = ChartData{Name: label}
+	}
+	for _, result := range results {
+		if result.Weekday >= 0 && result.Weekday < len(chartData) {
+			chartData[result.Weekday].Value = result.Count
+		}
+	}
+
+	return chartData
+}
+
 func (s *Service) getConsultationsCreated() []ChartData {
 	var results []struct {
 		Month string
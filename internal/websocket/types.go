@@ -24,6 +24,8 @@ type AppointmentStatsMessage struct {
 		AppointmentsByMonth  []ChartData `json:"appointments_by_month"`
 		TotalAppointments    int         `json:"total_appointments"`
 		TodayAppointments    int         `json:"today_appointments"`
+		NoShowRate           float64     `json:"no_show_rate"`
+		NoShowsByWeekday     []ChartData `json:"no_shows_by_weekday"`
 		Timestamp            time.Time   `json:"timestamp"`
 	} `json:"data"`
 }
@@ -46,10 +48,17 @@ type Client struct {
 }
 
 type Hub struct {
-	Clients    map[*Client]bool
-	Broadcast  chan []byte
-	Register   chan *Client
-	Unregister chan *Client
+	Clients         map[*Client]bool
+	Broadcast       chan []byte
+	ClinicBroadcast chan ClinicMessage
+	Register        chan *Client
+	Unregister      chan *Client
+}
+
+// ClinicMessage is a message for the clients of one clinic.
+type ClinicMessage struct {
+	ClinicID string
+	Data     []byte
 }
 
 type Message struct {
@@ -72,29 +72,6 @@ func GetLocationFromIP(ipAddress string) string {
 	return "Unknown Location"
 }
 
-func GetClientIP(xForwardedFor, xRealIP, remoteAddr string) string {
-
-	if xForwardedFor != "" {
-		ips := strings.Split(xForwardedFor, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
-		}
-	}
-
-	if xRealIP != "" {
-		return xRealIP
-	}
-
-	if strings.Contains(remoteAddr, ":") {
-		parts := strings.Split(remoteAddr, ":")
-		if len(parts) > 0 {
-			return parts[0]
-		}
-	}
-
-	return remoteAddr
-}
-
 func GenerateNanoID() string {
 	return uuid.New().String()
 }
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"fmt"
 	"math/big"
+	"strings"
 )
 
 var (
@@ -34,14 +35,34 @@ func shufflePassword(password []byte) ([]byte, error) {
 	return password, nil
 }
 
+// PasswordCharClasses reports which of the character sets used by
+// GeneratePassword appear in password.
+func PasswordCharClasses(password string) (lower, upper, digit, special bool) {
+	for _, char := range password {
+		switch {
+		case strings.ContainsRune(lowerChars, char):
+			lower = true
+		case strings.ContainsRune(upperChars, char):
+			upper = true
+		case strings.ContainsRune(digitChars, char):
+			digit = true
+		case strings.ContainsRune(specialChars, char):
+			special = true
+		}
+	}
+	return lower, upper, digit, special
+}
+
+// GeneratePassword returns a random password of length that contains at least
+// one character of every set, so that it satisfies the password policy.
 func GeneratePassword(length int) (string, error) {
-	if length < 3 {
-		return "", fmt.Errorf("la longitud mínima debe ser al menos 3")
+	if length < 4 {
+		return "", fmt.Errorf("la longitud mínima debe ser al menos 4")
 	}
 
 	password := make([]byte, 0, length)
 
-	requiredSets := []string{upperChars, digitChars, specialChars}
+	requiredSets := []string{lowerChars, upperChars, digitChars, specialChars}
 	for _, set := range requiredSets {
 		char, err := getRandomChar(set)
 		if err != nil {
@@ -8,62 +8,135 @@ import (
 	"time"
 )
 
-func GenerateJWT(userID string, isTime int16) (string, error) {
-	if isTime <= 0 {
-		isTime = 1
+const (
+	defaultIssuer         = "altheia"
+	defaultAudience       = "altheia-api"
+	defaultAccessTokenTTL = time.Hour
+
+	// MFAChallengeTTL is how long a user has to submit the second factor
+	// after a successful password check.
+	MFAChallengeTTL = 5 * time.Minute
+)
+
+// Claims are the claims carried by an Altheia access token. The subject is the
+// user id and the token id (jti) is the login session the token belongs to.
+type Claims struct {
+	Role     string `json:"role,omitempty"`
+	ClinicID string `json:"clinic_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+func (c *Claims) SessionID() string {
+	return c.ID
+}
+
+// AccessTokenTTL returns the access token lifetime configured in
+// JWT_ACCESS_TTL (a Go duration such as "15m"), defaulting to one hour.
+func AccessTokenTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("JWT_ACCESS_TTL")); err == nil && ttl > 0 {
+		return ttl
 	}
-	claims := jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(time.Hour * time.Duration(isTime)).Unix(),
+	return defaultAccessTokenTTL
+}
+
+func jwtIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return defaultIssuer
 }
 
-func ValidateJWT(tokenStr string) (string, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+func jwtAudience() string {
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		return audience
+	}
+	return defaultAudience
+}
 
-	if err != nil {
-		return "", fmt.Errorf("invalid token: %v", err)
+// GenerateJWT signs an access token for userID. A ttl of zero uses
+// AccessTokenTTL.
+func GenerateJWT(userID, role, clinicID, sessionID string, ttl time.Duration) (string, error) {
+	if ttl <= 0 {
+		ttl = AccessTokenTTL()
 	}
 
-	if !token.Valid {
-		return "", errors.New("invalid token")
+	now := time.Now()
+	claims := Claims{
+		Role:     role,
+		ClinicID: clinicID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        sessionID,
+			Issuer:    jwtIssuer(),
+			Audience:  jwt.ClaimStrings{jwtAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
 	}
+	return CurrentKeyring().Sign(claims)
+}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", errors.New("invalid token claims")
+// ValidateJWT verifies an access token against the keyring, checking its
+// signature, expiry, issuer and audience, and returns its claims.
+func ValidateJWT(tokenStr string) (*Claims, error) {
+	return parseClaims(tokenStr, jwtAudience())
+}
+
+// GenerateMFAChallenge signs the short-lived token returned by the password
+// step of a login that still needs a second factor. It uses its own audience
+// so it can never be used as an access token.
+func GenerateMFAChallenge(userID string) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			Issuer:    jwtIssuer(),
+			Audience:  jwt.ClaimStrings{mfaAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFAChallengeTTL)),
+		},
 	}
+	return CurrentKeyring().Sign(claims)
+}
 
-	sub, ok := claims["sub"].(string)
-	if !ok || sub == "" {
-		return "", errors.New("missing or invalid 'sub' claim")
+// ValidateMFAChallenge verifies an MFA challenge token and returns the user id
+// it was issued for.
+func ValidateMFAChallenge(tokenStr string) (string, error) {
+	claims, err := parseClaims(tokenStr, mfaAudience())
+	if err != nil {
+		return "", err
 	}
+	return claims.UserID(), nil
+}
 
-	return sub, nil
+func mfaAudience() string {
+	return jwtAudience() + ":mfa"
 }
 
-func RefreshToken(tokenStr string) (string, string, error) {
-	payload, err := ValidateJWT(tokenStr)
+func parseClaims(tokenStr, audience string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, CurrentKeyring().Keyfunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithIssuer(jwtIssuer()),
+		jwt.WithAudience(audience),
+		jwt.WithExpirationRequired(),
+	)
+
 	if err != nil {
-		return "", "", err
+		return nil, fmt.Errorf("invalid token: %v", err)
 	}
 
-	newAccessToken, err := GenerateJWT(payload, 3)
-	if err != nil {
-		return "", "", err
+	if !token.Valid {
+		return nil, errors.New("invalid token")
 	}
 
-	refreshToken, err := GenerateJWT(payload, 168)
-	if err != nil {
-		return "", "", err
+	if claims.Subject == "" {
+		return nil, errors.New("missing or invalid 'sub' claim")
 	}
 
-	return newAccessToken, refreshToken, nil
+	return claims, nil
 }
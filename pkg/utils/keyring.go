@@ -0,0 +1,273 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one entry of the keyring. Retired keys may be loaded with only
+// their public half so tokens they signed still verify until they expire.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// Keyring holds the keys used to sign and verify access tokens. New tokens are
+// signed with the active key; any key in the ring can verify.
+type Keyring struct {
+	active string
+	keys   map[string]*SigningKey
+}
+
+// NewKeyring builds a keyring that signs with the key whose ID is active.
+func NewKeyring(active string, keys ...*SigningKey) (*Keyring, error) {
+	ring := &Keyring{active: active, keys: make(map[string]*SigningKey, len(keys))}
+	for _, key := range keys {
+		if _, exists := ring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ring.keys[key.ID] = key
+	}
+
+	signer, ok := ring.keys[active]
+	if !ok {
+		return nil, fmt.Errorf("active key %q is not in the keyring", active)
+	}
+	if signer.Private == nil {
+		return nil, fmt.Errorf("active key %q has no private key", active)
+	}
+	return ring, nil
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key, picking RS256 or EdDSA
+// accordingly.
+func NewSigningKey(id string, private crypto.Signer) (*SigningKey, error) {
+	method, err := signingMethodFor(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Method: method, Private: private, Public: private.Public()}, nil
+}
+
+// NewVerificationKey wraps the public half of a retired RSA or Ed25519 key.
+func NewVerificationKey(id string, public crypto.PublicKey) (*SigningKey, error) {
+	method, err := signingMethodFor(public)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Method: method, Public: public}, nil
+}
+
+func signingMethodFor(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key := k.keys[k.active]
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key from the kid header of a token.
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the keyring, sorted by key ID.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(k.keys))}
+	for _, key := range k.keys {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+var (
+	keyringOnce sync.Once
+	keyring     *Keyring
+)
+
+// SetKeyring replaces the keyring used to sign and verify access tokens.
+func SetKeyring(k *Keyring) {
+	keyring = k
+}
+
+// CurrentKeyring returns the configured keyring, loading it from the
+// environment on first use.
+func CurrentKeyring() *Keyring {
+	keyringOnce.Do(func() {
+		if keyring != nil {
+			return
+		}
+		ring, err := LoadKeyringFromEnv()
+		if err != nil {
+			log.Fatalf("failed to load JWT signing keys: %v", err)
+		}
+		keyring = ring
+	})
+	return keyring
+}
+
+// LoadKeyringFromEnv loads every PEM file in JWT_KEYS_DIR into a keyring. The
+// file name without extension is the key ID; private keys (PKCS#8 or PKCS#1)
+// can sign, public keys (PKIX) only verify. JWT_ACTIVE_KID selects the signing
+// key and defaults to the last private key by name. JWT_KEYS_DIR may only be
+// left unset with APP_ENV=development, where an ephemeral Ed25519 key is
+// generated: its tokens do not survive a restart nor work across replicas.
+func LoadKeyringFromEnv() (*Keyring, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("APP_ENV") != "development" {
+			return nil, errors.New("JWT_KEYS_DIR is not set; set it to the directory of the signing keys, or APP_ENV=development to sign with an ephemeral key")
+		}
+		log.Println("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key")
+		return NewEphemeralKeyring()
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .pem keys found in %s", dir)
+	}
+	sort.Strings(paths)
+
+	var keys []*SigningKey
+	active := os.Getenv("JWT_ACTIVE_KID")
+	lastPrivate := ""
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		key, err := loadPEMKey(id, path)
+		if err != nil {
+			return nil, err
+		}
+		if key.Private != nil {
+			lastPrivate = id
+		}
+		keys = append(keys, key)
+	}
+
+	if active == "" {
+		active = lastPrivate
+	}
+	return NewKeyring(active, keys...)
+}
+
+// NewEphemeralKeyring returns a keyring with a freshly generated Ed25519 key.
+func NewEphemeralKeyring() (*Keyring, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id, err := GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewSigningKey(id[:16], private)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(key.ID, key)
+}
+
+func loadPEMKey(id, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported private key", path)
+		}
+		return NewSigningKey(id, signer)
+	case "RSA PRIVATE KEY":
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return NewSigningKey(id, parsed)
+	case "PUBLIC KEY":
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return NewVerificationKey(id, parsed)
+	default:
+		return nil, errors.New(path + ": unsupported PEM block " + block.Type)
+	}
+}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newRSAKey(t *testing.T, id string) *SigningKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() error = %v", err)
+	}
+	key, err := NewSigningKey(id, private)
+	if err != nil {
+		t.Fatalf("NewSigningKey() error = %v", err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T, id string) *SigningKey {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() error = %v", err)
+	}
+	key, err := NewSigningKey(id, private)
+	if err != nil {
+		t.Fatalf("NewSigningKey() error = %v", err)
+	}
+	return key
+}
+
+func useKeyring(t *testing.T, active string, keys ...*SigningKey) {
+	t.Helper()
+	ring, err := NewKeyring(active, keys...)
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	previous := keyring
+	SetKeyring(ring)
+	t.Cleanup(func() { SetKeyring(previous) })
+}
+
+func TestJWTRoundTrip(t *testing.T) {
+	for _, key := range []*SigningKey{newRSAKey(t, "rsa-1"), newEd25519Key(t, "ed-1")} {
+		t.Run(key.Method.Alg(), func(t *testing.T) {
+			useKeyring(t, key.ID, key)
+
+			token, err := GenerateJWT("user-1", "physician", "clinic-1", "session-1", time.Minute)
+			if err != nil {
+				t.Fatalf("GenerateJWT() error = %v", err)
+			}
+
+			claims, err := ValidateJWT(token)
+			if err != nil {
+				t.Fatalf("ValidateJWT() error = %v", err)
+			}
+			if claims.UserID() != "user-1" || claims.Role != "physician" || claims.ClinicID != "clinic-1" || claims.SessionID() != "session-1" {
+				t.Errorf("ValidateJWT() claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey := newEd25519Key(t, "2025-01")
+	newKey := newRSAKey(t, "2025-06")
+
+	useKeyring(t, oldKey.ID, oldKey)
+	oldToken, err := GenerateJWT("user-1", "owner", "", "", time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+
+	retired, err := NewVerificationKey(oldKey.ID, oldKey.Public)
+	if err != nil {
+		t.Fatalf("NewVerificationKey() error = %v", err)
+	}
+	useKeyring(t, newKey.ID, retired, newKey)
+
+	if _, err := ValidateJWT(oldToken); err != nil {
+		t.Errorf("token signed with retired key should still verify: %v", err)
+	}
+
+	useKeyring(t, newKey.ID, newKey)
+	if _, err := ValidateJWT(oldToken); err == nil {
+		t.Error("token signed with a removed key should not verify")
+	}
+}
+
+func TestNewKeyringRequiresPrivateActiveKey(t *testing.T) {
+	key := newEd25519Key(t, "k1")
+	public, _ := NewVerificationKey("k1", key.Public)
+
+	if _, err := NewKeyring("missing", key); err == nil {
+		t.Error("NewKeyring() with unknown active key should fail")
+	}
+	if _, err := NewKeyring("k1", public); err == nil {
+		t.Error("NewKeyring() with public-only active key should fail")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	ring, err := NewKeyring("b", newRSAKey(t, "b"), newEd25519Key(t, "a"))
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+
+	set := ring.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS() returned %d keys, want 2", len(set.Keys))
+	}
+
+	ed, rsaKey := set.Keys[0], set.Keys[1]
+	if ed.Kid != "a" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.X == "" {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+	if rsaKey.Kid != "b" || rsaKey.Kty != "RSA" || rsaKey.Alg != "RS256" || rsaKey.N == "" || rsaKey.E != "AQAB" {
+		t.Errorf("RSA JWK = %+v", rsaKey)
+	}
+}
+
+func TestLoadKeyringFromEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(edPrivate)
+	writePEM(t, filepath.Join(dir, "2025-06.pem"), "PRIVATE KEY", der)
+
+	rsaPrivate, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ = x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	writePEM(t, filepath.Join(dir, "2025-01.pem"), "PUBLIC KEY", der)
+
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_ACTIVE_KID", "")
+
+	ring, err := LoadKeyringFromEnv()
+	if err != nil {
+		t.Fatalf("LoadKeyringFromEnv() error = %v", err)
+	}
+	if ring.active != "2025-06" {
+		t.Errorf("active key = %q, want 2025-06", ring.active)
+	}
+	if len(ring.JWKS().Keys) != 2 {
+		t.Errorf("keyring has %d keys, want 2", len(ring.JWKS().Keys))
+	}
+
+	t.Setenv("JWT_ACTIVE_KID", "2025-01")
+	if _, err := LoadKeyringFromEnv(); err == nil {
+		t.Error("LoadKeyringFromEnv() with public-only active key should fail")
+	}
+}
+
+func TestLoadKeyringFromEnvWithoutKeys(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", "")
+
+	t.Setenv("APP_ENV", "")
+	if _, err := LoadKeyringFromEnv(); err == nil {
+		t.Error("LoadKeyringFromEnv() without JWT_KEYS_DIR outside development should fail")
+	}
+
+	t.Setenv("APP_ENV", "development")
+	ring, err := LoadKeyringFromEnv()
+	if err != nil {
+		t.Fatalf("LoadKeyringFromEnv() in development error = %v", err)
+	}
+	if len(ring.JWKS().Keys) != 1 {
+		t.Errorf("development keyring has %d keys, want an ephemeral one", len(ring.JWKS().Keys))
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
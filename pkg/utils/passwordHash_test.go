@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idPasswordHash(t *testing.T) {
+	hash, err := HashPassword("Blue-Tiger-42")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Errorf("HashPassword() = %q, want an encoded argon2id hash", hash)
+	}
+	if !CheckPasswordHash("Blue-Tiger-42", hash) {
+		t.Error("CheckPasswordHash() rejected the right password")
+	}
+	if CheckPasswordHash("Blue-Tiger-43", hash) {
+		t.Error("CheckPasswordHash() accepted a wrong password")
+	}
+	if PasswordNeedsRehash(hash) {
+		t.Error("PasswordNeedsRehash() = true for a hash with the current parameters")
+	}
+
+	again, _ := HashPassword("Blue-Tiger-42")
+	if again == hash {
+		t.Error("HashPassword() must use a random salt")
+	}
+
+	weaker := DefaultArgon2idHasher()
+	weaker.Iterations = 1
+	old, err := weaker.Hash("Blue-Tiger-42")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !CheckPasswordHash("Blue-Tiger-42", old) {
+		t.Error("CheckPasswordHash() must verify hashes made with other parameters")
+	}
+	if !PasswordNeedsRehash(old) {
+		t.Error("PasswordNeedsRehash() = false for a hash with outdated parameters")
+	}
+}
+
+func TestLegacyBcryptPasswordHash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Blue-Tiger-42"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error = %v", err)
+	}
+
+	if !CheckPasswordHash("Blue-Tiger-42", string(legacy)) {
+		t.Error("CheckPasswordHash() rejected the right password for a bcrypt hash")
+	}
+	if CheckPasswordHash("Blue-Tiger-43", string(legacy)) {
+		t.Error("CheckPasswordHash() accepted a wrong password for a bcrypt hash")
+	}
+	if !PasswordNeedsRehash(string(legacy)) {
+		t.Error("PasswordNeedsRehash() = false for a bcrypt hash")
+	}
+}
+
+func TestMalformedPasswordHash(t *testing.T) {
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=65536$bad", "$argon2id$v=19$m=1,t=1,p=1$!!$!!"} {
+		if CheckPasswordHash("", hash) || CheckPasswordHash("plaintext", hash) {
+			t.Errorf("CheckPasswordHash() accepted malformed hash %q", hash)
+		}
+		if !PasswordNeedsRehash(hash) {
+			t.Errorf("PasswordNeedsRehash(%q) = false", hash)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// GenerateOpaqueToken returns a URL-safe random token with 256 bits of entropy.
+func GenerateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex encoded SHA-256 of token, used to store
+// single-use tokens without keeping the secret value.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const recoveryCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// GenerateRecoveryCode returns a one-time code formatted as two groups of
+// five characters, avoiding characters that are easily confused.
+func GenerateRecoveryCode() (string, error) {
+	code := make([]byte, 0, 11)
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		char, err := getRandomChar(recoveryCodeChars)
+		if err != nil {
+			return "", err
+		}
+		code = append(code, char)
+	}
+	return string(code), nil
+}
+
+// NormalizeRecoveryCode lowercases code and drops separators so that codes
+// typed with or without the dash hash to the same value.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
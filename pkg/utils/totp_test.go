@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors for HMAC-SHA1, truncated to six digits.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	step := TOTPStep(now)
+
+	previous, _ := TOTPCode(secret, step-1)
+	if got, ok := ValidateTOTP(secret, previous, now); !ok || got != step-1 {
+		t.Errorf("ValidateTOTP() with previous step = %d, %v; want %d, true", got, ok, step-1)
+	}
+
+	stale, _ := TOTPCode(secret, step-2)
+	if _, ok := ValidateTOTP(secret, stale, now); ok {
+		t.Error("ValidateTOTP() accepted a code two steps old")
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("ValidateTOTP() accepted a short code")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Altheia", "doc@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/Altheia:doc@example.com?") {
+		t.Errorf("TOTPProvisioningURI() = %s", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=Altheia") {
+		t.Errorf("TOTPProvisioningURI() = %s", uri)
+	}
+}